@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+// archiveVersion is the version of format of basket archives, archives of unknown versions are not imported
+const archiveVersion = 1
+
+// archivePageSize is the number of baskets or requests that are read at once while exporting
+const archivePageSize = 100
+
+// maxArchiveSize is the maximum size of a single basket archive that is accepted for import
+const maxArchiveSize = 64 * 1024 * 1024
+
+// errArchiveTooLarge is returned if basket archive exceeds the maximum size
+var errArchiveTooLarge = fmt.Errorf("basket archive exceeds maximum size of %d bytes", maxArchiveSize)
+
+// BasketArchive is a portable copy of a basket that can be imported by any service regardless of its database type,
+// collected requests are ordered from the oldest one; warnings describe settings that are not exported
+type BasketArchive struct {
+	Version   int                       `json:"version"`
+	Name      string                    `json:"name"`
+	Config    BasketConfig              `json:"config"`
+	Responses map[string]ResponseConfig `json:"responses,omitempty"`
+	Requests  []*RequestData            `json:"requests"`
+	Warnings  []string                  `json:"warnings,omitempty"`
+}
+
+// ImportResult describes the outcome of import of multiple baskets, imported baskets are given new tokens
+type ImportResult struct {
+	Baskets map[string]BasketAuth `json:"baskets"`
+	Failed  map[string]string     `json:"failed,omitempty"`
+}
+
+// archiveConfigWithoutSecrets returns basket configuration without secrets, settings that cannot be imported
+// without their secrets are removed, so the archive remains valid; returns warnings about removed settings
+func archiveConfigWithoutSecrets(config BasketConfig) (BasketConfig, []string) {
+	var warnings []string
+	stripped := config.WithoutSecrets()
+
+	if stripped.Signing != nil {
+		stripped.Signing = nil
+		warnings = append(warnings, "signing of forwarded requests is not exported without its secret")
+	}
+	if stripped.Transport != nil && stripped.Transport.TLS != nil && len(stripped.Transport.TLS.ClientCert) > 0 {
+		// certificate is not usable without its key, transport settings are already copied by WithoutSecrets
+		stripped.Transport.TLS.ClientCert = ""
+		warnings = append(warnings, "client certificate of forwarded requests is not exported without its key")
+	}
+	if config.Transport != nil && config.Transport.Proxy != nil && len(config.Transport.Proxy.Password) > 0 {
+		warnings = append(warnings, "password of proxy is not exported")
+	}
+
+	return stripped, warnings
+}
+
+// exportBasket creates archive of basket, secrets of basket configuration are only included if requested
+func exportBasket(ctx context.Context, name string, basket Basket, withSecrets bool) (*BasketArchive, error) {
+	config, err := basket.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	if !withSecrets {
+		config, warnings = archiveConfigWithoutSecrets(config)
+	}
+
+	archive := &BasketArchive{Version: archiveVersion, Name: name, Config: config,
+		Responses: make(map[string]ResponseConfig), Requests: make([]*RequestData, 0), Warnings: warnings}
+
+	for _, method := range responseMethods {
+		response, err := basket.GetResponse(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+		if response != nil {
+			archive.Responses[method] = *response
+		}
+	}
+
+	// pages may overlap if requests are collected meanwhile, requests collected by previous versions have no ID
+	// and are always exported
+	seen := make(map[string]bool)
+	for skip := 0; ; skip += archivePageSize {
+		page, err := basket.GetRequests(ctx, archivePageSize, skip)
+		if err != nil {
+			return nil, err
+		}
+		for _, request := range page.Requests {
+			if len(request.ID) > 0 {
+				if seen[request.ID] {
+					continue
+				}
+				seen[request.ID] = true
+			}
+			archive.Requests = append(archive.Requests, request)
+		}
+		if !page.HasMore {
+			break
+		}
+	}
+
+	// pages start with the latest request
+	for i, j := 0, len(archive.Requests)-1; i < j; i, j = i+1, j-1 {
+		archive.Requests[i], archive.Requests[j] = archive.Requests[j], archive.Requests[i]
+	}
+
+	return archive, nil
+}
+
+// validateArchive validates archive of basket before it is imported under the given name
+func validateArchive(archive *BasketArchive, name string, serviceHost string) error {
+	if archive.Version < 1 || archive.Version > archiveVersion {
+		return fmt.Errorf("unsupported version of basket archive: %d, supported versions: 1 - %d",
+			archive.Version, archiveVersion)
+	}
+
+	if err := validateBasketConfig(&archive.Config); err != nil {
+		return err
+	}
+	if err := validateForwardLoop(&archive.Config, name, serviceHost); err != nil {
+		return err
+	}
+
+	for method, response := range archive.Responses {
+		if !isValidMethod(method) {
+			return fmt.Errorf("unknown HTTP method of response: %s", method)
+		}
+		if err := validateResponseConfig(&response); err != nil {
+			return fmt.Errorf("invalid response of %s method: %s", method, err)
+		}
+	}
+
+	for i, request := range archive.Requests {
+		if request == nil {
+			return fmt.Errorf("request #%d of basket archive is empty", i+1)
+		}
+		if len(request.ID) == 0 {
+			id, err := GenerateID()
+			if err != nil {
+				return fmt.Errorf("failed to generate ID of imported request - %s", err)
+			}
+			request.ID = id
+		}
+	}
+
+	return nil
+}
+
+// importBasket creates a new basket from validated archive, the basket is deleted if it cannot be fully restored
+func importBasket(ctx context.Context, db BasketsDatabase, name string, archive *BasketArchive) (BasketAuth, error) {
+	auth, err := db.Create(ctx, name, archive.Config)
+	if err != nil {
+		return auth, err
+	}
+
+	if err = restoreBasket(ctx, db, name, archive); err != nil {
+		if derr := db.Delete(ctx, name); derr != nil {
+			log.Printf("[error] %s", derr)
+		}
+		return auth, fmt.Errorf("failed to import basket: %s - %s", name, err)
+	}
+	return auth, nil
+}
+
+// restoreBasket restores responses and collected requests of created basket from archive
+func restoreBasket(ctx context.Context, db BasketsDatabase, name string, archive *BasketArchive) error {
+	basket, err := db.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	if basket == nil {
+		return fmt.Errorf("basket is not found")
+	}
+
+	for method, response := range archive.Responses {
+		if err = basket.SetResponse(ctx, method, response); err != nil {
+			return err
+		}
+	}
+	for _, request := range archive.Requests {
+		if err = basket.Add(ctx, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportBaskets writes archives of all baskets to a stream of JSON documents, one archive per line,
+// returns the number of exported baskets
+func exportBaskets(ctx context.Context, db BasketsDatabase, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	count := 0
+
+	for skip := 0; ; skip += archivePageSize {
+		page, err := db.GetNames(ctx, archivePageSize, skip)
+		if err != nil {
+			return count, err
+		}
+
+		for _, name := range page.Names {
+			basket, err := db.Get(ctx, name)
+			if err != nil {
+				return count, err
+			}
+			if basket == nil {
+				// basket may be deleted meanwhile
+				continue
+			}
+
+			archive, err := exportBasket(ctx, name, basket, true)
+			if err != nil {
+				return count, err
+			}
+			if err = encoder.Encode(archive); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		if !page.HasMore {
+			return count, nil
+		}
+	}
+}
+
+// readLimitedLine reads a line that is up to limit bytes including line break, errArchiveTooLarge is returned
+// if the line is longer; io.EOF is returned along with the last line if it has no line break
+func readLimitedLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, errArchiveTooLarge
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// importBaskets creates baskets from a stream of archives, one archive per line, baskets that cannot be imported
+// are reported as failed; the import stops if the stream is malformed or an archive exceeds the maximum size
+func importBaskets(ctx context.Context, db BasketsDatabase, r io.Reader, serviceHost string) (ImportResult, error) {
+	result := ImportResult{Baskets: make(map[string]BasketAuth), Failed: make(map[string]string)}
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := readLimitedLine(reader, maxArchiveSize)
+		if err != nil && err != io.EOF {
+			if errors.Is(err, errArchiveTooLarge) {
+				return result, err
+			}
+			return result, fmt.Errorf("failed to read stream of basket archives - %s", err)
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if derr := importArchive(ctx, db, line, serviceHost, &result); derr != nil {
+				return result, derr
+			}
+		}
+		if err == io.EOF {
+			return result, nil
+		}
+	}
+}
+
+// importArchive imports a basket from serialized archive and records the outcome, returns error if the archive
+// is malformed; failures of storage are only logged, since their details are not exposed to clients
+func importArchive(ctx context.Context, db BasketsDatabase, data []byte, serviceHost string, result *ImportResult) error {
+	archive := new(BasketArchive)
+	if err := json.Unmarshal(data, archive); err != nil {
+		return fmt.Errorf("malformed stream of basket archives - %s", err)
+	}
+
+	name := archive.Name
+	if _, err := validateNewBasketName(name); err != nil {
+		result.Failed[name] = err.Error()
+		return nil
+	}
+	if err := validateArchive(archive, name, serviceHost); err != nil {
+		result.Failed[name] = err.Error()
+		return nil
+	}
+
+	auth, err := importBasket(ctx, db, name, archive)
+	if errors.Is(err, errBasketExists) {
+		result.Failed[name] = errBasketExists.Error()
+	} else if err != nil {
+		log.Printf("[warn] %s", err)
+		result.Failed[name] = "failed to import basket"
+	} else {
+		result.Baskets[name] = auth
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestArchiveBasket creates basket with responses and collected requests to export
+func createTestArchiveBasket(t *testing.T, db *testDatabase, name string) {
+	db.Create(name, BasketConfig{Capacity: 3, ForwardURL: "http://localhost:12345/test",
+		Signing: &SigningConfig{Secret: "abc"}})
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		basket.SetResponse(http.MethodPost, ResponseConfig{Status: 201, Headers: http.Header{}, Body: "created"})
+		basket.SetResponse(http.MethodGet, ResponseConfig{Status: 200, Headers: http.Header{}, Body: "{{.id}}", IsTemplate: true})
+		for i := 0; i < 4; i++ {
+			basket.Add(createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("req%v", i)))
+		}
+	}
+}
+
+func TestExportBasket(t *testing.T) {
+	name := "test172"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	createTestArchiveBasket(t, db, name)
+
+	archive, err := exportBasket(context.Background(), name, db.Get(name).Basket, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, archiveVersion, archive.Version, "wrong archive version")
+		assert.Equal(t, name, archive.Name, "wrong basket name")
+		assert.Equal(t, 3, archive.Config.Capacity, "wrong capacity")
+		assert.Nil(t, archive.Config.Signing, "signing without secret is not expected")
+		assert.Len(t, archive.Warnings, 1, "warning about signing is expected")
+		assert.Len(t, archive.Responses, 2, "wrong number of responses")
+		assert.Equal(t, "created", archive.Responses[http.MethodPost].Body, "wrong response body")
+		if assert.Len(t, archive.Requests, 3, "wrong number of requests") {
+			// from the oldest request
+			assert.Equal(t, "req1", archive.Requests[0].Body, "wrong request")
+			assert.Equal(t, "req3", archive.Requests[2].Body, "wrong request")
+		}
+	}
+
+	archive, err = exportBasket(context.Background(), name, db.Get(name).Basket, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "abc", archive.Config.Signing.Secret, "secret is expected")
+		assert.Empty(t, archive.Warnings, "warnings are not expected")
+	}
+}
+
+func TestImportBasket(t *testing.T) {
+	name := "test173"
+	source := testDB(t, NewMemoryDatabase())
+	defer source.Release()
+
+	createTestArchiveBasket(t, source, name)
+	archive, err := exportBasket(context.Background(), name, source.Get(name).Basket, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// the oldest request is collected long ago
+	archive.Requests[0].Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+
+	boltFile := name + ".db"
+	defer os.Remove(boltFile)
+	databases := map[string]BasketsDatabase{
+		DbTypeMemory: NewMemoryDatabase(),
+		DbTypeBolt:   NewBoltDatabase(boltFile),
+		DbTypeSQL:    NewSQLDatabase(sqliteTestConnection),
+		DbTypeRedis:  NewRedisDatabase(redisTestConnection())}
+
+	for dbType, bdb := range databases {
+		db := testDB(t, bdb)
+		if !assert.NotNil(t, db, "%s database is expected", dbType) {
+			continue
+		}
+
+		auth, err := importBasket(context.Background(), db.BasketsDatabase, name, archive)
+		if assert.NoError(t, err, "failed to import basket into %s database", dbType) {
+			assert.NotEmpty(t, auth.Token, "basket token is expected")
+
+			basket := db.Get(name)
+			if assert.NotNil(t, basket, "imported basket is expected in %s database", dbType) {
+				assert.True(t, basket.Authorize(auth.Token), "basket authorization has failed")
+				imported, err := exportBasket(context.Background(), name, basket.Basket, true)
+				if assert.NoError(t, err) {
+					assert.Equal(t, archive, imported, "archive of imported basket differs in %s database", dbType)
+				}
+
+				// imported requests keep their age
+				assert.Equal(t, 1, basket.DeleteExpired(time.Hour), "wrong number of expired requests in %s database", dbType)
+				if page := basket.GetRequests(10, 0); assert.Len(t, page.Requests, 2, "wrong number of requests in %s database", dbType) {
+					assert.Equal(t, "req3", page.Requests[0].Body, "wrong latest request in %s database", dbType)
+				}
+			}
+		}
+
+		// basket cannot be imported twice
+		_, err = importBasket(context.Background(), db.BasketsDatabase, name, archive)
+		assert.ErrorIs(t, err, errBasketExists, "basket is not expected to be imported into %s database", dbType)
+
+		db.Delete(name)
+		db.Release()
+	}
+}
+
+func TestValidateArchive(t *testing.T) {
+	name := "test174"
+	valid := func() *BasketArchive {
+		return &BasketArchive{Version: archiveVersion, Name: name, Config: BasketConfig{Capacity: 20},
+			Responses: map[string]ResponseConfig{http.MethodGet: {Status: 200}},
+			Requests:  []*RequestData{{ID: "abc", Path: "/" + name}}}
+	}
+
+	assert.NoError(t, validateArchive(valid(), name, "localhost"))
+
+	archive := valid()
+	archive.Requests[0].ID = ""
+	if assert.NoError(t, validateArchive(archive, name, "localhost")) {
+		assert.NotEmpty(t, archive.Requests[0].ID, "request ID is expected to be generated")
+	}
+
+	invalid := map[string]func(archive *BasketArchive){
+		"missing version":  func(archive *BasketArchive) { archive.Version = 0 },
+		"newer version":    func(archive *BasketArchive) { archive.Version = archiveVersion + 1 },
+		"invalid capacity": func(archive *BasketArchive) { archive.Config.Capacity = 0 },
+		"unknown method":   func(archive *BasketArchive) { archive.Responses["XYZ"] = ResponseConfig{Status: 200} },
+		"invalid response": func(archive *BasketArchive) { archive.Responses[http.MethodGet] = ResponseConfig{Status: 20} },
+		"empty request":    func(archive *BasketArchive) { archive.Requests = append(archive.Requests, nil) },
+		"forward loop":     func(archive *BasketArchive) { archive.Config.ForwardURL = "http://localhost/" + name },
+	}
+	for reason, change := range invalid {
+		archive := valid()
+		change(archive)
+		assert.Error(t, validateArchive(archive, name, "localhost"), "archive is expected to be invalid: %s", reason)
+	}
+}
+
+func TestExportImportBaskets(t *testing.T) {
+	name := "test175"
+	source := testDB(t, NewMemoryDatabase())
+	defer source.Release()
+
+	for i := 0; i < 3; i++ {
+		createTestArchiveBasket(t, source, fmt.Sprintf("%s_%v", name, i))
+	}
+
+	var stream bytes.Buffer
+	count, err := exportBaskets(context.Background(), source.BasketsDatabase, &stream)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count, "wrong number of exported baskets")
+		assert.Equal(t, 3, strings.Count(stream.String(), "\n"), "one archive per line is expected")
+	}
+
+	target := testDB(t, NewMemoryDatabase())
+	defer target.Release()
+	target.Create(name+"_1", BasketConfig{Capacity: 20})
+
+	result, err := importBaskets(context.Background(), target.BasketsDatabase, &stream, "localhost")
+	if assert.NoError(t, err) {
+		assert.Len(t, result.Baskets, 2, "wrong number of imported baskets")
+		assert.Contains(t, result.Failed, name+"_1", "existing basket is expected to fail")
+		assert.Equal(t, 3, target.Size(), "wrong database size")
+		if basket := target.Get(name + "_2"); assert.NotNil(t, basket, "imported basket is expected") {
+			assert.Equal(t, 3, basket.Size(), "wrong basket size")
+			assert.Equal(t, "abc", basket.Config().Signing.Secret, "secret is expected")
+		}
+	}
+}
+
+func TestImportBaskets_InvalidStream(t *testing.T) {
+	name := "test176"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	stream := fmt.Sprintf("{\"version\":1,\"name\":\"%s\",\"config\":{\"capacity\":20}}\n", name) +
+		"{\"version\":1,\"name\":\"api\",\"config\":{\"capacity\":20}}\n" +
+		fmt.Sprintf("{\"version\":2,\"name\":\"%s_1\",\"config\":{\"capacity\":20}}\n", name) +
+		"{\"version\":1,\"name\":"
+
+	result, err := importBaskets(context.Background(), db.BasketsDatabase, strings.NewReader(stream), "localhost")
+	assert.Error(t, err, "malformed stream is expected")
+	assert.Len(t, result.Baskets, 1, "wrong number of imported baskets")
+	assert.Len(t, result.Failed, 2, "wrong number of failed baskets")
+	assert.Contains(t, result.Failed, "api", "reserved name is expected to fail")
+	assert.Contains(t, result.Failed, name+"_1", "unsupported version is expected to fail")
+	assert.NotNil(t, db.Get(name), "imported basket is expected")
+}
+
+func TestExportBasket_RequestsWithoutID(t *testing.T) {
+	name := "test182"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 2 * archivePageSize})
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// requests collected by previous versions have no ID
+		for i := 0; i < archivePageSize+5; i++ {
+			request := createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("req%v", i))
+			request.ID = ""
+			basket.Add(request)
+		}
+		basket.Add(createTestRequestData("/"+name, "latest"))
+
+		archive, err := exportBasket(context.Background(), name, basket.Basket, true)
+		if assert.NoError(t, err) && assert.Len(t, archive.Requests, archivePageSize+6, "wrong number of requests") {
+			assert.Equal(t, "req0", archive.Requests[0].Body, "wrong oldest request")
+			assert.Equal(t, "latest", archive.Requests[archivePageSize+5].Body, "wrong latest request")
+		}
+	}
+}
+
+func TestExportBasket_WithoutSecrets(t *testing.T) {
+	name := "test183"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20, Signing: &SigningConfig{Secret: "abc"},
+		Transport: &TransportConfig{TLS: &TLSConfig{ClientCert: "cert", ClientKey: "key", MinVersion: "1.2"},
+			Proxy: &ProxyConfig{URL: "http://proxy.local:3128", Username: "user", Password: "pwd"}}})
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		archive, err := exportBasket(context.Background(), name, basket.Basket, false)
+		if assert.NoError(t, err) {
+			config := archive.Config
+			assert.Nil(t, config.Signing, "signing without secret is not expected")
+			if assert.NotNil(t, config.Transport.TLS, "TLS settings are expected") {
+				assert.Empty(t, config.Transport.TLS.ClientCert, "client certificate without key is not expected")
+				assert.Empty(t, config.Transport.TLS.ClientKey, "client key is not expected")
+				assert.Equal(t, "1.2", config.Transport.TLS.MinVersion, "wrong minimum TLS version")
+			}
+			if assert.NotNil(t, config.Transport.Proxy, "proxy settings are expected") {
+				assert.Equal(t, "user", config.Transport.Proxy.Username, "wrong proxy username")
+				assert.Empty(t, config.Transport.Proxy.Password, "proxy password is not expected")
+			}
+			assert.Len(t, archive.Warnings, 3, "warnings about settings that are not exported are expected")
+
+			// archive without secrets can be imported
+			assert.NoError(t, validateArchive(archive, name+"_copy", "localhost"))
+		}
+
+		// settings of stored basket are kept
+		config := basket.Config()
+		assert.Equal(t, "abc", config.Signing.Secret, "secret of stored basket is expected")
+		assert.Equal(t, "cert", config.Transport.TLS.ClientCert, "client certificate of stored basket is expected")
+	}
+}
+
+func TestReadLimitedLine(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	reader := bufio.NewReaderSize(strings.NewReader("short\n"+long+"\nlast"), 16)
+
+	line, err := readLimitedLine(reader, 32)
+	assert.NoError(t, err)
+	assert.Equal(t, "short\n", string(line), "wrong line")
+
+	_, err = readLimitedLine(reader, 32)
+	assert.Equal(t, errArchiveTooLarge, err, "line is expected to exceed limit")
+
+	reader = bufio.NewReaderSize(strings.NewReader(long+"\nlast"), 16)
+	line, err = readLimitedLine(reader, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, long+"\n", string(line), "wrong line")
+	line, err = readLimitedLine(reader, 64)
+	assert.Equal(t, io.EOF, err, "end of stream is expected")
+	assert.Equal(t, "last", string(line), "wrong last line")
+}
@@ -1,27 +1,136 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
 const toMs = int64(time.Millisecond) / int64(time.Nanosecond)
 
-// DoNotForwardHeader indicates whether request can (0) or cannot (1) be forwarded
+// DoNotForwardHeader indicates whether request can (0) or cannot (1) be forwarded, forwarded requests are protected
+// from loops by HopsHeader and ViaHeader
 const DoNotForwardHeader = "X-Do-Not-Forward"
 
+// errBasketExists is returned by database if basket with the same name already exists
+var errBasketExists = errors.New("basket already exists")
+
+// maxCompiledPatterns is the number of compiled patterns of forward rules that are kept for reuse
+const maxCompiledPatterns = 1000
+
+var compiledPatterns = newLRUCache(maxCompiledPatterns, nil)
+
 // BasketConfig describes single basket configuration.
 type BasketConfig struct {
-	ForwardURL    string `json:"forward_url"`
-	ProxyResponse bool   `json:"proxy_response"`
-	InsecureTLS   bool   `json:"insecure_tls"`
-	ExpandPath    bool   `json:"expand_path"`
-	Capacity      int    `json:"capacity"`
+	ForwardURL     string          `json:"forward_url"`
+	ProxyResponse  bool            `json:"proxy_response"`
+	InsecureTLS    bool            `json:"insecure_tls"`
+	ExpandPath     bool            `json:"expand_path"`
+	Capacity       int             `json:"capacity"`
+	ForwardTargets []ForwardTarget `json:"forward_targets,omitempty"`
+	RetryCount     int             `json:"retry_count"`
+	RetryBackoff   int             `json:"retry_backoff"`
+	HeaderRules    []HeaderRule    `json:"header_rules,omitempty"`
+
+	ForwardBodyTemplate string `json:"forward_body_template,omitempty"`
+	ForwardMethod       string `json:"forward_method,omitempty"`
+	ForwardContentType  string `json:"forward_content_type,omitempty"`
+
+	ForwardRules []ForwardRule `json:"forward_rules,omitempty"`
+
+	Transport *TransportConfig `json:"transport,omitempty"`
+	Signing   *SigningConfig   `json:"signing,omitempty"`
+	WebSocket *WebSocketConfig `json:"websocket,omitempty"`
+
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	RateLimit      int                   `json:"rate_limit,omitempty"`
+
+	MaxAge int  `json:"max_age,omitempty"`
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// BasketDetails describes basket configuration along with the date (in milliseconds) when basket expires
+// according to server configuration, the date is omitted if basket never expires.
+type BasketDetails struct {
+	BasketConfig
+	ExpiryDate int64 `json:"expiry_date,omitempty"`
+}
+
+// TransportConfig describes settings of HTTP client used to forward requests of a basket,
+// undefined settings are taken from server defaults.
+type TransportConfig struct {
+	ConnectTimeout  int   `json:"connect_timeout,omitempty"`
+	TLSTimeout      int   `json:"tls_timeout,omitempty"`
+	Timeout         int   `json:"timeout,omitempty"`
+	FollowRedirects *bool `json:"follow_redirects,omitempty"`
+	HTTP2           *bool `json:"http2,omitempty"`
+
+	TLSProfile string     `json:"tls_profile,omitempty"`
+	TLS        *TLSConfig `json:"tls,omitempty"`
+
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+}
+
+// ProxyConfig describes outbound HTTP or SOCKS5 proxy to forward requests through, the URL "direct" disables
+// the proxy defined by server; proxy password is a secret that is never exposed by API.
+type ProxyConfig struct {
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// TLSConfig describes TLS settings to communicate with forward targets, certificates and keys are PEM encoded;
+// client key is a secret that is never exposed by API.
+type TLSConfig struct {
+	CACerts    string `json:"ca_certs,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+	MinVersion string `json:"min_version,omitempty"`
+}
+
+// ForwardTarget describes additional URL to forward incoming requests of a basket to.
+type ForwardTarget struct {
+	URL         string `json:"url"`
+	InsecureTLS bool   `json:"insecure_tls"`
+	ExpandPath  bool   `json:"expand_path"`
+	Primary     bool   `json:"primary"`
+}
+
+// ForwardRule describes conditions of incoming requests and the targets to forward matching requests to.
+// Conditions are regular expressions, all defined conditions should match; matching requests are forwarded
+// to the rule targets, or to the basket forward URL and targets if the rule has no targets.
+type ForwardRule struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Targets []ForwardTarget   `json:"targets,omitempty"`
+	Drop    bool              `json:"drop,omitempty"`
+}
+
+// Actions of header rules
+const (
+	HeaderRuleSet    = "set"
+	HeaderRuleAdd    = "add"
+	HeaderRuleRemove = "remove"
+)
+
+// HeaderRule describes modification of a header of forwarded request, the value is a template
+// that may refer the original request.
+type HeaderRule struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Value  string `json:"value,omitempty"`
 }
 
 // ResponseConfig describes response that is generates by service upon HTTP request sent to a basket.
@@ -32,6 +141,27 @@ type ResponseConfig struct {
 	IsTemplate bool        `json:"is_template"`
 }
 
+// ReplayOptions describes optional overrides of collected request that is replayed, the request is sent to the
+// forward URL of basket unless the URL is specified; headers with empty values are removed.
+type ReplayOptions struct {
+	URL    string      `json:"url,omitempty"`
+	Header http.Header `json:"headers,omitempty"`
+	Body   *string     `json:"body,omitempty"`
+}
+
+// WebSocket modes of basket, basket echoes incoming messages unless it is configured to reply with messages.
+const (
+	WebSocketEcho  = "echo"
+	WebSocketReply = "reply"
+)
+
+// WebSocketConfig describes how a basket responds to messages of WebSocket sessions, in reply mode every incoming
+// message is answered with configured messages; in proxy mode sessions are tunneled to the forward URL.
+type WebSocketConfig struct {
+	Mode     string   `json:"mode,omitempty"`
+	Messages []string `json:"messages,omitempty"`
+}
+
 // BasketAuth describes basket authentication response that is sent when new basket is created.
 type BasketAuth struct {
 	Token string `json:"token"`
@@ -39,13 +169,77 @@ type BasketAuth struct {
 
 // RequestData describes collected request data.
 type RequestData struct {
-	Date          int64       `json:"date"`
-	Header        http.Header `json:"headers"`
-	ContentLength int64       `json:"content_length"`
-	Body          string      `json:"body"`
-	Method        string      `json:"method"`
-	Path          string      `json:"path"`
-	Query         string      `json:"query"`
+	ID            string            `json:"id,omitempty"`
+	Date          int64             `json:"date"`
+	Header        http.Header       `json:"headers"`
+	ContentLength int64             `json:"content_length"`
+	Body          string            `json:"body"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         string            `json:"query"`
+	ForwardRule   string            `json:"forward_rule,omitempty"`
+	Forwards      []*ForwardResult  `json:"forwards,omitempty"`
+	Frames        []*WebSocketFrame `json:"frames,omitempty"`
+}
+
+// WebSocketFrame describes a frame of WebSocket session that is collected along with the upgrade request,
+// direction "in" is from client and "out" is to client; payload that is not a text is base64 encoded.
+type WebSocketFrame struct {
+	Date      int64  `json:"date"`
+	Direction string `json:"direction"`
+	Type      string `json:"type"`
+	Final     bool   `json:"final"`
+	Size      int    `json:"size"`
+	Data      string `json:"data,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// ForwardResult describes the outcome of forwarding collected request to a target.
+type ForwardResult struct {
+	Date      int64           `json:"date"`
+	URL       string          `json:"url"`
+	Status    int             `json:"status,omitempty"`
+	Duration  int64           `json:"duration"`
+	Body      string          `json:"body,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Attempts  int             `json:"attempts,omitempty"`
+	Rejected  bool            `json:"rejected,omitempty"`
+	Payload   *ForwardPayload `json:"payload,omitempty"`
+}
+
+// ForwardPayload describes payload of forwarded request if it is transformed before forwarding.
+type ForwardPayload struct {
+	Method      string `json:"method"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+}
+
+// DeadLetter describes forwarded request that was not delivered to the target after all attempts.
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Date     int64           `json:"date"`
+	Target   ForwardTarget   `json:"target"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Request  *RequestData    `json:"request"`
+	Payload  *ForwardPayload `json:"payload,omitempty"`
+}
+
+// PendingForward describes request that is queued to be forwarded to a target.
+type PendingForward struct {
+	ID      string        `json:"id"`
+	Date    int64         `json:"date"`
+	Target  ForwardTarget `json:"target"`
+	Request *RequestData  `json:"request"`
+}
+
+// DeadLettersPage describes a page with undelivered forwarded requests.
+type DeadLettersPage struct {
+	DeadLetters []*DeadLetter `json:"dead_letters"`
+	Count       int           `json:"count"`
+	HasMore     bool          `json:"has_more"`
 }
 
 // RequestsPage describes a page with collected requests.
@@ -95,42 +289,286 @@ type BasketInfo struct {
 	LastRequestDate    int64  `json:"last_request_date"`
 }
 
-// Basket is an interface that represent request basket entity to collects HTTP requests
-type Basket interface {
-	Config() BasketConfig
-	Update(config BasketConfig)
-	Authorize(token string) bool
-
-	GetResponse(method string) *ResponseConfig
-	SetResponse(method string, response ResponseConfig)
+// BasketActivity describes the dates (in milliseconds) of basket creation and of the last collected request,
+// the date of the last request is 0 if basket has never collected requests
+type BasketActivity struct {
+	CreatedDate     int64 `json:"created_date"`
+	LastRequestDate int64 `json:"last_request_date"`
+}
 
-	Add(req *http.Request) *RequestData
-	Clear()
+// Basket is an interface that represent request basket entity to collects HTTP requests,
+// storage failures are reported with errors, the context cancels long running operations of storage
+type Basket interface {
+	Config(ctx context.Context) (BasketConfig, error)
+	Update(ctx context.Context, config BasketConfig) error
+	Authorize(ctx context.Context, token string) (bool, error)
+
+	GetResponse(ctx context.Context, method string) (*ResponseConfig, error)
+	SetResponse(ctx context.Context, method string, response ResponseConfig) error
+
+	Add(ctx context.Context, data *RequestData) error
+	AddForwardResult(ctx context.Context, id string, result *ForwardResult) error
+	AddWebSocketFrames(ctx context.Context, id string, frames []*WebSocketFrame) error
+	GetRequest(ctx context.Context, id string) (*RequestData, error)
+	Clear(ctx context.Context) error
+	DeleteExpired(ctx context.Context, maxAge time.Duration) (int, error)
+	Activity(ctx context.Context) (BasketActivity, error)
+
+	Size(ctx context.Context) (int, error)
+	GetRequests(ctx context.Context, max int, skip int) (RequestsPage, error)
+	FindRequests(ctx context.Context, query string, in string, max int, skip int) (RequestsQueryPage, error)
+
+	AddDeadLetter(ctx context.Context, letter *DeadLetter) error
+	GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+	GetDeadLetters(ctx context.Context, max int, skip int) (DeadLettersPage, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+	ClearDeadLetters(ctx context.Context) error
+}
 
-	Size() int
-	GetRequests(max int, skip int) RequestsPage
-	FindRequests(query string, in string, max int, skip int) RequestsQueryPage
+// ForwardsStore is an optional interface of a basket that persists queued forwards,
+// so the forwards that are not yet delivered can be resumed after service restart;
+// ClaimForward atomically removes persisted forward and returns false if the forward is already claimed
+// (e.g. by another service instance that shares the database)
+type ForwardsStore interface {
+	SaveForward(ctx context.Context, forward *PendingForward) error
+	ClaimForward(ctx context.Context, id string) (bool, error)
+	GetPendingForwards(ctx context.Context) ([]*PendingForward, error)
 }
 
-// BasketsDatabase is an interface that represent database to manage collection of request baskets
+// BasketsDatabase is an interface that represent database to manage collection of request baskets,
+// Get returns nil basket without error if basket does not exist
 type BasketsDatabase interface {
-	Create(name string, config BasketConfig) (BasketAuth, error)
-	Get(name string) Basket
-	Delete(name string)
+	Create(ctx context.Context, name string, config BasketConfig) (BasketAuth, error)
+	Get(ctx context.Context, name string) (Basket, error)
+	Delete(ctx context.Context, name string) error
 
-	Size() int
-	GetNames(max int, skip int) BasketNamesPage
-	FindNames(query string, max int, skip int) BasketNamesQueryPage
+	Size(ctx context.Context) (int, error)
+	GetNames(ctx context.Context, max int, skip int) (BasketNamesPage, error)
+	FindNames(ctx context.Context, query string, max int, skip int) (BasketNamesQueryPage, error)
 
-	GetStats(max int) DatabaseStats
+	GetStats(ctx context.Context, max int) (DatabaseStats, error)
 
 	Release()
 }
 
+// RetentionAge returns maximum age of collected requests of a basket, server default is used if basket
+// defines no age; zero means that requests are only evicted according to basket capacity.
+func (config BasketConfig) RetentionAge(defaultAge time.Duration) time.Duration {
+	if config.MaxAge > 0 {
+		return time.Duration(config.MaxAge) * time.Second
+	}
+	return defaultAge
+}
+
+// expiryDate returns the date (in milliseconds) of the oldest collected request that is not expired yet
+func expiryDate(maxAge time.Duration) int64 {
+	return time.Now().Add(-maxAge).UnixNano() / toMs
+}
+
+// ExpiryDate returns the date (in milliseconds) when basket is deleted as inactive or as outlived, inactivity is
+// counted from the last collected request or from basket creation; 0 means that basket never expires.
+func (config BasketConfig) ExpiryDate(activity BasketActivity, ttl time.Duration, lifetime time.Duration) int64 {
+	if config.Pinned {
+		return 0
+	}
+
+	var expiry int64
+	if ttl > 0 {
+		lastActive := activity.CreatedDate
+		if activity.LastRequestDate > lastActive {
+			lastActive = activity.LastRequestDate
+		}
+		expiry = lastActive + int64(ttl/time.Millisecond)
+	}
+	if lifetime > 0 {
+		if end := activity.CreatedDate + int64(lifetime/time.Millisecond); expiry == 0 || end < expiry {
+			expiry = end
+		}
+	}
+
+	return expiry
+}
+
+// Targets returns all forward targets of a basket, target defined by ForwardURL (if any) comes first.
+func (config BasketConfig) Targets() []ForwardTarget {
+	targets := make([]ForwardTarget, 0, len(config.ForwardTargets)+1)
+	if len(config.ForwardURL) > 0 {
+		targets = append(targets, ForwardTarget{URL: config.ForwardURL, InsecureTLS: config.InsecureTLS, ExpandPath: config.ExpandPath})
+	}
+
+	return append(targets, config.ForwardTargets...)
+}
+
+// PrimaryTarget returns index of forward target, which response is proxied back to the client if ProxyResponse
+// is enabled; target explicitly marked as primary takes precedence over the one defined by ForwardURL.
+func (config BasketConfig) PrimaryTarget() int {
+	targets := config.Targets()
+	for i, target := range targets {
+		if target.Primary {
+			return i
+		}
+	}
+
+	if len(config.ForwardURL) > 0 || len(targets) == 1 {
+		return 0
+	}
+
+	return -1
+}
+
+// WithoutSecrets returns copy of basket configuration without secrets, so it can be exposed by API.
+func (config BasketConfig) WithoutSecrets() BasketConfig {
+	if config.Signing != nil {
+		signing := *config.Signing
+		signing.Secret = ""
+		config.Signing = &signing
+	}
+	if config.Transport == nil {
+		return config
+	}
+
+	transport := *config.Transport
+	if transport.TLS != nil {
+		tlsConfig := *transport.TLS
+		tlsConfig.ClientKey = ""
+		transport.TLS = &tlsConfig
+	}
+	if transport.Proxy != nil {
+		proxy := *transport.Proxy
+		proxy.Password = ""
+		transport.Proxy = &proxy
+	}
+	config.Transport = &transport
+
+	return config
+}
+
+// WithSecretsOf returns a copy of configuration that keeps secrets of current configuration if they are not defined,
+// since secrets are never exposed by API the clients do not send them back on update
+func (config BasketConfig) WithSecretsOf(current BasketConfig) BasketConfig {
+	if config.Signing != nil && current.Signing != nil && len(config.Signing.Secret) == 0 {
+		signing := *config.Signing
+		signing.Secret = current.Signing.Secret
+		config.Signing = &signing
+	}
+	if config.Transport == nil || current.Transport == nil {
+		return config
+	}
+
+	transport := *config.Transport
+	if transport.TLS != nil && current.Transport.TLS != nil && len(transport.TLS.ClientKey) == 0 {
+		tlsConfig := *transport.TLS
+		tlsConfig.ClientKey = current.Transport.TLS.ClientKey
+		transport.TLS = &tlsConfig
+	}
+	if transport.Proxy != nil && current.Transport.Proxy != nil && len(transport.Proxy.Password) == 0 {
+		proxy := *transport.Proxy
+		proxy.Password = current.Transport.Proxy.Password
+		transport.Proxy = &proxy
+	}
+	config.Transport = &transport
+
+	return config
+}
+
+// AllTargets returns forward targets of a basket including the targets of forward rules.
+func (config BasketConfig) AllTargets() []ForwardTarget {
+	targets := config.Targets()
+	for _, rule := range config.ForwardRules {
+		targets = append(targets, rule.Targets...)
+	}
+	return targets
+}
+
+// ForTarget returns copy of basket configuration that forwards requests only to the specified target.
+func (config BasketConfig) ForTarget(target ForwardTarget) BasketConfig {
+	config.ForwardURL = target.URL
+	config.InsecureTLS = target.InsecureTLS
+	config.ExpandPath = target.ExpandPath
+	config.ForwardTargets = nil
+
+	return config
+}
+
+// ForRule returns copy of basket configuration that forwards requests to the targets of fired forward rule.
+func (config BasketConfig) ForRule(rule *ForwardRule) BasketConfig {
+	if rule != nil && rule.Drop {
+		config.ForwardURL = ""
+		config.ForwardTargets = nil
+	} else if rule != nil && len(rule.Targets) > 0 {
+		config.ForwardURL = ""
+		config.ForwardTargets = rule.Targets
+	}
+	config.ForwardRules = nil
+
+	return config
+}
+
+// MatchRule returns the first forward rule that matches the request, or nil if none of rules matches.
+func (config BasketConfig) MatchRule(req *RequestData) *ForwardRule {
+	for i := range config.ForwardRules {
+		if matches, err := config.ForwardRules[i].Matches(req); err != nil {
+			log.Printf("[warn] failed to evaluate forward rule: %s - %s", config.ForwardRules[i].Name, err)
+		} else if matches {
+			return &config.ForwardRules[i]
+		}
+	}
+
+	return nil
+}
+
+// HasTransformation checks if payload of forwarded requests is transformed.
+func (config BasketConfig) HasTransformation() bool {
+	return len(config.ForwardBodyTemplate) > 0 || len(config.ForwardMethod) > 0 || len(config.ForwardContentType) > 0
+}
+
+// ParseBodyTemplate parses template of forwarded request body.
+func (config BasketConfig) ParseBodyTemplate() (*template.Template, error) {
+	return template.New("forward-body").Parse(config.ForwardBodyTemplate)
+}
+
+// Apply returns copy of collected request with overridden headers and body, the original request is not modified
+func (options ReplayOptions) Apply(request *RequestData) *RequestData {
+	replay := *request
+	replay.Header = make(http.Header)
+	for k, v := range request.Header {
+		replay.Header[k] = v
+	}
+	for k, v := range options.Header {
+		if len(v) > 0 {
+			replay.Header[http.CanonicalHeaderKey(k)] = v
+		} else {
+			replay.Header.Del(k)
+		}
+	}
+
+	if options.Body != nil {
+		replay.Body = *options.Body
+		replay.ContentLength = int64(len(replay.Body))
+	}
+
+	return &replay
+}
+
+// ForReplay returns copy of basket configuration to replay collected request, the request is forwarded only to
+// the forward URL of basket or to the specified URL as is
+func (config BasketConfig) ForReplay(options ReplayOptions) BasketConfig {
+	if len(options.URL) > 0 {
+		return config.ForTarget(ForwardTarget{URL: options.URL, InsecureTLS: config.InsecureTLS})
+	}
+	return config.ForTarget(ForwardTarget{URL: config.ForwardURL, InsecureTLS: config.InsecureTLS,
+		ExpandPath: config.ExpandPath})
+}
+
 // ToRequestData converts HTTP Request object into RequestData holder
 func ToRequestData(req *http.Request) *RequestData {
 	data := new(RequestData)
 
+	if id, err := GenerateID(); err == nil {
+		data.ID = id
+	} else {
+		log.Printf("[error] failed to generate ID of collected request - %s", err)
+	}
 	data.Date = time.Now().UnixNano() / toMs
 	data.Header = make(http.Header)
 	for k, v := range req.Header {
@@ -150,6 +588,34 @@ func ToRequestData(req *http.Request) *RequestData {
 
 // Forward forwards request data to specified URL
 func (req *RequestData) Forward(client *http.Client, config BasketConfig, basket string) (*http.Response, error) {
+	forwardReq, err := req.NewForwardRequest(config, basket)
+	if err != nil {
+		return nil, err
+	}
+
+	// forward request
+	response, err := client.Do(forwardReq)
+	if err != nil {
+		// HTTP issue during forwarding - HTTP 502 Bad Gateway
+		log.Printf("[warn] failed to forward request for basket: %s - %s", basket, err)
+		return badGatewayResponse(err), nil
+	}
+
+	return response, nil
+}
+
+// NewForwardRequest creates HTTP request to forward request data to the target defined by basket config
+func (req *RequestData) NewForwardRequest(config BasketConfig, basket string) (*http.Request, error) {
+	payload, err := req.Transform(config)
+	if err != nil {
+		return nil, err
+	}
+	return req.newForwardRequest(config, basket, payload)
+}
+
+// newForwardRequest creates HTTP request to forward request data with payload that is already transformed according
+// to basket config, payload is nil if request is forwarded as is
+func (req *RequestData) newForwardRequest(config BasketConfig, basket string, payload *ForwardPayload) (*http.Request, error) {
 	forwardURL, err := url.ParseRequestURI(config.ForwardURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid forward URL: %s - %s", config.ForwardURL, err)
@@ -169,7 +635,13 @@ func (req *RequestData) Forward(client *http.Client, config BasketConfig, basket
 		}
 	}
 
-	forwardReq, err := http.NewRequest(req.Method, forwardURL.String(), strings.NewReader(req.Body))
+	// transformed payload
+	method, body := req.Method, req.Body
+	if payload != nil {
+		method, body = payload.Method, payload.Body
+	}
+
+	forwardReq, err := http.NewRequest(method, forwardURL.String(), strings.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create forward request: %s", err)
 	}
@@ -180,26 +652,76 @@ func (req *RequestData) Forward(client *http.Client, config BasketConfig, basket
 			forwardReq.Header.Add(header, val)
 		}
 	}
+	if payload != nil && len(payload.ContentType) > 0 {
+		forwardReq.Header.Set("Content-Type", payload.ContentType)
+	}
 	// headers cleanup
 	forwardHeadersCleanup(forwardReq)
-	// set do not forward header
-	forwardReq.Header.Set(DoNotForwardHeader, "1")
+	// apply header rules
+	if err = applyHeaderRules(forwardReq, config.HeaderRules, req); err != nil {
+		return nil, err
+	}
+	// loop protection headers cannot be removed by header rules
+	forwardReq.Header.Set(HopsHeader, strconv.Itoa(hopsOf(req.Header)+1))
+	forwardReq.Header.Add(ViaHeader, viaEntry(basket))
+	// sign the final payload
+	if config.Signing != nil {
+		if err = config.Signing.Sign(forwardReq, body); err != nil {
+			return nil, err
+		}
+	}
 
-	// forward request
-	response, err := client.Do(forwardReq)
-	if err != nil {
-		// HTTP issue during forwarding - HTTP 502 Bad Gateway
-		log.Printf("[warn] failed to forward request for basket: %s - %s", basket, err)
-		badGatewayResp := &http.Response{
-			StatusCode: http.StatusBadGateway,
-			Header:     http.Header{},
-			Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf("Failed to forward request: %s", err)))}
-		badGatewayResp.Header.Set("Content-Type", "text/plain")
+	return forwardReq, nil
+}
+
+// badGatewayResponse creates HTTP 502 response that explains why request is not forwarded
+func badGatewayResponse(err error) *http.Response {
+	badGatewayResp := &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(fmt.Sprintf("Failed to forward request: %s", err)))}
+	badGatewayResp.Header.Set("Content-Type", "text/plain")
+
+	return badGatewayResp
+}
+
+// unavailableResponse creates HTTP 503 response that explains why request is not forwarded to available target
+func unavailableResponse(err error) *http.Response {
+	response := badGatewayResponse(err)
+	response.StatusCode = http.StatusServiceUnavailable
+
+	return response
+}
 
-		return badGatewayResp, nil
+// Transform builds payload of forwarded request according to basket configuration, the original request is not
+// modified; returns nil if payload is not transformed
+func (req *RequestData) Transform(config BasketConfig) (*ForwardPayload, error) {
+	if !config.HasTransformation() {
+		return nil, nil
 	}
 
-	return response, nil
+	payload := &ForwardPayload{Method: req.Method, ContentType: req.Header.Get("Content-Type"), Body: req.Body}
+	if len(config.ForwardMethod) > 0 {
+		payload.Method = strings.ToUpper(config.ForwardMethod)
+	}
+	if len(config.ForwardContentType) > 0 {
+		payload.ContentType = config.ForwardContentType
+	}
+
+	if len(config.ForwardBodyTemplate) > 0 {
+		t, err := config.ParseBodyTemplate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid template of forwarded request body - %s", err)
+		}
+
+		var body strings.Builder
+		if err = t.Execute(&body, createTemplateData(req)); err != nil {
+			return nil, fmt.Errorf("failed to transform body of forwarded request - %s", err)
+		}
+		payload.Body = body.String()
+	}
+
+	return payload, nil
 }
 
 // forwardHeadersCleanup removes headers that may corrupt the underlying connection when forwarding request
@@ -212,10 +734,113 @@ func forwardHeadersCleanup(req *http.Request) {
 	// TODO: find out what else may break or corrupt the forwarding
 }
 
+// ParseValue parses the value of header rule as a template
+func (rule HeaderRule) ParseValue() (*template.Template, error) {
+	return template.New(rule.Name).Parse(rule.Value)
+}
+
+// applyHeaderRules adds, overrides or removes headers of forwarded request according to the rules,
+// "Host" header defines the host of forwarded request
+func applyHeaderRules(req *http.Request, rules []HeaderRule, original *RequestData) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	data := createTemplateData(original)
+	for _, rule := range rules {
+		isHost := http.CanonicalHeaderKey(rule.Name) == "Host"
+		if rule.Action == HeaderRuleRemove {
+			if !isHost {
+				req.Header.Del(rule.Name)
+			}
+			continue
+		}
+
+		t, err := rule.ParseValue()
+		if err != nil {
+			return fmt.Errorf("invalid value of header rule for %s - %s", rule.Name, err)
+		}
+
+		var value strings.Builder
+		if err = t.Execute(&value, data); err != nil {
+			return fmt.Errorf("failed to evaluate value of header rule for %s - %s", rule.Name, err)
+		}
+
+		if isHost {
+			req.Host = value.String()
+		} else if rule.Action == HeaderRuleAdd {
+			req.Header.Add(rule.Name, value.String())
+		} else {
+			req.Header.Set(rule.Name, value.String())
+		}
+	}
+
+	return nil
+}
+
 func expandURL(url string, original string, basket string) string {
 	return strings.TrimSuffix(url, "/") + strings.TrimPrefix(original, "/"+basket)
 }
 
+// compilePattern returns compiled regular expression of forward rule, patterns are compiled once and reused
+// by all requests
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := compiledPatterns.getOrCreate(pattern, func() (interface{}, error) {
+		return regexp.Compile(pattern)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return re.(*regexp.Regexp), nil
+}
+
+// matchPattern checks if value matches regular expression of forward rule
+func matchPattern(pattern string, value string) (bool, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(value), nil
+}
+
+// Matches checks if request matches all conditions of the forward rule.
+func (rule *ForwardRule) Matches(req *RequestData) (bool, error) {
+	if len(rule.Method) > 0 && !strings.EqualFold(rule.Method, req.Method) {
+		return false, nil
+	}
+
+	if len(rule.Path) > 0 {
+		if matches, err := matchPattern(rule.Path, req.Path); err != nil || !matches {
+			return false, err
+		}
+	}
+
+	for header, pattern := range rule.Headers {
+		values, found := req.Header[http.CanonicalHeaderKey(header)]
+		if !found {
+			return false, nil
+		}
+
+		matches := false
+		for _, value := range values {
+			m, err := matchPattern(pattern, value)
+			if err != nil {
+				return false, err
+			}
+			matches = matches || m
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+
+	if len(rule.Body) > 0 {
+		return matchPattern(rule.Body, req.Body)
+	}
+
+	return true, nil
+}
+
 // Matches checks if RequestData matches the search criterea.
 func (req *RequestData) Matches(query string, in string) bool {
 	// detect where to search
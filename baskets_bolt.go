@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,6 +18,18 @@ import (
 // DbTypeBolt defines name of Bolt database storage
 const DbTypeBolt = "bolt"
 
+// Versions of Bolt file layout: 1 - basket configuration is stored under separate keys of basket bucket with flags
+// packed into the options bitmask, 2 - basket configuration is stored as a versioned JSON document,
+// 3 - dates of basket creation and of the last collected request are kept in basket bucket,
+// 4 - statistics of all baskets are maintained in internal bucket
+const boltLayoutVersion = 4
+
+// boltConfigVersion defines the latest version of serialized basket configuration
+const boltConfigVersion = 1
+
+// boltInternalPrefix starts the names of internal buckets, it is not allowed in basket names
+const boltInternalPrefix = '/'
+
 const (
 	boltOptExpandPath = 1 << iota
 	boltOptInsecureTLS
@@ -22,16 +37,48 @@ const (
 )
 
 var (
-	boltKeyToken      = []byte("token")
-	boltKeyForwardURL = []byte("url")
-	boltKeyOptions    = []byte("opts")
-	boltKeyCapacity   = []byte("capacity")
-	boltKeyTotalCount = []byte("total")
-	boltKeyCount      = []byte("count")
-	boltKeyRequests   = []byte("requests")
-	boltKeyResponses  = []byte("responses")
+	boltBucketMeta     = []byte("/meta")
+	boltKeyVersion     = []byte("version")
+	boltKeyConfig      = []byte("config")
+	boltKeyToken       = []byte("token")
+	boltKeyForwardURL  = []byte("url")
+	boltKeyTargets     = []byte("targets")
+	boltKeyHeaders     = []byte("headers")
+	boltKeyBodyTpl     = []byte("bodytpl")
+	boltKeyMethod      = []byte("method")
+	boltKeyCType       = []byte("ctype")
+	boltKeyRules       = []byte("rules")
+	boltKeyTransport   = []byte("transport")
+	boltKeySigning     = []byte("signing")
+	boltKeyWebSocket   = []byte("websocket")
+	boltKeyBreaker     = []byte("breaker")
+	boltKeyRateLimit   = []byte("ratelimit")
+	boltKeyOptions     = []byte("opts")
+	boltKeyCapacity    = []byte("capacity")
+	boltKeyRetries     = []byte("retries")
+	boltKeyBackoff     = []byte("backoff")
+	boltKeyTotalCount  = []byte("total")
+	boltKeyCount       = []byte("count")
+	boltKeyCreated     = []byte("created")
+	boltKeyLastRequest = []byte("lastreq")
+	boltKeyLastKept    = []byte("lastkept")
+	boltKeyRequests    = []byte("requests")
+	boltKeyResponses   = []byte("responses")
+	boltKeyDeadLetters = []byte("deadletters")
+	boltKeyForwards    = []byte("forwards")
 )
 
+// boltLegacyConfigKeys are the keys of basket configuration in the first layout of Bolt file
+var boltLegacyConfigKeys = [][]byte{boltKeyForwardURL, boltKeyTargets, boltKeyHeaders, boltKeyBodyTpl, boltKeyMethod,
+	boltKeyCType, boltKeyRules, boltKeyTransport, boltKeySigning, boltKeyWebSocket, boltKeyBreaker, boltKeyRateLimit,
+	boltKeyOptions, boltKeyCapacity, boltKeyRetries, boltKeyBackoff}
+
+// boltConfigDocument is the serialized basket configuration
+type boltConfigDocument struct {
+	Version int          `json:"version"`
+	Config  BasketConfig `json:"config"`
+}
+
 func itob(i int) []byte {
 	b := make([]byte, 4)
 	binary.BigEndian.PutUint32(b, uint32(i))
@@ -42,21 +89,6 @@ func btoi(b []byte) int {
 	return int(binary.BigEndian.Uint32(b))
 }
 
-func toOpts(config BasketConfig) []byte {
-	opts := byte(0)
-	if config.ExpandPath {
-		opts |= boltOptExpandPath
-	}
-	if config.InsecureTLS {
-		opts |= boltOptInsecureTLS
-	}
-	if config.ProxyResponse {
-		opts |= boltOptProxyResponse
-	}
-
-	return []byte{opts}
-}
-
 func fromOpts(opts []byte, config *BasketConfig) {
 	if len(opts) > 0 {
 		config.ExpandPath = opts[0]&boltOptExpandPath != 0
@@ -69,6 +101,223 @@ func fromOpts(opts []byte, config *BasketConfig) {
 	}
 }
 
+func i64tob(i int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return b
+}
+
+func btoi64OrDefault(b []byte, defaultValue int64) int64 {
+	if len(b) < 8 {
+		return defaultValue
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func btoiOrDefault(b []byte, defaultValue int) int {
+	if len(b) < 4 {
+		return defaultValue
+	}
+	return btoi(b)
+}
+
+// isEmptyValue checks if optional value of basket config is undefined or empty
+func isEmptyValue(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// fromJSONBytes parses optional value of basket config from JSON
+func fromJSONBytes(data []byte, value interface{}, what string) {
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, value); err != nil {
+			log.Printf("[error] failed to parse %s: %s", what, err)
+		}
+	}
+}
+
+// toConfigDocument serializes basket configuration into versioned document
+func toConfigDocument(config BasketConfig) []byte {
+	data, err := json.Marshal(boltConfigDocument{boltConfigVersion, config})
+	if err != nil {
+		log.Printf("[error] failed to serialize basket config: %s", err)
+	}
+	return data
+}
+
+// fromConfigDocument parses versioned document with basket configuration
+func fromConfigDocument(data []byte, config *BasketConfig) error {
+	doc := boltConfigDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse basket config: %s", err)
+	}
+	if doc.Version > boltConfigVersion {
+		return fmt.Errorf("unsupported version of basket config: %d", doc.Version)
+	}
+
+	*config = doc.Config
+	return nil
+}
+
+// configOf reads configuration of basket from bucket
+func configOf(b *bolt.Bucket) (BasketConfig, error) {
+	config := BasketConfig{}
+	err := fromConfigDocument(b.Get(boltKeyConfig), &config)
+	return config, err
+}
+
+// legacyConfigOf reads configuration of basket stored in the first layout of Bolt file
+func legacyConfigOf(b *bolt.Bucket) BasketConfig {
+	config := BasketConfig{}
+	config.ForwardURL = string(b.Get(boltKeyForwardURL))
+	config.Capacity = btoiOrDefault(b.Get(boltKeyCapacity), 0)
+
+	fromOpts(b.Get(boltKeyOptions), &config)
+	fromJSONBytes(b.Get(boltKeyTargets), &config.ForwardTargets, "forward targets")
+	fromJSONBytes(b.Get(boltKeyHeaders), &config.HeaderRules, "header rules")
+	config.ForwardBodyTemplate = string(b.Get(boltKeyBodyTpl))
+	config.ForwardMethod = string(b.Get(boltKeyMethod))
+	config.ForwardContentType = string(b.Get(boltKeyCType))
+	fromJSONBytes(b.Get(boltKeyRules), &config.ForwardRules, "forward rules")
+	fromJSONBytes(b.Get(boltKeyTransport), &config.Transport, "transport settings")
+	fromJSONBytes(b.Get(boltKeySigning), &config.Signing, "signing settings")
+	fromJSONBytes(b.Get(boltKeyWebSocket), &config.WebSocket, "WebSocket settings")
+	fromJSONBytes(b.Get(boltKeyBreaker), &config.CircuitBreaker, "circuit breaker settings")
+	config.RateLimit = btoiOrDefault(b.Get(boltKeyRateLimit), 0)
+	config.RetryCount = btoiOrDefault(b.Get(boltKeyRetries), 0)
+	config.RetryBackoff = btoiOrDefault(b.Get(boltKeyBackoff), 0)
+
+	return config
+}
+
+// isBasketBucket checks if the bucket at root of Bolt file belongs to a basket
+func isBasketBucket(name []byte) bool {
+	return len(name) > 0 && name[0] != boltInternalPrefix
+}
+
+// basketNames returns the names of all baskets in Bolt file
+func basketNames(tx *bolt.Tx) [][]byte {
+	names := make([][]byte, 0)
+	cur := tx.Cursor()
+	for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
+		if isBasketBucket(key) {
+			names = append(names, append([]byte{}, key...))
+		}
+	}
+	return names
+}
+
+// List of upgrades of Bolt file layout, upgrade at index N upgrades the layout to version N+2
+var boltUpgrades = []func(tx *bolt.Tx) error{
+	upgradeToConfigDocument,
+	upgradeToActivityDates,
+	upgradeToStats}
+
+// upgradeToConfigDocument replaces separate keys of basket configuration with versioned document
+func upgradeToConfigDocument(tx *bolt.Tx) error {
+	for _, name := range basketNames(tx) {
+		b := tx.Bucket(name)
+		if err := b.Put(boltKeyConfig, toConfigDocument(legacyConfigOf(b))); err != nil {
+			return fmt.Errorf("failed to upgrade config of basket: %s - %s", name, err)
+		}
+		for _, key := range boltLegacyConfigKeys {
+			if err := b.Delete(key); err != nil {
+				return fmt.Errorf("failed to upgrade config of basket: %s - %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// upgradeToActivityDates records dates of basket activity, the creation date of existing baskets is unknown,
+// so the date of upgrade is recorded instead
+func upgradeToActivityDates(tx *bolt.Tx) error {
+	now := time.Now().UnixNano() / toMs
+	for _, name := range basketNames(tx) {
+		b := tx.Bucket(name)
+		if err := b.Put(boltKeyCreated, i64tob(now)); err != nil {
+			return fmt.Errorf("failed to upgrade basket: %s - %s", name, err)
+		}
+		if date := lastRequestDateOf(b); date > 0 {
+			if err := b.Put(boltKeyLastRequest, i64tob(date)); err != nil {
+				return fmt.Errorf("failed to upgrade basket: %s - %s", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lastRequestDateOf reads the date of the latest request that is kept in basket bucket, 0 if basket is empty;
+// it is used to upgrade and rebuild statistics, the date is kept in basket bucket afterwards
+func lastRequestDateOf(b *bolt.Bucket) int64 {
+	if reqs := b.Bucket(boltKeyRequests); reqs != nil {
+		if _, val := reqs.Cursor().Last(); val != nil {
+			var request struct {
+				Date int64 `json:"date"`
+			}
+			if err := json.Unmarshal(val, &request); err == nil {
+				return request.Date
+			}
+		}
+	}
+	return 0
+}
+
+// upgradeToStats collects statistics of existing baskets
+func upgradeToStats(tx *bolt.Tx) error {
+	_, err := rebuildStats(tx)
+	return err
+}
+
+// initLayout records the layout version of a new Bolt file or upgrades the layout of existing file,
+// files created before the layout is versioned have the first layout
+func initLayout(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltBucketMeta)
+		if err != nil {
+			return err
+		}
+
+		version := btoiOrDefault(meta.Get(boltKeyVersion), 0)
+		if version == 0 {
+			if len(basketNames(tx)) > 0 {
+				version = 1
+			} else {
+				// statistics of a new file are empty
+				if _, err = rebuildStats(tx); err != nil {
+					return err
+				}
+				version = boltLayoutVersion
+			}
+		}
+
+		if version > boltLayoutVersion {
+			return fmt.Errorf("layout version: %v is newer than supported version: %v, please upgrade the service",
+				version, boltLayoutVersion)
+		}
+
+		for ; version < boltLayoutVersion; version++ {
+			log.Printf("[info] upgrading Bolt database layout to version: %v", version+1)
+			if err = boltUpgrades[version-1](tx); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put(boltKeyVersion, itob(version))
+	})
+}
+
 /// Basket interface ///
 
 type boltBasket struct {
@@ -76,7 +325,13 @@ type boltBasket struct {
 	name string
 }
 
-func (basket *boltBasket) update(fn func(*bolt.Bucket) error) error {
+// update runs read-write transaction with the basket bucket, Bolt transactions cannot be interrupted,
+// so the context is only checked before transaction is started
+func (basket *boltBasket) update(ctx context.Context, fn func(*bolt.Bucket) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := basket.db.Update(func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(basket.name)); b != nil {
 			return fn(b)
@@ -85,13 +340,17 @@ func (basket *boltBasket) update(fn func(*bolt.Bucket) error) error {
 	})
 
 	if err != nil {
-		log.Printf("[error] %s; basket: %s", err, basket.name)
+		return fmt.Errorf("%s; basket: %s", err, basket.name)
 	}
-
-	return err
+	return nil
 }
 
-func (basket *boltBasket) view(fn func(*bolt.Bucket) error) error {
+// view runs read-only transaction with the basket bucket
+func (basket *boltBasket) view(ctx context.Context, fn func(*bolt.Bucket) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := basket.db.View(func(tx *bolt.Tx) error {
 		if b := tx.Bucket([]byte(basket.name)); b != nil {
 			return fn(b)
@@ -100,35 +359,35 @@ func (basket *boltBasket) view(fn func(*bolt.Bucket) error) error {
 	})
 
 	if err != nil {
-		log.Printf("[error] %s; basket: %s", err, basket.name)
+		return fmt.Errorf("%s; basket: %s", err, basket.name)
 	}
-
-	return err
+	return nil
 }
 
-func (basket *boltBasket) Config() BasketConfig {
+func (basket *boltBasket) Config(ctx context.Context) (BasketConfig, error) {
 	config := BasketConfig{}
 
-	basket.view(func(b *bolt.Bucket) error {
-		config.ForwardURL = string(b.Get(boltKeyForwardURL))
-		config.Capacity = btoi(b.Get(boltKeyCapacity))
-
-		fromOpts(b.Get(boltKeyOptions), &config)
-
-		return nil
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		var err error
+		config, err = configOf(b)
+		return err
 	})
 
-	return config
+	return config, err
 }
 
-func (basket *boltBasket) Update(config BasketConfig) {
-	basket.update(func(b *bolt.Bucket) error {
-		oldCap := btoi(b.Get(boltKeyCapacity))
+func (basket *boltBasket) Update(ctx context.Context, config BasketConfig) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		oldConfig, err := configOf(b)
+		if err != nil {
+			return err
+		}
+		oldCap := oldConfig.Capacity
 		curCount := btoi(b.Get(boltKeyCount))
 
-		b.Put(boltKeyForwardURL, []byte(config.ForwardURL))
-		b.Put(boltKeyOptions, toOpts(config))
-		b.Put(boltKeyCapacity, itob(config.Capacity))
+		if err = b.Put(boltKeyConfig, toConfigDocument(config)); err != nil {
+			return err
+		}
 
 		if oldCap != config.Capacity && curCount > config.Capacity {
 			// remove overflow requests
@@ -142,28 +401,34 @@ func (basket *boltBasket) Update(config BasketConfig) {
 			}
 
 			// update count
-			b.Put(boltKeyCount, itob(config.Capacity))
+			if err = setCount(b, config.Capacity); err != nil {
+				return err
+			}
+		}
+
+		if letters := b.Bucket(boltKeyDeadLetters); letters != nil {
+			shrinkBucket(letters, config.Capacity)
 		}
 
 		return nil
 	})
 }
 
-func (basket *boltBasket) Authorize(token string) bool {
+func (basket *boltBasket) Authorize(ctx context.Context, token string) (bool, error) {
 	result := false
 
-	basket.view(func(b *bolt.Bucket) error {
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
 		result = string(b.Get(boltKeyToken)) == token
 		return nil
 	})
 
-	return result
+	return result, err
 }
 
-func (basket *boltBasket) GetResponse(method string) *ResponseConfig {
+func (basket *boltBasket) GetResponse(ctx context.Context, method string) (*ResponseConfig, error) {
 	var response *ResponseConfig
 
-	basket.view(func(b *bolt.Bucket) error {
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
 		if resps := b.Bucket(boltKeyResponses); resps != nil {
 			if resp := resps.Get([]byte(method)); resp != nil {
 				// parse response configuration
@@ -177,11 +442,14 @@ func (basket *boltBasket) GetResponse(method string) *ResponseConfig {
 		return nil
 	})
 
-	return response
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
-func (basket *boltBasket) SetResponse(method string, response ResponseConfig) {
-	basket.update(func(b *bolt.Bucket) error {
+func (basket *boltBasket) SetResponse(ctx context.Context, method string, response ResponseConfig) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
 		respj, err := json.Marshal(response)
 		if err != nil {
 			return err
@@ -200,36 +468,46 @@ func (basket *boltBasket) SetResponse(method string, response ResponseConfig) {
 	})
 }
 
-func (basket *boltBasket) Add(req *http.Request) *RequestData {
-	data := ToRequestData(req)
-
-	basket.update(func(b *bolt.Bucket) error {
+func (basket *boltBasket) Add(ctx context.Context, data *RequestData) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
 		reqs := b.Bucket(boltKeyRequests)
+		name := []byte(basket.name)
 
 		dataj, err := json.Marshal(data)
 		if err != nil {
 			return err
 		}
 
+		if err = setLastRequestDate(b, name, data.Date); err != nil {
+			return err
+		}
+
 		key, _ := reqs.NextSequence()
 		err = reqs.Put(itob(int(key)), dataj)
 		if err != nil {
 			return err
 		}
 
+		config, err := configOf(b)
+		if err != nil {
+			return err
+		}
+
 		// update counters
-		cap := btoi(b.Get(boltKeyCapacity))
+		cap := config.Capacity
 		count := btoi(b.Get(boltKeyCount))
-		total := btoi(b.Get(boltKeyTotalCount))
 
 		// total count
-		total++
-		b.Put(boltKeyTotalCount, itob(total))
+		if err = incTotal(b, name); err != nil {
+			return err
+		}
+		b.Put(boltKeyLastRequest, i64tob(data.Date))
 
 		// current count (may not exceed capacity)
 		if count < cap {
-			count++
-			b.Put(boltKeyCount, itob(count))
+			if err = setCount(b, count+1); err != nil {
+				return err
+			}
 		} else {
 			// do not increase counter, just remove 1 entry
 			cur := reqs.Cursor()
@@ -244,42 +522,180 @@ func (basket *boltBasket) Add(req *http.Request) *RequestData {
 
 		return nil
 	})
+}
 
-	return data
+func (basket *boltBasket) AddForwardResult(ctx context.Context, id string, result *ForwardResult) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		reqs := b.Bucket(boltKeyRequests)
+
+		key, request, err := findRequest(reqs, id)
+		if err != nil || request == nil {
+			// request is already removed from the basket
+			return err
+		}
+
+		request.Forwards = append(request.Forwards, result)
+		dataj, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return reqs.Put(key, dataj)
+	})
+}
+
+func (basket *boltBasket) AddWebSocketFrames(ctx context.Context, id string, frames []*WebSocketFrame) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		reqs := b.Bucket(boltKeyRequests)
+
+		key, request, err := findRequest(reqs, id)
+		if err != nil || request == nil {
+			// request is already removed from the basket
+			return err
+		}
+
+		request.Frames = append(request.Frames, frames...)
+		dataj, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return reqs.Put(key, dataj)
+	})
+}
+
+func (basket *boltBasket) GetRequest(ctx context.Context, id string) (*RequestData, error) {
+	var result *RequestData
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		_, request, err := findRequest(b.Bucket(boltKeyRequests), id)
+		result = request
+		return err
+	})
+
+	return result, err
 }
 
-func (basket *boltBasket) Clear() {
-	basket.update(func(b *bolt.Bucket) error {
+// findRequest looks up for collected request by ID and returns its key in bucket
+func findRequest(reqs *bolt.Bucket, id string) ([]byte, *RequestData, error) {
+	// recent requests are accessed more often, so the lookup starts from the end
+	cur := reqs.Cursor()
+	for key, val := cur.Last(); key != nil; key, val = cur.Prev() {
+		if !bytes.Contains(val, []byte(id)) {
+			continue
+		}
+
+		request := new(RequestData)
+		if err := json.Unmarshal(val, request); err != nil {
+			return nil, nil, err
+		}
+		if request.ID == id {
+			return key, request, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func (basket *boltBasket) Clear(ctx context.Context) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		if err := setLastRequestDate(b, []byte(basket.name), 0); err != nil {
+			return err
+		}
+
 		err := b.DeleteBucket(boltKeyRequests)
 		if err != nil {
 			return err
 		}
 
 		// b.Put(boltKeyTotalCount, itob(0)) // reset total stats
-		b.Put(boltKeyCount, itob(0))
+		if err = setCount(b, 0); err != nil {
+			return err
+		}
 		b.CreateBucket(boltKeyRequests)
 
 		return nil
 	})
 }
 
-func (basket *boltBasket) Size() int {
-	result := -1
+func (basket *boltBasket) DeleteExpired(ctx context.Context, maxAge time.Duration) (int, error) {
+	before := expiryDate(maxAge)
+	deleted := 0
 
-	basket.view(func(b *bolt.Bucket) error {
+	err := basket.update(ctx, func(b *bolt.Bucket) error {
+		reqs := b.Bucket(boltKeyRequests)
+
+		// keys follow the order of collected requests, so the lookup stops at the first request that is not expired
+		expired := make([][]byte, 0)
+		cur := reqs.Cursor()
+		for key, val := cur.First(); key != nil; key, val = cur.Next() {
+			var request struct {
+				Date int64 `json:"date"`
+			}
+			if err := json.Unmarshal(val, &request); err != nil {
+				return err
+			}
+			if request.Date >= before {
+				break
+			}
+			expired = append(expired, key)
+		}
+
+		for _, key := range expired {
+			if err := reqs.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		if deleted = len(expired); deleted > 0 {
+			count := btoi(b.Get(boltKeyCount)) - deleted
+			if count < 0 {
+				count = 0
+			}
+			if err := setCount(b, count); err != nil {
+				return err
+			}
+			if key, _ := reqs.Cursor().First(); key == nil {
+				return setLastRequestDate(b, []byte(basket.name), 0)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+func (basket *boltBasket) Activity(ctx context.Context) (BasketActivity, error) {
+	activity := BasketActivity{}
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		activity.CreatedDate = btoi64OrDefault(b.Get(boltKeyCreated), 0)
+		activity.LastRequestDate = btoi64OrDefault(b.Get(boltKeyLastRequest), 0)
+		return nil
+	})
+
+	return activity, err
+}
+
+func (basket *boltBasket) Size(ctx context.Context) (int, error) {
+	result := 0
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
 		result = btoi(b.Get(boltKeyCount))
 
 		return nil
 	})
 
-	return result
+	return result, err
 }
 
-func (basket *boltBasket) GetRequests(max int, skip int) RequestsPage {
+func (basket *boltBasket) GetRequests(ctx context.Context, max int, skip int) (RequestsPage, error) {
 	last := skip + max
 	page := RequestsPage{make([]*RequestData, 0, max), 0, 0, false}
 
-	basket.view(func(b *bolt.Bucket) error {
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
 		page.TotalCount = btoi(b.Get(boltKeyTotalCount))
 		page.Count = btoi(b.Get(boltKeyCount))
 
@@ -302,13 +718,13 @@ func (basket *boltBasket) GetRequests(max int, skip int) RequestsPage {
 		return nil
 	})
 
-	return page
+	return page, err
 }
 
-func (basket *boltBasket) FindRequests(query string, in string, max int, skip int) RequestsQueryPage {
+func (basket *boltBasket) FindRequests(ctx context.Context, query string, in string, max int, skip int) (RequestsQueryPage, error) {
 	page := RequestsQueryPage{make([]*RequestData, 0, max), false}
 
-	basket.view(func(b *bolt.Bucket) error {
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
 		cur := b.Bucket(boltKeyRequests).Cursor()
 		skipped := 0
 		for key, val := cur.Last(); key != nil; key, val = cur.Prev() {
@@ -338,7 +754,198 @@ func (basket *boltBasket) FindRequests(query string, in string, max int, skip in
 		return nil
 	})
 
-	return page
+	return page, err
+}
+
+// bucketSize counts the number of entries in a bucket
+func bucketSize(b *bolt.Bucket) int {
+	size := 0
+	cur := b.Cursor()
+	for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
+		size++
+	}
+
+	return size
+}
+
+// shrinkBucket removes the oldest entries of a bucket to keep the number of entries up to specified size
+func shrinkBucket(b *bolt.Bucket, size int) {
+	cur := b.Cursor()
+	for remCount := bucketSize(b) - size; remCount > 0; remCount-- {
+		cur.First()
+		cur.Delete()
+	}
+}
+
+func (basket *boltBasket) AddDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		letterj, err := json.Marshal(letter)
+		if err != nil {
+			return err
+		}
+
+		letters, err := b.CreateBucketIfNotExists(boltKeyDeadLetters)
+		if err != nil {
+			return err
+		}
+
+		key, _ := letters.NextSequence()
+		if err = letters.Put(itob(int(key)), letterj); err != nil {
+			return err
+		}
+
+		// keep the number of undelivered requests up to basket capacity
+		config, err := configOf(b)
+		if err != nil {
+			return err
+		}
+		shrinkBucket(letters, config.Capacity)
+		return nil
+	})
+}
+
+// findDeadLetter looks up for undelivered request by ID and returns its key in bucket
+func findDeadLetter(letters *bolt.Bucket, id string) ([]byte, *DeadLetter, error) {
+	cur := letters.Cursor()
+	for key, val := cur.First(); key != nil; key, val = cur.Next() {
+		letter := new(DeadLetter)
+		if err := json.Unmarshal(val, letter); err != nil {
+			return nil, nil, err
+		}
+		if letter.ID == id {
+			return key, letter, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func (basket *boltBasket) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	var result *DeadLetter
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		if letters := b.Bucket(boltKeyDeadLetters); letters != nil {
+			_, letter, err := findDeadLetter(letters, id)
+			result = letter
+			return err
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+func (basket *boltBasket) GetDeadLetters(ctx context.Context, max int, skip int) (DeadLettersPage, error) {
+	last := skip + max
+	page := DeadLettersPage{make([]*DeadLetter, 0, max), 0, false}
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		letters := b.Bucket(boltKeyDeadLetters)
+		if letters == nil {
+			return nil
+		}
+
+		page.Count = bucketSize(letters)
+
+		cur := letters.Cursor()
+		index := 0
+		for key, val := cur.Last(); key != nil; key, val = cur.Prev() {
+			if index >= skip && index < last {
+				letter := new(DeadLetter)
+				if err := json.Unmarshal(val, letter); err != nil {
+					return err
+				}
+				page.DeadLetters = append(page.DeadLetters, letter)
+			} else if index >= last {
+				page.HasMore = true
+				break
+			}
+			index++
+		}
+
+		return nil
+	})
+
+	return page, err
+}
+
+func (basket *boltBasket) RemoveDeadLetter(ctx context.Context, id string) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		if letters := b.Bucket(boltKeyDeadLetters); letters != nil {
+			key, _, err := findDeadLetter(letters, id)
+			if err != nil || key == nil {
+				return err
+			}
+			return letters.Delete(key)
+		}
+		return nil
+	})
+}
+
+func (basket *boltBasket) ClearDeadLetters(ctx context.Context) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		if b.Bucket(boltKeyDeadLetters) != nil {
+			return b.DeleteBucket(boltKeyDeadLetters)
+		}
+		return nil
+	})
+}
+
+/// ForwardsStore interface ///
+
+func (basket *boltBasket) SaveForward(ctx context.Context, forward *PendingForward) error {
+	return basket.update(ctx, func(b *bolt.Bucket) error {
+		forwardj, err := json.Marshal(forward)
+		if err != nil {
+			return err
+		}
+
+		forwards, err := b.CreateBucketIfNotExists(boltKeyForwards)
+		if err != nil {
+			return err
+		}
+
+		return forwards.Put([]byte(forward.ID), forwardj)
+	})
+}
+
+func (basket *boltBasket) ClaimForward(ctx context.Context, id string) (bool, error) {
+	claimed := false
+	err := basket.update(ctx, func(b *bolt.Bucket) error {
+		if forwards := b.Bucket(boltKeyForwards); forwards != nil && forwards.Get([]byte(id)) != nil {
+			claimed = true
+			return forwards.Delete([]byte(id))
+		}
+		return nil
+	})
+	return claimed && err == nil, err
+}
+
+func (basket *boltBasket) GetPendingForwards(ctx context.Context) ([]*PendingForward, error) {
+	result := make([]*PendingForward, 0)
+
+	err := basket.view(ctx, func(b *bolt.Bucket) error {
+		if forwards := b.Bucket(boltKeyForwards); forwards != nil {
+			return forwards.ForEach(func(key []byte, val []byte) error {
+				forward := new(PendingForward)
+				if err := json.Unmarshal(val, forward); err != nil {
+					return err
+				}
+				result = append(result, forward)
+				return nil
+			})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// forwards are keyed by random IDs, restore the order in which they were queued
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+
+	return result, nil
 }
 
 /// BasketsDatabase interface ///
@@ -347,29 +954,33 @@ type boltDatabase struct {
 	db *bolt.DB
 }
 
-func (bdb *boltDatabase) Create(name string, config BasketConfig) (BasketAuth, error) {
+func (bdb *boltDatabase) Create(ctx context.Context, name string, config BasketConfig) (BasketAuth, error) {
 	auth := BasketAuth{}
 	token, err := GenerateToken()
 	if err != nil {
 		return auth, fmt.Errorf("failed to generate token: %s", err)
 	}
+	if err = ctx.Err(); err != nil {
+		return auth, err
+	}
 
 	err = bdb.db.Update(func(tx *bolt.Tx) error {
 		b, cerr := tx.CreateBucket([]byte(name))
-		if cerr != nil {
+		if cerr == bolt.ErrBucketExists {
+			return fmt.Errorf("failed to create basket: %s - %w", name, errBasketExists)
+		} else if cerr != nil {
 			return fmt.Errorf("failed to create basket: %s - %s", name, cerr)
 		}
 
 		// initialize basket bucket (assuming that no issues arose)
 		b.Put(boltKeyToken, []byte(token))
-		b.Put(boltKeyForwardURL, []byte(config.ForwardURL))
-		b.Put(boltKeyOptions, toOpts(config))
-		b.Put(boltKeyCapacity, itob(config.Capacity))
+		b.Put(boltKeyConfig, toConfigDocument(config))
 		b.Put(boltKeyTotalCount, itob(0))
 		b.Put(boltKeyCount, itob(0))
+		b.Put(boltKeyCreated, i64tob(time.Now().UnixNano()/toMs))
 		b.CreateBucket(boltKeyRequests)
 
-		return nil
+		return statsAdd(tx, []byte(name))
 	})
 
 	if err != nil {
@@ -381,55 +992,79 @@ func (bdb *boltDatabase) Create(name string, config BasketConfig) (BasketAuth, e
 	return auth, nil
 }
 
-func (bdb *boltDatabase) Get(name string) Basket {
-	err := bdb.db.View(func(tx *bolt.Tx) error {
-		if tx.Bucket([]byte(name)) != nil {
-			return nil
-		}
+func (bdb *boltDatabase) Get(ctx context.Context, name string) (Basket, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-		return fmt.Errorf("[warn] no basket found: %s", name)
+	exists := false
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		exists = isBasketBucket([]byte(name)) && tx.Bucket([]byte(name)) != nil
+		return nil
 	})
 
 	if err != nil {
-		log.Print(err)
-		return nil
+		return nil, fmt.Errorf("failed to get basket: %s - %s", name, err)
+	} else if !exists {
+		log.Printf("[warn] no basket found: %s", name)
+		return nil, nil
 	}
 
-	return &boltBasket{bdb.db, name}
+	return &boltBasket{bdb.db, name}, nil
 }
 
-func (bdb *boltDatabase) Delete(name string) {
+func (bdb *boltDatabase) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := bdb.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil || !isBasketBucket([]byte(name)) {
+			return bolt.ErrBucketNotFound
+		}
+		if err := statsRemove(tx, []byte(name), b); err != nil {
+			return err
+		}
 		return tx.DeleteBucket([]byte(name))
 	})
 
-	if err != nil {
-		log.Print(err)
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return fmt.Errorf("failed to delete basket: %s - %s", name, err)
 	}
+	return nil
 }
 
-func (bdb *boltDatabase) Size() int {
-	// TODO : introduce bucket with statistics (e.g. "/stats", or ".stats"), see https://github.com/boltdb/bolt/issues/276
+func (bdb *boltDatabase) Size(ctx context.Context) (int, error) {
 	size := 0
+	if err := ctx.Err(); err != nil {
+		return size, err
+	}
 
-	bdb.db.View(func(tx *bolt.Tx) error {
-		cur := tx.Cursor()
-		for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
-			size++
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		stats, err := statsOf(tx)
+		if err == nil {
+			size = getStat(stats, boltStatBaskets)
 		}
-		return nil
+		return err
 	})
 
-	return size
+	return size, err
 }
 
-func (bdb *boltDatabase) GetNames(max int, skip int) BasketNamesPage {
+func (bdb *boltDatabase) GetNames(ctx context.Context, max int, skip int) (BasketNamesPage, error) {
 	last := skip + max
 	page := BasketNamesPage{make([]string, 0, max), 0, false}
+	if err := ctx.Err(); err != nil {
+		return page, err
+	}
 
-	bdb.db.View(func(tx *bolt.Tx) error {
+	err := bdb.db.View(func(tx *bolt.Tx) error {
 		cur := tx.Cursor()
 		for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
+			if !isBasketBucket(key) {
+				continue
+			}
 			if page.Count >= skip && page.Count < last {
 				page.Names = append(page.Names, string(key))
 			} else if page.Count >= last {
@@ -441,19 +1076,22 @@ func (bdb *boltDatabase) GetNames(max int, skip int) BasketNamesPage {
 		return nil
 	})
 
-	return page
+	return page, err
 }
 
-func (bdb *boltDatabase) FindNames(query string, max int, skip int) BasketNamesQueryPage {
+func (bdb *boltDatabase) FindNames(ctx context.Context, query string, max int, skip int) (BasketNamesQueryPage, error) {
 	page := BasketNamesQueryPage{make([]string, 0, max), false}
+	if err := ctx.Err(); err != nil {
+		return page, err
+	}
 
-	bdb.db.View(func(tx *bolt.Tx) error {
+	err := bdb.db.View(func(tx *bolt.Tx) error {
 		skipped := 0
 		cur := tx.Cursor()
 		for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
 			// filter
 			name := string(key)
-			if strings.Contains(name, query) {
+			if isBasketBucket(key) && strings.Contains(name, query) {
 				if skipped < skip {
 					skipped++
 				} else {
@@ -464,7 +1102,8 @@ func (bdb *boltDatabase) FindNames(query string, max int, skip int) BasketNamesQ
 			// early exit
 			if len(page.Names) == max {
 				// check if there are more keys (basket names)
-				key, _ = cur.Next()
+				for key, _ = cur.Next(); key != nil && !isBasketBucket(key); key, _ = cur.Next() {
+				}
 				page.HasMore = key != nil
 				break
 			}
@@ -472,36 +1111,40 @@ func (bdb *boltDatabase) FindNames(query string, max int, skip int) BasketNamesQ
 		return nil
 	})
 
-	return page
+	return page, err
 }
 
-func (bdb *boltDatabase) GetStats(max int) DatabaseStats {
+func (bdb *boltDatabase) GetStats(ctx context.Context, max int) (DatabaseStats, error) {
 	stats := DatabaseStats{}
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
 
-	bdb.db.View(func(tx *bolt.Tx) error {
-		cur := tx.Cursor()
-		for key, _ := cur.First(); key != nil; key, _ = cur.Next() {
-			if b := tx.Bucket(key); b != nil {
-				var lastRequestDate int64
-				if _, val := b.Bucket(boltKeyRequests).Cursor().Last(); val != nil {
-					request := new(RequestData)
-					if err := json.Unmarshal(val, request); err == nil {
-						lastRequestDate = request.Date
-					}
-				}
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		counters, err := statsOf(tx)
+		if err != nil {
+			return err
+		}
 
-				stats.Collect(&BasketInfo{
-					Name:               string(key),
-					RequestsCount:      btoi(b.Get(boltKeyCount)),
-					RequestsTotalCount: btoi(b.Get(boltKeyTotalCount)),
-					LastRequestDate:    lastRequestDate}, max)
-			}
+		stats.BasketsCount = getStat(counters, boltStatBaskets)
+		stats.EmptyBasketsCount = getStat(counters, boltStatEmpty)
+		stats.RequestsCount = getStat(counters, boltStatRequests)
+		stats.RequestsTotalCount = getStat(counters, boltStatTotal)
+
+		stats.TopBasketsBySize = topBaskets(tx, counters, boltIndexBySize, max)
+		stats.TopBasketsByDate = topBaskets(tx, counters, boltIndexByDate, max)
+		if len(stats.TopBasketsBySize) > 0 {
+			stats.MaxBasketSize = stats.TopBasketsBySize[0].RequestsTotalCount
 		}
 		return nil
 	})
 
+	if err != nil {
+		return stats, err
+	}
+
 	stats.UpdateAvarage()
-	return stats
+	return stats, nil
 }
 
 func (bdb *boltDatabase) Release() {
@@ -522,5 +1165,11 @@ func NewBoltDatabase(file string) BasketsDatabase {
 		return nil
 	}
 
+	if err = initLayout(db); err != nil {
+		log.Printf("[error] failed to initialize layout of Bolt database: %s - %s", file, err)
+		db.Close()
+		return nil
+	}
+
 	return &boltDatabase{db}
 }
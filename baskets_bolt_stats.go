@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statistics of Bolt database are kept in internal bucket and updated within the same transactions that change
+// baskets, so counters are read without walking all baskets; baskets are indexed by the total number of collected
+// requests and by the date of the last kept request to find top baskets
+var (
+	boltBucketStats  = []byte("/stats")
+	boltStatBaskets  = []byte("baskets")
+	boltStatEmpty    = []byte("empty")
+	boltStatRequests = []byte("requests")
+	boltStatTotal    = []byte("total")
+	boltIndexBySize  = []byte("bysize")
+	boltIndexByDate  = []byte("bydate")
+)
+
+// indexKey returns the key of basket in statistics index, keys are ordered by the value first
+func indexKey(value int64, name []byte) []byte {
+	return append(i64tob(value), name...)
+}
+
+// statsOf returns the bucket with statistics of Bolt database
+func statsOf(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if stats := tx.Bucket(boltBucketStats); stats != nil {
+		return stats, nil
+	}
+	return nil, fmt.Errorf("failed to locate statistics bucket")
+}
+
+// addStat changes the counter of statistics by delta
+func addStat(stats *bolt.Bucket, key []byte, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	return stats.Put(key, i64tob(btoi64OrDefault(stats.Get(key), 0)+int64(delta)))
+}
+
+// getStat returns the counter of statistics
+func getStat(stats *bolt.Bucket, key []byte) int {
+	return int(btoi64OrDefault(stats.Get(key), 0))
+}
+
+// reindex moves basket in statistics index from the old value to the new value
+func reindex(stats *bolt.Bucket, index []byte, name []byte, old int64, value int64) error {
+	idx := stats.Bucket(index)
+	if idx == nil {
+		return fmt.Errorf("failed to locate statistics index: %s", index)
+	}
+	if err := idx.Delete(indexKey(old, name)); err != nil {
+		return err
+	}
+	return idx.Put(indexKey(value, name), []byte{})
+}
+
+// unindex removes basket from statistics index
+func unindex(stats *bolt.Bucket, index []byte, name []byte, value int64) error {
+	if idx := stats.Bucket(index); idx != nil {
+		return idx.Delete(indexKey(value, name))
+	}
+	return nil
+}
+
+// statsAdd accounts a new basket in statistics
+func statsAdd(tx *bolt.Tx, name []byte) error {
+	stats, err := statsOf(tx)
+	if err != nil {
+		return err
+	}
+
+	if err = addStat(stats, boltStatBaskets, 1); err != nil {
+		return err
+	}
+	if err = addStat(stats, boltStatEmpty, 1); err != nil {
+		return err
+	}
+	if err = reindex(stats, boltIndexBySize, name, 0, 0); err != nil {
+		return err
+	}
+	return reindex(stats, boltIndexByDate, name, 0, 0)
+}
+
+// statsRemove discounts the basket that is about to be deleted from statistics
+func statsRemove(tx *bolt.Tx, name []byte, b *bolt.Bucket) error {
+	stats, err := statsOf(tx)
+	if err != nil {
+		return err
+	}
+
+	total := btoi(b.Get(boltKeyTotalCount))
+	if err = addStat(stats, boltStatBaskets, -1); err != nil {
+		return err
+	}
+	if total == 0 {
+		if err = addStat(stats, boltStatEmpty, -1); err != nil {
+			return err
+		}
+	}
+	if err = addStat(stats, boltStatRequests, -btoi(b.Get(boltKeyCount))); err != nil {
+		return err
+	}
+	if err = addStat(stats, boltStatTotal, -total); err != nil {
+		return err
+	}
+	if err = unindex(stats, boltIndexBySize, name, int64(total)); err != nil {
+		return err
+	}
+	return unindex(stats, boltIndexByDate, name, lastKeptDateOf(b))
+}
+
+// setCount updates the number of kept requests of basket along with statistics
+func setCount(b *bolt.Bucket, count int) error {
+	stats, err := statsOf(b.Tx())
+	if err != nil {
+		return err
+	}
+
+	if err = addStat(stats, boltStatRequests, count-btoi(b.Get(boltKeyCount))); err != nil {
+		return err
+	}
+	return b.Put(boltKeyCount, itob(count))
+}
+
+// incTotal increments the total number of collected requests of basket along with statistics
+func incTotal(b *bolt.Bucket, name []byte) error {
+	stats, err := statsOf(b.Tx())
+	if err != nil {
+		return err
+	}
+
+	total := btoi(b.Get(boltKeyTotalCount))
+	if total == 0 {
+		if err = addStat(stats, boltStatEmpty, -1); err != nil {
+			return err
+		}
+	}
+	if err = addStat(stats, boltStatTotal, 1); err != nil {
+		return err
+	}
+	if err = reindex(stats, boltIndexBySize, name, int64(total), int64(total+1)); err != nil {
+		return err
+	}
+	return b.Put(boltKeyTotalCount, itob(total+1))
+}
+
+// lastKeptDateOf returns the date of the last kept request of basket, the value of basket in statistics index
+func lastKeptDateOf(b *bolt.Bucket) int64 {
+	return btoi64OrDefault(b.Get(boltKeyLastKept), 0)
+}
+
+// setLastRequestDate updates the date of the last kept request of basket along with statistics
+func setLastRequestDate(b *bolt.Bucket, name []byte, date int64) error {
+	old := lastKeptDateOf(b)
+	if old == date {
+		return nil
+	}
+
+	stats, err := statsOf(b.Tx())
+	if err != nil {
+		return err
+	}
+	if err = reindex(stats, boltIndexByDate, name, old, date); err != nil {
+		return err
+	}
+	return b.Put(boltKeyLastKept, i64tob(date))
+}
+
+// rebuildStats recreates statistics of Bolt database from all baskets, returns the number of baskets
+func rebuildStats(tx *bolt.Tx) (int, error) {
+	if err := tx.DeleteBucket(boltBucketStats); err != nil && err != bolt.ErrBucketNotFound {
+		return 0, err
+	}
+	stats, err := tx.CreateBucket(boltBucketStats)
+	if err != nil {
+		return 0, err
+	}
+	bySize, err := stats.CreateBucket(boltIndexBySize)
+	if err != nil {
+		return 0, err
+	}
+	byDate, err := stats.CreateBucket(boltIndexByDate)
+	if err != nil {
+		return 0, err
+	}
+
+	var baskets, empty, requests, total int
+	for _, name := range basketNames(tx) {
+		b := tx.Bucket(name)
+		count := btoi(b.Get(boltKeyCount))
+		totalCount := btoi(b.Get(boltKeyTotalCount))
+
+		baskets++
+		if totalCount == 0 {
+			empty++
+		}
+		requests += count
+		total += totalCount
+
+		if err = bySize.Put(indexKey(int64(totalCount), name), []byte{}); err != nil {
+			return 0, err
+		}
+		date := lastRequestDateOf(b)
+		if err = b.Put(boltKeyLastKept, i64tob(date)); err != nil {
+			return 0, err
+		}
+		if err = byDate.Put(indexKey(date, name), []byte{}); err != nil {
+			return 0, err
+		}
+	}
+
+	counters := []struct {
+		key   []byte
+		value int
+	}{{boltStatBaskets, baskets}, {boltStatEmpty, empty}, {boltStatRequests, requests}, {boltStatTotal, total}}
+	for _, counter := range counters {
+		if err = stats.Put(counter.key, i64tob(int64(counter.value))); err != nil {
+			return 0, err
+		}
+	}
+
+	return baskets, nil
+}
+
+// topBaskets collects baskets with the highest values of statistics index
+func topBaskets(tx *bolt.Tx, stats *bolt.Bucket, index []byte, max int) []*BasketInfo {
+	top := make([]*BasketInfo, 0, max)
+	idx := stats.Bucket(index)
+	if idx == nil {
+		return top
+	}
+
+	cur := idx.Cursor()
+	for key, _ := cur.Last(); key != nil && len(top) < max; key, _ = cur.Prev() {
+		name := key[8:]
+		if b := tx.Bucket(name); b != nil {
+			top = append(top, &BasketInfo{
+				Name:               string(name),
+				RequestsCount:      btoi(b.Get(boltKeyCount)),
+				RequestsTotalCount: btoi(b.Get(boltKeyTotalCount)),
+				LastRequestDate:    lastKeptDateOf(b)})
+		}
+	}
+	return top
+}
+
+// RebuildBoltStats recreates statistics of Bolt database to fix the drift of counters,
+// the database must not be used by running service
+func RebuildBoltStats(file string) error {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open Bolt database: %s - %s", file, err)
+	}
+	defer db.Close()
+
+	if err = initLayout(db); err != nil {
+		return fmt.Errorf("failed to initialize layout of Bolt database: %s - %s", file, err)
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		baskets, err := rebuildStats(tx)
+		if err == nil {
+			log.Printf("[info] rebuilt statistics of %d basket(s)", baskets)
+		}
+		return err
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func test_validateBoltStats(t *testing.T, db *testDatabase, baskets int, empty int, requests int, total int) {
+	stats := db.GetStats(10)
+	assert.Equal(t, baskets, db.Size(), "wrong database size")
+	assert.Equal(t, baskets, stats.BasketsCount, "wrong BasketsCount stats")
+	assert.Equal(t, empty, stats.EmptyBasketsCount, "wrong EmptyBasketsCount stats")
+	assert.Equal(t, requests, stats.RequestsCount, "wrong RequestsCount stats")
+	assert.Equal(t, total, stats.RequestsTotalCount, "wrong RequestsTotalCount stats")
+	assert.Len(t, stats.TopBasketsBySize, baskets, "wrong number of top baskets by size")
+	assert.Len(t, stats.TopBasketsByDate, baskets, "wrong number of top baskets by date")
+}
+
+func TestBoltDatabase_Stats(t *testing.T) {
+	name := "test168"
+	file := name + ".db"
+	db := testDB(t, NewBoltDatabase(file))
+	defer os.Remove(file)
+	defer db.Release()
+
+	test_validateBoltStats(t, db, 0, 0, 0, 0)
+
+	for i := 0; i < 3; i++ {
+		db.Create(fmt.Sprintf("%s_%v", name, i), BasketConfig{Capacity: 5})
+	}
+	test_validateBoltStats(t, db, 3, 3, 0, 0)
+
+	// collect requests beyond capacity
+	basket := db.Get(name + "_0")
+	for i := 0; i < 7; i++ {
+		basket.Add(createTestRequestData(fmt.Sprintf("/%v_0/%v", name, i), "data"))
+	}
+	db.Get(name + "_1").Add(createTestRequestData(fmt.Sprintf("/%v_1", name), "data"))
+	test_validateBoltStats(t, db, 3, 1, 6, 8)
+
+	stats := db.GetStats(1)
+	if assert.Len(t, stats.TopBasketsBySize, 1, "top basket by size is expected") {
+		test_validateBasketStats(t, stats.TopBasketsBySize[0], name+"_0", 5, 7)
+		assert.Equal(t, 7, stats.MaxBasketSize, "wrong MaxBasketSize stats")
+	}
+	if assert.Len(t, stats.TopBasketsByDate, 1, "top basket by date is expected") {
+		test_validateBasketStats(t, stats.TopBasketsByDate[0], name+"_1", 1, 1)
+	}
+
+	// shrink basket
+	basket.Update(BasketConfig{Capacity: 2})
+	test_validateBoltStats(t, db, 3, 1, 3, 8)
+
+	// delete expired requests
+	old := createTestRequestData(fmt.Sprintf("/%v_2", name), "old")
+	old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+	db.Get(name + "_2").Add(old)
+	test_validateBoltStats(t, db, 3, 0, 4, 9)
+	assert.Equal(t, 1, db.Get(name+"_2").DeleteExpired(time.Hour), "wrong number of deleted requests")
+	test_validateBoltStats(t, db, 3, 0, 3, 9)
+
+	// clear keeps total count
+	basket.Clear()
+	test_validateBoltStats(t, db, 3, 0, 1, 9)
+	stats = db.GetStats(1)
+	if assert.Len(t, stats.TopBasketsByDate, 1, "top basket by date is expected") {
+		test_validateBasketStats(t, stats.TopBasketsByDate[0], name+"_1", 1, 1)
+	}
+
+	// delete discounts basket
+	db.Delete(name + "_1")
+	test_validateBoltStats(t, db, 2, 0, 0, 8)
+	db.Delete(name + "_1")
+	test_validateBoltStats(t, db, 2, 0, 0, 8)
+}
+
+func TestBoltDatabase_Stats_InternalBucket(t *testing.T) {
+	name := "test169"
+	file := name + ".db"
+	db := testDB(t, NewBoltDatabase(file))
+	defer os.Remove(file)
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 5})
+	assert.Nil(t, db.Get(string(boltBucketStats)), "statistics bucket is not a basket")
+	assert.Empty(t, db.FindNames("stats", 10, 0).Names, "statistics bucket is not a basket")
+	assert.NoError(t, db.BasketsDatabase.Delete(context.Background(), string(boltBucketStats)),
+		"unknown basket is ignored")
+	test_validateBoltStats(t, db, 1, 1, 0, 0)
+}
+
+func TestBoltDatabase_Stats_LastKeptDate(t *testing.T) {
+	name := "test181"
+	file := name + ".db"
+	db := testDB(t, NewBoltDatabase(file))
+	defer os.Remove(file)
+	defer db.Release()
+
+	lastKept := func(basket string) (date int64) {
+		db.BasketsDatabase.(*boltDatabase).db.View(func(tx *bolt.Tx) error {
+			date = lastKeptDateOf(tx.Bucket([]byte(basket)))
+			return nil
+		})
+		return
+	}
+	topDates := func() []int64 {
+		dates := make([]int64, 0)
+		for _, info := range db.GetStats(10).TopBasketsByDate {
+			dates = append(dates, info.LastRequestDate)
+		}
+		return dates
+	}
+
+	db.Create(name+"_0", BasketConfig{Capacity: 5})
+	db.Create(name+"_1", BasketConfig{Capacity: 5})
+	now := time.Now().UnixNano() / toMs
+
+	old := createTestRequestData(fmt.Sprintf("/%v_0", name), "old")
+	old.Date = now - 2*time.Hour.Milliseconds()
+	db.Get(name + "_0").Add(old)
+	recent := createTestRequestData(fmt.Sprintf("/%v_1", name), "recent")
+	recent.Date = now - 1000
+	db.Get(name + "_1").Add(recent)
+
+	assert.Equal(t, old.Date, lastKept(name+"_0"), "wrong date of the last kept request")
+	assert.Equal(t, recent.Date, lastKept(name+"_1"), "wrong date of the last kept request")
+	assert.Equal(t, []int64{recent.Date, old.Date}, topDates(), "wrong top baskets by date")
+
+	// basket without requests is indexed with zero date
+	assert.Equal(t, 1, db.Get(name+"_0").DeleteExpired(time.Hour), "wrong number of deleted requests")
+	assert.Equal(t, int64(0), lastKept(name+"_0"), "date of the last kept request is not expected")
+	assert.Equal(t, []int64{recent.Date, 0}, topDates(), "wrong top baskets by date")
+
+	db.Get(name + "_1").Clear()
+	assert.Equal(t, int64(0), lastKept(name+"_1"), "date of the last kept request is not expected")
+	assert.Equal(t, []int64{0, 0}, topDates(), "wrong top baskets by date")
+
+	// dates are restored when statistics are rebuilt
+	db.Get(name + "_1").Add(recent)
+	db.BasketsDatabase.(*boltDatabase).db.Update(func(tx *bolt.Tx) error {
+		tx.Bucket([]byte(name + "_1")).Delete(boltKeyLastKept)
+		_, err := rebuildStats(tx)
+		return err
+	})
+	assert.Equal(t, recent.Date, lastKept(name+"_1"), "wrong date of the last kept request")
+	assert.Equal(t, []int64{recent.Date, 0}, topDates(), "wrong top baskets by date")
+}
+
+func TestRebuildBoltStats(t *testing.T) {
+	name := "test170"
+	file := name + ".db"
+	defer os.Remove(file)
+
+	db := testDB(t, NewBoltDatabase(file))
+	if !assert.NotNil(t, db, "Bolt database is expected with file name: %s", file) {
+		return
+	}
+	db.Create(name+"_0", BasketConfig{Capacity: 5})
+	db.Create(name+"_1", BasketConfig{Capacity: 5})
+	db.Get(name + "_1").Add(createTestRequestData(fmt.Sprintf("/%v_1", name), "data"))
+
+	// simulate drift of counters
+	db.BasketsDatabase.(*boltDatabase).db.Update(func(tx *bolt.Tx) error {
+		stats := tx.Bucket(boltBucketStats)
+		stats.Put(boltStatBaskets, i64tob(10))
+		stats.Put(boltStatRequests, i64tob(-3))
+		return stats.DeleteBucket(boltIndexByDate)
+	})
+	db.Release()
+
+	assert.NoError(t, RebuildBoltStats(file))
+
+	db = testDB(t, NewBoltDatabase(file))
+	if assert.NotNil(t, db, "Bolt database is expected with file name: %s", file) {
+		defer db.Release()
+		test_validateBoltStats(t, db, 2, 1, 1, 1)
+	}
+}
+
+func TestRebuildBoltStats_InvalidFile(t *testing.T) {
+	assert.Error(t, RebuildBoltStats("./test171/unknown.db"), "database file is not expected to be opened")
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"testing"
 	"time"
@@ -10,6 +13,38 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
+// toOpts is the reverse of fromOpts, it is used to prepare database content in tests
+func toOpts(config BasketConfig) []byte {
+	opts := byte(0)
+	if config.ExpandPath {
+		opts |= boltOptExpandPath
+	}
+	if config.InsecureTLS {
+		opts |= boltOptInsecureTLS
+	}
+	if config.ProxyResponse {
+		opts |= boltOptProxyResponse
+	}
+
+	return []byte{opts}
+}
+
+// toJSONBytes serializes optional value of basket config into JSON the way it was stored before database layout
+// was versioned, empty value is stored as empty array of bytes
+func toJSONBytes(value interface{}, what string) []byte {
+	if isEmptyValue(value) {
+		return []byte{}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[error] failed to serialize %s: %s", what, err)
+		return []byte{}
+	}
+
+	return data
+}
+
 func TestToOpts(t *testing.T) {
 	assert.Equal(t, []byte{0}, toOpts(BasketConfig{ExpandPath: false, InsecureTLS: false, ProxyResponse: false}), "wrong options")
 	assert.Equal(t, []byte{1}, toOpts(BasketConfig{ExpandPath: true, InsecureTLS: false, ProxyResponse: false}), "wrong options")
@@ -53,7 +88,7 @@ func TestFromOpts(t *testing.T) {
 
 func TestBoltDatabase_Create(t *testing.T) {
 	name := "test1"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -66,7 +101,7 @@ func TestBoltDatabase_Create(t *testing.T) {
 
 func TestBoltDatabase_Create_NameConflict(t *testing.T) {
 	name := "test2"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -81,7 +116,7 @@ func TestBoltDatabase_Create_NameConflict(t *testing.T) {
 
 func TestBoltDatabase_Get(t *testing.T) {
 	name := "test3"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -97,7 +132,7 @@ func TestBoltDatabase_Get(t *testing.T) {
 
 func TestBoltDatabase_Get_NotFound(t *testing.T) {
 	name := "test4"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -107,7 +142,7 @@ func TestBoltDatabase_Get_NotFound(t *testing.T) {
 
 func TestBoltDatabase_Delete(t *testing.T) {
 	name := "test5"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -120,7 +155,7 @@ func TestBoltDatabase_Delete(t *testing.T) {
 
 func TestBoltDatabase_Delete_Multi(t *testing.T) {
 	name := "test6"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -139,7 +174,7 @@ func TestBoltDatabase_Delete_Multi(t *testing.T) {
 }
 
 func TestBoltDatabase_Size(t *testing.T) {
-	db := NewBoltDatabase("test7.db")
+	db := testDB(t, NewBoltDatabase("test7.db"))
 	defer db.Release()
 	defer os.Remove("test7.db")
 
@@ -152,7 +187,7 @@ func TestBoltDatabase_Size(t *testing.T) {
 }
 
 func TestBoltDatabase_GetNames(t *testing.T) {
-	db := NewBoltDatabase("test8.db")
+	db := testDB(t, NewBoltDatabase("test8.db"))
 	defer db.Release()
 	defer os.Remove("test8.db")
 
@@ -181,7 +216,7 @@ func TestBoltDatabase_GetNames(t *testing.T) {
 }
 
 func TestBoltDatabase_FindNames(t *testing.T) {
-	db := NewBoltDatabase("test9.db")
+	db := testDB(t, NewBoltDatabase("test9.db"))
 	defer db.Release()
 	defer os.Remove("test9.db")
 
@@ -211,7 +246,7 @@ func TestBoltDatabase_FindNames(t *testing.T) {
 
 func TestBoltBasket_Add(t *testing.T) {
 	name := "test101"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -221,8 +256,9 @@ func TestBoltBasket_Add(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// add 1st HTTP request
 		content := "{ \"user\": \"tester\", \"age\": 24 }"
-		data := basket.Add(createTestPOSTRequest(
+		data := ToRequestData(createTestPOSTRequest(
 			fmt.Sprintf("http://localhost/%v/demo?name=abc&ver=12", name), content, "application/json"))
+		basket.Add(data)
 
 		assert.Equal(t, 1, basket.Size(), "wrong basket size")
 
@@ -231,14 +267,14 @@ func TestBoltBasket_Add(t *testing.T) {
 		assert.Equal(t, int64(len(content)), data.ContentLength, "wrong content length")
 
 		// add 2nd HTTP request
-		basket.Add(createTestPOSTRequest(fmt.Sprintf("http://localhost/%v/demo", name), "Hellow world", "text/plain"))
+		basket.Add(ToRequestData(createTestPOSTRequest(fmt.Sprintf("http://localhost/%v/demo", name), "Hellow world", "text/plain")))
 		assert.Equal(t, 2, basket.Size(), "wrong basket size")
 	}
 }
 
 func TestBoltBasket_Add_ExceedLimit(t *testing.T) {
 	name := "test102"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -248,8 +284,8 @@ func TestBoltBasket_Add_ExceedLimit(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 35; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 10, basket.Size(), "wrong basket size")
 	}
@@ -257,7 +293,7 @@ func TestBoltBasket_Add_ExceedLimit(t *testing.T) {
 
 func TestBoltBasket_Clear(t *testing.T) {
 	name := "test103"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -267,8 +303,8 @@ func TestBoltBasket_Clear(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 15; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 15, basket.Size(), "wrong basket size")
 
@@ -280,7 +316,7 @@ func TestBoltBasket_Clear(t *testing.T) {
 
 func TestBoltBasket_Update_Shrink(t *testing.T) {
 	name := "test104"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -290,8 +326,8 @@ func TestBoltBasket_Update_Shrink(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 25; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 25, basket.Size(), "wrong basket size")
 
@@ -305,7 +341,7 @@ func TestBoltBasket_Update_Shrink(t *testing.T) {
 
 func TestBoltBasket_GetRequests(t *testing.T) {
 	name := "test105"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -315,8 +351,8 @@ func TestBoltBasket_GetRequests(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 1; i <= 35; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain")))
 		}
 		assert.Equal(t, 25, basket.Size(), "wrong basket size")
 
@@ -340,7 +376,7 @@ func TestBoltBasket_GetRequests(t *testing.T) {
 
 func TestBoltBasket_FindRequests(t *testing.T) {
 	name := "test106"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -358,7 +394,7 @@ func TestBoltBasket_FindRequests(t *testing.T) {
 			if i <= 20 {
 				r.Header.Add("Muffin", "tasty")
 			}
-			basket.Add(r)
+			basket.Add(ToRequestData(r))
 		}
 		assert.Equal(t, 30, basket.Size(), "wrong basket size")
 
@@ -396,7 +432,7 @@ func TestBoltBasket_FindRequests(t *testing.T) {
 func TestBoltBasket_SetResponse(t *testing.T) {
 	name := "test107"
 	method := "POST"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -422,7 +458,7 @@ func TestBoltBasket_SetResponse(t *testing.T) {
 func TestBoltBasket_SetResponse_Update(t *testing.T) {
 	name := "test108"
 	method := "GET"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -446,7 +482,7 @@ func TestBoltBasket_SetResponse_Update(t *testing.T) {
 
 func TestBoltDatabase_GetStats(t *testing.T) {
 	name := "test130"
-	db := NewBoltDatabase(name + ".db")
+	db := testDB(t, NewBoltDatabase(name+".db"))
 	defer db.Release()
 	defer os.Remove(name + ".db")
 
@@ -458,8 +494,8 @@ func TestBoltDatabase_GetStats(t *testing.T) {
 		// fill basket
 		basket := db.Get(bname)
 		for j := 0; j < 9-i; j++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v?id=%v", bname, j), fmt.Sprintf("req%v", j), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v?id=%v", bname, j), fmt.Sprintf("req%v", j), "text/plain")))
 		}
 		time.Sleep(20 * time.Millisecond)
 	}
@@ -500,15 +536,15 @@ func TestBoltBasket_InvalidBasket(t *testing.T) {
 
 	// create a basket referring non-existing name in the database file
 	basket := &boltBasket{db, name}
-	// should print error in log file during update
-	basket.Clear()
-	// should print error in log file during view and return nil
-	assert.Nil(t, basket.GetResponse("GET"), "expected to fail and return nil")
+	assert.Error(t, basket.Clear(context.Background()), "expected to fail during update")
+	response, err := basket.GetResponse(context.Background(), "GET")
+	assert.Error(t, err, "expected to fail during view")
+	assert.Nil(t, response, "expected to fail and return nil")
 }
 
 func TestNewBoltDatabase_Error(t *testing.T) {
 	file := "test200.db"
-	db := NewBoltDatabase(file)
+	db := testDB(t, NewBoltDatabase(file))
 	if assert.NotNil(t, db, "Bolt database is expected with file name: %s", file) {
 		defer db.Release()
 		defer os.Remove(file)
@@ -516,3 +552,558 @@ func TestNewBoltDatabase_Error(t *testing.T) {
 		assert.Nil(t, NewBoltDatabase(file), "expected to fail and return nil")
 	}
 }
+
+func TestBoltBasket_Update_ForwardTargets(t *testing.T) {
+	name := "test140"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		config := basket.Config()
+		assert.Equal(t, []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}, config.ForwardTargets)
+
+		config.ForwardTargets = append(config.ForwardTargets, ForwardTarget{URL: "http://localhost:12345/b", ExpandPath: true, Primary: true})
+		basket.Update(config)
+		assert.Equal(t, config.ForwardTargets, basket.Config().ForwardTargets, "wrong forward targets")
+
+		config.ForwardTargets = nil
+		basket.Update(config)
+		assert.Empty(t, basket.Config().ForwardTargets, "forward targets are not expected")
+	}
+}
+
+func TestBoltBasket_DeadLetters(t *testing.T) {
+	name := "test141"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 2})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		for i := 0; i < 3; i++ {
+			basket.AddDeadLetter(&DeadLetter{ID: fmt.Sprintf("id%v", i), Date: int64(i), Attempts: 2, Error: "HTTP 503",
+				Target:  ForwardTarget{URL: "http://localhost:12345"},
+				Request: createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("test%v", i))})
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// basket capacity applies to undelivered requests
+		page := basket.GetDeadLetters(10, 0)
+		assert.Equal(t, 2, page.Count, "wrong number of dead letters")
+		assert.False(t, page.HasMore, "no more dead letters are expected")
+		if assert.Equal(t, 2, len(page.DeadLetters), "wrong number of dead letters") {
+			// newest come first
+			assert.Equal(t, "id2", page.DeadLetters[0].ID, "wrong dead letter")
+			assert.Equal(t, "id1", page.DeadLetters[1].ID, "wrong dead letter")
+			assert.Equal(t, "test2", page.DeadLetters[0].Request.Body, "wrong request body")
+		}
+
+		page = basket.GetDeadLetters(1, 0)
+		assert.True(t, page.HasMore, "more dead letters are expected")
+
+		if letter := basket.GetDeadLetter("id1"); assert.NotNil(t, letter, "dead letter is expected") {
+			assert.Equal(t, "http://localhost:12345", letter.Target.URL, "wrong target URL")
+			assert.Equal(t, 2, letter.Attempts, "wrong number of attempts")
+		}
+		assert.Nil(t, basket.GetDeadLetter("id0"), "evicted dead letter is not expected")
+
+		basket.RemoveDeadLetter("id1")
+		assert.Nil(t, basket.GetDeadLetter("id1"), "removed dead letter is not expected")
+		assert.Equal(t, 1, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+
+		basket.ClearDeadLetters()
+		assert.Equal(t, 0, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+	}
+}
+
+func TestBoltBasket_Update_Retries(t *testing.T) {
+	name := "test142"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20, RetryCount: 3, RetryBackoff: 500})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, 3, basket.Config().RetryCount, "wrong retry count")
+		assert.Equal(t, 500, basket.Config().RetryBackoff, "wrong retry backoff")
+
+		config := basket.Config()
+		config.RetryCount = 0
+		config.RetryBackoff = 2000
+		basket.Update(config)
+		assert.Equal(t, 0, basket.Config().RetryCount, "wrong retry count")
+		assert.Equal(t, 2000, basket.Config().RetryBackoff, "wrong retry backoff")
+	}
+}
+
+func TestBoltBasket_Forwards(t *testing.T) {
+	name := "test143"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		store, ok := basket.Basket.(ForwardsStore)
+		if assert.True(t, ok, "basket is expected to persist queued forwards") {
+			assert.Empty(t, pendingForwards(t, store), "no queued forwards are expected")
+
+			for i := 0; i < 3; i++ {
+				assert.NoError(t, store.SaveForward(context.Background(), &PendingForward{ID: fmt.Sprintf("fwd%v", i), Date: int64(i),
+					Target:  ForwardTarget{URL: "http://localhost:12345", ExpandPath: true},
+					Request: createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("test%v", i))}))
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			claimed, err := store.ClaimForward(context.Background(), "fwd1")
+			if assert.NoError(t, err) {
+				assert.True(t, claimed, "queued forward is expected to be claimed")
+			}
+			claimed, err = store.ClaimForward(context.Background(), "fwd1")
+			if assert.NoError(t, err) {
+				assert.False(t, claimed, "queued forward is not expected to be claimed twice")
+			}
+
+			forwards := pendingForwards(t, store)
+			if assert.Equal(t, 2, len(forwards), "wrong number of queued forwards") {
+				// queued order is preserved
+				assert.Equal(t, "fwd0", forwards[0].ID, "wrong queued forward")
+				assert.Equal(t, "fwd2", forwards[1].ID, "wrong queued forward")
+				assert.Equal(t, "test2", forwards[1].Request.Body, "wrong request body")
+				assert.True(t, forwards[1].Target.ExpandPath, "wrong value of Expand Path flag")
+			}
+		}
+	}
+}
+
+func TestBoltBasket_Update_HeaderRules(t *testing.T) {
+	name := "test144"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20, HeaderRules: []HeaderRule{{Action: HeaderRuleRemove, Name: "Cookie"}}})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, []HeaderRule{{Action: HeaderRuleRemove, Name: "Cookie"}}, basket.Config().HeaderRules, "wrong header rules")
+
+		config := basket.Config()
+		config.HeaderRules = []HeaderRule{
+			{Action: HeaderRuleSet, Name: "Authorization", Value: "Bearer 123"},
+			{Action: HeaderRuleAdd, Name: "X-Method", Value: "{{.method}}"}}
+		basket.Update(config)
+		assert.Equal(t, config.HeaderRules, basket.Config().HeaderRules, "wrong header rules")
+
+		config.HeaderRules = nil
+		basket.Update(config)
+		assert.Empty(t, basket.Config().HeaderRules, "header rules are not expected")
+	}
+}
+
+func TestBoltBasket_Update_Transformation(t *testing.T) {
+	name := "test145"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20, ForwardMethod: "PUT"})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, "PUT", basket.Config().ForwardMethod, "wrong forward method")
+		assert.Empty(t, basket.Config().ForwardBodyTemplate, "body template is not expected")
+
+		config := basket.Config()
+		config.ForwardMethod = ""
+		config.ForwardContentType = "application/json"
+		config.ForwardBodyTemplate = "{\"event\": \"{{.method}}\"}"
+		basket.Update(config)
+		assert.Empty(t, basket.Config().ForwardMethod, "forward method is not expected")
+		assert.Equal(t, "application/json", basket.Config().ForwardContentType, "wrong forward content type")
+		assert.Equal(t, "{\"event\": \"{{.method}}\"}", basket.Config().ForwardBodyTemplate, "wrong body template")
+	}
+}
+
+func TestBoltBasket_Update_ForwardRules(t *testing.T) {
+	name := "test146"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Empty(t, basket.Config().ForwardRules, "forward rules are not expected")
+
+		config := basket.Config()
+		config.ForwardRules = []ForwardRule{
+			{Name: "push", Headers: map[string]string{"X-GitHub-Event": "^push$"}, Targets: []ForwardTarget{{URL: "http://ci.local"}}},
+			{Name: "drop", Method: "GET", Drop: true}}
+		basket.Update(config)
+		assert.Equal(t, config.ForwardRules, basket.Config().ForwardRules, "wrong forward rules")
+
+		// fired rule is kept with collected request
+		data := createTestRequestData("/"+name, "test")
+		data.ForwardRule = "push"
+		basket.Add(data)
+		if page := basket.GetRequests(1, 0); assert.Equal(t, 1, len(page.Requests), "collected request is expected") {
+			assert.Equal(t, "push", page.Requests[0].ForwardRule, "wrong fired rule")
+		}
+	}
+}
+
+func TestBoltBasket_ForwardResults(t *testing.T) {
+	name := "test147"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		second := createTestRequestData("/"+name, "second")
+		basket.Add(second)
+
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200, Duration: 15, Body: "ok"})
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8081", Error: "connection refused"})
+		// unknown request is ignored
+		basket.AddForwardResult("abc", &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		page := basket.GetRequests(10, 0)
+		if assert.Equal(t, 2, len(page.Requests), "wrong number of requests") {
+			assert.Empty(t, page.Requests[0].Forwards, "results of forwarding are not expected")
+			if assert.Equal(t, 2, len(page.Requests[1].Forwards), "wrong number of forward results") {
+				assert.Equal(t, first.ID, page.Requests[1].ID, "wrong request ID")
+				assert.Equal(t, 200, page.Requests[1].Forwards[0].Status, "wrong status of target response")
+				assert.Equal(t, int64(15), page.Requests[1].Forwards[0].Duration, "wrong duration of forwarding")
+				assert.Equal(t, "ok", page.Requests[1].Forwards[0].Body, "wrong body of target response")
+				assert.Equal(t, "connection refused", page.Requests[1].Forwards[1].Error, "wrong error of forwarding")
+			}
+		}
+
+		// results are found with requests
+		found := basket.FindRequests("first", "body", 10, 0)
+		if assert.Equal(t, 1, len(found.Requests), "wrong number of found requests") {
+			assert.Equal(t, 2, len(found.Requests[0].Forwards), "wrong number of forward results")
+		}
+
+		// results are removed with requests
+		basket.Clear()
+		basket.Add(first)
+		assert.Empty(t, basket.GetRequests(10, 0).Requests[0].Forwards, "results of forwarding are not expected")
+	}
+}
+
+func TestBoltBasket_Update_Transport(t *testing.T) {
+	name := "test148"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().Transport, "transport settings are not expected")
+
+		follow := false
+		config := basket.Config()
+		config.Transport = &TransportConfig{ConnectTimeout: 1000, Timeout: 5000, FollowRedirects: &follow}
+		basket.Update(config)
+		if transport := basket.Config().Transport; assert.NotNil(t, transport, "transport settings are expected") {
+			assert.Equal(t, 1000, transport.ConnectTimeout, "wrong connect timeout")
+			assert.Equal(t, 0, transport.TLSTimeout, "TLS handshake timeout is not expected")
+			assert.Equal(t, 5000, transport.Timeout, "wrong timeout")
+			if assert.NotNil(t, transport.FollowRedirects, "redirects policy is expected") {
+				assert.False(t, *transport.FollowRedirects, "wrong redirects policy")
+			}
+			assert.Nil(t, transport.HTTP2, "HTTP/2 preference is not expected")
+		}
+
+		config.Transport = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().Transport, "transport settings are not expected")
+	}
+}
+
+func TestBoltBasket_Update_Signing(t *testing.T) {
+	name := "test149"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().Signing, "signing settings are not expected")
+
+		config := basket.Config()
+		config.Signing = &SigningConfig{Header: "X-Signature", Secret: "secret"}
+		basket.Update(config)
+		if signing := basket.Config().Signing; assert.NotNil(t, signing, "signing settings are expected") {
+			assert.Equal(t, "X-Signature", signing.Header, "wrong signature header")
+			assert.Equal(t, "secret", signing.Secret, "wrong signing secret")
+			assert.Empty(t, signing.Algorithm, "signing algorithm is not expected")
+		}
+
+		config.Signing = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().Signing, "signing settings are not expected")
+	}
+}
+
+func TestBoltBasket_GetRequest(t *testing.T) {
+	name := "test150"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		basket.Add(createTestRequestData("/"+name, "second"))
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		if request := basket.GetRequest(first.ID); assert.NotNil(t, request, "request is expected") {
+			assert.Equal(t, first.ID, request.ID, "wrong request ID")
+			assert.Equal(t, "first", request.Body, "wrong request body")
+			assert.Equal(t, 1, len(request.Forwards), "result of forwarding is expected")
+		}
+		assert.Nil(t, basket.GetRequest("abc"), "unknown request is not expected")
+	}
+}
+
+func TestBoltBasket_AddWebSocketFrames(t *testing.T) {
+	name := "test151"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		request := createTestRequestData("/"+name, "")
+		basket.Add(request)
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{
+			{Direction: WebSocketIn, Type: "text", Final: true, Size: 5, Data: "hello"},
+			{Direction: WebSocketOut, Type: "text", Final: true, Size: 5, Data: "hello"}})
+		basket.AddForwardResult(request.ID, &ForwardResult{URL: "http://localhost:8080", Status: 101})
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{{Direction: WebSocketIn, Type: "close", Final: true, Size: 2, Data: "1000 "}})
+		basket.AddWebSocketFrames("abc", []*WebSocketFrame{{Direction: WebSocketIn, Type: "ping", Final: true}})
+
+		if saved := basket.GetRequest(request.ID); assert.NotNil(t, saved, "request is expected") {
+			if assert.Equal(t, 3, len(saved.Frames), "WebSocket frames are expected") {
+				assert.Equal(t, "hello", saved.Frames[0].Data, "wrong data of frame")
+				assert.Equal(t, WebSocketOut, saved.Frames[1].Direction, "wrong direction of frame")
+				assert.Equal(t, "close", saved.Frames[2].Type, "wrong type of frame")
+			}
+			assert.Equal(t, 1, len(saved.Forwards), "result of forwarding is expected to be kept")
+		}
+		assert.Equal(t, 3, len(basket.GetRequests(10, 0).Requests[0].Frames), "frames are expected with collected request")
+	}
+}
+
+func TestBoltBasket_Update_CircuitBreaker(t *testing.T) {
+	name := "test152"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20, RateLimit: 5})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().CircuitBreaker, "circuit breaker settings are not expected")
+		assert.Equal(t, 5, basket.Config().RateLimit, "wrong rate limit")
+
+		config := basket.Config()
+		config.CircuitBreaker = &CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: 60000}
+		config.RateLimit = 0
+		basket.Update(config)
+		if breaker := basket.Config().CircuitBreaker; assert.NotNil(t, breaker, "circuit breaker settings are expected") {
+			assert.Equal(t, 3, breaker.FailureThreshold, "wrong failure threshold")
+			assert.Equal(t, 60000, breaker.OpenDuration, "wrong open duration")
+		}
+		assert.Equal(t, 0, basket.Config().RateLimit, "rate limit is not expected")
+
+		config.CircuitBreaker = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().CircuitBreaker, "circuit breaker settings are not expected")
+	}
+}
+
+func TestBoltDatabase_UpgradeLayout(t *testing.T) {
+	name := "test153"
+	file := name + ".db"
+	defer os.Remove(file)
+
+	// database file created before the layout is versioned
+	bdb, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if !assert.NoError(t, err) {
+		return
+	}
+	bdb.Update(func(tx *bolt.Tx) error {
+		b, _ := tx.CreateBucket([]byte(name))
+		b.Put(boltKeyToken, []byte("abc"))
+		b.Put(boltKeyForwardURL, []byte("http://localhost:8080"))
+		b.Put(boltKeyOptions, toOpts(BasketConfig{ExpandPath: true, ProxyResponse: true}))
+		b.Put(boltKeyTargets, toJSONBytes([]ForwardTarget{{URL: "http://localhost:9090"}}, "forward targets"))
+		b.Put(boltKeyMethod, []byte("PUT"))
+		b.Put(boltKeyCapacity, itob(15))
+		b.Put(boltKeyRetries, itob(3))
+		b.Put(boltKeyTotalCount, itob(0))
+		b.Put(boltKeyCount, itob(0))
+		b.CreateBucket(boltKeyRequests)
+		return nil
+	})
+	bdb.Close()
+
+	db := testDB(t, NewBoltDatabase(file))
+	if assert.NotNil(t, db, "Bolt database is expected to be upgraded") {
+		defer db.Release()
+
+		// internal buckets are not baskets
+		assert.Equal(t, 1, db.Size(), "wrong number of baskets")
+		assert.Equal(t, []string{name}, db.GetNames(10, 0).Names, "wrong basket names")
+		assert.Empty(t, db.FindNames("meta", 10, 0).Names, "internal buckets are not expected")
+		assert.Nil(t, db.Get(string(boltBucketMeta)), "internal buckets are not expected")
+
+		basket := db.Get(name)
+		if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+			assert.True(t, basket.Authorize("abc"), "basket authorization has failed")
+			config := basket.Config()
+			assert.Equal(t, "http://localhost:8080", config.ForwardURL, "wrong forward URL")
+			if assert.Len(t, config.ForwardTargets, 1, "forward target is expected") {
+				assert.Equal(t, "http://localhost:9090", config.ForwardTargets[0].URL, "wrong forward target")
+			}
+			assert.Equal(t, "PUT", config.ForwardMethod, "wrong forward method")
+			assert.Equal(t, 15, config.Capacity, "wrong capacity")
+			assert.Equal(t, 3, config.RetryCount, "wrong retry count")
+			assert.True(t, config.ExpandPath, "wrong value of Expand Path flag")
+			assert.True(t, config.ProxyResponse, "wrong value of Proxy Response flag")
+			assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
+			// creation date of existing basket is the date of upgrade
+			assert.InDelta(t, time.Now().UnixNano()/toMs, basket.Activity().CreatedDate, 5000, "wrong creation date")
+		}
+
+		db.BasketsDatabase.(*boltDatabase).db.View(func(tx *bolt.Tx) error {
+			assert.Equal(t, boltLayoutVersion, btoi(tx.Bucket(boltBucketMeta).Get(boltKeyVersion)), "wrong layout version")
+			b := tx.Bucket([]byte(name))
+			assert.NotNil(t, b.Get(boltKeyConfig), "basket config document is expected")
+			for _, key := range boltLegacyConfigKeys {
+				assert.Nil(t, b.Get(key), "legacy key: %s is not expected", key)
+			}
+			return nil
+		})
+	}
+}
+
+func TestBoltDatabase_NewerLayout(t *testing.T) {
+	name := "test154"
+	file := name + ".db"
+	defer os.Remove(file)
+
+	db := testDB(t, NewBoltDatabase(file))
+	if assert.NotNil(t, db, "Bolt database is expected with file name: %s", file) {
+		db.Create(name, BasketConfig{Capacity: 20})
+		db.BasketsDatabase.(*boltDatabase).db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltBucketMeta).Put(boltKeyVersion, itob(boltLayoutVersion+1))
+		})
+		db.Release()
+
+		assert.Nil(t, NewBoltDatabase(file), "service is not expected to use newer layout of Bolt file")
+	}
+}
+
+func TestFromConfigDocument(t *testing.T) {
+	config := BasketConfig{}
+	if assert.NoError(t, fromConfigDocument(toConfigDocument(BasketConfig{Capacity: 25, ExpandPath: true}), &config)) {
+		assert.Equal(t, 25, config.Capacity, "wrong capacity")
+		assert.True(t, config.ExpandPath, "wrong value of Expand Path flag")
+	}
+
+	assert.Error(t, fromConfigDocument([]byte("{"), &config), "invalid document is not expected to be parsed")
+	assert.Error(t, fromConfigDocument([]byte(`{"version": 100, "config": {}}`), &config),
+		"document of newer version is not expected to be parsed")
+}
+
+func TestBoltBasket_DeleteExpired(t *testing.T) {
+	name := "test157"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// collect outdated request
+		old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+		old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+		basket.Add(old)
+		// collect recent requests
+		for i := 0; i < 3; i++ {
+			basket.Add(createTestRequestData(fmt.Sprintf("/%v/new", name), fmt.Sprintf("test%v", i)))
+		}
+		assert.Equal(t, 4, basket.Size(), "wrong basket size")
+
+		assert.Equal(t, 0, basket.DeleteExpired(3*time.Hour), "no expired requests are expected")
+		assert.Equal(t, 1, basket.DeleteExpired(time.Hour), "wrong number of deleted requests")
+		assert.Equal(t, 3, basket.Size(), "wrong basket size")
+		page := basket.GetRequests(10, 0)
+		assert.Equal(t, 3, page.Count, "wrong requests count")
+		assert.Equal(t, 4, page.TotalCount, "wrong total count")
+		for _, request := range page.Requests {
+			assert.Equal(t, fmt.Sprintf("/%v/new", name), request.Path, "outdated request is not expected")
+		}
+	}
+}
+
+func TestBoltBasket_Activity(t *testing.T) {
+	name := "test163"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		activity := basket.Activity()
+		assert.InDelta(t, time.Now().UnixNano()/toMs, activity.CreatedDate, 5000, "wrong creation date")
+		assert.Zero(t, activity.LastRequestDate, "no requests are expected")
+
+		request := createTestRequestData(fmt.Sprintf("/%v/demo", name), "test")
+		request.Date -= 1000
+		basket.Add(request)
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+
+		// date of the last request is kept even if requests are deleted
+		basket.Clear()
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+		assert.Equal(t, activity.CreatedDate, basket.Activity().CreatedDate, "creation date is not expected to change")
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
 	"sync"
+	"time"
 )
 
 // DbTypeMemory defines name of in-memory database storage
@@ -15,11 +16,13 @@ const DbTypeMemory = "mem"
 
 type memoryBasket struct {
 	sync.RWMutex
-	token      string
-	config     BasketConfig
-	requests   []*RequestData
-	totalCount int
-	responses  map[string]*ResponseConfig
+	token       string
+	config      BasketConfig
+	requests    []*RequestData
+	totalCount  int
+	responses   map[string]*ResponseConfig
+	deadLetters []*DeadLetter
+	activity    BasketActivity
 }
 
 func (basket *memoryBasket) applyLimit() {
@@ -27,76 +30,157 @@ func (basket *memoryBasket) applyLimit() {
 	if len(basket.requests) > basket.config.Capacity {
 		basket.requests = basket.requests[:basket.config.Capacity]
 	}
+	// Same limit applies to undelivered requests
+	if len(basket.deadLetters) > basket.config.Capacity {
+		basket.deadLetters = basket.deadLetters[:basket.config.Capacity]
+	}
 }
 
-func (basket *memoryBasket) Config() BasketConfig {
-	return basket.config
+func (basket *memoryBasket) Config(ctx context.Context) (BasketConfig, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	return basket.config, nil
 }
 
-func (basket *memoryBasket) Update(config BasketConfig) {
+func (basket *memoryBasket) Update(ctx context.Context, config BasketConfig) error {
 	basket.Lock()
 	defer basket.Unlock()
 
 	basket.config = config
 	basket.applyLimit()
+	return nil
 }
 
-func (basket *memoryBasket) Authorize(token string) bool {
-	return token == basket.token
+func (basket *memoryBasket) Authorize(ctx context.Context, token string) (bool, error) {
+	return token == basket.token, nil
 }
 
-func (basket *memoryBasket) GetResponse(method string) *ResponseConfig {
-	basket.Lock()
-	defer basket.Unlock()
+func (basket *memoryBasket) GetResponse(ctx context.Context, method string) (*ResponseConfig, error) {
+	basket.RLock()
+	defer basket.RUnlock()
 
 	if response, exists := basket.responses[method]; exists {
-		return response
+		return response, nil
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (basket *memoryBasket) SetResponse(method string, response ResponseConfig) {
+func (basket *memoryBasket) SetResponse(ctx context.Context, method string, response ResponseConfig) error {
 	basket.Lock()
 	defer basket.Unlock()
 
 	basket.responses[method] = &response
+	return nil
 }
 
-func (basket *memoryBasket) Add(req *http.Request) *RequestData {
+func (basket *memoryBasket) Add(ctx context.Context, data *RequestData) error {
 	basket.Lock()
 	defer basket.Unlock()
 
-	data := ToRequestData(req)
 	// insert in front of collection
 	basket.requests = append([]*RequestData{data}, basket.requests...)
 
-	// keep total number of all collected requests
+	// keep total number of all collected requests and the date of the last one
 	basket.totalCount++
+	basket.activity.LastRequestDate = data.Date
 	// apply limits according to basket capacity
 	basket.applyLimit()
+	return nil
+}
+
+func (basket *memoryBasket) AddForwardResult(ctx context.Context, id string, result *ForwardResult) error {
+	basket.Lock()
+	defer basket.Unlock()
 
-	return data
+	for index, request := range basket.requests {
+		if request.ID == id {
+			// replace collected request with a copy, the original request may be read by others
+			updated := *request
+			updated.Forwards = append(append(make([]*ForwardResult, 0, len(request.Forwards)+1), request.Forwards...), result)
+			basket.requests[index] = &updated
+			break
+		}
+	}
+	return nil
 }
 
-func (basket *memoryBasket) Clear() {
+func (basket *memoryBasket) AddWebSocketFrames(ctx context.Context, id string, frames []*WebSocketFrame) error {
+	basket.Lock()
+	defer basket.Unlock()
+
+	for index, request := range basket.requests {
+		if request.ID == id {
+			// replace collected request with a copy, the original request may be read by others
+			updated := *request
+			updated.Frames = append(append(make([]*WebSocketFrame, 0, len(request.Frames)+len(frames)), request.Frames...), frames...)
+			basket.requests[index] = &updated
+			break
+		}
+	}
+	return nil
+}
+
+func (basket *memoryBasket) GetRequest(ctx context.Context, id string) (*RequestData, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	for _, request := range basket.requests {
+		if request.ID == id {
+			return request, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (basket *memoryBasket) Clear(ctx context.Context) error {
 	basket.Lock()
 	defer basket.Unlock()
 
 	// reset collected requests and total counter
 	basket.requests = make([]*RequestData, 0, basket.config.Capacity)
 	// basket.totalCount = 0 // reset total stats
+	return nil
 }
 
-func (basket *memoryBasket) Size() int {
-	return len(basket.requests)
+func (basket *memoryBasket) DeleteExpired(ctx context.Context, maxAge time.Duration) (int, error) {
+	before := expiryDate(maxAge)
+
+	basket.Lock()
+	defer basket.Unlock()
+
+	// requests are kept from the newest to the oldest one
+	size := len(basket.requests)
+	keep := size
+	for keep > 0 && basket.requests[keep-1].Date < before {
+		keep--
+	}
+	basket.requests = basket.requests[:keep]
+
+	return size - keep, nil
 }
 
-func (basket *memoryBasket) GetRequests(max int, skip int) RequestsPage {
+func (basket *memoryBasket) Activity(ctx context.Context) (BasketActivity, error) {
 	basket.RLock()
 	defer basket.RUnlock()
 
-	size := basket.Size()
+	return basket.activity, nil
+}
+
+func (basket *memoryBasket) Size(ctx context.Context) (int, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	return len(basket.requests), nil
+}
+
+func (basket *memoryBasket) GetRequests(ctx context.Context, max int, skip int) (RequestsPage, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	size := len(basket.requests)
 	last := skip + max
 
 	requestsPage := RequestsPage{
@@ -111,10 +195,10 @@ func (basket *memoryBasket) GetRequests(max int, skip int) RequestsPage {
 		requestsPage.Requests = basket.requests[skip:last]
 	}
 
-	return requestsPage
+	return requestsPage, nil
 }
 
-func (basket *memoryBasket) FindRequests(query string, in string, max int, skip int) RequestsQueryPage {
+func (basket *memoryBasket) FindRequests(ctx context.Context, query string, in string, max int, skip int) (RequestsQueryPage, error) {
 	basket.RLock()
 	defer basket.RUnlock()
 
@@ -133,12 +217,78 @@ func (basket *memoryBasket) FindRequests(query string, in string, max int, skip
 
 		// early exit
 		if len(result) == max {
-			return RequestsQueryPage{Requests: result, HasMore: index < len(basket.requests)-1}
+			return RequestsQueryPage{Requests: result, HasMore: index < len(basket.requests)-1}, nil
 		}
 	}
 
 	// whole basket is scanned through
-	return RequestsQueryPage{Requests: result, HasMore: false}
+	return RequestsQueryPage{Requests: result, HasMore: false}, nil
+}
+
+func (basket *memoryBasket) AddDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	basket.Lock()
+	defer basket.Unlock()
+
+	// insert in front of collection
+	basket.deadLetters = append([]*DeadLetter{letter}, basket.deadLetters...)
+	basket.applyLimit()
+	return nil
+}
+
+func (basket *memoryBasket) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	for _, letter := range basket.deadLetters {
+		if letter.ID == id {
+			return letter, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (basket *memoryBasket) GetDeadLetters(ctx context.Context, max int, skip int) (DeadLettersPage, error) {
+	basket.RLock()
+	defer basket.RUnlock()
+
+	size := len(basket.deadLetters)
+	last := skip + max
+
+	page := DeadLettersPage{
+		DeadLetters: make([]*DeadLetter, 0, max),
+		Count:       size,
+		HasMore:     last < size}
+
+	if skip < size {
+		if last > size {
+			last = size
+		}
+		page.DeadLetters = append(page.DeadLetters, basket.deadLetters[skip:last]...)
+	}
+
+	return page, nil
+}
+
+func (basket *memoryBasket) RemoveDeadLetter(ctx context.Context, id string) error {
+	basket.Lock()
+	defer basket.Unlock()
+
+	for i, letter := range basket.deadLetters {
+		if letter.ID == id {
+			basket.deadLetters = append(basket.deadLetters[:i], basket.deadLetters[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (basket *memoryBasket) ClearDeadLetters(ctx context.Context) error {
+	basket.Lock()
+	defer basket.Unlock()
+
+	basket.deadLetters = nil
+	return nil
 }
 
 /// BasketsDatabase interface ///
@@ -149,7 +299,7 @@ type memoryDatabase struct {
 	names   []string
 }
 
-func (db *memoryDatabase) Create(name string, config BasketConfig) (BasketAuth, error) {
+func (db *memoryDatabase) Create(ctx context.Context, name string, config BasketConfig) (BasketAuth, error) {
 	auth := BasketAuth{}
 	token, err := GenerateToken()
 	if err != nil {
@@ -161,7 +311,7 @@ func (db *memoryDatabase) Create(name string, config BasketConfig) (BasketAuth,
 
 	_, exists := db.baskets[name]
 	if exists {
-		return auth, fmt.Errorf("Basket with name '%s' already exists", name)
+		return auth, fmt.Errorf("failed to create basket: '%s' - %w", name, errBasketExists)
 	}
 
 	basket := new(memoryBasket)
@@ -170,6 +320,7 @@ func (db *memoryDatabase) Create(name string, config BasketConfig) (BasketAuth,
 	basket.requests = make([]*RequestData, 0, config.Capacity)
 	basket.totalCount = 0
 	basket.responses = make(map[string]*ResponseConfig)
+	basket.activity.CreatedDate = time.Now().UnixNano() / toMs
 
 	db.baskets[name] = basket
 	db.names = append(db.names, name)
@@ -181,16 +332,19 @@ func (db *memoryDatabase) Create(name string, config BasketConfig) (BasketAuth,
 	return auth, nil
 }
 
-func (db *memoryDatabase) Get(name string) Basket {
+func (db *memoryDatabase) Get(ctx context.Context, name string) (Basket, error) {
+	db.RLock()
+	defer db.RUnlock()
+
 	if basket, exists := db.baskets[name]; exists {
-		return basket
+		return basket, nil
 	}
 
 	log.Printf("[warn] no basket found: %s", name)
-	return nil
+	return nil, nil
 }
 
-func (db *memoryDatabase) Delete(name string) {
+func (db *memoryDatabase) Delete(ctx context.Context, name string) error {
 	db.Lock()
 	defer db.Unlock()
 
@@ -201,13 +355,17 @@ func (db *memoryDatabase) Delete(name string) {
 			break
 		}
 	}
+	return nil
 }
 
-func (db *memoryDatabase) Size() int {
-	return len(db.names)
+func (db *memoryDatabase) Size(ctx context.Context) (int, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	return len(db.names), nil
 }
 
-func (db *memoryDatabase) GetNames(max int, skip int) BasketNamesPage {
+func (db *memoryDatabase) GetNames(ctx context.Context, max int, skip int) (BasketNamesPage, error) {
 	db.RLock()
 	defer db.RUnlock()
 
@@ -223,13 +381,14 @@ func (db *memoryDatabase) GetNames(max int, skip int) BasketNamesPage {
 			last = size
 		}
 
-		namesPage.Names = db.names[skip:last]
+		// copy names, the page must not change if baskets are deleted meanwhile
+		namesPage.Names = append([]string{}, db.names[skip:last]...)
 	}
 
-	return namesPage
+	return namesPage, nil
 }
 
-func (db *memoryDatabase) FindNames(query string, max int, skip int) BasketNamesQueryPage {
+func (db *memoryDatabase) FindNames(ctx context.Context, query string, max int, skip int) (BasketNamesQueryPage, error) {
 	db.RLock()
 	defer db.RUnlock()
 
@@ -248,15 +407,15 @@ func (db *memoryDatabase) FindNames(query string, max int, skip int) BasketNames
 
 		// early exit
 		if len(result) == max {
-			return BasketNamesQueryPage{Names: result, HasMore: index < len(db.names)-1}
+			return BasketNamesQueryPage{Names: result, HasMore: index < len(db.names)-1}, nil
 		}
 	}
 
 	// whole database is scanned through
-	return BasketNamesQueryPage{Names: result, HasMore: false}
+	return BasketNamesQueryPage{Names: result, HasMore: false}, nil
 }
 
-func (db *memoryDatabase) GetStats(max int) DatabaseStats {
+func (db *memoryDatabase) GetStats(ctx context.Context, max int) (DatabaseStats, error) {
 	db.RLock()
 	defer db.RUnlock()
 
@@ -264,21 +423,23 @@ func (db *memoryDatabase) GetStats(max int) DatabaseStats {
 
 	for _, name := range db.names {
 		if basket, exists := db.baskets[name]; exists {
+			basket.RLock()
 			var lastRequestDate int64
-			if basket.Size() > 0 {
-				lastRequestDate = basket.GetRequests(1, 0).Requests[0].Date
+			if len(basket.requests) > 0 {
+				lastRequestDate = basket.requests[0].Date
 			}
 
 			stats.Collect(&BasketInfo{
 				Name:               name,
-				RequestsCount:      basket.Size(),
+				RequestsCount:      len(basket.requests),
 				RequestsTotalCount: basket.totalCount,
 				LastRequestDate:    lastRequestDate}, max)
+			basket.RUnlock()
 		}
 	}
 
 	stats.UpdateAvarage()
-	return stats
+	return stats, nil
 }
 
 func (db *memoryDatabase) Release() {
@@ -28,7 +28,7 @@ func createTestPOSTRequest(reqURL string, content string, contentType string) *h
 
 func TestMemoryDatabase_Create(t *testing.T) {
 	name := "test1"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	auth, err := db.Create(name, BasketConfig{Capacity: 20})
@@ -40,7 +40,7 @@ func TestMemoryDatabase_Create(t *testing.T) {
 
 func TestMemoryDatabase_Create_NameConflict(t *testing.T) {
 	name := "test2"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -54,7 +54,7 @@ func TestMemoryDatabase_Create_NameConflict(t *testing.T) {
 
 func TestMemoryDatabase_Get(t *testing.T) {
 	name := "test3"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	auth, err := db.Create(name, BasketConfig{Capacity: 16})
@@ -69,7 +69,7 @@ func TestMemoryDatabase_Get(t *testing.T) {
 
 func TestMemoryDatabase_Get_NotFound(t *testing.T) {
 	name := "test4"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	basket := db.Get(name)
@@ -78,7 +78,7 @@ func TestMemoryDatabase_Get_NotFound(t *testing.T) {
 
 func TestMemoryDatabase_Delete(t *testing.T) {
 	name := "test5"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 10})
@@ -90,7 +90,7 @@ func TestMemoryDatabase_Delete(t *testing.T) {
 
 func TestMemoryDatabase_Delete_Multi(t *testing.T) {
 	name := "test6"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	config := BasketConfig{Capacity: 10}
@@ -108,7 +108,7 @@ func TestMemoryDatabase_Delete_Multi(t *testing.T) {
 }
 
 func TestMemoryDatabase_Size(t *testing.T) {
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	config := BasketConfig{Capacity: 15}
@@ -120,7 +120,7 @@ func TestMemoryDatabase_Size(t *testing.T) {
 }
 
 func TestMemoryDatabase_GetNames(t *testing.T) {
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	config := BasketConfig{Capacity: 15}
@@ -148,7 +148,7 @@ func TestMemoryDatabase_GetNames(t *testing.T) {
 }
 
 func TestMemoryDatabase_FindNames(t *testing.T) {
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	config := BasketConfig{Capacity: 5}
@@ -177,7 +177,7 @@ func TestMemoryDatabase_FindNames(t *testing.T) {
 
 func TestMemoryBasket_Add(t *testing.T) {
 	name := "test101"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -186,8 +186,9 @@ func TestMemoryBasket_Add(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// add 1st HTTP request
 		content := "{ \"user\": \"tester\", \"age\": 24 }"
-		data := basket.Add(createTestPOSTRequest(
+		data := ToRequestData(createTestPOSTRequest(
 			fmt.Sprintf("http://localhost/%v/demo?name=abc&ver=12", name), content, "application/json"))
+		basket.Add(data)
 
 		assert.Equal(t, 1, basket.Size(), "wrong basket size")
 
@@ -196,14 +197,14 @@ func TestMemoryBasket_Add(t *testing.T) {
 		assert.Equal(t, int64(len(content)), data.ContentLength, "wrong content length")
 
 		// add 2nd HTTP request
-		basket.Add(createTestPOSTRequest(fmt.Sprintf("http://localhost/%v/demo", name), "Hellow world", "text/plain"))
+		basket.Add(ToRequestData(createTestPOSTRequest(fmt.Sprintf("http://localhost/%v/demo", name), "Hellow world", "text/plain")))
 		assert.Equal(t, 2, basket.Size(), "wrong basket size")
 	}
 }
 
 func TestMemoryBasket_Add_ExceedLimit(t *testing.T) {
 	name := "test102"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 10})
@@ -212,8 +213,8 @@ func TestMemoryBasket_Add_ExceedLimit(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 35; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 10, basket.Size(), "wrong basket size")
 	}
@@ -221,7 +222,7 @@ func TestMemoryBasket_Add_ExceedLimit(t *testing.T) {
 
 func TestMemoryBasket_Clear(t *testing.T) {
 	name := "test103"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -230,8 +231,8 @@ func TestMemoryBasket_Clear(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 15; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 15, basket.Size(), "wrong basket size")
 
@@ -243,7 +244,7 @@ func TestMemoryBasket_Clear(t *testing.T) {
 
 func TestMemoryBasket_Update_Shrink(t *testing.T) {
 	name := "test104"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 30})
@@ -252,8 +253,8 @@ func TestMemoryBasket_Update_Shrink(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 0; i < 25; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
 		}
 		assert.Equal(t, 25, basket.Size(), "wrong basket size")
 
@@ -267,7 +268,7 @@ func TestMemoryBasket_Update_Shrink(t *testing.T) {
 
 func TestMemoryBasket_GetRequests(t *testing.T) {
 	name := "test105"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 25})
@@ -276,8 +277,8 @@ func TestMemoryBasket_GetRequests(t *testing.T) {
 	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
 		// fill basket
 		for i := 1; i <= 35; i++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v/demo?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain")))
 		}
 		assert.Equal(t, 25, basket.Size(), "wrong basket size")
 
@@ -301,7 +302,7 @@ func TestMemoryBasket_GetRequests(t *testing.T) {
 
 func TestMemoryBasket_FindRequests(t *testing.T) {
 	name := "test106"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 100})
@@ -318,7 +319,7 @@ func TestMemoryBasket_FindRequests(t *testing.T) {
 			if i <= 20 {
 				r.Header.Add("Muffin", "tasty")
 			}
-			basket.Add(r)
+			basket.Add(ToRequestData(r))
 		}
 		assert.Equal(t, 30, basket.Size(), "wrong basket size")
 
@@ -356,7 +357,7 @@ func TestMemoryBasket_FindRequests(t *testing.T) {
 func TestMemoryBasket_SetResponse(t *testing.T) {
 	name := "test107"
 	method := "POST"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -381,7 +382,7 @@ func TestMemoryBasket_SetResponse(t *testing.T) {
 func TestMemoryBasket_SetResponse_Update(t *testing.T) {
 	name := "test108"
 	method := "GET"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -404,7 +405,7 @@ func TestMemoryBasket_SetResponse_Update(t *testing.T) {
 
 func TestMemoryDatabase_GetStats(t *testing.T) {
 	name := "test130"
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
 	config := BasketConfig{Capacity: 5}
@@ -415,8 +416,8 @@ func TestMemoryDatabase_GetStats(t *testing.T) {
 		// fill basket
 		basket := db.Get(bname)
 		for j := 0; j < 9-i; j++ {
-			basket.Add(createTestPOSTRequest(
-				fmt.Sprintf("http://localhost/%v?id=%v", bname, j), fmt.Sprintf("req%v", j), "text/plain"))
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v?id=%v", bname, j), fmt.Sprintf("req%v", j), "text/plain")))
 		}
 		time.Sleep(20 * time.Millisecond)
 	}
@@ -448,3 +449,222 @@ func TestMemoryDatabase_GetStats(t *testing.T) {
 		}
 	}
 }
+
+func TestMemoryBasket_Update_ForwardTargets(t *testing.T) {
+	name := "test140"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		config := basket.Config()
+		assert.Equal(t, []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}, config.ForwardTargets)
+
+		config.ForwardTargets = append(config.ForwardTargets, ForwardTarget{URL: "http://localhost:12345/b", ExpandPath: true, Primary: true})
+		basket.Update(config)
+		assert.Equal(t, config.ForwardTargets, basket.Config().ForwardTargets, "wrong forward targets")
+	}
+}
+
+func TestMemoryBasket_DeadLetters(t *testing.T) {
+	name := "test141"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 2})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		for i := 0; i < 3; i++ {
+			basket.AddDeadLetter(&DeadLetter{ID: fmt.Sprintf("id%v", i), Date: int64(i), Attempts: 2, Error: "HTTP 503",
+				Target:  ForwardTarget{URL: "http://localhost:12345"},
+				Request: createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("test%v", i))})
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// basket capacity applies to undelivered requests
+		page := basket.GetDeadLetters(10, 0)
+		assert.Equal(t, 2, page.Count, "wrong number of dead letters")
+		assert.False(t, page.HasMore, "no more dead letters are expected")
+		if assert.Equal(t, 2, len(page.DeadLetters), "wrong number of dead letters") {
+			// newest come first
+			assert.Equal(t, "id2", page.DeadLetters[0].ID, "wrong dead letter")
+			assert.Equal(t, "id1", page.DeadLetters[1].ID, "wrong dead letter")
+			assert.Equal(t, "test2", page.DeadLetters[0].Request.Body, "wrong request body")
+		}
+
+		page = basket.GetDeadLetters(1, 0)
+		assert.True(t, page.HasMore, "more dead letters are expected")
+
+		if letter := basket.GetDeadLetter("id1"); assert.NotNil(t, letter, "dead letter is expected") {
+			assert.Equal(t, "http://localhost:12345", letter.Target.URL, "wrong target URL")
+			assert.Equal(t, 2, letter.Attempts, "wrong number of attempts")
+		}
+		assert.Nil(t, basket.GetDeadLetter("id0"), "evicted dead letter is not expected")
+
+		basket.RemoveDeadLetter("id1")
+		assert.Nil(t, basket.GetDeadLetter("id1"), "removed dead letter is not expected")
+		assert.Equal(t, 1, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+
+		basket.ClearDeadLetters()
+		assert.Equal(t, 0, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+	}
+}
+
+func TestMemoryBasket_ForwardResults(t *testing.T) {
+	name := "test147"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		second := createTestRequestData("/"+name, "second")
+		basket.Add(second)
+
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200, Duration: 15, Body: "ok"})
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8081", Error: "connection refused"})
+		// unknown request is ignored
+		basket.AddForwardResult("abc", &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		page := basket.GetRequests(10, 0)
+		if assert.Equal(t, 2, len(page.Requests), "wrong number of requests") {
+			assert.Empty(t, page.Requests[0].Forwards, "results of forwarding are not expected")
+			if assert.Equal(t, 2, len(page.Requests[1].Forwards), "wrong number of forward results") {
+				assert.Equal(t, first.ID, page.Requests[1].ID, "wrong request ID")
+				assert.Equal(t, 200, page.Requests[1].Forwards[0].Status, "wrong status of target response")
+				assert.Equal(t, int64(15), page.Requests[1].Forwards[0].Duration, "wrong duration of forwarding")
+				assert.Equal(t, "ok", page.Requests[1].Forwards[0].Body, "wrong body of target response")
+				assert.Equal(t, "connection refused", page.Requests[1].Forwards[1].Error, "wrong error of forwarding")
+			}
+		}
+
+		// results are found with requests
+		found := basket.FindRequests("first", "body", 10, 0)
+		if assert.Equal(t, 1, len(found.Requests), "wrong number of found requests") {
+			assert.Equal(t, 2, len(found.Requests[0].Forwards), "wrong number of forward results")
+		}
+
+		// results are removed with requests
+		basket.Clear()
+		basket.Add(first)
+		assert.Empty(t, basket.GetRequests(10, 0).Requests[0].Forwards, "results of forwarding are not expected")
+	}
+}
+
+func TestMemoryBasket_GetRequest(t *testing.T) {
+	name := "test150"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		basket.Add(createTestRequestData("/"+name, "second"))
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		if request := basket.GetRequest(first.ID); assert.NotNil(t, request, "request is expected") {
+			assert.Equal(t, first.ID, request.ID, "wrong request ID")
+			assert.Equal(t, "first", request.Body, "wrong request body")
+			assert.Equal(t, 1, len(request.Forwards), "result of forwarding is expected")
+		}
+		assert.Nil(t, basket.GetRequest("abc"), "unknown request is not expected")
+	}
+}
+
+func TestMemoryBasket_AddWebSocketFrames(t *testing.T) {
+	name := "test151"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		request := createTestRequestData("/"+name, "")
+		basket.Add(request)
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{
+			{Direction: WebSocketIn, Type: "text", Final: true, Size: 5, Data: "hello"},
+			{Direction: WebSocketOut, Type: "text", Final: true, Size: 5, Data: "hello"}})
+		basket.AddForwardResult(request.ID, &ForwardResult{URL: "http://localhost:8080", Status: 101})
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{{Direction: WebSocketIn, Type: "close", Final: true, Size: 2, Data: "1000 "}})
+		basket.AddWebSocketFrames("abc", []*WebSocketFrame{{Direction: WebSocketIn, Type: "ping", Final: true}})
+
+		if saved := basket.GetRequest(request.ID); assert.NotNil(t, saved, "request is expected") {
+			if assert.Equal(t, 3, len(saved.Frames), "WebSocket frames are expected") {
+				assert.Equal(t, "hello", saved.Frames[0].Data, "wrong data of frame")
+				assert.Equal(t, WebSocketOut, saved.Frames[1].Direction, "wrong direction of frame")
+				assert.Equal(t, "close", saved.Frames[2].Type, "wrong type of frame")
+			}
+			assert.Equal(t, 1, len(saved.Forwards), "result of forwarding is expected to be kept")
+		}
+		assert.Equal(t, 3, len(basket.GetRequests(10, 0).Requests[0].Frames), "frames are expected with collected request")
+	}
+}
+
+func TestMemoryBasket_DeleteExpired(t *testing.T) {
+	name := "test157"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// collect outdated request
+		old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+		old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+		basket.Add(old)
+		// collect recent requests
+		for i := 0; i < 3; i++ {
+			basket.Add(createTestRequestData(fmt.Sprintf("/%v/new", name), fmt.Sprintf("test%v", i)))
+		}
+		assert.Equal(t, 4, basket.Size(), "wrong basket size")
+
+		assert.Equal(t, 0, basket.DeleteExpired(3*time.Hour), "no expired requests are expected")
+		assert.Equal(t, 1, basket.DeleteExpired(time.Hour), "wrong number of deleted requests")
+		assert.Equal(t, 3, basket.Size(), "wrong basket size")
+		page := basket.GetRequests(10, 0)
+		assert.Equal(t, 3, page.Count, "wrong requests count")
+		assert.Equal(t, 4, page.TotalCount, "wrong total count")
+		for _, request := range page.Requests {
+			assert.Equal(t, fmt.Sprintf("/%v/new", name), request.Path, "outdated request is not expected")
+		}
+	}
+}
+
+func TestMemoryBasket_Activity(t *testing.T) {
+	name := "test163"
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		activity := basket.Activity()
+		assert.InDelta(t, time.Now().UnixNano()/toMs, activity.CreatedDate, 5000, "wrong creation date")
+		assert.Zero(t, activity.LastRequestDate, "no requests are expected")
+
+		request := createTestRequestData(fmt.Sprintf("/%v/demo", name), "test")
+		request.Date -= 1000
+		basket.Add(request)
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+
+		// date of the last request is kept even if requests are deleted
+		basket.Clear()
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+		assert.Equal(t, activity.CreatedDate, basket.Activity().CreatedDate, "creation date is not expected to change")
+	}
+}
@@ -0,0 +1,876 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DbTypeRedis defines name of Redis database storage
+const DbTypeRedis = "redis"
+
+// Redis keys: basket names and statistics are kept in sorted sets shared by all baskets,
+// every basket owns a hash with token and configuration, a capped list of IDs of collected requests (newest first)
+// with a hash of requests and a sorted set of request dates keyed by the same IDs, a hash of responses,
+// a capped list of undelivered requests and a hash of queued forwards; results of forwarding and WebSocket frames
+// of every collected request are kept in separate lists, so they are appended without rewriting the request
+const (
+	redisKeyNames  = "rb:names"       // basket names, all scores are 0 to keep names sorted
+	redisKeyTotals = "rb:stats:total" // total number of requests collected by basket
+	redisKeyDates  = "rb:stats:date"  // date of last request collected by basket
+
+	redisPrefixBasket         = "rb:basket:"
+	redisPrefixRequests       = "rb:requests:"
+	redisPrefixRequestData    = "rb:requestdata:"
+	redisPrefixRequestDates   = "rb:requestdates:"
+	redisPrefixForwardResults = "rb:results:" // + basket name + ":" + request ID
+	redisPrefixFrames         = "rb:frames:"  // + basket name + ":" + request ID
+	redisPrefixResponses      = "rb:responses:"
+	redisPrefixDeadLetters    = "rb:deadletters:"
+	redisPrefixForwards       = "rb:forwards:"
+
+	redisFieldToken       = "token"
+	redisFieldConfig      = "config"
+	redisFieldCapacity    = "capacity"
+	redisFieldCreated     = "created"
+	redisFieldLastRequest = "lastreq"
+
+	// redisReadBatch defines how many collected requests are read at once while searching
+	redisReadBatch = 100
+)
+
+// redisLuaRemoveRequests is a Lua function shared by scripts that evict collected requests;
+// KEYS: list of request IDs, hash of requests, sorted set of request dates;
+// ARGV[2], ARGV[3]: key prefixes of forward results and WebSocket frames of request
+const redisLuaRemoveRequests = `
+local function remove(ids)
+	for _, id in ipairs(ids) do
+		redis.call('HDEL', KEYS[2], id)
+		redis.call('ZREM', KEYS[3], id)
+		redis.call('DEL', ARGV[2] .. id, ARGV[3] .. id)
+	end
+	return #ids
+end
+`
+
+// redisTrimRequests keeps up to ARGV[1] latest collected requests
+var redisTrimRequests = redis.NewScript(redisLuaRemoveRequests + `
+local size = tonumber(ARGV[1])
+local evicted = redis.call('LRANGE', KEYS[1], math.max(size, 0), -1)
+if size > 0 then
+	redis.call('LTRIM', KEYS[1], 0, size - 1)
+else
+	redis.call('DEL', KEYS[1])
+end
+return remove(evicted)
+`)
+
+// redisExpireRequests deletes collected requests with dates before ARGV[1]
+var redisExpireRequests = redis.NewScript(redisLuaRemoveRequests + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', '(' .. ARGV[1])
+for _, id in ipairs(expired) do
+	redis.call('LREM', KEYS[1], 0, id)
+end
+return remove(expired)
+`)
+
+// redisAppendToRequest appends values to the list KEYS[2] that belongs to collected request ARGV[1]
+// if the request is still kept in hash of requests KEYS[1]
+var redisAppendToRequest = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+return redis.call('RPUSH', KEYS[2], unpack(ARGV, 2))
+`)
+
+/// Basket interface ///
+
+type redisBasket struct {
+	client *redis.Client
+	name   string
+}
+
+func (basket *redisBasket) key(prefix string) string {
+	return prefix + basket.name
+}
+
+func (basket *redisBasket) capacity(ctx context.Context) (int, error) {
+	capacity, err := basket.client.HGet(ctx, basket.key(redisPrefixBasket), redisFieldCapacity).Int()
+	if err = redisError(ctx, err); err != nil {
+		return 0, fmt.Errorf("failed to get capacity of basket: %s - %s", basket.name, err)
+	}
+	return capacity, nil
+}
+
+// requestKey returns key of a list that belongs to collected request
+func (basket *redisBasket) requestKey(prefix string, id string) string {
+	return prefix + basket.name + ":" + id
+}
+
+// requestKeys returns keys that are passed to scripts evicting collected requests
+func (basket *redisBasket) requestKeys() []string {
+	return []string{basket.key(redisPrefixRequests), basket.key(redisPrefixRequestData), basket.key(redisPrefixRequestDates)}
+}
+
+// trimRequests queues the script that keeps up to specified number of the latest collected requests
+func (basket *redisBasket) trimRequests(ctx context.Context, pipe redis.Pipeliner, size int) {
+	redisTrimRequests.Eval(ctx, pipe, basket.requestKeys(), size,
+		basket.requestKey(redisPrefixForwardResults, ""), basket.requestKey(redisPrefixFrames, ""))
+}
+
+// appendToRequest appends serialized values to the list that belongs to collected request,
+// values are dropped if the request is evicted meanwhile
+func (basket *redisBasket) appendToRequest(ctx context.Context, prefix string, id string, values []interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := []string{basket.key(redisPrefixRequestData), basket.requestKey(prefix, id)}
+	args := append([]interface{}{id}, values...)
+	return redisError(ctx, redisAppendToRequest.Run(ctx, basket.client, keys, args...).Err())
+}
+
+// fetchRequests reads collected requests by IDs without results of forwarding and WebSocket frames,
+// requests evicted meanwhile are skipped, IDs of found requests are returned along with the requests
+func (basket *redisBasket) fetchRequests(ctx context.Context, ids []string) ([]string, []*RequestData, error) {
+	found := make([]string, 0, len(ids))
+	requests := make([]*RequestData, 0, len(ids))
+	if len(ids) == 0 {
+		return found, requests, nil
+	}
+
+	values, err := basket.client.HMGet(ctx, basket.key(redisPrefixRequestData), ids...).Result()
+	if err = redisError(ctx, err); err != nil {
+		return found, requests, err
+	}
+
+	for index, value := range values {
+		if s, ok := value.(string); ok {
+			request := new(RequestData)
+			if err = json.Unmarshal([]byte(s), request); err != nil {
+				return found, requests, err
+			}
+			found = append(found, ids[index])
+			requests = append(requests, request)
+		}
+	}
+
+	return found, requests, nil
+}
+
+// attachDetails reads results of forwarding and WebSocket frames of collected requests
+func (basket *redisBasket) attachDetails(ctx context.Context, ids []string, requests []*RequestData) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	results := make([]*redis.StringSliceCmd, len(ids))
+	frames := make([]*redis.StringSliceCmd, len(ids))
+	_, err := basket.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for index, id := range ids {
+			results[index] = pipe.LRange(ctx, basket.requestKey(redisPrefixForwardResults, id), 0, -1)
+			frames[index] = pipe.LRange(ctx, basket.requestKey(redisPrefixFrames, id), 0, -1)
+		}
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return err
+	}
+
+	for index, request := range requests {
+		for _, value := range results[index].Val() {
+			result := new(ForwardResult)
+			if err = json.Unmarshal([]byte(value), result); err != nil {
+				return err
+			}
+			request.Forwards = append(request.Forwards, result)
+		}
+		for _, value := range frames[index].Val() {
+			frame := new(WebSocketFrame)
+			if err = json.Unmarshal([]byte(value), frame); err != nil {
+				return err
+			}
+			request.Frames = append(request.Frames, frame)
+		}
+	}
+
+	return nil
+}
+
+// loadRequests reads collected requests by IDs along with their results of forwarding and WebSocket frames
+func (basket *redisBasket) loadRequests(ctx context.Context, ids []string) ([]*RequestData, error) {
+	found, requests, err := basket.fetchRequests(ctx, ids)
+	if err == nil {
+		err = basket.attachDetails(ctx, found, requests)
+	}
+	return requests, err
+}
+
+// redisMarshalEach serializes every item of a list to JSON
+func redisMarshalEach(count int, item func(index int) interface{}) ([]interface{}, error) {
+	values := make([]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		value, err := json.Marshal(item(i))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (basket *redisBasket) Config(ctx context.Context) (BasketConfig, error) {
+	config := BasketConfig{}
+
+	value, err := basket.client.HGet(ctx, basket.key(redisPrefixBasket), redisFieldConfig).Result()
+	if err = redisError(ctx, err); err != nil {
+		return config, fmt.Errorf("failed to get basket config: %s - %s", basket.name, err)
+	}
+
+	fromJSONBytes([]byte(value), &config, "config of basket: "+basket.name)
+	return config, nil
+}
+
+func (basket *redisBasket) Update(ctx context.Context, config BasketConfig) error {
+	configb, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to serialize config of basket: %s - %s", basket.name, err)
+	}
+
+	_, err = basket.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, basket.key(redisPrefixBasket), redisFieldConfig, configb, redisFieldCapacity, config.Capacity)
+		// apply new basket limits
+		basket.trimRequests(ctx, pipe, config.Capacity)
+		redisTrim(ctx, pipe, basket.key(redisPrefixDeadLetters), config.Capacity)
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return fmt.Errorf("failed to update basket config: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) Authorize(ctx context.Context, token string) (bool, error) {
+	value, err := basket.client.HGet(ctx, basket.key(redisPrefixBasket), redisFieldToken).Result()
+	if err = redisError(ctx, err); err != nil {
+		return false, fmt.Errorf("failed authorize access to basket: %s - %s", basket.name, err)
+	}
+
+	return len(value) > 0 && value == token, nil
+}
+
+func (basket *redisBasket) GetResponse(ctx context.Context, method string) (*ResponseConfig, error) {
+	value, err := basket.client.HGet(ctx, basket.key(redisPrefixResponses), method).Result()
+	if err = redisError(ctx, err); err == redis.Nil {
+		// no response for this basket + HTTP method
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get response for HTTP %s method of basket: %s - %s", method, basket.name, err)
+	}
+
+	response := new(ResponseConfig)
+	if err = json.Unmarshal([]byte(value), response); err != nil {
+		return nil, fmt.Errorf("failed to parse response for HTTP %s method of basket: %s - %s", method, basket.name, err)
+	}
+
+	return response, nil
+}
+
+func (basket *redisBasket) SetResponse(ctx context.Context, method string, response ResponseConfig) error {
+	respb, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	if err = redisError(ctx, basket.client.HSet(ctx, basket.key(redisPrefixResponses), method, respb).Err()); err != nil {
+		return fmt.Errorf("failed to update response for HTTP %s method of basket: %s - %s", method, basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) Add(ctx context.Context, data *RequestData) error {
+	capacity, err := basket.capacity(ctx)
+	if err != nil {
+		return err
+	}
+
+	// requests imported from archives of previous versions have no ID, they are kept under generated one
+	id := data.ID
+	if len(id) == 0 {
+		if id, err = GenerateID(); err != nil {
+			return fmt.Errorf("failed to generate ID of incoming HTTP request in basket: %s - %s", basket.name, err)
+		}
+	}
+
+	request := *data
+	request.Forwards = nil
+	request.Frames = nil
+	datab, err := json.Marshal(&request)
+	if err != nil {
+		return fmt.Errorf("failed to serialize incoming HTTP request of basket: %s - %s", basket.name, err)
+	}
+	results, err := redisMarshalEach(len(data.Forwards), func(i int) interface{} { return data.Forwards[i] })
+	if err != nil {
+		return fmt.Errorf("failed to serialize results of forwarding in basket: %s - %s", basket.name, err)
+	}
+	frames, err := redisMarshalEach(len(data.Frames), func(i int) interface{} { return data.Frames[i] })
+	if err != nil {
+		return fmt.Errorf("failed to serialize WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+
+	_, err = basket.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, basket.key(redisPrefixRequests), id)
+		pipe.HSet(ctx, basket.key(redisPrefixRequestData), id, datab)
+		pipe.ZAdd(ctx, basket.key(redisPrefixRequestDates), redis.Z{Score: float64(data.Date), Member: id})
+		if len(results) > 0 {
+			pipe.RPush(ctx, basket.requestKey(redisPrefixForwardResults, id), results...)
+		}
+		if len(frames) > 0 {
+			pipe.RPush(ctx, basket.requestKey(redisPrefixFrames, id), frames...)
+		}
+		// apply limit according to basket capacity
+		basket.trimRequests(ctx, pipe, capacity)
+		// update statistics
+		pipe.ZIncrBy(ctx, redisKeyTotals, 1, basket.name)
+		pipe.ZAdd(ctx, redisKeyDates, redis.Z{Score: float64(data.Date), Member: basket.name})
+		pipe.HSet(ctx, basket.key(redisPrefixBasket), redisFieldLastRequest, data.Date)
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return fmt.Errorf("failed to collect incoming HTTP request in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) AddForwardResult(ctx context.Context, id string, result *ForwardResult) error {
+	resultb, err := json.Marshal(result)
+	if err == nil {
+		err = basket.appendToRequest(ctx, redisPrefixForwardResults, id, []interface{}{resultb})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save result of forwarding in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) AddWebSocketFrames(ctx context.Context, id string, frames []*WebSocketFrame) error {
+	values, err := redisMarshalEach(len(frames), func(i int) interface{} { return frames[i] })
+	if err == nil {
+		err = basket.appendToRequest(ctx, redisPrefixFrames, id, values)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) GetRequest(ctx context.Context, id string) (*RequestData, error) {
+	requests, err := basket.loadRequests(ctx, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request %s of basket: %s - %s", id, basket.name, err)
+	}
+
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	return requests[0], nil
+}
+
+func (basket *redisBasket) Clear(ctx context.Context) error {
+	_, err := basket.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		basket.trimRequests(ctx, pipe, 0)
+		// pipe.ZRem(ctx, redisKeyTotals, basket.name) // reset total stats
+		pipe.ZRem(ctx, redisKeyDates, basket.name)
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return fmt.Errorf("failed to delete collected requests in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) DeleteExpired(ctx context.Context, maxAge time.Duration) (int, error) {
+	deleted, err := redisExpireRequests.Run(ctx, basket.client, basket.requestKeys(), expiryDate(maxAge),
+		basket.requestKey(redisPrefixForwardResults, ""), basket.requestKey(redisPrefixFrames, "")).Int()
+	if err = redisError(ctx, err); err != nil {
+		return 0, fmt.Errorf("failed to delete expired requests in basket: %s - %s", basket.name, err)
+	}
+
+	return deleted, nil
+}
+
+func (basket *redisBasket) Activity(ctx context.Context) (BasketActivity, error) {
+	activity := BasketActivity{}
+	key := basket.key(redisPrefixBasket)
+
+	err := redisOptimistic(ctx, basket.client, func(tx *redis.Tx) error {
+		values, err := tx.HMGet(ctx, key, redisFieldToken, redisFieldCreated, redisFieldLastRequest).Result()
+		if err != nil {
+			return err
+		}
+		if len(values) != 3 || values[0] == nil {
+			return fmt.Errorf("basket is not found")
+		}
+
+		activity.CreatedDate = redisInt64(values[1])
+		activity.LastRequestDate = redisInt64(values[2])
+		if activity.CreatedDate > 0 {
+			return nil
+		}
+
+		// creation date of baskets created by previous versions is unknown, the date it is first asked is recorded
+		activity.CreatedDate = time.Now().UnixNano() / toMs
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSetNX(ctx, key, redisFieldCreated, activity.CreatedDate)
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil {
+		return activity, fmt.Errorf("failed to get activity of basket: %s - %s", basket.name, err)
+	}
+
+	return activity, nil
+}
+
+func (basket *redisBasket) Size(ctx context.Context) (int, error) {
+	size, err := basket.client.LLen(ctx, basket.key(redisPrefixRequests)).Result()
+	if err = redisError(ctx, err); err != nil {
+		return 0, fmt.Errorf("failed to get size of basket: %s - %s", basket.name, err)
+	}
+	return int(size), nil
+}
+
+func (basket *redisBasket) GetRequests(ctx context.Context, max int, skip int) (RequestsPage, error) {
+	page := RequestsPage{make([]*RequestData, 0, max), 0, 0, false}
+
+	var err error
+	if page.Count, err = basket.Size(ctx); err != nil {
+		return page, err
+	}
+
+	total, err := basket.client.ZScore(ctx, redisKeyTotals, basket.name).Result()
+	if err = redisError(ctx, err); err != nil && err != redis.Nil {
+		return page, fmt.Errorf("failed to get total requests count of basket: %s - %s", basket.name, err)
+	}
+	page.TotalCount = int(total)
+
+	if start, stop, ok := redisRange(max, skip); ok {
+		ids, err := basket.client.LRange(ctx, basket.key(redisPrefixRequests), start, stop).Result()
+		if err = redisError(ctx, err); err != nil {
+			return page, fmt.Errorf("failed to get requests of basket: %s - %s", basket.name, err)
+		}
+		if page.Requests, err = basket.loadRequests(ctx, ids); err != nil {
+			return page, fmt.Errorf("failed to get HTTP request data in basket: %s - %s", basket.name, err)
+		}
+	}
+	page.HasMore = skip+max < page.Count
+
+	return page, nil
+}
+
+func (basket *redisBasket) FindRequests(ctx context.Context, query string, in string, max int, skip int) (RequestsQueryPage, error) {
+	page := RequestsQueryPage{make([]*RequestData, 0, max), false}
+
+	ids, err := basket.client.LRange(ctx, basket.key(redisPrefixRequests), 0, -1).Result()
+	if err = redisError(ctx, err); err != nil {
+		return page, fmt.Errorf("failed to get requests of basket: %s - %s", basket.name, err)
+	}
+
+	// requests are read in batches, results of forwarding and frames are read only for found requests
+	matchedIDs := make([]string, 0, max)
+	skipped := 0
+	for offset := 0; offset < len(ids) && len(page.Requests) < max; offset += redisReadBatch {
+		batch := ids[offset:]
+		if len(batch) > redisReadBatch {
+			batch = batch[:redisReadBatch]
+		}
+
+		found, requests, err := basket.fetchRequests(ctx, batch)
+		if err != nil {
+			return page, fmt.Errorf("failed to get HTTP request data in basket: %s - %s", basket.name, err)
+		}
+
+		for index, request := range requests {
+			// filter
+			if request.Matches(query, in) {
+				if skipped < skip {
+					skipped++
+				} else {
+					matchedIDs = append(matchedIDs, found[index])
+					page.Requests = append(page.Requests, request)
+				}
+			}
+
+			// early exit
+			if len(page.Requests) == max {
+				page.HasMore = index < len(requests)-1 || offset+len(batch) < len(ids)
+				break
+			}
+		}
+	}
+
+	if err = basket.attachDetails(ctx, matchedIDs, page.Requests); err != nil {
+		return page, fmt.Errorf("failed to get HTTP request data in basket: %s - %s", basket.name, err)
+	}
+
+	return page, nil
+}
+
+func (basket *redisBasket) AddDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	capacity, err := basket.capacity(ctx)
+	if err != nil {
+		return err
+	}
+
+	letterb, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	key := basket.key(redisPrefixDeadLetters)
+	_, err = basket.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, letterb)
+		// keep the number of undelivered requests up to basket capacity
+		redisTrim(ctx, pipe, key, capacity)
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return fmt.Errorf("failed to save undelivered request in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+// findDeadLetter looks up for undelivered request by ID and returns its serialized value
+func (basket *redisBasket) findDeadLetter(ctx context.Context, id string) (string, *DeadLetter, error) {
+	values, err := basket.client.LRange(ctx, basket.key(redisPrefixDeadLetters), 0, -1).Result()
+	if err = redisError(ctx, err); err != nil {
+		return "", nil, err
+	}
+
+	for _, value := range values {
+		letter := new(DeadLetter)
+		if err = json.Unmarshal([]byte(value), letter); err != nil {
+			return "", nil, err
+		}
+		if letter.ID == id {
+			return value, letter, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+func (basket *redisBasket) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	_, letter, err := basket.findDeadLetter(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get undelivered request %s of basket: %s - %s", id, basket.name, err)
+	}
+
+	return letter, nil
+}
+
+func (basket *redisBasket) GetDeadLetters(ctx context.Context, max int, skip int) (DeadLettersPage, error) {
+	page := DeadLettersPage{make([]*DeadLetter, 0, max), 0, false}
+	key := basket.key(redisPrefixDeadLetters)
+
+	count, err := basket.client.LLen(ctx, key).Result()
+	if err = redisError(ctx, err); err != nil {
+		return page, fmt.Errorf("failed to get undelivered requests of basket: %s - %s", basket.name, err)
+	}
+	page.Count = int(count)
+	page.HasMore = skip+max < page.Count
+
+	if start, stop, ok := redisRange(max, skip); ok {
+		values, err := basket.client.LRange(ctx, key, start, stop).Result()
+		if err = redisError(ctx, err); err != nil {
+			return page, fmt.Errorf("failed to get undelivered requests of basket: %s - %s", basket.name, err)
+		}
+		for _, value := range values {
+			letter := new(DeadLetter)
+			if err = json.Unmarshal([]byte(value), letter); err != nil {
+				return page, fmt.Errorf("failed to parse undelivered request in basket: %s - %s", basket.name, err)
+			}
+			page.DeadLetters = append(page.DeadLetters, letter)
+		}
+	}
+
+	return page, nil
+}
+
+func (basket *redisBasket) RemoveDeadLetter(ctx context.Context, id string) error {
+	value, letter, err := basket.findDeadLetter(ctx, id)
+	if err == nil && letter != nil {
+		err = redisError(ctx, basket.client.LRem(ctx, basket.key(redisPrefixDeadLetters), 1, value).Err())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove undelivered request %s of basket: %s - %s", id, basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) ClearDeadLetters(ctx context.Context) error {
+	if err := redisError(ctx, basket.client.Del(ctx, basket.key(redisPrefixDeadLetters)).Err()); err != nil {
+		return fmt.Errorf("failed to delete undelivered requests in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+/// ForwardsStore interface ///
+
+func (basket *redisBasket) SaveForward(ctx context.Context, forward *PendingForward) error {
+	forwardb, err := json.Marshal(forward)
+	if err != nil {
+		return err
+	}
+
+	if err = redisError(ctx, basket.client.HSet(ctx, basket.key(redisPrefixForwards), forward.ID, forwardb).Err()); err != nil {
+		return fmt.Errorf("failed to save queued forward in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *redisBasket) ClaimForward(ctx context.Context, id string) (bool, error) {
+	removed, err := basket.client.HDel(ctx, basket.key(redisPrefixForwards), id).Result()
+	if err = redisError(ctx, err); err != nil {
+		return false, fmt.Errorf("failed to claim queued forward %s of basket: %s - %s", id, basket.name, err)
+	}
+	return removed > 0, nil
+}
+
+func (basket *redisBasket) GetPendingForwards(ctx context.Context) ([]*PendingForward, error) {
+	result := make([]*PendingForward, 0)
+
+	values, err := basket.client.HVals(ctx, basket.key(redisPrefixForwards)).Result()
+	if err = redisError(ctx, err); err != nil {
+		return nil, fmt.Errorf("failed to get queued forwards of basket: %s - %s", basket.name, err)
+	}
+
+	for _, value := range values {
+		forward := new(PendingForward)
+		if err = json.Unmarshal([]byte(value), forward); err != nil {
+			log.Printf("[error] failed to parse queued forward in basket: %s - %s", basket.name, err)
+			continue
+		}
+		result = append(result, forward)
+	}
+
+	// forwards are keyed by random IDs, restore the order in which they were queued
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Date < result[j].Date })
+
+	return result, nil
+}
+
+/// BasketsDatabase interface ///
+
+type redisDatabase struct {
+	client *redis.Client
+}
+
+func (rdb *redisDatabase) Create(ctx context.Context, name string, config BasketConfig) (BasketAuth, error) {
+	auth := BasketAuth{}
+	token, err := GenerateToken()
+	if err != nil {
+		return auth, fmt.Errorf("failed to generate token: %s", err)
+	}
+
+	configb, err := json.Marshal(config)
+	if err != nil {
+		return auth, fmt.Errorf("failed to serialize config of basket: %s - %s", name, err)
+	}
+
+	key := redisPrefixBasket + name
+	created, err := rdb.client.HSetNX(ctx, key, redisFieldToken, token).Result()
+	if err = redisError(ctx, err); err != nil {
+		return auth, fmt.Errorf("failed to create basket: %s - %s", name, err)
+	}
+	if !created {
+		return auth, fmt.Errorf("failed to create basket: %s - %w", name, errBasketExists)
+	}
+
+	_, err = rdb.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, redisFieldConfig, configb, redisFieldCapacity, config.Capacity,
+			redisFieldCreated, time.Now().UnixNano()/toMs)
+		pipe.ZAdd(ctx, redisKeyNames, redis.Z{Score: 0, Member: name})
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		// clean up even if the context is canceled
+		rdb.Delete(context.Background(), name)
+		return auth, fmt.Errorf("failed to create basket: %s - %s", name, err)
+	}
+
+	auth.Token = token
+
+	return auth, nil
+}
+
+func (rdb *redisDatabase) Get(ctx context.Context, name string) (Basket, error) {
+	exists, err := rdb.client.Exists(ctx, redisPrefixBasket+name).Result()
+	if err = redisError(ctx, err); err != nil {
+		return nil, fmt.Errorf("failed to get basket: %s - %s", name, err)
+	}
+	if exists == 0 {
+		log.Printf("[warn] no basket found: %s", name)
+		return nil, nil
+	}
+
+	return &redisBasket{rdb.client, name}, nil
+}
+
+func (rdb *redisDatabase) Delete(ctx context.Context, name string) error {
+	basket := &redisBasket{rdb.client, name}
+	_, err := rdb.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		basket.trimRequests(ctx, pipe, 0)
+		pipe.Del(ctx, redisPrefixBasket+name, redisPrefixResponses+name, redisPrefixDeadLetters+name, redisPrefixForwards+name)
+		pipe.ZRem(ctx, redisKeyNames, name)
+		pipe.ZRem(ctx, redisKeyTotals, name)
+		pipe.ZRem(ctx, redisKeyDates, name)
+		return nil
+	})
+	if err = redisError(ctx, err); err != nil {
+		return fmt.Errorf("failed to delete basket: %s - %s", name, err)
+	}
+	return nil
+}
+
+func (rdb *redisDatabase) Size(ctx context.Context) (int, error) {
+	size, err := rdb.client.ZCard(ctx, redisKeyNames).Result()
+	if err = redisError(ctx, err); err != nil {
+		return 0, fmt.Errorf("failed to get number of baskets: %s", err)
+	}
+	return int(size), nil
+}
+
+func (rdb *redisDatabase) GetNames(ctx context.Context, max int, skip int) (BasketNamesPage, error) {
+	page := BasketNamesPage{make([]string, 0, max), 0, false}
+
+	var err error
+	if page.Count, err = rdb.Size(ctx); err != nil {
+		return page, err
+	}
+	page.HasMore = skip+max < page.Count
+
+	if start, stop, ok := redisRange(max, skip); ok {
+		names, err := rdb.client.ZRange(ctx, redisKeyNames, start, stop).Result()
+		if err = redisError(ctx, err); err != nil {
+			return page, fmt.Errorf("failed to get basket names: %s", err)
+		}
+		page.Names = append(page.Names, names...)
+	}
+
+	return page, nil
+}
+
+func (rdb *redisDatabase) FindNames(ctx context.Context, query string, max int, skip int) (BasketNamesQueryPage, error) {
+	page := BasketNamesQueryPage{make([]string, 0, max), false}
+
+	names, err := rdb.client.ZRange(ctx, redisKeyNames, 0, -1).Result()
+	if err = redisError(ctx, err); err != nil {
+		return page, fmt.Errorf("failed to find basket names: %s", err)
+	}
+
+	skipped := 0
+	for index, name := range names {
+		// filter
+		if strings.Contains(name, query) {
+			if skipped < skip {
+				skipped++
+			} else {
+				page.Names = append(page.Names, name)
+			}
+		}
+
+		// early exit
+		if len(page.Names) == max {
+			page.HasMore = index < len(names)-1
+			break
+		}
+	}
+
+	return page, nil
+}
+
+// getScores returns scores of all members of sorted set
+func (rdb *redisDatabase) getScores(ctx context.Context, key string) (map[string]int64, error) {
+	values, err := rdb.client.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err = redisError(ctx, err); err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]int64, len(values))
+	for _, value := range values {
+		if member, ok := value.Member.(string); ok {
+			scores[member] = int64(value.Score)
+		}
+	}
+
+	return scores, nil
+}
+
+func (rdb *redisDatabase) GetStats(ctx context.Context, max int) (DatabaseStats, error) {
+	stats := DatabaseStats{}
+
+	names, err := rdb.client.ZRange(ctx, redisKeyNames, 0, -1).Result()
+	if err = redisError(ctx, err); err != nil {
+		return stats, fmt.Errorf("failed to get basket names: %s", err)
+	}
+	totals, err := rdb.getScores(ctx, redisKeyTotals)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get total requests count of baskets: %s", err)
+	}
+	dates, err := rdb.getScores(ctx, redisKeyDates)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get last request date of baskets: %s", err)
+	}
+
+	for _, name := range names {
+		size, err := rdb.client.LLen(ctx, redisPrefixRequests+name).Result()
+		if err = redisError(ctx, err); err != nil {
+			return stats, fmt.Errorf("failed to get size of basket: %s - %s", name, err)
+		}
+
+		stats.Collect(&BasketInfo{
+			Name:               name,
+			RequestsCount:      int(size),
+			RequestsTotalCount: int(totals[name]),
+			LastRequestDate:    dates[name]}, max)
+	}
+
+	stats.UpdateAvarage()
+	return stats, nil
+}
+
+func (rdb *redisDatabase) Release() {
+	log.Print("[info] closing Redis database")
+	if err := rdb.client.Close(); err != nil {
+		log.Printf("[error] failed to close Redis database: %s", err)
+	}
+}
+
+// NewRedisDatabase creates an instance of Baskets Database backed with Redis
+func NewRedisDatabase(connection string) BasketsDatabase {
+	log.Print("[info] using Redis database to store baskets")
+
+	options, err := parseRedisOptions(connection)
+	if err != nil {
+		log.Printf("[error] %s", err)
+		return nil
+	}
+	log.Printf("[info] Redis database address: %s, database: %d", options.Addr, options.DB)
+
+	client := redis.NewClient(options)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		log.Printf("[error] Redis database connection is not alive: %s - %s", options.Addr, err)
+		client.Close()
+		return nil
+	}
+
+	return &redisDatabase{client}
+}
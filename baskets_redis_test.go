@@ -0,0 +1,1115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Note: since Redis server is shared, these tests cannot run in parallel
+func TestRedisDatabase_Create(t *testing.T) {
+	name := "test1"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	auth, err := db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, auth.Token, "basket token may not be empty")
+		assert.False(t, len(auth.Token) < 30, "weak basket token: %v", auth.Token)
+	}
+}
+
+func TestRedisDatabase_Create_NameConflict(t *testing.T) {
+	name := "test2"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	auth, err := db.Create(name, BasketConfig{Capacity: 20})
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), ": "+name+" ", "error is not detailed enough")
+		assert.Empty(t, auth.Token, "basket token is not expected")
+	}
+}
+
+func TestRedisDatabase_Get(t *testing.T) {
+	name := "test3"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	auth, err := db.Create(name, BasketConfig{Capacity: 16})
+	defer db.Delete(name)
+
+	assert.NoError(t, err)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.True(t, basket.Authorize(auth.Token), "basket authorization has failed")
+		assert.Equal(t, 16, basket.Config().Capacity, "wrong capacity")
+	}
+}
+
+func TestRedisDatabase_Get_NotFound(t *testing.T) {
+	name := "test4"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	basket := db.Get(name)
+	assert.Nil(t, basket, "basket with name: %v is not expected", name)
+}
+
+func TestRedisDatabase_Delete(t *testing.T) {
+	name := "test5"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 10})
+	assert.NotNil(t, db.Get(name), "basket with name: %v is expected", name)
+
+	db.Delete(name)
+	assert.Nil(t, db.Get(name), "basket with name: %v is not expected", name)
+}
+
+func TestRedisDatabase_Delete_Multi(t *testing.T) {
+	name := "test6"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	config := BasketConfig{Capacity: 10}
+	for i := 0; i < 10; i++ {
+		bname := fmt.Sprintf("%s_%v", name, i)
+		db.Create(bname, config)
+		defer db.Delete(bname)
+	}
+
+	dname := name + "_5"
+
+	assert.NotNil(t, db.Get(dname), "basket with name: %v is expected", name)
+	assert.Equal(t, 10, db.Size(), "wrong database size")
+
+	db.Delete(dname)
+
+	assert.Nil(t, db.Get(dname), "basket with name: %v is not expected", name)
+	assert.Equal(t, 9, db.Size(), "wrong database size")
+}
+
+func TestRedisDatabase_Size(t *testing.T) {
+	name := "test7"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	config := BasketConfig{Capacity: 15}
+	for i := 0; i < 25; i++ {
+		bname := fmt.Sprintf("%s_%v", name, i)
+		db.Create(bname, config)
+		defer db.Delete(bname)
+	}
+
+	assert.Equal(t, 25, db.Size(), "wrong database size")
+}
+
+func TestRedisDatabase_GetNames(t *testing.T) {
+	name := "test8"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	config := BasketConfig{Capacity: 15}
+	for i := 0; i < 45; i++ {
+		bname := fmt.Sprintf("%s_%v", name, i)
+		db.Create(bname, config)
+		defer db.Delete(bname)
+	}
+
+	// Get and validate page 1 (test8_0, test8_1, test8_10, test8_11, ... - sorted)
+	page1 := db.GetNames(10, 0)
+	assert.Equal(t, 45, page1.Count, "wrong baskets count")
+	assert.True(t, page1.HasMore, "expected more names")
+	assert.Len(t, page1.Names, 10, "wrong page size")
+	assert.Equal(t, "test8_10", page1.Names[2], "wrong basket name at index #2")
+
+	// Get and validate page 5 (test8_5, test8_6, test8_7, test8_8, test8_9)
+	page5 := db.GetNames(10, 40)
+	assert.Equal(t, 45, page5.Count, "wrong baskets count")
+	assert.False(t, page5.HasMore, "no more names are expected")
+	assert.Len(t, page5.Names, 5, "wrong page size")
+	assert.Equal(t, "test8_5", page5.Names[0], "wrong basket name at index #0")
+
+	// Corner cases
+	assert.Empty(t, db.GetNames(0, 0).Names, "names are not expected")
+	assert.False(t, db.GetNames(5, 40).HasMore, "no more names are expected")
+}
+
+func TestRedisDatabase_FindNames(t *testing.T) {
+	name := "test9"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	config := BasketConfig{Capacity: 5}
+	for i := 0; i < 35; i++ {
+		bname := fmt.Sprintf("%s_%v", name, i)
+		db.Create(bname, config)
+		defer db.Delete(bname)
+	}
+
+	res1 := db.FindNames("test9_2", 20, 0)
+	assert.False(t, res1.HasMore, "no more names are expected")
+	assert.Len(t, res1.Names, 11, "wrong number of found names")
+	for _, name := range res1.Names {
+		assert.Contains(t, name, "test9_2", "invalid name among search results")
+	}
+
+	res2 := db.FindNames("test9_1", 5, 0)
+	assert.True(t, res2.HasMore, "more names are expected")
+	assert.Len(t, res2.Names, 5, "wrong number of found names")
+
+	// Corner cases
+	assert.Len(t, db.FindNames("test9_1", 5, 10).Names, 1, "wrong number of returned names")
+	assert.Empty(t, db.FindNames("test9_2", 5, 20).Names, "names in this page are not expected")
+	assert.False(t, db.FindNames("test9_3", 5, 6).HasMore, "no more names are expected")
+	assert.False(t, db.FindNames("abc", 5, 0).HasMore, "no more names are expected")
+	assert.Empty(t, db.FindNames("xyz", 5, 0).Names, "names are not expected")
+}
+
+func TestRedisBasket_Add(t *testing.T) {
+	name := "test101"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// add 1st HTTP request
+		content := "{ \"user\": \"tester\", \"age\": 24 }"
+		data := ToRequestData(createTestPOSTRequest(
+			fmt.Sprintf("http://localhost/%v/demo?name=abc&ver=12", name), content, "application/json"))
+		basket.Add(data)
+
+		assert.Equal(t, 1, basket.Size(), "wrong basket size")
+
+		// detailed http.Request to RequestData tests should be covered by test of ToRequestData function
+		assert.Equal(t, content, data.Body, "wrong body")
+		assert.Equal(t, int64(len(content)), data.ContentLength, "wrong content length")
+
+		// add 2nd HTTP request
+		basket.Add(ToRequestData(createTestPOSTRequest(fmt.Sprintf("http://localhost/%v/demo", name), "Hellow world", "text/plain")))
+		assert.Equal(t, 2, basket.Size(), "wrong basket size")
+	}
+}
+
+func TestRedisBasket_Add_ExceedLimit(t *testing.T) {
+	name := "test102"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 10})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// fill basket
+		for i := 0; i < 35; i++ {
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
+		}
+		assert.Equal(t, 10, basket.Size(), "wrong basket size")
+	}
+}
+
+func TestRedisBasket_Clear(t *testing.T) {
+	name := "test103"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// fill basket
+		for i := 0; i < 15; i++ {
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
+		}
+		assert.Equal(t, 15, basket.Size(), "wrong basket size")
+
+		// clean basket
+		basket.Clear()
+		assert.Equal(t, 0, basket.Size(), "wrong basket size, empty basket is expected")
+	}
+}
+
+func TestRedisBasket_Update_Shrink(t *testing.T) {
+	name := "test104"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 30})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// fill basket
+		for i := 0; i < 25; i++ {
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo", name), fmt.Sprintf("test%v", i), "text/plain")))
+		}
+		assert.Equal(t, 25, basket.Size(), "wrong basket size")
+
+		// update config with lower capacity
+		config := basket.Config()
+		config.Capacity = 12
+		basket.Update(config)
+		assert.Equal(t, config.Capacity, basket.Size(), "wrong basket size")
+	}
+}
+
+func TestRedisBasket_GetRequests(t *testing.T) {
+	name := "test105"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 25})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// fill basket
+		for i := 1; i <= 35; i++ {
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v/demo?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain")))
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.Equal(t, 25, basket.Size(), "wrong basket size")
+
+		// Get and validate last 10 requests
+		page1 := basket.GetRequests(10, 0)
+		assert.True(t, page1.HasMore, "expected more requests")
+		assert.Len(t, page1.Requests, 10, "wrong page size")
+		assert.Equal(t, 25, page1.Count, "wrong requests count")
+		assert.Equal(t, 35, page1.TotalCount, "wrong requests total count")
+		assert.Equal(t, "req35", page1.Requests[0].Body, "last request #35 is expected at index #0")
+
+		// Get and validate 10 requests, skip 20
+		page3 := basket.GetRequests(10, 20)
+		assert.False(t, page3.HasMore, "no more requests are expected")
+		assert.Len(t, page3.Requests, 5, "wrong page size")
+		assert.Equal(t, 25, page3.Count, "wrong requests count")
+		assert.Equal(t, 35, page3.TotalCount, "wrong requests total count")
+		assert.Equal(t, "req15", page3.Requests[0].Body, "request #15 is expected at index #0")
+
+		// Get only collected statistics
+		page0 := basket.GetRequests(0, 0)
+		assert.True(t, page0.HasMore, "expected more requests")
+		assert.Empty(t, page0.Requests, "requests are not expected")
+		assert.Equal(t, 25, page1.Count, "wrong requests count")
+		assert.Equal(t, 35, page1.TotalCount, "wrong requests total count")
+	}
+}
+
+func TestRedisBasket_FindRequests(t *testing.T) {
+	name := "test106"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 100})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// fill basket
+		for i := 1; i <= 30; i++ {
+			r := createTestPOSTRequest(fmt.Sprintf("http://localhost/%v?id=%v", name, i), fmt.Sprintf("req%v", i), "text/plain")
+			r.Header.Add("HeaderId", fmt.Sprintf("header%v", i))
+			if i <= 10 {
+				r.Header.Add("ChocoPie", "yummy")
+			}
+			if i <= 20 {
+				r.Header.Add("Muffin", "tasty")
+			}
+			basket.Add(ToRequestData(r))
+		}
+		assert.Equal(t, 30, basket.Size(), "wrong basket size")
+
+		// search everywhere
+		s1 := basket.FindRequests("req1", "any", 30, 0)
+		assert.False(t, s1.HasMore, "no more results are expected")
+		assert.Len(t, s1.Requests, 11, "wrong number of found requests")
+		for _, r := range s1.Requests {
+			assert.Contains(t, r.Body, "req1", "incorrect request among results")
+		}
+
+		// search everywhere (limited output)
+		s2 := basket.FindRequests("req2", "any", 5, 5)
+		assert.True(t, s2.HasMore, "more results are expected")
+		assert.Len(t, s2.Requests, 5, "wrong number of found requests")
+
+		// search everywhere with max = 0
+		assert.Empty(t, basket.FindRequests("req2", "any", 0, 0).Requests, "found unexpected requests")
+
+		// search in body (positive)
+		assert.Len(t, basket.FindRequests("req3", "body", 100, 0).Requests, 2, "wrong number of found requests")
+		// search in body (negative)
+		assert.Empty(t, basket.FindRequests("yummy", "body", 100, 0).Requests, "found unexpected requests")
+
+		// search in headers (positive)
+		assert.Len(t, basket.FindRequests("yummy", "headers", 100, 0).Requests, 10, "wrong number of found requests")
+		assert.Len(t, basket.FindRequests("tasty", "headers", 100, 0).Requests, 20, "wrong number of found requests")
+		// search in headers (negative)
+		assert.Empty(t, basket.FindRequests("req1", "headers", 100, 0).Requests, "found unexpected requests")
+
+		// search in query (positive)
+		assert.Len(t, basket.FindRequests("id=1", "query", 100, 0).Requests, 11, "wrong number of found requests")
+		// search in query (negative)
+		assert.Empty(t, basket.FindRequests("tasty", "query", 100, 0).Requests, "found unexpected requests")
+	}
+}
+
+func TestRedisBasket_SetResponse(t *testing.T) {
+	name := "test107"
+	method := "POST"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// Ensure no response
+		assert.Nil(t, basket.GetResponse(method))
+
+		// Set response
+		basket.SetResponse(method, ResponseConfig{Status: 201, Body: "{ 'message' : 'created' }"})
+		// Get and validate
+		response := basket.GetResponse(method)
+		if assert.NotNil(t, response, "response for method: %v is expected", method) {
+			assert.Equal(t, 201, response.Status, "wrong HTTP response status")
+			assert.Equal(t, "{ 'message' : 'created' }", response.Body, "wrong HTTP response body")
+			assert.False(t, response.IsTemplate, "template is not expected")
+		}
+	}
+}
+
+func TestRedisBasket_SetResponse_Update(t *testing.T) {
+	name := "test108"
+	method := "GET"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// Set response
+		basket.SetResponse(method, ResponseConfig{Status: 200, Body: ""})
+		// Update response
+		basket.SetResponse(method, ResponseConfig{Status: 200, Body: "welcome", IsTemplate: true})
+		// Get and validate
+		response := basket.GetResponse(method)
+		if assert.NotNil(t, response, "response for method: %v is expected", method) {
+			assert.Equal(t, 200, response.Status, "wrong HTTP response status")
+			assert.Equal(t, "welcome", response.Body, "wrong HTTP response body")
+			assert.True(t, response.IsTemplate, "template is expected")
+		}
+	}
+}
+
+func TestRedisBasket_Config_Error(t *testing.T) {
+	name := "test120"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 30, ForwardURL: "http://localhost:8080"})
+	basket := db.Get(name)
+	// delete basket
+	db.Delete(name)
+
+	// try to get configuration of deleted basket
+	config, err := basket.Basket.Config(context.Background())
+	assert.Error(t, err, "error is expected for deleted basket")
+	if assert.NotNil(t, config, "configuration is expected") {
+		// empty config is expected
+		assert.Equal(t, 0, config.Capacity, "Capacity is not expected")
+		assert.Empty(t, config.ForwardURL, "ForwardURL is not expected")
+	}
+}
+
+func TestRedisDatabase_GetStats(t *testing.T) {
+	name := "test130"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	config := BasketConfig{Capacity: 5}
+	for i := 0; i < 10; i++ {
+		bname := fmt.Sprintf("%s_%v", name, i)
+		db.Create(bname, config)
+		defer db.Delete(bname)
+
+		// fill basket
+		basket := db.Get(bname)
+		for j := 0; j < 9-i; j++ {
+			basket.Add(ToRequestData(createTestPOSTRequest(
+				fmt.Sprintf("http://localhost/%v?id=%v", bname, j), fmt.Sprintf("req%v", j), "text/plain")))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// get stats
+	stats := db.GetStats(3)
+	if assert.NotNil(t, stats, "database statistics is expected") {
+		assert.Equal(t, 10, stats.BasketsCount, "wrong BasketsCount stats")
+		assert.Equal(t, 1, stats.EmptyBasketsCount, "wrong EmptyBasketsCount stats")
+		assert.Equal(t, 9, stats.MaxBasketSize, "wrong MaxBasketSize stats")
+		assert.Equal(t, 35, stats.RequestsCount, "wrong RequestsCount stats")
+		assert.Equal(t, 45, stats.RequestsTotalCount, "wrong RequestsTotalCount stats")
+		assert.Equal(t, 5, stats.AvgBasketSize, "wrong AvgBasketSize stats")
+
+		// top 3 by date
+		if assert.NotNil(t, stats.TopBasketsByDate, "top baskets by date are expected") {
+			assert.Equal(t, 3, len(stats.TopBasketsByDate), "unexpected number of top baskets")
+			test_validateBasketStats(t, stats.TopBasketsByDate[0], fmt.Sprintf("%s_%v", name, 8), 1, 1)
+			test_validateBasketStats(t, stats.TopBasketsByDate[1], fmt.Sprintf("%s_%v", name, 7), 2, 2)
+			test_validateBasketStats(t, stats.TopBasketsByDate[2], fmt.Sprintf("%s_%v", name, 6), 3, 3)
+		}
+
+		// top 3 by size
+		if assert.NotNil(t, stats.TopBasketsBySize, "top baskets by size are expected") {
+			assert.Equal(t, 3, len(stats.TopBasketsBySize), "unexpected number of top baskets")
+			test_validateBasketStats(t, stats.TopBasketsBySize[0], fmt.Sprintf("%s_%v", name, 0), 5, 9)
+			test_validateBasketStats(t, stats.TopBasketsBySize[1], fmt.Sprintf("%s_%v", name, 1), 5, 8)
+			test_validateBasketStats(t, stats.TopBasketsBySize[2], fmt.Sprintf("%s_%v", name, 2), 5, 7)
+		}
+	}
+}
+
+func TestRedisBasket_Update_ForwardTargets(t *testing.T) {
+	name := "test140"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		config := basket.Config()
+		assert.Equal(t, []ForwardTarget{{URL: "http://localhost:12345/a", InsecureTLS: true}}, config.ForwardTargets)
+
+		config.ForwardTargets = append(config.ForwardTargets, ForwardTarget{URL: "http://localhost:12345/b", ExpandPath: true, Primary: true})
+		basket.Update(config)
+		assert.Equal(t, config.ForwardTargets, basket.Config().ForwardTargets, "wrong forward targets")
+
+		config.ForwardTargets = nil
+		basket.Update(config)
+		assert.Empty(t, basket.Config().ForwardTargets, "forward targets are not expected")
+	}
+}
+
+func TestRedisBasket_DeadLetters(t *testing.T) {
+	name := "test141"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 2})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		for i := 0; i < 3; i++ {
+			basket.AddDeadLetter(&DeadLetter{ID: fmt.Sprintf("id%v", i), Date: int64(i), Attempts: 2, Error: "HTTP 503",
+				Target:  ForwardTarget{URL: "http://localhost:12345"},
+				Request: createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("test%v", i))})
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// basket capacity applies to undelivered requests
+		page := basket.GetDeadLetters(10, 0)
+		assert.Equal(t, 2, page.Count, "wrong number of dead letters")
+		assert.False(t, page.HasMore, "no more dead letters are expected")
+		if assert.Equal(t, 2, len(page.DeadLetters), "wrong number of dead letters") {
+			// newest come first
+			assert.Equal(t, "id2", page.DeadLetters[0].ID, "wrong dead letter")
+			assert.Equal(t, "id1", page.DeadLetters[1].ID, "wrong dead letter")
+			assert.Equal(t, "test2", page.DeadLetters[0].Request.Body, "wrong request body")
+		}
+
+		page = basket.GetDeadLetters(1, 0)
+		assert.True(t, page.HasMore, "more dead letters are expected")
+
+		if letter := basket.GetDeadLetter("id1"); assert.NotNil(t, letter, "dead letter is expected") {
+			assert.Equal(t, "http://localhost:12345", letter.Target.URL, "wrong target URL")
+			assert.Equal(t, 2, letter.Attempts, "wrong number of attempts")
+		}
+		assert.Nil(t, basket.GetDeadLetter("id0"), "evicted dead letter is not expected")
+
+		basket.RemoveDeadLetter("id1")
+		assert.Nil(t, basket.GetDeadLetter("id1"), "removed dead letter is not expected")
+		assert.Equal(t, 1, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+
+		basket.ClearDeadLetters()
+		assert.Equal(t, 0, basket.GetDeadLetters(10, 0).Count, "wrong number of dead letters")
+	}
+}
+
+func TestRedisBasket_Update_Retries(t *testing.T) {
+	name := "test142"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20, RetryCount: 3, RetryBackoff: 500})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, 3, basket.Config().RetryCount, "wrong retry count")
+		assert.Equal(t, 500, basket.Config().RetryBackoff, "wrong retry backoff")
+
+		config := basket.Config()
+		config.RetryCount = 0
+		config.RetryBackoff = 2000
+		basket.Update(config)
+		assert.Equal(t, 0, basket.Config().RetryCount, "wrong retry count")
+		assert.Equal(t, 2000, basket.Config().RetryBackoff, "wrong retry backoff")
+	}
+}
+
+func TestRedisBasket_Forwards(t *testing.T) {
+	name := "test143"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		store, ok := basket.Basket.(ForwardsStore)
+		if assert.True(t, ok, "basket is expected to persist queued forwards") {
+			assert.Empty(t, pendingForwards(t, store), "no queued forwards are expected")
+
+			for i := 0; i < 3; i++ {
+				assert.NoError(t, store.SaveForward(context.Background(), &PendingForward{ID: fmt.Sprintf("fwd%v", i), Date: int64(i),
+					Target:  ForwardTarget{URL: "http://localhost:12345", ExpandPath: true},
+					Request: createTestRequestData(fmt.Sprintf("/%v/%v", name, i), fmt.Sprintf("test%v", i))}))
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			claimed, err := store.ClaimForward(context.Background(), "fwd1")
+			if assert.NoError(t, err) {
+				assert.True(t, claimed, "queued forward is expected to be claimed")
+			}
+			claimed, err = store.ClaimForward(context.Background(), "fwd1")
+			if assert.NoError(t, err) {
+				assert.False(t, claimed, "queued forward is not expected to be claimed twice")
+			}
+
+			forwards := pendingForwards(t, store)
+			if assert.Equal(t, 2, len(forwards), "wrong number of queued forwards") {
+				// queued order is preserved
+				assert.Equal(t, "fwd0", forwards[0].ID, "wrong queued forward")
+				assert.Equal(t, "fwd2", forwards[1].ID, "wrong queued forward")
+				assert.Equal(t, "test2", forwards[1].Request.Body, "wrong request body")
+				assert.True(t, forwards[1].Target.ExpandPath, "wrong value of Expand Path flag")
+			}
+		}
+	}
+}
+
+func TestRedisBasket_Update_HeaderRules(t *testing.T) {
+	name := "test144"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20, HeaderRules: []HeaderRule{{Action: HeaderRuleRemove, Name: "Cookie"}}})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, []HeaderRule{{Action: HeaderRuleRemove, Name: "Cookie"}}, basket.Config().HeaderRules, "wrong header rules")
+
+		config := basket.Config()
+		config.HeaderRules = []HeaderRule{
+			{Action: HeaderRuleSet, Name: "Authorization", Value: "Bearer 123"},
+			{Action: HeaderRuleAdd, Name: "X-Method", Value: "{{.method}}"}}
+		basket.Update(config)
+		assert.Equal(t, config.HeaderRules, basket.Config().HeaderRules, "wrong header rules")
+
+		config.HeaderRules = nil
+		basket.Update(config)
+		assert.Empty(t, basket.Config().HeaderRules, "header rules are not expected")
+	}
+}
+
+func TestRedisBasket_Update_Transformation(t *testing.T) {
+	name := "test145"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20, ForwardMethod: "PUT"})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Equal(t, "PUT", basket.Config().ForwardMethod, "wrong forward method")
+		assert.Empty(t, basket.Config().ForwardBodyTemplate, "body template is not expected")
+
+		config := basket.Config()
+		config.ForwardMethod = ""
+		config.ForwardContentType = "application/json"
+		config.ForwardBodyTemplate = "{\"event\": \"{{.method}}\"}"
+		basket.Update(config)
+		assert.Empty(t, basket.Config().ForwardMethod, "forward method is not expected")
+		assert.Equal(t, "application/json", basket.Config().ForwardContentType, "wrong forward content type")
+		assert.Equal(t, "{\"event\": \"{{.method}}\"}", basket.Config().ForwardBodyTemplate, "wrong body template")
+	}
+}
+
+func TestRedisBasket_Update_ForwardRules(t *testing.T) {
+	name := "test146"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Empty(t, basket.Config().ForwardRules, "forward rules are not expected")
+
+		config := basket.Config()
+		config.ForwardRules = []ForwardRule{
+			{Name: "push", Headers: map[string]string{"X-GitHub-Event": "^push$"}, Targets: []ForwardTarget{{URL: "http://ci.local"}}},
+			{Name: "drop", Method: "GET", Drop: true}}
+		basket.Update(config)
+		assert.Equal(t, config.ForwardRules, basket.Config().ForwardRules, "wrong forward rules")
+
+		// fired rule is kept with collected request
+		data := createTestRequestData("/"+name, "test")
+		data.ForwardRule = "push"
+		basket.Add(data)
+		if page := basket.GetRequests(1, 0); assert.Equal(t, 1, len(page.Requests), "collected request is expected") {
+			assert.Equal(t, "push", page.Requests[0].ForwardRule, "wrong fired rule")
+		}
+	}
+}
+
+func TestRedisBasket_ForwardResults(t *testing.T) {
+	name := "test147"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		second := createTestRequestData("/"+name, "second")
+		basket.Add(second)
+
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200, Duration: 15, Body: "ok"})
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8081", Error: "connection refused"})
+		// unknown request is ignored
+		basket.AddForwardResult("abc", &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		page := basket.GetRequests(10, 0)
+		if assert.Equal(t, 2, len(page.Requests), "wrong number of requests") {
+			assert.Empty(t, page.Requests[0].Forwards, "results of forwarding are not expected")
+			if assert.Equal(t, 2, len(page.Requests[1].Forwards), "wrong number of forward results") {
+				assert.Equal(t, first.ID, page.Requests[1].ID, "wrong request ID")
+				assert.Equal(t, 200, page.Requests[1].Forwards[0].Status, "wrong status of target response")
+				assert.Equal(t, int64(15), page.Requests[1].Forwards[0].Duration, "wrong duration of forwarding")
+				assert.Equal(t, "ok", page.Requests[1].Forwards[0].Body, "wrong body of target response")
+				assert.Equal(t, "connection refused", page.Requests[1].Forwards[1].Error, "wrong error of forwarding")
+			}
+		}
+
+		// results are found with requests
+		found := basket.FindRequests("first", "body", 10, 0)
+		if assert.Equal(t, 1, len(found.Requests), "wrong number of found requests") {
+			assert.Equal(t, 2, len(found.Requests[0].Forwards), "wrong number of forward results")
+		}
+
+		// results are removed with requests
+		basket.Clear()
+		basket.Add(first)
+		assert.Empty(t, basket.GetRequests(10, 0).Requests[0].Forwards, "results of forwarding are not expected")
+	}
+}
+
+func TestRedisBasket_Update_Transport(t *testing.T) {
+	name := "test148"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().Transport, "transport settings are not expected")
+
+		follow := false
+		config := basket.Config()
+		config.Transport = &TransportConfig{ConnectTimeout: 1000, Timeout: 5000, FollowRedirects: &follow}
+		basket.Update(config)
+		if transport := basket.Config().Transport; assert.NotNil(t, transport, "transport settings are expected") {
+			assert.Equal(t, 1000, transport.ConnectTimeout, "wrong connect timeout")
+			assert.Equal(t, 0, transport.TLSTimeout, "TLS handshake timeout is not expected")
+			assert.Equal(t, 5000, transport.Timeout, "wrong timeout")
+			if assert.NotNil(t, transport.FollowRedirects, "redirects policy is expected") {
+				assert.False(t, *transport.FollowRedirects, "wrong redirects policy")
+			}
+			assert.Nil(t, transport.HTTP2, "HTTP/2 preference is not expected")
+		}
+
+		config.Transport = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().Transport, "transport settings are not expected")
+	}
+}
+
+func TestRedisBasket_Update_Signing(t *testing.T) {
+	name := "test149"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().Signing, "signing settings are not expected")
+
+		config := basket.Config()
+		config.Signing = &SigningConfig{Header: "X-Signature", Secret: "secret"}
+		basket.Update(config)
+		if signing := basket.Config().Signing; assert.NotNil(t, signing, "signing settings are expected") {
+			assert.Equal(t, "X-Signature", signing.Header, "wrong signature header")
+			assert.Equal(t, "secret", signing.Secret, "wrong signing secret")
+			assert.Empty(t, signing.Algorithm, "signing algorithm is not expected")
+		}
+
+		config.Signing = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().Signing, "signing settings are not expected")
+	}
+}
+
+func TestRedisBasket_GetRequest(t *testing.T) {
+	name := "test150"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		time.Sleep(5 * time.Millisecond)
+		basket.Add(createTestRequestData("/"+name, "second"))
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+
+		if request := basket.GetRequest(first.ID); assert.NotNil(t, request, "request is expected") {
+			assert.Equal(t, first.ID, request.ID, "wrong request ID")
+			assert.Equal(t, "first", request.Body, "wrong request body")
+			assert.Equal(t, 1, len(request.Forwards), "result of forwarding is expected")
+		}
+		assert.Nil(t, basket.GetRequest("abc"), "unknown request is not expected")
+	}
+}
+
+func TestRedisBasket_AddWebSocketFrames(t *testing.T) {
+	name := "test151"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		request := createTestRequestData("/"+name, "")
+		basket.Add(request)
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{
+			{Direction: WebSocketIn, Type: "text", Final: true, Size: 5, Data: "hello"},
+			{Direction: WebSocketOut, Type: "text", Final: true, Size: 5, Data: "hello"}})
+		basket.AddForwardResult(request.ID, &ForwardResult{URL: "http://localhost:8080", Status: 101})
+		basket.AddWebSocketFrames(request.ID, []*WebSocketFrame{{Direction: WebSocketIn, Type: "close", Final: true, Size: 2, Data: "1000 "}})
+		basket.AddWebSocketFrames("abc", []*WebSocketFrame{{Direction: WebSocketIn, Type: "ping", Final: true}})
+
+		if saved := basket.GetRequest(request.ID); assert.NotNil(t, saved, "request is expected") {
+			if assert.Equal(t, 3, len(saved.Frames), "WebSocket frames are expected") {
+				assert.Equal(t, "hello", saved.Frames[0].Data, "wrong data of frame")
+				assert.Equal(t, WebSocketOut, saved.Frames[1].Direction, "wrong direction of frame")
+				assert.Equal(t, "close", saved.Frames[2].Type, "wrong type of frame")
+			}
+			assert.Equal(t, 1, len(saved.Forwards), "result of forwarding is expected to be kept")
+		}
+		assert.Equal(t, 3, len(basket.GetRequests(10, 0).Requests[0].Frames), "frames are expected with collected request")
+	}
+}
+
+func TestRedisBasket_RequestKeys(t *testing.T) {
+	name := "test177"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 2})
+	defer db.Delete(name)
+
+	client := redisTestClient(t)
+	defer client.Close()
+	ctx := context.Background()
+	exists := func(keys ...string) int64 {
+		count, err := client.Exists(ctx, keys...).Result()
+		assert.NoError(t, err)
+		return count
+	}
+	requestKeys := func(id string) []string {
+		return []string{redisPrefixForwardResults + name + ":" + id, redisPrefixFrames + name + ":" + id}
+	}
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		old := createTestRequestData("/"+name, "old")
+		old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+		basket.Add(old)
+		basket.AddForwardResult(old.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+		basket.AddWebSocketFrames(old.ID, []*WebSocketFrame{{Direction: WebSocketIn, Type: "text", Final: true}})
+		assert.Equal(t, int64(2), exists(requestKeys(old.ID)...), "results and frames of request are expected")
+
+		// expired request is deleted along with its results and frames
+		assert.Equal(t, 1, basket.DeleteExpired(time.Hour), "wrong number of deleted requests")
+		assert.Equal(t, int64(0), exists(requestKeys(old.ID)...), "results and frames of request are not expected")
+
+		// evicted request is deleted along with its results and frames
+		first := createTestRequestData("/"+name, "first")
+		basket.Add(first)
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+		basket.Add(createTestRequestData("/"+name, "second"))
+		basket.Add(createTestRequestData("/"+name, "third"))
+		assert.Equal(t, 2, basket.Size(), "wrong basket size")
+		assert.Nil(t, basket.GetRequest(first.ID), "evicted request is not expected")
+		assert.Equal(t, int64(0), exists(requestKeys(first.ID)...), "results of evicted request are not expected")
+		size, _ := client.HLen(ctx, redisPrefixRequestData+name).Result()
+		assert.Equal(t, int64(2), size, "evicted request is not expected to be kept")
+		size, _ = client.ZCard(ctx, redisPrefixRequestDates+name).Result()
+		assert.Equal(t, int64(2), size, "date of evicted request is not expected to be kept")
+
+		// results of evicted request are dropped
+		basket.AddForwardResult(first.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200})
+		assert.Equal(t, int64(0), exists(requestKeys(first.ID)...), "results of evicted request are not expected")
+
+		// requests without ID that are imported from archives of previous versions are kept
+		legacy := createTestRequestData("/"+name, "legacy")
+		legacy.ID = ""
+		legacy.Forwards = []*ForwardResult{{URL: "http://localhost:8080", Status: 201}}
+		basket.Add(legacy)
+		if page := basket.GetRequests(10, 0); assert.Equal(t, 2, len(page.Requests), "wrong number of requests") {
+			assert.Empty(t, page.Requests[0].ID, "ID of request is not expected")
+			assert.Equal(t, "legacy", page.Requests[0].Body, "wrong request body")
+			if assert.Equal(t, 1, len(page.Requests[0].Forwards), "result of forwarding is expected") {
+				assert.Equal(t, 201, page.Requests[0].Forwards[0].Status, "wrong status of target response")
+			}
+		}
+
+		// all keys of requests are deleted with basket
+		ids, _ := client.LRange(ctx, redisPrefixRequests+name, 0, -1).Result()
+		db.Delete(name)
+		assert.Equal(t, int64(0), exists(redisPrefixRequests+name, redisPrefixRequestData+name, redisPrefixRequestDates+name),
+			"keys of collected requests are not expected")
+		for _, id := range ids {
+			assert.Equal(t, int64(0), exists(requestKeys(id)...), "results and frames of request are not expected")
+		}
+	}
+}
+
+func TestRedisBasket_FindRequests_Batches(t *testing.T) {
+	name := "test178"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 3 * redisReadBatch})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		for i := 0; i < 3*redisReadBatch; i++ {
+			request := createTestRequestData("/"+name, fmt.Sprintf("body %v", i%2))
+			basket.Add(request)
+			basket.AddForwardResult(request.ID, &ForwardResult{URL: "http://localhost:8080", Status: 200 + i%2})
+		}
+
+		page := basket.FindRequests("body 1", "body", 20, redisReadBatch)
+		if assert.Equal(t, 20, len(page.Requests), "wrong number of found requests") {
+			assert.True(t, page.HasMore, "more requests are expected")
+			for _, request := range page.Requests {
+				assert.Equal(t, "body 1", request.Body, "wrong request body")
+				if assert.Equal(t, 1, len(request.Forwards), "result of forwarding is expected") {
+					assert.Equal(t, 201, request.Forwards[0].Status, "wrong status of target response")
+				}
+			}
+		}
+
+		page = basket.FindRequests("body 0", "body", 20, 3*redisReadBatch/2-10)
+		assert.Equal(t, 10, len(page.Requests), "wrong number of found requests")
+		assert.False(t, page.HasMore, "more requests are not expected")
+	}
+}
+
+func TestRedisBasket_Update_CircuitBreaker(t *testing.T) {
+	name := "test152"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20, RateLimit: 5})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		assert.Nil(t, basket.Config().CircuitBreaker, "circuit breaker settings are not expected")
+		assert.Equal(t, 5, basket.Config().RateLimit, "wrong rate limit")
+
+		config := basket.Config()
+		config.CircuitBreaker = &CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: 60000}
+		config.RateLimit = 0
+		basket.Update(config)
+		if breaker := basket.Config().CircuitBreaker; assert.NotNil(t, breaker, "circuit breaker settings are expected") {
+			assert.Equal(t, 3, breaker.FailureThreshold, "wrong failure threshold")
+			assert.Equal(t, 60000, breaker.OpenDuration, "wrong open duration")
+		}
+		assert.Equal(t, 0, basket.Config().RateLimit, "rate limit is not expected")
+
+		config.CircuitBreaker = nil
+		basket.Update(config)
+		assert.Nil(t, basket.Config().CircuitBreaker, "circuit breaker settings are not expected")
+	}
+}
+
+func TestNewRedisDatabase_Error(t *testing.T) {
+	assert.Nil(t, NewRedisDatabase("redis://localhost/abc"), "invalid connection is not expected")
+
+	// reserve a port that is not listened
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if assert.NoError(t, err) {
+		addr := listener.Addr().String()
+		listener.Close()
+		assert.Nil(t, NewRedisDatabase("redis://"+addr), "Redis database is not expected without server")
+	}
+}
+
+func TestRedisBasket_DeleteExpired(t *testing.T) {
+	name := "test157"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		// collect outdated request
+		old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+		old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+		basket.Add(old)
+		// collect recent requests
+		for i := 0; i < 3; i++ {
+			basket.Add(createTestRequestData(fmt.Sprintf("/%v/new", name), fmt.Sprintf("test%v", i)))
+		}
+		assert.Equal(t, 4, basket.Size(), "wrong basket size")
+
+		assert.Equal(t, 0, basket.DeleteExpired(3*time.Hour), "no expired requests are expected")
+		assert.Equal(t, 1, basket.DeleteExpired(time.Hour), "wrong number of deleted requests")
+		assert.Equal(t, 3, basket.Size(), "wrong basket size")
+		page := basket.GetRequests(10, 0)
+		assert.Equal(t, 3, page.Count, "wrong requests count")
+		assert.Equal(t, 4, page.TotalCount, "wrong total count")
+		for _, request := range page.Requests {
+			assert.Equal(t, fmt.Sprintf("/%v/new", name), request.Path, "outdated request is not expected")
+		}
+	}
+}
+
+func TestRedisBasket_Activity(t *testing.T) {
+	name := "test163"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		activity := basket.Activity()
+		assert.InDelta(t, time.Now().UnixNano()/toMs, activity.CreatedDate, 5000, "wrong creation date")
+		assert.Zero(t, activity.LastRequestDate, "no requests are expected")
+
+		request := createTestRequestData(fmt.Sprintf("/%v/demo", name), "test")
+		request.Date -= 1000
+		basket.Add(request)
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+
+		// date of the last request is kept even if requests are deleted
+		basket.Clear()
+		assert.Equal(t, request.Date, basket.Activity().LastRequestDate, "wrong date of the last request")
+		assert.Equal(t, activity.CreatedDate, basket.Activity().CreatedDate, "creation date is not expected to change")
+	}
+}
+
+func TestRedisBasket_Activity_UnknownCreation(t *testing.T) {
+	name := "test166"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	defer db.Delete(name)
+
+	// basket created by previous version of service
+	client := db.BasketsDatabase.(*redisDatabase).client
+	assert.NoError(t, client.HDel(context.Background(), redisPrefixBasket+name, redisFieldCreated).Err())
+
+	basket := db.Get(name)
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		created := basket.Activity().CreatedDate
+		assert.InDelta(t, time.Now().UnixNano()/toMs, created, 5000, "wrong creation date")
+
+		value, err := client.HGet(context.Background(), redisPrefixBasket+name, redisFieldCreated).Result()
+		if assert.NoError(t, err) {
+			assert.Equal(t, fmt.Sprint(created), value, "creation date is expected to be recorded")
+		}
+	}
+}
+
+func TestRedisBasket_Activity_Deleted(t *testing.T) {
+	name := "test167"
+	db := testDB(t, NewRedisDatabase(redisTestConnection()))
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	basket := db.Get(name)
+	db.Delete(name)
+
+	if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+		_, err := basket.Basket.Activity(context.Background())
+		assert.Error(t, err, "error is expected for deleted basket")
+		assert.Nil(t, db.Get(name), "deleted basket is not expected to be restored")
+	}
+}
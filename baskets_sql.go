@@ -1,53 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"regexp"
 	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
 // DbTypeSQL defines name of SQL database storage
 const DbTypeSQL = "sql"
 
-// List of DDL statements to create database schema for baskets
-var sqlSchema = []string{
-	`CREATE TABLE rb_baskets (
-		basket_name varchar(250) PRIMARY KEY,
-		token varchar(100) NOT NULL,
-		capacity integer NOT NULL,
-		forward_url text NOT NULL,
-		proxy_response boolean NOT NULL,
-		insecure_tls boolean NOT NULL,
-		expand_path boolean NOT NULL,
-		requests_count integer NOT NULL DEFAULT 0,
-		created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
-	)`,
-	`CREATE TABLE rb_responses (
-		basket_name varchar(250) NOT NULL,
-		http_method varchar(20) NOT NULL,
-		response text NOT NULL,
-		PRIMARY KEY (basket_name, http_method),
-		FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
-	)`,
-	`CREATE TABLE rb_requests (
-		basket_name varchar(250) NOT NULL,
-		request text NOT NULL,
-		created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
-		FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
-	)`,
-	`CREATE INDEX rb_requests_name_time_index ON rb_requests (basket_name, created_at)`,
-	`CREATE TABLE rb_version (
-		version integer NOT NULL
-	)`,
-	`INSERT INTO rb_version (version) VALUES (1)`}
+// sqlExecutor runs SQL statements, it is implemented by both database and transaction
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
 // Basket interface //
 type sqlBasket struct {
@@ -56,232 +31,797 @@ type sqlBasket struct {
 	name   string
 }
 
-func (basket *sqlBasket) getInt(sql string, defaultValue int) int {
+func (basket *sqlBasket) getInt(ctx context.Context, sql string) (int, error) {
+	return basket.queryInt(ctx, basket.db, sql)
+}
+
+func (basket *sqlBasket) queryInt(ctx context.Context, exec sqlExecutor, sql string) (int, error) {
 	var value int
-	if err := basket.db.QueryRow(unifySQL(basket.dbType, sql), basket.name).Scan(&value); err != nil {
-		log.Printf("[error] failed to get counter info about basket: %s - %s", basket.name, err)
-		return defaultValue
+	if err := exec.QueryRowContext(ctx, unifySQL(basket.dbType, sql), basket.name).Scan(&value); err != nil {
+		return 0, fmt.Errorf("failed to get counter info about basket: %s - %s", basket.name, err)
 	}
 
-	return value
+	return value, nil
+}
+
+// capacity returns basket capacity, the initial capacity is applied if capacity is not defined in database,
+// e.g. for rows inserted by previous versions or modified manually
+func (basket *sqlBasket) capacity(ctx context.Context, exec sqlExecutor) (int, error) {
+	capacity, err := basket.queryInt(ctx, exec, "SELECT capacity FROM rb_baskets WHERE basket_name = $1")
+	if err == nil && capacity <= 0 {
+		capacity = serverConfig.InitCapacity
+	}
+	return capacity, err
 }
 
-func (basket *sqlBasket) applyLimit(capacity int) {
+func (basket *sqlBasket) applyLimit(ctx context.Context, capacity int) error {
 	// keep the number of requests up to specified capacity
-	size := basket.Size()
+	size, err := basket.Size(ctx)
+	if err != nil {
+		return err
+	}
 
 	if size > capacity {
-		var cleanupSQL string
+		if err = basket.deleteOldest(ctx, basket.db, "rb_requests", size-capacity); err != nil {
+			return fmt.Errorf("failed to shrink collected requests: %s - %s", basket.name, err)
+		}
+		if err = basket.deleteOrphanedForwardResults(ctx, basket.db); err != nil {
+			return fmt.Errorf("failed to clean up basket: %s - %s", basket.name, err)
+		}
+	}
+	return nil
+}
 
-		// Note: 'ctid' is PostgreSQL specific
-		// see example for MySQL here: https://stackoverflow.com/questions/5170546
-		switch basket.dbType {
-		case "postgres":
-			cleanupSQL = "DELETE FROM rb_requests WHERE ctid IN (SELECT ctid FROM rb_requests WHERE basket_name = $1 ORDER BY created_at LIMIT $2)"
-		default:
-			cleanupSQL = "DELETE FROM rb_requests WHERE basket_name = ? ORDER BY created_at LIMIT ?"
+// deleteOrphanedForwardResults deletes the results of forwarding and WebSocket frames for requests that are removed
+// from the basket
+func (basket *sqlBasket) deleteOrphanedForwardResults(ctx context.Context, exec sqlExecutor) error {
+	_, err := exec.ExecContext(ctx, unifySQL(basket.dbType,
+		"DELETE FROM rb_forward_results WHERE basket_name = $1 AND request_id NOT IN "+
+			"(SELECT request_id FROM rb_requests WHERE basket_name = $2 AND request_id IS NOT NULL)"),
+		basket.name, basket.name)
+	if err != nil {
+		return fmt.Errorf("failed to delete results of forwarding - %s", err)
+	}
+
+	_, err = exec.ExecContext(ctx, unifySQL(basket.dbType,
+		"DELETE FROM rb_websocket_frames WHERE basket_name = $1 AND request_id NOT IN "+
+			"(SELECT request_id FROM rb_requests WHERE basket_name = $2 AND request_id IS NOT NULL)"),
+		basket.name, basket.name)
+	if err != nil {
+		return fmt.Errorf("failed to delete WebSocket frames - %s", err)
+	}
+	return nil
+}
+
+// queryRequestDetails selects values of the column in the table for collected requests with a single query,
+// the values are passed to the function in the order of creation; requests without ID are skipped
+func (basket *sqlBasket) queryRequestDetails(ctx context.Context, table string, column string, requests []*RequestData,
+	add func(request *RequestData, value string)) error {
+	byID := make(map[string][]*RequestData, len(requests))
+	args := []interface{}{basket.name}
+	params := make([]string, 0, len(requests))
+	for _, request := range requests {
+		if len(request.ID) == 0 {
+			continue
+		}
+		if _, exists := byID[request.ID]; !exists {
+			args = append(args, request.ID)
+			params = append(params, fmt.Sprintf("$%d", len(args)))
+		}
+		byID[request.ID] = append(byID[request.ID], request)
+	}
+	if len(params) == 0 {
+		return nil
+	}
+
+	rows, err := basket.db.QueryContext(ctx, unifySQL(basket.dbType, fmt.Sprintf(
+		"SELECT request_id, %s FROM %s WHERE basket_name = $1 AND request_id IN (%s) ORDER BY created_at",
+		column, table, strings.Join(params, ", "))), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var id, value string
+	for rows.Next() {
+		if err = rows.Scan(&id, &value); err != nil {
+			return err
+		}
+		for _, request := range byID[id] {
+			add(request, value)
+		}
+	}
+
+	return rows.Err()
+}
+
+// attachForwardResults loads the results of forwarding and WebSocket frames for collected requests
+func (basket *sqlBasket) attachForwardResults(ctx context.Context, requests []*RequestData) error {
+	err := basket.queryRequestDetails(ctx, "rb_forward_results", "result", requests, func(request *RequestData, value string) {
+		result := new(ForwardResult)
+		if err := json.Unmarshal([]byte(value), result); err != nil {
+			log.Printf("[error] failed to parse result of forwarding in basket: %s - %s", basket.name, err)
+		} else {
+			request.Forwards = append(request.Forwards, result)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get results of forwarding in basket: %s - %s", basket.name, err)
+	}
+
+	return basket.attachWebSocketFrames(ctx, requests)
+}
+
+// attachWebSocketFrames loads the frames of WebSocket sessions for collected requests
+func (basket *sqlBasket) attachWebSocketFrames(ctx context.Context, requests []*RequestData) error {
+	err := basket.queryRequestDetails(ctx, "rb_websocket_frames", "frame", requests, func(request *RequestData, value string) {
+		frame := new(WebSocketFrame)
+		if err := json.Unmarshal([]byte(value), frame); err != nil {
+			log.Printf("[error] failed to parse WebSocket frame in basket: %s - %s", basket.name, err)
+		} else {
+			request.Frames = append(request.Frames, frame)
 		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+
+	return nil
+}
 
-		if _, err := basket.db.Exec(cleanupSQL, basket.name, size-capacity); err != nil {
-			log.Printf("[error] failed to shrink collected requests: %s - %s", basket.name, err)
+func (basket *sqlBasket) applyDeadLettersLimit(ctx context.Context, capacity int) error {
+	// keep the number of undelivered requests up to specified capacity
+	letters, err := basket.getInt(ctx, "SELECT COUNT(*) FROM rb_dead_letters WHERE basket_name = $1")
+	if err != nil {
+		return err
+	}
+
+	if letters > capacity {
+		if err = basket.deleteOldest(ctx, basket.db, "rb_dead_letters", letters-capacity); err != nil {
+			return fmt.Errorf("failed to shrink undelivered requests: %s - %s", basket.name, err)
 		}
 	}
+	return nil
+}
+
+// deleteOldest deletes specified number of the oldest records of the basket from the table
+func (basket *sqlBasket) deleteOldest(ctx context.Context, exec sqlExecutor, table string, count int) error {
+	var cleanupSQL string
+
+	// Note: 'ctid' is PostgreSQL specific, 'rowid' is SQLite specific
+	// see example for MySQL here: https://stackoverflow.com/questions/5170546
+	switch basket.dbType {
+	case "postgres":
+		cleanupSQL = "DELETE FROM " + table + " WHERE ctid IN (SELECT ctid FROM " + table + " WHERE basket_name = $1 ORDER BY created_at LIMIT $2)"
+	case "sqlite":
+		cleanupSQL = "DELETE FROM " + table + " WHERE rowid IN (SELECT rowid FROM " + table + " WHERE basket_name = ? ORDER BY created_at LIMIT ?)"
+	default:
+		cleanupSQL = "DELETE FROM " + table + " WHERE basket_name = ? ORDER BY created_at LIMIT ?"
+	}
+
+	_, err := exec.ExecContext(ctx, cleanupSQL, basket.name, count)
+	return err
 }
 
-func (basket *sqlBasket) getTotalRequestsCount() int {
-	return basket.getInt("SELECT requests_count FROM rb_baskets WHERE basket_name = $1", 0)
+func (basket *sqlBasket) getTotalRequestsCount(ctx context.Context) (int, error) {
+	return basket.getInt(ctx, "SELECT requests_count FROM rb_baskets WHERE basket_name = $1")
 }
 
-func (basket *sqlBasket) getLastRequestDate() int64 {
+func (basket *sqlBasket) getLastRequestDate(ctx context.Context) (int64, error) {
+	// Note: the date is selected as column rather than MAX(created_at), SQLite loses column type on aggregation
 	var value time.Time
-	if err := basket.db.QueryRow(unifySQL(basket.dbType,
-		"SELECT MAX(created_at) FROM rb_requests WHERE basket_name = $1"), basket.name).Scan(&value); err != nil {
-		log.Printf("[error] failed to get last request date of basket: %s - %s", basket.name, err)
-		return 0
+	err := basket.db.QueryRowContext(ctx, unifySQL(basket.dbType,
+		"SELECT created_at FROM rb_requests WHERE basket_name = $1 ORDER BY created_at DESC LIMIT 1"), basket.name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to get last request date of basket: %s - %s", basket.name, err)
+	}
+
+	return value.UnixNano() / toMs, nil
+}
+
+func (basket *sqlBasket) Activity(ctx context.Context) (BasketActivity, error) {
+	activity := BasketActivity{}
+
+	var created time.Time
+	err := basket.db.QueryRowContext(ctx,
+		unifySQL(basket.dbType, "SELECT created_at, last_request_date FROM rb_baskets WHERE basket_name = $1"),
+		basket.name).Scan(&created, &activity.LastRequestDate)
+	if err != nil {
+		return activity, fmt.Errorf("failed to get activity of basket: %s - %s", basket.name, err)
 	}
+	activity.CreatedDate = created.UnixNano() / toMs
 
-	return value.UnixNano() / toMs
+	if activity.LastRequestDate == 0 {
+		// requests collected before the date of the last request is tracked
+		activity.LastRequestDate, err = basket.getLastRequestDate(ctx)
+	}
+	return activity, err
 }
 
-func (basket *sqlBasket) Config() BasketConfig {
+func (basket *sqlBasket) Config(ctx context.Context) (BasketConfig, error) {
 	config := BasketConfig{}
+	var targets, headers, bodyTemplate, method, contentType, rules, transport, signing, websocket, breaker sql.NullString
 
-	err := basket.db.QueryRow(
-		unifySQL(basket.dbType, "SELECT capacity, forward_url, proxy_response, insecure_tls, expand_path FROM rb_baskets WHERE basket_name = $1"),
-		basket.name).Scan(&config.Capacity, &config.ForwardURL, &config.ProxyResponse, &config.InsecureTLS, &config.ExpandPath)
+	err := basket.db.QueryRowContext(ctx,
+		unifySQL(basket.dbType, "SELECT capacity, forward_url, proxy_response, insecure_tls, expand_path, forward_targets, retry_count, retry_backoff, header_rules, forward_body_template, forward_method, forward_content_type, forward_rules, transport, signing, websocket, circuit_breaker, rate_limit, max_age FROM rb_baskets WHERE basket_name = $1"),
+		basket.name).Scan(&config.Capacity, &config.ForwardURL, &config.ProxyResponse, &config.InsecureTLS, &config.ExpandPath, &targets,
+		&config.RetryCount, &config.RetryBackoff, &headers, &bodyTemplate, &method, &contentType, &rules, &transport, &signing, &websocket, &breaker, &config.RateLimit, &config.MaxAge)
 	if err != nil {
-		log.Printf("[error] failed to get basket config: %s - %s", basket.name, err)
+		return config, fmt.Errorf("failed to get basket config: %s - %s", basket.name, err)
 	}
 
-	return config
+	config.ForwardBodyTemplate = bodyTemplate.String
+	config.ForwardMethod = method.String
+	config.ForwardContentType = contentType.String
+	fromJSONColumn(targets, &config.ForwardTargets, "forward targets of basket: "+basket.name)
+	fromJSONColumn(headers, &config.HeaderRules, "header rules of basket: "+basket.name)
+	fromJSONColumn(rules, &config.ForwardRules, "forward rules of basket: "+basket.name)
+	fromJSONColumn(transport, &config.Transport, "transport settings of basket: "+basket.name)
+	fromJSONColumn(signing, &config.Signing, "signing settings of basket: "+basket.name)
+	fromJSONColumn(websocket, &config.WebSocket, "WebSocket settings of basket: "+basket.name)
+	fromJSONColumn(breaker, &config.CircuitBreaker, "circuit breaker settings of basket: "+basket.name)
+
+	return config, nil
 }
 
-func (basket *sqlBasket) Update(config BasketConfig) {
-	_, err := basket.db.Exec(
-		unifySQL(basket.dbType, "UPDATE rb_baskets SET capacity = $1, forward_url = $2, proxy_response = $3, insecure_tls = $4, expand_path = $5 WHERE basket_name = $6"),
-		config.Capacity, config.ForwardURL, config.ProxyResponse, config.InsecureTLS, config.ExpandPath, basket.name)
+func (basket *sqlBasket) Update(ctx context.Context, config BasketConfig) error {
+	_, err := basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "UPDATE rb_baskets SET capacity = $1, forward_url = $2, proxy_response = $3, insecure_tls = $4, expand_path = $5, forward_targets = $6, retry_count = $7, retry_backoff = $8, header_rules = $9, forward_body_template = $10, forward_method = $11, forward_content_type = $12, forward_rules = $13, transport = $14, signing = $15, websocket = $16, circuit_breaker = $17, rate_limit = $18, max_age = $19 WHERE basket_name = $20"),
+		config.Capacity, config.ForwardURL, config.ProxyResponse, config.InsecureTLS, config.ExpandPath, toJSONColumn(config.ForwardTargets),
+		config.RetryCount, config.RetryBackoff, toJSONColumn(config.HeaderRules), config.ForwardBodyTemplate, config.ForwardMethod,
+		config.ForwardContentType, toJSONColumn(config.ForwardRules), toJSONColumn(config.Transport), toJSONColumn(config.Signing), toJSONColumn(config.WebSocket), toJSONColumn(config.CircuitBreaker), config.RateLimit, config.MaxAge, basket.name)
 	if err != nil {
-		log.Printf("[error] failed to update basket config: %s - %s", basket.name, err)
-	} else {
-		// apply new basket limits
-		basket.applyLimit(config.Capacity)
+		return fmt.Errorf("failed to update basket config: %s - %s", basket.name, err)
 	}
+
+	// apply new basket limits
+	if err = basket.applyLimit(ctx, config.Capacity); err != nil {
+		return err
+	}
+	return basket.applyDeadLettersLimit(ctx, config.Capacity)
 }
 
-func (basket *sqlBasket) Authorize(token string) bool {
+func (basket *sqlBasket) Authorize(ctx context.Context, token string) (bool, error) {
 	var found int
 
-	err := basket.db.QueryRow(
+	err := basket.db.QueryRowContext(ctx,
 		unifySQL(basket.dbType, "SELECT COUNT(*) FROM rb_baskets WHERE basket_name = $1 AND token = $2"),
 		basket.name, token).Scan(&found)
 	if err != nil {
-		log.Printf("[error] failed authorize access to basket: %s - %s", basket.name, err)
-		return false
+		return false, fmt.Errorf("failed authorize access to basket: %s - %s", basket.name, err)
 	}
 
-	return found > 0
+	return found > 0, nil
 }
 
-func (basket *sqlBasket) GetResponse(method string) *ResponseConfig {
+func (basket *sqlBasket) GetResponse(ctx context.Context, method string) (*ResponseConfig, error) {
 	var resp string
 
-	err := basket.db.QueryRow(
+	err := basket.db.QueryRowContext(ctx,
 		unifySQL(basket.dbType, "SELECT response FROM rb_responses WHERE basket_name = $1 AND http_method = $2"),
 		basket.name, method).Scan(&resp)
 	if err == sql.ErrNoRows {
 		// no response for this basket + HTTP method
-		return nil
+		return nil, nil
 	} else if err != nil {
-		log.Printf("[error] failed to get response for HTTP %s method of basket: %s - %s", method, basket.name, err)
-		return nil
+		return nil, fmt.Errorf("failed to get response for HTTP %s method of basket: %s - %s", method, basket.name, err)
 	}
 
 	response := new(ResponseConfig)
 	if err := json.Unmarshal([]byte(resp), response); err != nil {
-		log.Printf("[error] failed to parse response for HTTP %s method of basket: %s - %s", method, basket.name, err)
-		return nil
+		return nil, fmt.Errorf("failed to parse response for HTTP %s method of basket: %s - %s", method, basket.name, err)
 	}
 
-	return response
+	return response, nil
 }
 
-func (basket *sqlBasket) SetResponse(method string, response ResponseConfig) {
-	if respb, err := json.Marshal(response); err == nil {
-		// delete existing if present
-		basket.db.Exec(unifySQL(basket.dbType, "DELETE FROM rb_responses WHERE basket_name = $1 AND http_method = $2"), basket.name, method)
-		// insert new response (ignore concurrency)
-		_, err = basket.db.Exec(
-			unifySQL(basket.dbType, "INSERT INTO rb_responses (basket_name, http_method, response) VALUES ($1, $2, $3)"),
-			basket.name, method, string(respb))
+func (basket *sqlBasket) SetResponse(ctx context.Context, method string, response ResponseConfig) error {
+	respb, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	tx, err := basket.db.BeginTx(ctx, nil)
+	if err == nil {
+		// replace existing response if present
+		_, err = tx.ExecContext(ctx,
+			unifySQL(basket.dbType, "DELETE FROM rb_responses WHERE basket_name = $1 AND http_method = $2"), basket.name, method)
+		if err == nil {
+			_, err = tx.ExecContext(ctx,
+				unifySQL(basket.dbType, "INSERT INTO rb_responses (basket_name, http_method, response) VALUES ($1, $2, $3)"),
+				basket.name, method, string(respb))
+		}
 
 		if err != nil {
-			log.Printf("[error] failed to update response for HTTP %s method of basket: %s - %s", method, basket.name, err)
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
 		}
 	}
+
+	if err != nil {
+		return fmt.Errorf("failed to update response for HTTP %s method of basket: %s - %s", method, basket.name, err)
+	}
+	return nil
 }
 
-func (basket *sqlBasket) Add(req *http.Request) *RequestData {
-	data := ToRequestData(req)
-	if datab, err := json.Marshal(data); err == nil {
-		_, err = basket.db.Exec(
-			unifySQL(basket.dbType, "INSERT INTO rb_requests (basket_name, request) VALUES ($1, $2)"), basket.name, string(datab))
-		if err != nil {
-			log.Printf("[error] failed to collect incoming HTTP request in basket: %s - %s", basket.name, err)
+// addRequest collects the request and keeps the number of requests up to basket capacity within the transaction;
+// the basket row is updated first, so it stays locked and concurrent requests to the same basket are serialized
+func (basket *sqlBasket) addRequest(ctx context.Context, tx *sql.Tx, data *RequestData, request string) error {
+	// update global counter
+	res, err := tx.ExecContext(ctx,
+		unifySQL(basket.dbType, "UPDATE rb_baskets SET requests_count = requests_count + 1 WHERE basket_name = $1"),
+		basket.name)
+	if err != nil {
+		return fmt.Errorf("failed to update requests counter - %s", err)
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return fmt.Errorf("basket is not found")
+	}
+
+	// creation time reflects the date of request (e.g. imported one), it is set relative to the clock of database
+	// server in the same way as the age of requests is evaluated on deletion of expired requests; requests are
+	// ordered by creation time, so the request collected within the same millisecond is placed after the last one
+	now := time.Now().UnixNano() / toMs
+	date := data.Date
+	if date <= 0 || date > now {
+		date = now
+	}
+
+	var lastCreated int64
+	if err = tx.QueryRowContext(ctx, unifySQL(basket.dbType, "SELECT last_created_date FROM rb_baskets WHERE basket_name = $1"),
+		basket.name).Scan(&lastCreated); err != nil {
+		return fmt.Errorf("failed to get creation date of the last request - %s", err)
+	}
+	if date <= lastCreated {
+		date = lastCreated + 1
+	}
+
+	// update the date of the last request
+	_, err = tx.ExecContext(ctx,
+		unifySQL(basket.dbType, "UPDATE rb_baskets SET last_request_date = $1, last_created_date = $2 WHERE basket_name = $3"),
+		data.Date, date, basket.name)
+	if err != nil {
+		return fmt.Errorf("failed to update date of the last request - %s", err)
+	}
+
+	// the age is negative if requests are collected faster than one per millisecond
+	ms := now - date
+
+	var insertSQL string
+	var age interface{} = ms
+	switch basket.dbType {
+	case "sqlite":
+		insertSQL = "INSERT INTO rb_requests (basket_name, request_id, request, created_at) VALUES (?, ?, ?, STRFTIME('%Y-%m-%d %H:%M:%f', 'now', ?))"
+		sign := "-"
+		if ms < 0 {
+			sign, ms = "+", -ms
+		}
+		age = fmt.Sprintf("%s%d.%03d seconds", sign, ms/1000, ms%1000)
+	case "mysql":
+		insertSQL = "INSERT INTO rb_requests (basket_name, request_id, request, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP(3) - INTERVAL ? MICROSECOND)"
+		age = ms * 1000
+	default:
+		insertSQL = "INSERT INTO rb_requests (basket_name, request_id, request, created_at) VALUES ($1, $2, $3, LOCALTIMESTAMP - CAST($4 AS bigint) * INTERVAL '1 millisecond')"
+	}
+
+	_, err = tx.ExecContext(ctx, insertSQL, basket.name, data.ID, request, age)
+	if err != nil {
+		return err
+	}
+
+	// apply limit if necessary
+	capacity, err := basket.capacity(ctx, tx)
+	if err != nil {
+		return err
+	}
+	size, err := basket.queryInt(ctx, tx, "SELECT COUNT(*) FROM rb_requests WHERE basket_name = $1")
+	if err != nil {
+		return err
+	}
+
+	if size > capacity {
+		if err = basket.deleteOldest(ctx, tx, "rb_requests", size-capacity); err != nil {
+			return fmt.Errorf("failed to shrink collected requests - %s", err)
+		}
+		if err = basket.deleteOrphanedForwardResults(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (basket *sqlBasket) Add(ctx context.Context, data *RequestData) error {
+	datab, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to serialize incoming HTTP request of basket: %s - %s", basket.name, err)
+	}
+
+	tx, err := basket.db.BeginTx(ctx, nil)
+	if err == nil {
+		if err = basket.addRequest(ctx, tx, data, string(datab)); err != nil {
+			tx.Rollback()
 		} else {
-			// update global counter
-			_, err = basket.db.Exec(
-				unifySQL(basket.dbType, "UPDATE rb_baskets SET requests_count = requests_count + 1 WHERE basket_name = $1"), basket.name)
+			err = tx.Commit()
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to collect incoming HTTP request in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *sqlBasket) AddForwardResult(ctx context.Context, id string, result *ForwardResult) error {
+	resultb, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "INSERT INTO rb_forward_results (basket_name, request_id, result) VALUES ($1, $2, $3)"),
+		basket.name, id, string(resultb))
+	if err != nil {
+		return fmt.Errorf("failed to save result of forwarding in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *sqlBasket) AddWebSocketFrames(ctx context.Context, id string, frames []*WebSocketFrame) error {
+	tx, err := basket.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+
+	for _, frame := range frames {
+		if frameb, err := json.Marshal(frame); err == nil {
+			_, err = tx.ExecContext(ctx,
+				unifySQL(basket.dbType, "INSERT INTO rb_websocket_frames (basket_name, request_id, frame) VALUES ($1, $2, $3)"),
+				basket.name, id, string(frameb))
 			if err != nil {
-				log.Printf("[error] failed to update requests counter of basket: %s - %s", basket.name, err)
+				tx.Rollback()
+				return fmt.Errorf("failed to save WebSocket frames in basket: %s - %s", basket.name, err)
 			}
-			// apply limit if necessary
-			// TODO: replace 200 with serverConfig.InitCapacity
-			basket.applyLimit(basket.getInt("SELECT capacity FROM rb_baskets WHERE basket_name = $1", 200))
 		}
 	}
 
-	return data
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+	return nil
 }
 
-func (basket *sqlBasket) Clear() {
-	if _, err := basket.db.Exec(unifySQL(basket.dbType, "DELETE FROM rb_requests WHERE basket_name = $1"), basket.name); err != nil {
-		log.Printf("[error] failed to delete collected requests in basket: %s - %s", basket.name, err)
+func (basket *sqlBasket) GetRequest(ctx context.Context, id string) (*RequestData, error) {
+	var req string
+
+	err := basket.db.QueryRowContext(ctx,
+		unifySQL(basket.dbType, "SELECT request FROM rb_requests WHERE basket_name = $1 AND request_id = $2"),
+		basket.name, id).Scan(&req)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get request %s of basket: %s - %s", id, basket.name, err)
+	}
+
+	request := new(RequestData)
+	if err = json.Unmarshal([]byte(req), request); err != nil {
+		return nil, fmt.Errorf("failed to parse HTTP request data in basket: %s - %s", basket.name, err)
+	}
+	if err = basket.attachForwardResults(ctx, []*RequestData{request}); err != nil {
+		return nil, err
 	}
+
+	return request, nil
 }
 
-func (basket *sqlBasket) Size() int {
-	return basket.getInt("SELECT COUNT(*) FROM rb_requests WHERE basket_name = $1", 0)
+func (basket *sqlBasket) Clear(ctx context.Context) error {
+	if _, err := basket.db.ExecContext(ctx, unifySQL(basket.dbType, "DELETE FROM rb_requests WHERE basket_name = $1"), basket.name); err != nil {
+		return fmt.Errorf("failed to delete collected requests in basket: %s - %s", basket.name, err)
+	}
+	if _, err := basket.db.ExecContext(ctx, unifySQL(basket.dbType, "DELETE FROM rb_forward_results WHERE basket_name = $1"), basket.name); err != nil {
+		return fmt.Errorf("failed to delete results of forwarding in basket: %s - %s", basket.name, err)
+	}
+	if _, err := basket.db.ExecContext(ctx, unifySQL(basket.dbType, "DELETE FROM rb_websocket_frames WHERE basket_name = $1"), basket.name); err != nil {
+		return fmt.Errorf("failed to delete WebSocket frames in basket: %s - %s", basket.name, err)
+	}
+	return nil
 }
 
-func (basket *sqlBasket) GetRequests(max int, skip int) RequestsPage {
-	page := RequestsPage{make([]*RequestData, 0, max), basket.Size(), basket.getTotalRequestsCount(), false}
+// deleteExpired deletes requests that are collected before the age within the transaction, the date of collected
+// request is compared with the clock of database server using the index on creation time
+func (basket *sqlBasket) deleteExpired(ctx context.Context, tx *sql.Tx, maxAge time.Duration) (int, error) {
+	seconds := int(maxAge / time.Second)
+
+	var expireSQL string
+	var age interface{} = seconds
+	switch basket.dbType {
+	case "sqlite":
+		expireSQL = "DELETE FROM rb_requests WHERE basket_name = ? AND created_at < STRFTIME('%Y-%m-%d %H:%M:%f', 'now', ?)"
+		age = fmt.Sprintf("-%d seconds", seconds)
+	case "mysql":
+		expireSQL = "DELETE FROM rb_requests WHERE basket_name = ? AND created_at < CURRENT_TIMESTAMP(3) - INTERVAL ? SECOND"
+	default:
+		expireSQL = "DELETE FROM rb_requests WHERE basket_name = $1 AND created_at < LOCALTIMESTAMP - CAST($2 AS integer) * INTERVAL '1 second'"
+	}
+
+	res, err := tx.ExecContext(ctx, expireSQL, basket.name, age)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, _ := res.RowsAffected()
+	if deleted > 0 {
+		if err = basket.deleteOrphanedForwardResults(ctx, tx); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(deleted), nil
+}
+
+func (basket *sqlBasket) DeleteExpired(ctx context.Context, maxAge time.Duration) (int, error) {
+	deleted := 0
+
+	tx, err := basket.db.BeginTx(ctx, nil)
+	if err == nil {
+		if deleted, err = basket.deleteExpired(ctx, tx, maxAge); err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired requests in basket: %s - %s", basket.name, err)
+	}
+	return deleted, nil
+}
+
+func (basket *sqlBasket) Size(ctx context.Context) (int, error) {
+	return basket.getInt(ctx, "SELECT COUNT(*) FROM rb_requests WHERE basket_name = $1")
+}
+
+// readRequests reads collected requests from rows up to the limit, returns true if there are more rows
+func (basket *sqlBasket) readRequests(rows *sql.Rows, max int, filter func(*RequestData) bool) ([]*RequestData, bool, error) {
+	defer rows.Close()
+
+	result := make([]*RequestData, 0, max)
+	var req string
+	for len(result) < max && rows.Next() {
+		if err := rows.Scan(&req); err != nil {
+			return result, false, err
+		}
+		request := new(RequestData)
+		if err := json.Unmarshal([]byte(req), request); err != nil {
+			log.Printf("[error] failed to parse HTTP request data in basket: %s - %s", basket.name, err)
+		} else if filter(request) {
+			result = append(result, request)
+		}
+	}
+
+	hasMore := rows.Next()
+	return result, hasMore, rows.Err()
+}
+
+func (basket *sqlBasket) GetRequests(ctx context.Context, max int, skip int) (RequestsPage, error) {
+	page := RequestsPage{make([]*RequestData, 0, max), 0, 0, false}
+
+	var err error
+	if page.Count, err = basket.Size(ctx); err != nil {
+		return page, err
+	}
+	if page.TotalCount, err = basket.getTotalRequestsCount(ctx); err != nil {
+		return page, err
+	}
 
 	if max > 0 {
-		requests, err := basket.db.Query(
+		requests, err := basket.db.QueryContext(ctx,
 			unifySQL(basket.dbType, "SELECT request FROM rb_requests WHERE basket_name = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3"),
 			basket.name, max+1, skip)
 		if err != nil {
-			log.Printf("[error] failed to get requests of basket: %s - %s", basket.name, err)
-			return page
-		}
-		defer requests.Close()
-
-		var req string
-		for len(page.Requests) < max && requests.Next() {
-			if err = requests.Scan(&req); err == nil {
-				request := new(RequestData)
-				if err = json.Unmarshal([]byte(req), request); err != nil {
-					log.Printf("[error] failed to parse HTTP request data in basket: %s - %s", basket.name, err)
-				} else {
-					page.Requests = append(page.Requests, request)
-				}
-			}
+			return page, fmt.Errorf("failed to get requests of basket: %s - %s", basket.name, err)
 		}
 
-		page.HasMore = requests.Next()
+		page.Requests, page.HasMore, err = basket.readRequests(requests, max, func(*RequestData) bool { return true })
+		if err != nil {
+			return page, fmt.Errorf("failed to get requests of basket: %s - %s", basket.name, err)
+		}
+
+		if err = basket.attachForwardResults(ctx, page.Requests); err != nil {
+			return page, err
+		}
 	} else {
 		page.HasMore = page.Count > skip
 	}
 
-	return page
+	return page, nil
 }
 
-func (basket *sqlBasket) FindRequests(query string, in string, max int, skip int) RequestsQueryPage {
+func (basket *sqlBasket) FindRequests(ctx context.Context, query string, in string, max int, skip int) (RequestsQueryPage, error) {
 	page := RequestsQueryPage{make([]*RequestData, 0, max), false}
 	if max > 0 {
-		requests, err := basket.db.Query(
+		requests, err := basket.db.QueryContext(ctx,
 			unifySQL(basket.dbType, "SELECT request FROM rb_requests WHERE basket_name = $1 ORDER BY created_at DESC"), basket.name)
 		if err != nil {
-			log.Printf("[error] failed to find requests of basket: %s - %s", basket.name, err)
-			return page
+			return page, fmt.Errorf("failed to find requests of basket: %s - %s", basket.name, err)
 		}
-		defer requests.Close()
 
 		skipped := 0
-		var req string
-		for len(page.Requests) < max && requests.Next() {
-			if err = requests.Scan(&req); err == nil {
-				request := new(RequestData)
-				if err = json.Unmarshal([]byte(req), request); err != nil {
-					log.Printf("[error] failed to parse HTTP request data in basket: %s - %s", basket.name, err)
-				} else {
-					// filter
-					if request.Matches(query, in) {
-						if skipped < skip {
-							skipped++
-						} else {
-							page.Requests = append(page.Requests, request)
-						}
-					}
-				}
+		page.Requests, page.HasMore, err = basket.readRequests(requests, max, func(request *RequestData) bool {
+			// filter
+			if !request.Matches(query, in) {
+				return false
+			} else if skipped < skip {
+				skipped++
+				return false
 			}
+			return true
+		})
+		if err != nil {
+			return page, fmt.Errorf("failed to find requests of basket: %s - %s", basket.name, err)
+		}
+
+		if err = basket.attachForwardResults(ctx, page.Requests); err != nil {
+			return page, err
 		}
-		page.HasMore = requests.Next()
 	} else {
 		page.HasMore = true
 	}
 
-	return page
+	return page, nil
+}
+
+func (basket *sqlBasket) AddDeadLetter(ctx context.Context, letter *DeadLetter) error {
+	letterb, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	_, err = basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "INSERT INTO rb_dead_letters (basket_name, letter_id, letter) VALUES ($1, $2, $3)"),
+		basket.name, letter.ID, string(letterb))
+	if err != nil {
+		return fmt.Errorf("failed to save undelivered request in basket: %s - %s", basket.name, err)
+	}
+
+	capacity, err := basket.capacity(ctx, basket.db)
+	if err != nil {
+		return err
+	}
+	return basket.applyDeadLettersLimit(ctx, capacity)
+}
+
+func (basket *sqlBasket) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	var letterj string
+
+	err := basket.db.QueryRowContext(ctx,
+		unifySQL(basket.dbType, "SELECT letter FROM rb_dead_letters WHERE basket_name = $1 AND letter_id = $2"),
+		basket.name, id).Scan(&letterj)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get undelivered request %s of basket: %s - %s", id, basket.name, err)
+	}
+
+	letter := new(DeadLetter)
+	if err = json.Unmarshal([]byte(letterj), letter); err != nil {
+		return nil, fmt.Errorf("failed to parse undelivered request %s of basket: %s - %s", id, basket.name, err)
+	}
+
+	return letter, nil
+}
+
+func (basket *sqlBasket) GetDeadLetters(ctx context.Context, max int, skip int) (DeadLettersPage, error) {
+	page := DeadLettersPage{make([]*DeadLetter, 0, max), 0, false}
+
+	var err error
+	if page.Count, err = basket.getInt(ctx, "SELECT COUNT(*) FROM rb_dead_letters WHERE basket_name = $1"); err != nil {
+		return page, err
+	}
+
+	letters, err := basket.db.QueryContext(ctx,
+		unifySQL(basket.dbType, "SELECT letter FROM rb_dead_letters WHERE basket_name = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3"),
+		basket.name, max+1, skip)
+	if err != nil {
+		return page, fmt.Errorf("failed to get undelivered requests of basket: %s - %s", basket.name, err)
+	}
+	defer letters.Close()
+
+	var letterj string
+	for len(page.DeadLetters) < max && letters.Next() {
+		if err = letters.Scan(&letterj); err == nil {
+			letter := new(DeadLetter)
+			if err = json.Unmarshal([]byte(letterj), letter); err != nil {
+				log.Printf("[error] failed to parse undelivered request in basket: %s - %s", basket.name, err)
+			} else {
+				page.DeadLetters = append(page.DeadLetters, letter)
+			}
+		}
+	}
+
+	page.HasMore = letters.Next()
+	if err = letters.Err(); err != nil {
+		return page, fmt.Errorf("failed to get undelivered requests of basket: %s - %s", basket.name, err)
+	}
+
+	return page, nil
+}
+
+func (basket *sqlBasket) RemoveDeadLetter(ctx context.Context, id string) error {
+	_, err := basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "DELETE FROM rb_dead_letters WHERE basket_name = $1 AND letter_id = $2"), basket.name, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete undelivered request %s of basket: %s - %s", id, basket.name, err)
+	}
+	return nil
+}
+
+func (basket *sqlBasket) ClearDeadLetters(ctx context.Context) error {
+	if _, err := basket.db.ExecContext(ctx, unifySQL(basket.dbType, "DELETE FROM rb_dead_letters WHERE basket_name = $1"), basket.name); err != nil {
+		return fmt.Errorf("failed to delete undelivered requests of basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+/// ForwardsStore interface ///
+
+func (basket *sqlBasket) SaveForward(ctx context.Context, forward *PendingForward) error {
+	forwardb, err := json.Marshal(forward)
+	if err != nil {
+		return err
+	}
+
+	_, err = basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "INSERT INTO rb_forwards (basket_name, forward_id, forward) VALUES ($1, $2, $3)"),
+		basket.name, forward.ID, string(forwardb))
+	if err != nil {
+		return fmt.Errorf("failed to save queued forward in basket: %s - %s", basket.name, err)
+	}
+	return nil
+}
+
+func (basket *sqlBasket) ClaimForward(ctx context.Context, id string) (bool, error) {
+	res, err := basket.db.ExecContext(ctx,
+		unifySQL(basket.dbType, "DELETE FROM rb_forwards WHERE basket_name = $1 AND forward_id = $2"), basket.name, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim queued forward %s of basket: %s - %s", id, basket.name, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim queued forward %s of basket: %s - %s", id, basket.name, err)
+	}
+	return deleted > 0, nil
+}
+
+func (basket *sqlBasket) GetPendingForwards(ctx context.Context) ([]*PendingForward, error) {
+	result := make([]*PendingForward, 0)
+
+	forwards, err := basket.db.QueryContext(ctx,
+		unifySQL(basket.dbType, "SELECT forward FROM rb_forwards WHERE basket_name = $1 ORDER BY created_at"), basket.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queued forwards of basket: %s - %s", basket.name, err)
+	}
+	defer forwards.Close()
+
+	var forwardj string
+	for forwards.Next() {
+		if err = forwards.Scan(&forwardj); err == nil {
+			forward := new(PendingForward)
+			if err = json.Unmarshal([]byte(forwardj), forward); err != nil {
+				log.Printf("[error] failed to parse queued forward in basket: %s - %s", basket.name, err)
+			} else {
+				result = append(result, forward)
+			}
+		}
+	}
+
+	if err = forwards.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get queued forwards of basket: %s - %s", basket.name, err)
+	}
+	return result, nil
 }
 
 /// BasketsDatabase interface ///
@@ -291,58 +831,75 @@ type sqlDatabase struct {
 	dbType string // postgresql, mysql, oracle, etc.
 }
 
-func (sdb *sqlDatabase) getInt(sql string, defaultValue int) int {
+func (sdb *sqlDatabase) getInt(ctx context.Context, sql string) (int, error) {
 	var value int
-	if err := sdb.db.QueryRow(sql).Scan(&value); err != nil {
-		log.Printf("[error] failed to query for int result, query: %s - %s", sql, err)
-		return defaultValue
+	if err := sdb.db.QueryRowContext(ctx, sql).Scan(&value); err != nil {
+		return 0, fmt.Errorf("failed to query for int result, query: %s - %s", sql, err)
 	}
 
-	return value
+	return value, nil
 }
 
-func (sdb *sqlDatabase) getTopBaskets(sql string, max int) []*BasketInfo {
-	top := make([]*BasketInfo, 0, max)
-	names, err := sdb.db.Query(unifySQL(sdb.dbType, sql), max)
+func (sdb *sqlDatabase) getTopBaskets(ctx context.Context, sql string, max int) ([]*BasketInfo, error) {
+	names, err := sdb.db.QueryContext(ctx, unifySQL(sdb.dbType, sql), max)
 	if err != nil {
-		log.Printf("[error] failed to find top baskets: %s", err)
-		return top
+		return nil, fmt.Errorf("failed to find top baskets: %s", err)
 	}
-	defer names.Close()
 
+	// read names first, the connection of query is busy until all rows are read
+	basketNames := make([]string, 0, max)
 	var name string
 	for names.Next() {
 		if err = names.Scan(&name); err == nil {
-			basket := &sqlBasket{sdb.db, sdb.dbType, name}
-			reqCount := basket.Size()
+			basketNames = append(basketNames, name)
+		}
+	}
+	err = names.Err()
+	names.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find top baskets: %s", err)
+	}
 
-			var lastRequestDate int64
-			if reqCount > 0 {
-				lastRequestDate = basket.getLastRequestDate()
-			}
+	top := make([]*BasketInfo, 0, max)
+	for _, name := range basketNames {
+		basket := &sqlBasket{sdb.db, sdb.dbType, name}
+		info := &BasketInfo{Name: name}
 
-			top = append(top, &BasketInfo{
-				Name:               name,
-				RequestsCount:      reqCount,
-				RequestsTotalCount: basket.getTotalRequestsCount(),
-				LastRequestDate:    lastRequestDate})
+		if info.RequestsCount, err = basket.Size(ctx); err != nil {
+			return nil, err
+		}
+		if info.RequestsCount > 0 {
+			if info.LastRequestDate, err = basket.getLastRequestDate(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if info.RequestsTotalCount, err = basket.getTotalRequestsCount(ctx); err != nil {
+			return nil, err
 		}
+
+		top = append(top, info)
 	}
 
-	return top
+	return top, nil
 }
 
-func (sdb *sqlDatabase) Create(name string, config BasketConfig) (BasketAuth, error) {
+func (sdb *sqlDatabase) Create(ctx context.Context, name string, config BasketConfig) (BasketAuth, error) {
 	auth := BasketAuth{}
 	token, err := GenerateToken()
 	if err != nil {
 		return auth, fmt.Errorf("failed to generate token: %s", err)
 	}
 
-	basket, err := sdb.db.Exec(
-		unifySQL(sdb.dbType, "INSERT INTO rb_baskets (basket_name, token, capacity, forward_url, proxy_response, insecure_tls, expand_path) VALUES($1, $2, $3, $4, $5, $6, $7)"),
-		name, token, config.Capacity, config.ForwardURL, config.ProxyResponse, config.InsecureTLS, config.ExpandPath)
+	basket, err := sdb.db.ExecContext(ctx,
+		unifySQL(sdb.dbType, "INSERT INTO rb_baskets (basket_name, token, capacity, forward_url, proxy_response, insecure_tls, expand_path, forward_targets, retry_count, retry_backoff, header_rules, forward_body_template, forward_method, forward_content_type, forward_rules, transport, signing, websocket, circuit_breaker, rate_limit, max_age) VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)"),
+		name, token, config.Capacity, config.ForwardURL, config.ProxyResponse, config.InsecureTLS, config.ExpandPath, toJSONColumn(config.ForwardTargets),
+		config.RetryCount, config.RetryBackoff, toJSONColumn(config.HeaderRules), config.ForwardBodyTemplate, config.ForwardMethod,
+		config.ForwardContentType, toJSONColumn(config.ForwardRules), toJSONColumn(config.Transport), toJSONColumn(config.Signing), toJSONColumn(config.WebSocket), toJSONColumn(config.CircuitBreaker), config.RateLimit, config.MaxAge)
 	if err != nil {
+		// violation of unique constraint is reported differently by SQL drivers, check if the basket exists instead
+		if existing, gerr := sdb.Get(ctx, name); gerr == nil && existing != nil {
+			return auth, fmt.Errorf("failed to create basket: %s - %w", name, errBasketExists)
+		}
 		return auth, fmt.Errorf("failed to create basket: %s - %s", name, err)
 	}
 
@@ -354,91 +911,115 @@ func (sdb *sqlDatabase) Create(name string, config BasketConfig) (BasketAuth, er
 	return auth, nil
 }
 
-func (sdb *sqlDatabase) Get(name string) Basket {
+func (sdb *sqlDatabase) Get(ctx context.Context, name string) (Basket, error) {
 	var bname string
-	err := sdb.db.QueryRow(unifySQL(sdb.dbType, "SELECT basket_name FROM rb_baskets WHERE basket_name = $1"), name).Scan(&bname)
+	err := sdb.db.QueryRowContext(ctx, unifySQL(sdb.dbType, "SELECT basket_name FROM rb_baskets WHERE basket_name = $1"), name).Scan(&bname)
 
 	if err == sql.ErrNoRows {
 		log.Printf("[warn] no basket found: %s", name)
-		return nil
+		return nil, nil
 	} else if err != nil {
-		log.Printf("[error] failed to get basket: %s - %s", name, err)
-		return nil
+		return nil, fmt.Errorf("failed to get basket: %s - %s", name, err)
 	}
 
-	return &sqlBasket{sdb.db, sdb.dbType, name}
+	return &sqlBasket{sdb.db, sdb.dbType, name}, nil
 }
 
-func (sdb *sqlDatabase) Delete(name string) {
-	if _, err := sdb.db.Exec(unifySQL(sdb.dbType, "DELETE FROM rb_baskets WHERE basket_name = $1"), name); err != nil {
-		log.Printf("[error] failed to delete basket: %s - %s", name, err)
+func (sdb *sqlDatabase) Delete(ctx context.Context, name string) error {
+	if _, err := sdb.db.ExecContext(ctx, unifySQL(sdb.dbType, "DELETE FROM rb_baskets WHERE basket_name = $1"), name); err != nil {
+		return fmt.Errorf("failed to delete basket: %s - %s", name, err)
 	}
+	return nil
 }
 
-func (sdb *sqlDatabase) Size() int {
-	return sdb.getInt("SELECT COUNT(*) FROM rb_baskets", 0)
+func (sdb *sqlDatabase) Size(ctx context.Context) (int, error) {
+	return sdb.getInt(ctx, "SELECT COUNT(*) FROM rb_baskets")
 }
 
-func (sdb *sqlDatabase) GetNames(max int, skip int) BasketNamesPage {
-	page := BasketNamesPage{make([]string, 0, max), sdb.Size(), false}
-
-	names, err := sdb.db.Query(unifySQL(sdb.dbType, "SELECT basket_name FROM rb_baskets ORDER BY basket_name LIMIT $1 OFFSET $2"), max+1, skip)
-	if err != nil {
-		log.Printf("[error] failed to get basket names: %s", err)
-		return page
-	}
-	defer names.Close()
+// readNames reads basket names from rows up to the limit, returns true if there are more rows
+func readNames(rows *sql.Rows, max int) ([]string, bool, error) {
+	defer rows.Close()
 
+	result := make([]string, 0, max)
 	var name string
-	for len(page.Names) < max && names.Next() {
-		if err = names.Scan(&name); err == nil {
-			page.Names = append(page.Names, name)
+	for len(result) < max && rows.Next() {
+		if err := rows.Scan(&name); err != nil {
+			return result, false, err
 		}
+		result = append(result, name)
 	}
 
-	page.HasMore = names.Next()
+	hasMore := rows.Next()
+	return result, hasMore, rows.Err()
+}
 
-	return page
+func (sdb *sqlDatabase) GetNames(ctx context.Context, max int, skip int) (BasketNamesPage, error) {
+	page := BasketNamesPage{make([]string, 0, max), 0, false}
+
+	var err error
+	if page.Count, err = sdb.Size(ctx); err != nil {
+		return page, err
+	}
+
+	names, err := sdb.db.QueryContext(ctx,
+		unifySQL(sdb.dbType, "SELECT basket_name FROM rb_baskets ORDER BY basket_name LIMIT $1 OFFSET $2"), max+1, skip)
+	if err != nil {
+		return page, fmt.Errorf("failed to get basket names: %s", err)
+	}
+
+	if page.Names, page.HasMore, err = readNames(names, max); err != nil {
+		return page, fmt.Errorf("failed to get basket names: %s", err)
+	}
+	return page, nil
 }
 
-func (sdb *sqlDatabase) FindNames(query string, max int, skip int) BasketNamesQueryPage {
+func (sdb *sqlDatabase) FindNames(ctx context.Context, query string, max int, skip int) (BasketNamesQueryPage, error) {
 	page := BasketNamesQueryPage{make([]string, 0, max), false}
 
-	names, err := sdb.db.Query(
+	names, err := sdb.db.QueryContext(ctx,
 		unifySQL(sdb.dbType, "SELECT basket_name FROM rb_baskets WHERE basket_name LIKE $1 ORDER BY basket_name LIMIT $2 OFFSET $3"),
 		"%"+query+"%", max+1, skip)
 	if err != nil {
-		log.Printf("[error] failed to find basket names: %s", err)
-		return page
+		return page, fmt.Errorf("failed to find basket names: %s", err)
 	}
-	defer names.Close()
 
-	var name string
-	for len(page.Names) < max && names.Next() {
-		if err = names.Scan(&name); err == nil {
-			page.Names = append(page.Names, name)
-		}
+	if page.Names, page.HasMore, err = readNames(names, max); err != nil {
+		return page, fmt.Errorf("failed to find basket names: %s", err)
 	}
-
-	page.HasMore = names.Next()
-
-	return page
+	return page, nil
 }
 
-func (sdb *sqlDatabase) GetStats(max int) DatabaseStats {
+func (sdb *sqlDatabase) GetStats(ctx context.Context, max int) (DatabaseStats, error) {
 	stats := DatabaseStats{}
 
-	stats.BasketsCount = sdb.getInt("SELECT COUNT(*) FROM rb_baskets", 0)
-	stats.EmptyBasketsCount = sdb.getInt("SELECT COUNT(*) FROM rb_baskets WHERE requests_count = 0", 0)
-	stats.RequestsCount = sdb.getInt("SELECT COUNT(*) FROM rb_requests", 0)
-	stats.RequestsTotalCount = sdb.getInt("SELECT COALESCE(SUM(requests_count), 0) FROM rb_baskets", 0)
-	stats.MaxBasketSize = sdb.getInt("SELECT COALESCE(MAX(requests_count), 0) FROM rb_baskets", 0)
+	counters := []struct {
+		value *int
+		sql   string
+	}{
+		{&stats.BasketsCount, "SELECT COUNT(*) FROM rb_baskets"},
+		{&stats.EmptyBasketsCount, "SELECT COUNT(*) FROM rb_baskets WHERE requests_count = 0"},
+		{&stats.RequestsCount, "SELECT COUNT(*) FROM rb_requests"},
+		{&stats.RequestsTotalCount, "SELECT COALESCE(SUM(requests_count), 0) FROM rb_baskets"},
+		{&stats.MaxBasketSize, "SELECT COALESCE(MAX(requests_count), 0) FROM rb_baskets"}}
+
+	var err error
+	for _, counter := range counters {
+		if *counter.value, err = sdb.getInt(ctx, counter.sql); err != nil {
+			return stats, err
+		}
+	}
 
-	stats.TopBasketsBySize = sdb.getTopBaskets("SELECT basket_name FROM rb_baskets ORDER BY requests_count DESC LIMIT $1", max)
-	stats.TopBasketsByDate = sdb.getTopBaskets("SELECT basket_name FROM rb_requests GROUP BY basket_name ORDER BY MAX(created_at) DESC LIMIT $1", max)
+	if stats.TopBasketsBySize, err = sdb.getTopBaskets(ctx,
+		"SELECT basket_name FROM rb_baskets ORDER BY requests_count DESC LIMIT $1", max); err != nil {
+		return stats, err
+	}
+	if stats.TopBasketsByDate, err = sdb.getTopBaskets(ctx,
+		"SELECT basket_name FROM rb_requests GROUP BY basket_name ORDER BY MAX(created_at) DESC LIMIT $1", max); err != nil {
+		return stats, err
+	}
 
 	stats.UpdateAvarage()
-	return stats
+	return stats, nil
 }
 
 func (sdb *sqlDatabase) Release() {
@@ -464,7 +1045,7 @@ func NewSQLDatabase(connection string) BasketsDatabase {
 
 	if err = db.Ping(); err != nil {
 		log.Printf("[error] database connection is not alive: %s - %s", connection, err)
-	} else if err = initSchema(db); err != nil {
+	} else if err = initSchema(db, driver); err != nil {
 		log.Printf("[error] failed to initialize SQL schema: %s", err)
 	} else {
 		return &sqlDatabase{db, driver}
@@ -474,11 +1055,35 @@ func NewSQLDatabase(connection string) BasketsDatabase {
 	return nil
 }
 
+// toJSONColumn serializes optional value into JSON to be stored in text column, empty values are stored as NULL
+func toJSONColumn(value interface{}) sql.NullString {
+	if isEmptyValue(value) {
+		return sql.NullString{}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[error] failed to serialize value to JSON: %s", err)
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: string(data), Valid: true}
+}
+
+// fromJSONColumn parses optional value stored as JSON in text column
+func fromJSONColumn(column sql.NullString, value interface{}, what string) {
+	if len(column.String) > 0 {
+		if err := json.Unmarshal([]byte(column.String), value); err != nil {
+			log.Printf("[error] failed to parse %s - %s", what, err)
+		}
+	}
+}
+
 var pgParams = regexp.MustCompile(`\$\d+`)
 
 func unifySQL(dbType string, sql string) string {
 	switch dbType {
-	case "mysql", "sqlite3":
+	case "mysql", "sqlite":
 		// replace $n with ?
 		return pgParams.ReplaceAllString(sql, "?")
 		// case "postgres", "sqlserver":
@@ -498,8 +1103,8 @@ func parseConnection(connection string) (string, string) {
 			return driver, connection
 		case "mysql":
 			return driver, source + "?parseTime=true"
-		// case "sqlite3":
-		// 	return driver, source
+		case "sqlite", "sqlite3":
+			return "sqlite", sqliteSource(source)
 		default:
 			return driver, connection
 		}
@@ -509,35 +1114,12 @@ func parseConnection(connection string) (string, string) {
 	return "", connection
 }
 
-func initSchema(db *sql.DB) error {
-	switch version := getSchemaVersion(db); version {
-	case 0:
-		return createSchema(db)
-	case 1:
-		log.Printf("[info] database schema already exists, version: %v", version)
-		return nil
-	default:
-		return fmt.Errorf("unknown database schema version: %v", version)
+// sqliteSource appends options to SQLite database file: WAL journal mode, enforced foreign keys (baskets data
+// is deleted in cascade), case sensitive LIKE operator (same as in other databases) and timeout of locked database
+func sqliteSource(file string) string {
+	options := "_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=case_sensitive_like(1)&_pragma=busy_timeout(5000)"
+	if strings.Contains(file, "?") {
+		return file + "&" + options
 	}
-}
-
-func getSchemaVersion(db *sql.DB) int {
-	var version int
-	if err := db.QueryRow("SELECT version FROM rb_version").Scan(&version); err != nil {
-		return 0
-	}
-
-	return version
-}
-
-func createSchema(db *sql.DB) error {
-	log.Printf("[info] creating database schema")
-	for idx, stmt := range sqlSchema {
-		if _, err := db.Exec(stmt); err != nil {
-			return fmt.Errorf("error in SQL statement #%v - %s", idx, err)
-		}
-	}
-
-	log.Printf("[info] database is created, version: %v", getSchemaVersion(db))
-	return nil
+	return file + "?" + options
 }
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// sqlMigration describes a step to upgrade database schema to the version
+type sqlMigration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// Ordered list of migrations of database schema, the version of migration at index N is N+1;
+// new migrations must be appended to the end of the list and existing migrations must never be changed
+var sqlMigrations = []sqlMigration{
+	{1, "create schema for baskets", []string{
+		`CREATE TABLE rb_baskets (
+			basket_name varchar(250) PRIMARY KEY,
+			token varchar(100) NOT NULL,
+			capacity integer NOT NULL,
+			forward_url text NOT NULL,
+			proxy_response boolean NOT NULL,
+			insecure_tls boolean NOT NULL,
+			expand_path boolean NOT NULL,
+			requests_count integer NOT NULL DEFAULT 0,
+			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE rb_responses (
+			basket_name varchar(250) NOT NULL,
+			http_method varchar(20) NOT NULL,
+			response text NOT NULL,
+			PRIMARY KEY (basket_name, http_method),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE rb_requests (
+			basket_name varchar(250) NOT NULL,
+			request text NOT NULL,
+			created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX rb_requests_name_time_index ON rb_requests (basket_name, created_at)`,
+		`CREATE TABLE rb_version (
+			version integer NOT NULL
+		)`,
+		`INSERT INTO rb_version (version) VALUES (0)`}},
+	{2, "add forward targets", []string{
+		`ALTER TABLE rb_baskets ADD forward_targets text`}},
+	{3, "add retries of forwarding and undelivered requests", []string{
+		`ALTER TABLE rb_baskets ADD retry_count integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE rb_baskets ADD retry_backoff integer NOT NULL DEFAULT 0`,
+		`CREATE TABLE rb_dead_letters (
+			basket_name varchar(250) NOT NULL,
+			letter_id varchar(50) NOT NULL,
+			letter text NOT NULL,
+			created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			PRIMARY KEY (basket_name, letter_id),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`}},
+	{4, "add queued forwards", []string{
+		`CREATE TABLE rb_forwards (
+			basket_name varchar(250) NOT NULL,
+			forward_id varchar(50) NOT NULL,
+			forward text NOT NULL,
+			created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			PRIMARY KEY (basket_name, forward_id),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`}},
+	{5, "add header rules", []string{
+		`ALTER TABLE rb_baskets ADD header_rules text`}},
+	{6, "add transformation of forwarded requests", []string{
+		`ALTER TABLE rb_baskets ADD forward_body_template text`,
+		`ALTER TABLE rb_baskets ADD forward_method varchar(20)`,
+		`ALTER TABLE rb_baskets ADD forward_content_type varchar(250)`}},
+	{7, "add forward rules", []string{
+		`ALTER TABLE rb_baskets ADD forward_rules text`}},
+	{8, "add request IDs and results of forwarding", []string{
+		`ALTER TABLE rb_requests ADD request_id varchar(50)`,
+		`CREATE TABLE rb_forward_results (
+			basket_name varchar(250) NOT NULL,
+			request_id varchar(50) NOT NULL,
+			result text NOT NULL,
+			created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX rb_forward_results_request_index ON rb_forward_results (basket_name, request_id)`}},
+	{9, "add transport settings", []string{
+		`ALTER TABLE rb_baskets ADD transport text`}},
+	{10, "add signing settings", []string{
+		`ALTER TABLE rb_baskets ADD signing text`}},
+	{11, "add WebSocket settings and frames", []string{
+		`CREATE TABLE rb_websocket_frames (
+			basket_name varchar(250) NOT NULL,
+			request_id varchar(50) NOT NULL,
+			frame text NOT NULL,
+			created_at timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			FOREIGN KEY (basket_name) REFERENCES rb_baskets (basket_name) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX rb_websocket_frames_request_index ON rb_websocket_frames (basket_name, request_id)`,
+		`ALTER TABLE rb_baskets ADD websocket text`}},
+	{12, "add circuit breaker and rate limit", []string{
+		`ALTER TABLE rb_baskets ADD circuit_breaker text`,
+		`ALTER TABLE rb_baskets ADD rate_limit integer NOT NULL DEFAULT 0`}},
+	{13, "add max age of collected requests", []string{
+		`ALTER TABLE rb_baskets ADD max_age integer NOT NULL DEFAULT 0`}},
+	{14, "add date of the last collected request", []string{
+		`ALTER TABLE rb_baskets ADD last_request_date bigint NOT NULL DEFAULT 0`}},
+	{15, "add creation date of the last collected request", []string{
+		`ALTER TABLE rb_baskets ADD last_created_date bigint NOT NULL DEFAULT 0`}}}
+
+// sqlSchemaVersion defines the latest version of database schema
+var sqlSchemaVersion = len(sqlMigrations)
+
+// unifyDDL adapts DDL statement written for PostgreSQL and MySQL to the SQL dialect of database
+func unifyDDL(dbType string, stmt string) string {
+	switch dbType {
+	case "sqlite":
+		// SQLite has no fractional seconds precision of timestamp, default value is formatted with milliseconds
+		return strings.ReplaceAll(stmt, "timestamp(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3)",
+			"timestamp NOT NULL DEFAULT (STRFTIME('%Y-%m-%d %H:%M:%f', 'now'))")
+	default:
+		return stmt
+	}
+}
+
+// getSchemaVersion returns the version of database schema, 0 if schema is not created yet
+func getSchemaVersion(db *sql.DB) int {
+	var version int
+	if err := db.QueryRow("SELECT version FROM rb_version").Scan(&version); err != nil {
+		return 0
+	}
+
+	return version
+}
+
+// pendingMigrations returns migrations to upgrade database schema from the version to the latest version,
+// database schema that is newer than the latest known version is refused
+func pendingMigrations(version int) ([]sqlMigration, error) {
+	if version > sqlSchemaVersion {
+		return nil, fmt.Errorf("database schema version: %v is newer than supported version: %v, please upgrade the service",
+			version, sqlSchemaVersion)
+	}
+	return sqlMigrations[version:], nil
+}
+
+// applyMigration upgrades database schema within a transaction,
+// note that MySQL commits DDL statements implicitly, so failed migration is not rolled back completely there
+func applyMigration(db *sql.DB, dbType string, migration sqlMigration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for idx, stmt := range migration.statements {
+		if _, err = tx.Exec(unifyDDL(dbType, stmt)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error in SQL statement #%v of migration to version %v - %s", idx, migration.version, err)
+		}
+	}
+
+	if _, err = tx.Exec(unifySQL(dbType, "UPDATE rb_version SET version = $1"), migration.version); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update schema version to %v - %s", migration.version, err)
+	}
+
+	return tx.Commit()
+}
+
+// migrateSchema applies pending migrations to database schema, migrations are only listed if it is a dry run
+func migrateSchema(db *sql.DB, dbType string, dryRun bool) ([]sqlMigration, error) {
+	version := getSchemaVersion(db)
+	migrations, err := pendingMigrations(version)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(migrations) == 0 {
+		log.Printf("[info] database schema is up to date, version: %v", version)
+		return migrations, nil
+	}
+
+	for _, migration := range migrations {
+		if dryRun {
+			log.Printf("[info] pending migration of database schema to version: %v - %s", migration.version, migration.description)
+			continue
+		}
+
+		log.Printf("[info] migrating database schema to version: %v - %s", migration.version, migration.description)
+		if err = applyMigration(db, dbType, migration); err != nil {
+			return nil, err
+		}
+	}
+
+	if !dryRun {
+		log.Printf("[info] database schema is up to date, version: %v", getSchemaVersion(db))
+	}
+	return migrations, nil
+}
+
+func initSchema(db *sql.DB, dbType string) error {
+	_, err := migrateSchema(db, dbType, false)
+	return err
+}
+
+// MigrateSQLDatabase applies pending migrations to schema of SQL database without starting the service,
+// pending migrations are only listed if it is a dry run
+func MigrateSQLDatabase(connection string, dryRun bool) error {
+	driver, source := parseConnection(connection)
+	if len(driver) == 0 {
+		return fmt.Errorf("unknown type of SQL database: %s", connection)
+	}
+
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %s - %s", connection, err)
+	}
+	defer db.Close()
+
+	if err = db.Ping(); err != nil {
+		return fmt.Errorf("database connection is not alive: %s - %s", connection, err)
+	}
+
+	_, err = migrateSchema(db, driver, dryRun)
+	return err
+}
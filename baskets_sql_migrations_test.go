@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestSQLiteDatabase opens a new SQLite database file without schema
+func openTestSQLiteDatabase(t *testing.T, name string) (*sql.DB, string) {
+	file := filepath.Join(os.TempDir(), name+".db")
+	os.Remove(file)
+
+	driver, source := parseConnection("sqlite://" + file)
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		t.Fatalf("failed to open SQLite database: %s", err)
+	}
+	return db, file
+}
+
+// removeTestSQLiteDatabase removes SQLite database file with WAL files
+func removeTestSQLiteDatabase(file string) {
+	os.Remove(file)
+	os.Remove(file + "-wal")
+	os.Remove(file + "-shm")
+}
+
+func TestSQLMigrations_Order(t *testing.T) {
+	for i, migration := range sqlMigrations {
+		assert.Equal(t, i+1, migration.version, "migrations are expected to be ordered by version")
+		assert.NotEmpty(t, migration.description, "description of migration is expected")
+		assert.NotEmpty(t, migration.statements, "statements of migration are expected")
+	}
+	assert.Equal(t, sqlMigrations[len(sqlMigrations)-1].version, sqlSchemaVersion, "wrong latest schema version")
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations, err := pendingMigrations(0)
+	if assert.NoError(t, err) {
+		assert.Len(t, migrations, sqlSchemaVersion, "all migrations are expected for new database")
+	}
+
+	migrations, err = pendingMigrations(10)
+	if assert.NoError(t, err) && assert.Len(t, migrations, sqlSchemaVersion-10, "wrong number of pending migrations") {
+		assert.Equal(t, 11, migrations[0].version, "wrong first pending migration")
+	}
+
+	migrations, err = pendingMigrations(sqlSchemaVersion)
+	if assert.NoError(t, err) {
+		assert.Empty(t, migrations, "no pending migrations are expected")
+	}
+
+	_, err = pendingMigrations(sqlSchemaVersion + 1)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is newer than supported version", "error is not detailed enough")
+	}
+}
+
+func TestMigrateSchema_FromVersion1(t *testing.T) {
+	name := "migration01"
+	db, file := openTestSQLiteDatabase(t, name)
+	defer removeTestSQLiteDatabase(file)
+
+	// database created by the first version of service
+	for _, stmt := range sqlMigrations[0].statements {
+		_, err := db.Exec(unifyDDL("sqlite", stmt))
+		assert.NoError(t, err)
+	}
+	db.Exec("UPDATE rb_version SET version = 1")
+	db.Exec("INSERT INTO rb_baskets (basket_name, token, capacity, forward_url, proxy_response, insecure_tls, expand_path, requests_count) "+
+		"VALUES (?, 'abc', 15, 'http://localhost:8080', 0, 0, 1, 1)", name)
+	db.Exec("INSERT INTO rb_requests (basket_name, request) VALUES (?, '{\"body\": \"hello\", \"method\": \"POST\"}')", name)
+	db.Close()
+
+	sdb := testDB(t, NewSQLDatabase("sqlite://"+file))
+	if assert.NotNil(t, sdb, "SQL database is expected to be upgraded") {
+		defer sdb.Release()
+		assert.Equal(t, sqlSchemaVersion, getSchemaVersion(sdb.BasketsDatabase.(*sqlDatabase).db), "wrong schema version")
+
+		// existing data is kept
+		basket := sdb.Get(name)
+		if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+			assert.True(t, basket.Authorize("abc"), "basket authorization has failed")
+			config := basket.Config()
+			assert.Equal(t, 15, config.Capacity, "wrong capacity")
+			assert.Equal(t, "http://localhost:8080", config.ForwardURL, "wrong forward URL")
+			assert.True(t, config.ExpandPath, "wrong value of Expand Path flag")
+			assert.Equal(t, 0, config.RetryCount, "retries are not expected")
+
+			page := basket.GetRequests(10, 0)
+			if assert.Len(t, page.Requests, 1, "collected request is expected") {
+				assert.Equal(t, "hello", page.Requests[0].Body, "wrong request body")
+			}
+
+			// new features are available
+			config.RetryCount = 3
+			config.CircuitBreaker = &CircuitBreakerConfig{FailureThreshold: 5}
+			basket.Update(config)
+			assert.Equal(t, 3, basket.Config().RetryCount, "wrong retry count")
+			assert.NotNil(t, basket.Config().CircuitBreaker, "circuit breaker settings are expected")
+		}
+	}
+}
+
+func TestMigrateSchema_DryRun(t *testing.T) {
+	db, file := openTestSQLiteDatabase(t, "migration02")
+	defer removeTestSQLiteDatabase(file)
+	defer db.Close()
+
+	migrations, err := migrateSchema(db, "sqlite", true)
+	if assert.NoError(t, err) {
+		assert.Len(t, migrations, sqlSchemaVersion, "all migrations are expected to be pending")
+		assert.Equal(t, 0, getSchemaVersion(db), "schema is not expected to be created")
+	}
+
+	migrations, err = migrateSchema(db, "sqlite", false)
+	if assert.NoError(t, err) {
+		assert.Len(t, migrations, sqlSchemaVersion, "all migrations are expected to be applied")
+		assert.Equal(t, sqlSchemaVersion, getSchemaVersion(db), "wrong schema version")
+	}
+
+	migrations, err = migrateSchema(db, "sqlite", true)
+	if assert.NoError(t, err) {
+		assert.Empty(t, migrations, "no pending migrations are expected")
+	}
+}
+
+func TestMigrateSchema_NewerVersion(t *testing.T) {
+	db, file := openTestSQLiteDatabase(t, "migration03")
+	defer removeTestSQLiteDatabase(file)
+
+	_, err := migrateSchema(db, "sqlite", false)
+	assert.NoError(t, err)
+	db.Exec("UPDATE rb_version SET version = ?", sqlSchemaVersion+1)
+	db.Close()
+
+	assert.Nil(t, NewSQLDatabase("sqlite://"+file), "service is not expected to use newer database schema")
+	if err = MigrateSQLDatabase("sqlite://"+file, false); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is newer than supported version", "error is not detailed enough")
+	}
+}
+
+func TestApplyMigration_Rollback(t *testing.T) {
+	db, file := openTestSQLiteDatabase(t, "migration04")
+	defer removeTestSQLiteDatabase(file)
+	defer db.Close()
+
+	_, err := migrateSchema(db, "sqlite", false)
+	assert.NoError(t, err)
+
+	err = applyMigration(db, "sqlite", sqlMigration{sqlSchemaVersion + 1, "broken migration", []string{
+		`ALTER TABLE rb_baskets ADD test_column text`,
+		`ALTER TABLE rb_unknown ADD test_column text`}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "error in SQL statement #1 of migration to version", "error is not detailed enough")
+	}
+
+	// failed migration is rolled back
+	assert.Equal(t, sqlSchemaVersion, getSchemaVersion(db), "schema version is not expected to change")
+	_, err = db.Exec("SELECT test_column FROM rb_baskets")
+	assert.Error(t, err, "column of failed migration is not expected")
+}
+
+func TestMigrateSQLDatabase(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "migration05.db")
+	defer removeTestSQLiteDatabase(file)
+
+	assert.NoError(t, MigrateSQLDatabase("sqlite://"+file, true))
+	assert.NoError(t, MigrateSQLDatabase("sqlite://"+file, false))
+
+	db, _ := sql.Open(parseConnection("sqlite://" + file))
+	defer db.Close()
+	assert.Equal(t, sqlSchemaVersion, getSchemaVersion(db), "wrong schema version")
+
+	assert.Error(t, MigrateSQLDatabase("user@localhost:8000", false), "database type is not expected to be detected")
+}
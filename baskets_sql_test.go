@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -27,7 +29,8 @@ func TestSQLDatabase_Get_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	basket := basketsDb.Get("anybasket")
+	basket, err := basketsDb.Get(context.Background(), "anybasket")
+	assert.Error(t, err, "SQL error is expected")
 	assert.Nil(t, basket, "basket is not expected")
 }
 
@@ -36,8 +39,7 @@ func TestSQLDatabase_Delete_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	basketsDb.Delete("anybasket")
-	// TODO: find out how to capture the log output for validation
+	assert.Error(t, basketsDb.Delete(context.Background(), "anybasket"), "SQL error is expected")
 }
 
 func TestSQLDatabase_GetNames_SQLError(t *testing.T) {
@@ -45,12 +47,11 @@ func TestSQLDatabase_GetNames_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	page := basketsDb.GetNames(10, 0)
-	if assert.NotNil(t, page, "page object with names is expected") {
-		assert.Equal(t, 0, page.Count)
-		assert.False(t, page.HasMore)
-		assert.Empty(t, page.Names)
-	}
+	page, err := basketsDb.GetNames(context.Background(), 10, 0)
+	assert.Error(t, err, "SQL error is expected")
+	assert.Equal(t, 0, page.Count)
+	assert.False(t, page.HasMore)
+	assert.Empty(t, page.Names)
 }
 
 func TestSQLDatabase_FindNames_SQLError(t *testing.T) {
@@ -58,17 +59,25 @@ func TestSQLDatabase_FindNames_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	page := basketsDb.FindNames("a", 10, 0)
-	if assert.NotNil(t, page, "page object with names is expected") {
-		assert.False(t, page.HasMore)
-		assert.Empty(t, page.Names)
-	}
+	page, err := basketsDb.FindNames(context.Background(), "a", 10, 0)
+	assert.Error(t, err, "SQL error is expected")
+	assert.False(t, page.HasMore)
+	assert.Empty(t, page.Names)
+}
+
+func TestSQLDatabase_GetStats_SQLError(t *testing.T) {
+	sqldb, _ := sql.Open("postgres", pgTestConnection)
+	sqldb.Close()
+
+	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
+	_, err := basketsDb.GetStats(context.Background(), 5)
+	assert.Error(t, err, "SQL error is expected")
 }
 
 func TestSQLBasket_InvalidBasket(t *testing.T) {
 	// Note: this test is using connection string for PostgreSQL from: baskets_sql_pg_test.go
 	name := "test199"
-	db := NewSQLDatabase(pgTestConnection)
+	db := testDB(t, NewSQLDatabase(pgTestConnection))
 	defer db.Release()
 
 	db.Create(name, BasketConfig{Capacity: 20})
@@ -80,9 +89,11 @@ func TestSQLBasket_InvalidBasket(t *testing.T) {
 
 	// corrupted GET response
 	sqldb.Exec("INSERT INTO rb_responses (basket_name, http_method, response) VALUES ($1, 'GET', '{ abc... <<<')", name)
-	assert.Nil(t, basket.GetResponse("GET"))
+	response, err := basket.Basket.GetResponse(context.Background(), "GET")
+	assert.Error(t, err, "error is expected for corrupted response")
+	assert.Nil(t, response)
 
-	// corrupted request data
+	// corrupted request data is skipped
 	sqldb.Exec("INSERT INTO rb_requests (basket_name, request) VALUES ($1, '.... <<< data - broken json')", name)
 	assert.Equal(t, 1, basket.Size(), "wrong number of collected requests")
 	page := basket.GetRequests(10, 0)
@@ -100,8 +111,7 @@ func TestSQLBasket_Update_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	basket.Update(BasketConfig{Capacity: 10})
-	// TODO: find out how to capture the log output for validation
+	assert.Error(t, basket.Update(context.Background(), BasketConfig{Capacity: 10}), "SQL error is expected")
 }
 
 func TestSQLBasket_Authorize_SQLError(t *testing.T) {
@@ -109,7 +119,9 @@ func TestSQLBasket_Authorize_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	assert.False(t, basket.Authorize("token"))
+	authorized, err := basket.Authorize(context.Background(), "token")
+	assert.Error(t, err, "SQL error is expected")
+	assert.False(t, authorized)
 }
 
 func TestSQLBasket_GetResponse_SQLError(t *testing.T) {
@@ -117,7 +129,9 @@ func TestSQLBasket_GetResponse_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	assert.Nil(t, basket.GetResponse("GET"))
+	response, err := basket.GetResponse(context.Background(), "GET")
+	assert.Error(t, err, "SQL error is expected")
+	assert.Nil(t, response)
 }
 
 func TestSQLBasket_Add_SQLError(t *testing.T) {
@@ -125,8 +139,9 @@ func TestSQLBasket_Add_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	basket.Add(createTestPOSTRequest("http://localhost/anybasket", "Hellow world", "text/plain"))
-	// TODO: find out how to capture the log output for validation
+	err := basket.Add(context.Background(),
+		ToRequestData(createTestPOSTRequest("http://localhost/anybasket", "Hellow world", "text/plain")))
+	assert.Error(t, err, "SQL error is expected")
 }
 
 func TestSQLBasket_Clear_SQLError(t *testing.T) {
@@ -134,8 +149,7 @@ func TestSQLBasket_Clear_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	basket.Clear()
-	// TODO: find out how to capture the log output for validation
+	assert.Error(t, basket.Clear(context.Background()), "SQL error is expected")
 }
 
 func TestSQLBasket_GetRequests_SQLError(t *testing.T) {
@@ -143,13 +157,12 @@ func TestSQLBasket_GetRequests_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	page := basket.GetRequests(10, 0)
-	if assert.NotNil(t, page, "page object with requests is expected") {
-		assert.Equal(t, 0, page.Count)
-		assert.Equal(t, 0, page.TotalCount)
-		assert.False(t, page.HasMore)
-		assert.Empty(t, page.Requests)
-	}
+	page, err := basket.GetRequests(context.Background(), 10, 0)
+	assert.Error(t, err, "SQL error is expected")
+	assert.Equal(t, 0, page.Count)
+	assert.Equal(t, 0, page.TotalCount)
+	assert.False(t, page.HasMore)
+	assert.Empty(t, page.Requests)
 }
 
 func TestSQLBasket_FindRequests_SQLError(t *testing.T) {
@@ -157,13 +170,44 @@ func TestSQLBasket_FindRequests_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	page := basket.FindRequests("q", "any", 10, 0)
-	if assert.NotNil(t, page, "page object with requests is expected") {
-		assert.False(t, page.HasMore)
-		assert.Empty(t, page.Requests)
+	page, err := basket.FindRequests(context.Background(), "q", "any", 10, 0)
+	assert.Error(t, err, "SQL error is expected")
+	assert.False(t, page.HasMore)
+	assert.Empty(t, page.Requests)
+}
+
+func TestSQLBasket_CanceledContext(t *testing.T) {
+	name := "test156"
+	file := name + ".db"
+	db := testDB(t, NewSQLDatabase("sqlite://"+file))
+	defer os.Remove(file)
+	defer db.Release()
+
+	db.Create(name, BasketConfig{Capacity: 20})
+	basket := db.Get(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := basket.Basket.Add(ctx, createTestRequestData("/"+name, "test"))
+	if assert.Error(t, err, "canceled context is expected to stop the query") {
+		assert.Contains(t, err.Error(), context.Canceled.Error(), "wrong error")
+	}
+	assert.Equal(t, 0, basket.Size(), "request is not expected to be collected")
+
+	_, err = basket.Basket.GetRequests(ctx, 10, 0)
+	if assert.Error(t, err, "canceled context is expected to stop the query") {
+		assert.Contains(t, err.Error(), context.Canceled.Error(), "wrong error")
 	}
 }
 
+// totalRequestsCount returns total number of requests collected by SQL basket, storage errors fail the test
+func totalRequestsCount(t *testing.T, basket *testBasket) int {
+	count, err := basket.Basket.(*sqlBasket).getTotalRequestsCount(context.Background())
+	assert.NoError(t, err, "failed to get total requests count")
+	return count
+}
+
 // SQL errors tests in private methods
 
 func TestSQLDatabase_getTopBaskets_SQLError(t *testing.T) {
@@ -171,7 +215,9 @@ func TestSQLDatabase_getTopBaskets_SQLError(t *testing.T) {
 	defer sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	assert.Empty(t, basketsDb.getTopBaskets("SELECT x FROM FROM", 10), "no baskets are expected")
+	baskets, err := basketsDb.getTopBaskets(context.Background(), "SELECT x FROM FROM", 10)
+	assert.Error(t, err, "SQL error is expected")
+	assert.Empty(t, baskets, "no baskets are expected")
 }
 
 func TestSQLDatabase_getInt_SQLError(t *testing.T) {
@@ -179,7 +225,8 @@ func TestSQLDatabase_getInt_SQLError(t *testing.T) {
 	defer sqldb.Close()
 
 	basketsDb := sqlDatabase{db: sqldb, dbType: "postgres"}
-	assert.Equal(t, 12, basketsDb.getInt("SELECT count(x) FROM FROM", 12), "default value is expected if SQL error occurs")
+	_, err := basketsDb.getInt(context.Background(), "SELECT count(x) FROM FROM")
+	assert.Error(t, err, "SQL error is expected")
 }
 
 func TestSQLBasket_getInt_SQLError(t *testing.T) {
@@ -187,7 +234,8 @@ func TestSQLBasket_getInt_SQLError(t *testing.T) {
 	defer sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	assert.Equal(t, 42, basket.getInt("SELECT count(x) FROM FROM", 42), "default value is expected if SQL error occurs")
+	_, err := basket.getInt(context.Background(), "SELECT count(x) FROM FROM")
+	assert.Error(t, err, "SQL error is expected")
 }
 
 func TestSQLBasket_applyLimit_SQLError(t *testing.T) {
@@ -195,6 +243,15 @@ func TestSQLBasket_applyLimit_SQLError(t *testing.T) {
 	sqldb.Close()
 
 	basket := sqlBasket{db: sqldb, dbType: "postgres", name: "anybasket"}
-	basket.applyLimit(-1)
-	// TODO: find out how to capture the log output for validation
+	assert.Error(t, basket.applyLimit(context.Background(), -1), "SQL error is expected")
+}
+
+func TestParseConnection_SQLite(t *testing.T) {
+	driver, source := parseConnection("sqlite:///var/lib/rbaskets/baskets.db")
+	assert.Equal(t, "sqlite", driver, "wrong driver")
+	assert.Equal(t, "/var/lib/rbaskets/baskets.db?_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=case_sensitive_like(1)&_pragma=busy_timeout(5000)", source, "wrong source")
+
+	driver, source = parseConnection("sqlite3://baskets.db?cache=shared")
+	assert.Equal(t, "sqlite", driver, "wrong driver")
+	assert.Equal(t, "baskets.db?cache=shared&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)&_pragma=case_sensitive_like(1)&_pragma=busy_timeout(5000)", source, "wrong source")
 }
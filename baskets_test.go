@@ -1,13 +1,183 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// testDatabase wraps baskets database to call it with background context, storage errors fail the test
+type testDatabase struct {
+	BasketsDatabase
+	t *testing.T
+}
+
+func testDB(t *testing.T, db BasketsDatabase) *testDatabase {
+	if db == nil {
+		return nil
+	}
+	return &testDatabase{db, t}
+}
+
+func (db *testDatabase) Create(name string, config BasketConfig) (BasketAuth, error) {
+	return db.BasketsDatabase.Create(context.Background(), name, config)
+}
+
+func (db *testDatabase) Get(name string) *testBasket {
+	basket, err := db.BasketsDatabase.Get(context.Background(), name)
+	assert.NoError(db.t, err, "failed to get basket: %s", name)
+	if basket == nil {
+		return nil
+	}
+	return &testBasket{basket, db.t}
+}
+
+func (db *testDatabase) Delete(name string) {
+	assert.NoError(db.t, db.BasketsDatabase.Delete(context.Background(), name), "failed to delete basket: %s", name)
+}
+
+func (db *testDatabase) Size() int {
+	size, err := db.BasketsDatabase.Size(context.Background())
+	assert.NoError(db.t, err, "failed to get number of baskets")
+	return size
+}
+
+func (db *testDatabase) GetNames(max int, skip int) BasketNamesPage {
+	page, err := db.BasketsDatabase.GetNames(context.Background(), max, skip)
+	assert.NoError(db.t, err, "failed to get basket names")
+	return page
+}
+
+func (db *testDatabase) FindNames(query string, max int, skip int) BasketNamesQueryPage {
+	page, err := db.BasketsDatabase.FindNames(context.Background(), query, max, skip)
+	assert.NoError(db.t, err, "failed to find basket names")
+	return page
+}
+
+func (db *testDatabase) GetStats(max int) DatabaseStats {
+	stats, err := db.BasketsDatabase.GetStats(context.Background(), max)
+	assert.NoError(db.t, err, "failed to get database stats")
+	return stats
+}
+
+// testBasket wraps basket to call it with background context, storage errors fail the test
+type testBasket struct {
+	Basket
+	t *testing.T
+}
+
+func (basket *testBasket) Config() BasketConfig {
+	config, err := basket.Basket.Config(context.Background())
+	assert.NoError(basket.t, err, "failed to get basket config")
+	return config
+}
+
+func (basket *testBasket) Update(config BasketConfig) {
+	assert.NoError(basket.t, basket.Basket.Update(context.Background(), config), "failed to update basket config")
+}
+
+func (basket *testBasket) Authorize(token string) bool {
+	authorized, err := basket.Basket.Authorize(context.Background(), token)
+	assert.NoError(basket.t, err, "failed to authorize access to basket")
+	return authorized
+}
+
+func (basket *testBasket) GetResponse(method string) *ResponseConfig {
+	response, err := basket.Basket.GetResponse(context.Background(), method)
+	assert.NoError(basket.t, err, "failed to get basket response")
+	return response
+}
+
+func (basket *testBasket) SetResponse(method string, response ResponseConfig) {
+	assert.NoError(basket.t, basket.Basket.SetResponse(context.Background(), method, response),
+		"failed to update basket response")
+}
+
+func (basket *testBasket) Add(data *RequestData) {
+	assert.NoError(basket.t, basket.Basket.Add(context.Background(), data), "failed to collect request")
+}
+
+func (basket *testBasket) AddForwardResult(id string, result *ForwardResult) {
+	assert.NoError(basket.t, basket.Basket.AddForwardResult(context.Background(), id, result),
+		"failed to save result of forwarding")
+}
+
+func (basket *testBasket) AddWebSocketFrames(id string, frames []*WebSocketFrame) {
+	assert.NoError(basket.t, basket.Basket.AddWebSocketFrames(context.Background(), id, frames),
+		"failed to save WebSocket frames")
+}
+
+func (basket *testBasket) GetRequest(id string) *RequestData {
+	request, err := basket.Basket.GetRequest(context.Background(), id)
+	assert.NoError(basket.t, err, "failed to get collected request")
+	return request
+}
+
+func (basket *testBasket) Clear() {
+	assert.NoError(basket.t, basket.Basket.Clear(context.Background()), "failed to clear basket")
+}
+
+func (basket *testBasket) DeleteExpired(maxAge time.Duration) int {
+	deleted, err := basket.Basket.DeleteExpired(context.Background(), maxAge)
+	assert.NoError(basket.t, err, "failed to delete expired requests")
+	return deleted
+}
+
+func (basket *testBasket) Activity() BasketActivity {
+	activity, err := basket.Basket.Activity(context.Background())
+	assert.NoError(basket.t, err, "failed to get basket activity")
+	return activity
+}
+
+func (basket *testBasket) Size() int {
+	size, err := basket.Basket.Size(context.Background())
+	assert.NoError(basket.t, err, "failed to get size of basket")
+	return size
+}
+
+func (basket *testBasket) GetRequests(max int, skip int) RequestsPage {
+	page, err := basket.Basket.GetRequests(context.Background(), max, skip)
+	assert.NoError(basket.t, err, "failed to get collected requests")
+	return page
+}
+
+func (basket *testBasket) FindRequests(query string, in string, max int, skip int) RequestsQueryPage {
+	page, err := basket.Basket.FindRequests(context.Background(), query, in, max, skip)
+	assert.NoError(basket.t, err, "failed to find collected requests")
+	return page
+}
+
+func (basket *testBasket) AddDeadLetter(letter *DeadLetter) {
+	assert.NoError(basket.t, basket.Basket.AddDeadLetter(context.Background(), letter),
+		"failed to save undelivered request")
+}
+
+func (basket *testBasket) GetDeadLetter(id string) *DeadLetter {
+	letter, err := basket.Basket.GetDeadLetter(context.Background(), id)
+	assert.NoError(basket.t, err, "failed to get undelivered request")
+	return letter
+}
+
+func (basket *testBasket) GetDeadLetters(max int, skip int) DeadLettersPage {
+	page, err := basket.Basket.GetDeadLetters(context.Background(), max, skip)
+	assert.NoError(basket.t, err, "failed to get undelivered requests")
+	return page
+}
+
+func (basket *testBasket) RemoveDeadLetter(id string) {
+	assert.NoError(basket.t, basket.Basket.RemoveDeadLetter(context.Background(), id),
+		"failed to remove undelivered request")
+}
+
+func (basket *testBasket) ClearDeadLetters() {
+	assert.NoError(basket.t, basket.Basket.ClearDeadLetters(context.Background()),
+		"failed to clear undelivered requests")
+}
+
 func TestRequestData_Forward(t *testing.T) {
 	basket := "demo"
 
@@ -53,6 +223,146 @@ func TestRequestData_Forward(t *testing.T) {
 	}
 }
 
+func TestRequestData_Forward_HeaderRules(t *testing.T) {
+	basket := "rules"
+
+	// Test request
+	data := new(RequestData)
+	data.Header = make(http.Header)
+	data.Header.Add("Content-Type", "application/json")
+	data.Header.Add("Cookie", "session=12345")
+	data.Header.Add("X-Tenant", "acme")
+	data.Header.Add("Accept", "plain/text")
+	data.Method = "POST"
+	data.Body = "{ \"name\" : \"test\" }"
+	data.ContentLength = int64(len(data.Body))
+	data.Path = "/" + basket
+	data.Query = "env=staging"
+
+	// Test HTTP server
+	var forwardedData *RequestData
+	var forwardedHost string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedData = ToRequestData(r)
+		forwardedHost = r.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, HeaderRules: []HeaderRule{
+		{Action: HeaderRuleSet, Name: "Authorization", Value: "Bearer internal-token"},
+		{Action: HeaderRuleSet, Name: "X-Tenant", Value: "{{index .headers \"X-Tenant\" 0}}-{{index .query.env 0}}"},
+		{Action: HeaderRuleAdd, Name: "Accept", Value: "application/json"},
+		{Action: HeaderRuleRemove, Name: "cookie"},
+		{Action: HeaderRuleSet, Name: "Host", Value: "api.example.com"},
+		{Action: HeaderRuleRemove, Name: HopsHeader},
+		{Action: HeaderRuleRemove, Name: ViaHeader}}}
+	_, err := data.Forward(new(http.Client), config, basket)
+
+	if assert.NoError(t, err) && assert.NotNil(t, forwardedData, "request is not forwarded") {
+		assert.Equal(t, "Bearer internal-token", forwardedData.Header.Get("Authorization"), "header is expected to be injected")
+		assert.Equal(t, "acme-staging", forwardedData.Header.Get("X-Tenant"), "header is expected to be overridden")
+		assert.Equal(t, []string{"plain/text", "application/json"}, forwardedData.Header["Accept"], "header value is expected to be added")
+		assert.Empty(t, forwardedData.Header.Get("Cookie"), "header is expected to be removed")
+		assert.Equal(t, "api.example.com", forwardedHost, "host is expected to be rewritten")
+		// loop protection headers cannot be removed
+		assert.Equal(t, "1", forwardedData.Header.Get(HopsHeader), "hops header is expected")
+		assert.Equal(t, viaEntry(basket), forwardedData.Header.Get(ViaHeader), "via header is expected")
+		assert.Empty(t, forwardedData.Header.Get(DoNotForwardHeader), "do not forward header is not expected")
+	}
+
+	// original request is not modified
+	assert.Equal(t, "session=12345", data.Header.Get("Cookie"), "original request should not be modified")
+	assert.Equal(t, "acme", data.Header.Get("X-Tenant"), "original request should not be modified")
+}
+
+func TestRequestData_Forward_HeaderRules_InvalidTemplate(t *testing.T) {
+	data := new(RequestData)
+	data.Header = make(http.Header)
+	data.Method = "GET"
+	data.Path = "/rules"
+
+	config := BasketConfig{ForwardURL: "http://localhost:12345", Capacity: 20, HeaderRules: []HeaderRule{
+		{Action: HeaderRuleSet, Name: "X-Test", Value: "{{.headers"}}}
+	response, err := data.Forward(new(http.Client), config, "rules")
+
+	assert.Nil(t, response, "response is not expected")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "header rule for X-Test", "error is not detailed enough")
+	}
+}
+
+func TestRequestData_Transform(t *testing.T) {
+	data := new(RequestData)
+	data.Header = make(http.Header)
+	data.Header.Add("Content-Type", "application/json")
+	data.Method = "POST"
+	data.Body = "{ \"action\" : \"opened\", \"number\" : 15 }"
+	data.Path = "/transform"
+
+	// no transformation
+	payload, err := data.Transform(BasketConfig{ForwardURL: "http://localhost:12345", Capacity: 20})
+	assert.NoError(t, err)
+	assert.Nil(t, payload, "payload is not expected without transformation")
+
+	// method and content type overrides
+	payload, err = data.Transform(BasketConfig{ForwardMethod: "put", ForwardContentType: "text/plain"})
+	if assert.NoError(t, err) && assert.NotNil(t, payload, "payload is expected") {
+		assert.Equal(t, "PUT", payload.Method, "wrong method")
+		assert.Equal(t, "text/plain", payload.ContentType, "wrong content type")
+		assert.Equal(t, data.Body, payload.Body, "body is not expected to be changed")
+	}
+
+	// body template
+	payload, err = data.Transform(BasketConfig{ForwardBodyTemplate: "event={{.body.action}}&id={{.body.number}}"})
+	if assert.NoError(t, err) && assert.NotNil(t, payload, "payload is expected") {
+		assert.Equal(t, "POST", payload.Method, "method is not expected to be changed")
+		assert.Equal(t, "application/json", payload.ContentType, "content type is not expected to be changed")
+		assert.Equal(t, "event=opened&id=15", payload.Body, "wrong transformed body")
+	}
+
+	// broken template
+	_, err = data.Transform(BasketConfig{ForwardBodyTemplate: "{{.body"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid template of forwarded request body", "error is not detailed enough")
+	}
+}
+
+func TestRequestData_Forward_Transformation(t *testing.T) {
+	basket := "transform"
+
+	data := new(RequestData)
+	data.Header = make(http.Header)
+	data.Header.Add("Content-Type", "application/json")
+	data.Method = "POST"
+	data.Body = "{ \"action\" : \"opened\", \"number\" : 15 }"
+	data.ContentLength = int64(len(data.Body))
+	data.Path = "/" + basket
+
+	var forwardedData *RequestData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedData = ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, ForwardMethod: "PUT", ForwardContentType: "application/x-www-form-urlencoded",
+		ForwardBodyTemplate: "event={{.body.action}}&id={{.body.number}}"}
+	_, err := data.Forward(new(http.Client), config, basket)
+
+	if assert.NoError(t, err) && assert.NotNil(t, forwardedData, "request is not forwarded") {
+		assert.Equal(t, "PUT", forwardedData.Method, "wrong request method")
+		assert.Equal(t, "application/x-www-form-urlencoded", forwardedData.Header.Get("Content-Type"), "wrong content type")
+		assert.Equal(t, "event=opened&id=15", forwardedData.Body, "wrong request body")
+		assert.Equal(t, int64(len("event=opened&id=15")), forwardedData.ContentLength, "wrong content length")
+	}
+
+	// original request is not modified
+	assert.Equal(t, "POST", data.Method, "original request should not be modified")
+	assert.Equal(t, "application/json", data.Header.Get("Content-Type"), "original request should not be modified")
+	assert.Equal(t, "{ \"action\" : \"opened\", \"number\" : 15 }", data.Body, "original request should not be modified")
+}
+
 func TestRequestData_Forward_ComplexForwardURL(t *testing.T) {
 	basket := "zooapi"
 	pathSuffix := "/rooms/1/pets/12"
@@ -194,3 +504,227 @@ func test_validateBasketStats(t *testing.T, info *BasketInfo, name string, count
 	assert.Equal(t, totalCount, info.RequestsTotalCount, "unexpected requests total count for basket: "+name)
 	assert.NotEqual(t, int64(0), info.LastRequestDate, "last request date is expected for basket: "+name)
 }
+
+func TestBasketConfig_Targets(t *testing.T) {
+	config := BasketConfig{ForwardURL: "http://localhost:12345/a", InsecureTLS: true, Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/b", ExpandPath: true}}}
+
+	targets := config.Targets()
+	if assert.Equal(t, 2, len(targets), "wrong number of forward targets") {
+		assert.Equal(t, ForwardTarget{URL: "http://localhost:12345/a", InsecureTLS: true}, targets[0])
+		assert.Equal(t, ForwardTarget{URL: "http://localhost:12345/b", ExpandPath: true}, targets[1])
+	}
+
+	assert.Empty(t, BasketConfig{Capacity: 20}.Targets(), "forward targets are not expected")
+}
+
+func TestBasketConfig_PrimaryTarget(t *testing.T) {
+	// forward URL is primary by default
+	config := BasketConfig{ForwardURL: "http://localhost:12345/a",
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/b"}}}
+	assert.Equal(t, 0, config.PrimaryTarget(), "wrong primary target")
+
+	// explicitly marked target
+	config.ForwardTargets = append(config.ForwardTargets, ForwardTarget{URL: "http://localhost:12345/c", Primary: true})
+	assert.Equal(t, 2, config.PrimaryTarget(), "wrong primary target")
+
+	// single target
+	config = BasketConfig{ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/b"}}}
+	assert.Equal(t, 0, config.PrimaryTarget(), "wrong primary target")
+
+	// ambiguous targets
+	config.ForwardTargets = append(config.ForwardTargets, ForwardTarget{URL: "http://localhost:12345/c"})
+	assert.Equal(t, -1, config.PrimaryTarget(), "primary target is not expected")
+}
+
+func TestBasketConfig_ForTarget(t *testing.T) {
+	config := BasketConfig{ForwardURL: "http://localhost:12345/a", ProxyResponse: true, Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:12345/b", InsecureTLS: true, ExpandPath: true}}}
+
+	target := config.ForTarget(config.ForwardTargets[0])
+	assert.Equal(t, "http://localhost:12345/b", target.ForwardURL, "wrong forward URL")
+	assert.True(t, target.InsecureTLS, "wrong value of Insecure TLS flag")
+	assert.True(t, target.ExpandPath, "wrong value of Expand Path flag")
+	assert.True(t, target.ProxyResponse, "wrong value of Proxy Response flag")
+	assert.Equal(t, 20, target.Capacity, "wrong capacity")
+	assert.Empty(t, target.ForwardTargets, "forward targets are not expected")
+	// original config is not affected
+	assert.Equal(t, "http://localhost:12345/a", config.ForwardURL, "wrong forward URL")
+}
+
+func TestForwardRule_Matches(t *testing.T) {
+	data := createTestRequestData("/rules/github/events", "{ \"action\": \"opened\" }")
+	data.Header.Add("X-GitHub-Event", "pull_request")
+
+	matches := func(rule ForwardRule) bool {
+		result, err := rule.Matches(data)
+		assert.NoError(t, err)
+		return result
+	}
+
+	assert.True(t, matches(ForwardRule{Name: "any"}), "rule without conditions should match any request")
+	assert.True(t, matches(ForwardRule{Name: "method", Method: "post"}), "method should match ignoring case")
+	assert.False(t, matches(ForwardRule{Name: "method", Method: "PUT"}), "method should not match")
+	assert.True(t, matches(ForwardRule{Name: "path", Path: "/github/"}), "path should match")
+	assert.False(t, matches(ForwardRule{Name: "path", Path: "^/github"}), "path should not match")
+	assert.True(t, matches(ForwardRule{Name: "header", Headers: map[string]string{"x-github-event": "^pull_request$"}}),
+		"header should match")
+	assert.False(t, matches(ForwardRule{Name: "header", Headers: map[string]string{"X-GitHub-Event": "^push$"}}),
+		"header should not match")
+	assert.False(t, matches(ForwardRule{Name: "header", Headers: map[string]string{"X-Missing": ".*"}}),
+		"missing header should not match")
+	assert.True(t, matches(ForwardRule{Name: "body", Body: "\"action\":\\s*\"opened\""}), "body should match")
+	assert.False(t, matches(ForwardRule{Name: "all", Method: "POST", Path: "events$", Body: "closed"}),
+		"all conditions should match")
+
+	_, err := (&ForwardRule{Name: "broken", Body: "(abc"}).Matches(data)
+	assert.Error(t, err, "invalid pattern should be reported")
+}
+
+func TestCompilePattern(t *testing.T) {
+	re, err := compilePattern("^/compiled/[0-9]+$")
+	if assert.NoError(t, err) {
+		again, _ := compilePattern("^/compiled/[0-9]+$")
+		assert.True(t, re == again, "compiled pattern is expected to be reused")
+		assert.True(t, re.MatchString("/compiled/42"), "pattern should match")
+	}
+
+	_, err = compilePattern("(abc")
+	assert.Error(t, err, "invalid pattern should be reported")
+}
+
+func TestBasketConfig_MatchRule(t *testing.T) {
+	data := createTestRequestData("/rules/events", "abc")
+	config := BasketConfig{ForwardRules: []ForwardRule{
+		{Name: "broken", Path: "(abc"},
+		{Name: "get", Method: "GET"},
+		{Name: "post", Method: "POST"},
+		{Name: "any"}}}
+
+	if rule := config.MatchRule(data); assert.NotNil(t, rule, "rule is expected to match") {
+		assert.Equal(t, "post", rule.Name, "first matching rule is expected")
+	}
+	assert.Nil(t, BasketConfig{}.MatchRule(data), "no rule is expected")
+}
+
+func TestBasketConfig_ForRule(t *testing.T) {
+	config := BasketConfig{ForwardURL: "http://localhost:8080", Capacity: 20,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:8081"}},
+		ForwardRules:   []ForwardRule{{Name: "ci", Targets: []ForwardTarget{{URL: "http://ci.local/hook"}}}}}
+
+	// no rule fired - default targets
+	ruleConfig := config.ForRule(nil)
+	assert.Equal(t, config.Targets(), ruleConfig.Targets(), "default targets are expected")
+	assert.Empty(t, ruleConfig.ForwardRules, "rules are not expected")
+
+	// rule with targets
+	ruleConfig = config.ForRule(&config.ForwardRules[0])
+	assert.Equal(t, []ForwardTarget{{URL: "http://ci.local/hook"}}, ruleConfig.Targets(), "targets of rule are expected")
+	assert.Equal(t, 20, ruleConfig.Capacity, "other settings are expected to be kept")
+
+	// rule without targets
+	ruleConfig = config.ForRule(&ForwardRule{Name: "default"})
+	assert.Equal(t, config.Targets(), ruleConfig.Targets(), "default targets are expected")
+
+	// rule that drops requests
+	ruleConfig = config.ForRule(&ForwardRule{Name: "drop", Drop: true})
+	assert.Empty(t, ruleConfig.Targets(), "no targets are expected")
+}
+
+func TestBasketConfig_WithSecretsOf(t *testing.T) {
+	current := BasketConfig{Capacity: 20, Transport: &TransportConfig{
+		TLS:   &TLSConfig{ClientCert: "cert", ClientKey: "key"},
+		Proxy: &ProxyConfig{URL: "http://proxy:3128", Username: "user", Password: "secret"}},
+		Signing: &SigningConfig{Header: "X-Signature", Secret: "signing-secret"}}
+
+	config := current.WithoutSecrets().WithSecretsOf(current)
+	assert.Equal(t, current, config, "secrets are expected to be kept")
+
+	config = BasketConfig{Capacity: 20, Transport: &TransportConfig{
+		TLS:   &TLSConfig{ClientCert: "cert2", ClientKey: "key2"},
+		Proxy: &ProxyConfig{URL: "http://proxy:3128", Username: "user", Password: "secret2"}},
+		Signing: &SigningConfig{Secret: "signing-secret2"}}.WithSecretsOf(current)
+	assert.Equal(t, "key2", config.Transport.TLS.ClientKey, "new client key is expected")
+	assert.Equal(t, "secret2", config.Transport.Proxy.Password, "new proxy password is expected")
+	assert.Equal(t, "signing-secret2", config.Signing.Secret, "new signing secret is expected")
+
+	config = BasketConfig{Capacity: 20}.WithSecretsOf(current)
+	assert.Nil(t, config.Transport, "removed transport settings are not expected")
+	assert.Nil(t, config.Signing, "removed signing settings are not expected")
+}
+
+func TestBasketConfig_WithoutSecrets(t *testing.T) {
+	config := BasketConfig{Capacity: 20, Transport: &TransportConfig{Timeout: 100,
+		TLS:   &TLSConfig{ClientCert: "cert", ClientKey: "key", MinVersion: "1.2"},
+		Proxy: &ProxyConfig{URL: "http://proxy:3128", Username: "user", Password: "secret"}},
+		Signing: &SigningConfig{Header: "X-Signature", Secret: "signing-secret"}}
+
+	exposed := config.WithoutSecrets()
+	if assert.NotNil(t, exposed.Transport, "transport settings are expected") &&
+		assert.NotNil(t, exposed.Transport.TLS, "TLS settings are expected") {
+		assert.Empty(t, exposed.Transport.TLS.ClientKey, "client key is not expected")
+		assert.Equal(t, "cert", exposed.Transport.TLS.ClientCert, "wrong client certificate")
+		assert.Equal(t, 100, exposed.Transport.Timeout, "wrong timeout")
+	}
+	if assert.NotNil(t, exposed.Transport.Proxy, "proxy settings are expected") {
+		assert.Empty(t, exposed.Transport.Proxy.Password, "proxy password is not expected")
+		assert.Equal(t, "user", exposed.Transport.Proxy.Username, "wrong proxy username")
+	}
+	assert.Equal(t, "key", config.Transport.TLS.ClientKey, "original config is not expected to be modified")
+	if assert.NotNil(t, exposed.Signing, "signing settings are expected") {
+		assert.Empty(t, exposed.Signing.Secret, "signing secret is not expected")
+		assert.Equal(t, "X-Signature", exposed.Signing.Header, "wrong signature header")
+	}
+	assert.Equal(t, "secret", config.Transport.Proxy.Password, "original config is not expected to be modified")
+	assert.Equal(t, "signing-secret", config.Signing.Secret, "original config is not expected to be modified")
+
+	assert.Nil(t, BasketConfig{Capacity: 20}.WithoutSecrets().Transport, "transport settings are not expected")
+}
+
+func TestReplayOptions_Apply(t *testing.T) {
+	request := createTestRequestData("/replay", "original")
+	request.Header.Set("X-Event", "push")
+	request.Header.Set("X-Delivery", "123")
+
+	body := "{\"updated\":true}"
+	replay := ReplayOptions{Header: http.Header{"x-event": {"ping"}, "X-Delivery": {}}, Body: &body}.Apply(request)
+
+	assert.Equal(t, request.ID, replay.ID, "replayed request is expected to keep ID")
+	assert.Equal(t, "ping", replay.Header.Get("X-Event"), "header is expected to be overridden")
+	assert.Empty(t, replay.Header.Get("X-Delivery"), "header is expected to be removed")
+	assert.Equal(t, "text/plain", replay.Header.Get("Content-Type"), "header is expected to remain")
+	assert.Equal(t, body, replay.Body, "body is expected to be overridden")
+	assert.Equal(t, int64(len(body)), replay.ContentLength, "wrong content length")
+
+	// original request is not modified
+	assert.Equal(t, "push", request.Header.Get("X-Event"), "original request is not expected to be modified")
+	assert.Equal(t, "123", request.Header.Get("X-Delivery"), "original request is not expected to be modified")
+	assert.Equal(t, "original", request.Body, "original request is not expected to be modified")
+
+	replay = ReplayOptions{}.Apply(request)
+	assert.Equal(t, "original", replay.Body, "body is expected to remain")
+	assert.Equal(t, "push", replay.Header.Get("X-Event"), "header is expected to remain")
+}
+
+func TestBasketConfig_ForReplay(t *testing.T) {
+	config := BasketConfig{ForwardURL: "http://localhost:8080/hook", ExpandPath: true, InsecureTLS: true,
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:8081"}}}
+
+	replay := config.ForReplay(ReplayOptions{})
+	assert.Equal(t, "http://localhost:8080/hook", replay.ForwardURL, "wrong forward URL")
+	assert.True(t, replay.ExpandPath, "path is expected to be expanded")
+	assert.True(t, replay.InsecureTLS, "insecure TLS is expected")
+	assert.Empty(t, replay.ForwardTargets, "forward targets are not expected")
+
+	replay = config.ForReplay(ReplayOptions{URL: "http://localhost:3000/debug"})
+	assert.Equal(t, "http://localhost:3000/debug", replay.ForwardURL, "wrong forward URL")
+	assert.False(t, replay.ExpandPath, "path is not expected to be expanded")
+	assert.True(t, replay.InsecureTLS, "insecure TLS is expected")
+}
+
+// pendingForwards returns forwards persisted by the store, storage errors fail the test
+func pendingForwards(t *testing.T, store ForwardsStore) []*PendingForward {
+	forwards, err := store.GetPendingForwards(context.Background())
+	assert.NoError(t, err, "failed to get queued forwards")
+	return forwards
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// States of circuit breaker of a forward target
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+const (
+	defaultCircuitOpenDuration = 30 * time.Second
+	maxRateLimitDelay          = 10 * time.Second
+)
+
+var errRateLimitExceeded = errors.New("rate limit of basket is exceeded, request is not forwarded")
+
+// CircuitBreakerConfig describes circuit breaker of forward targets, the circuit of a target opens after
+// the number of consecutive failures reaches the threshold and stops forwarding to the target for open duration (ms.),
+// then a single probe request decides whether the circuit is closed again
+type CircuitBreakerConfig struct {
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	OpenDuration     int `json:"open_duration,omitempty"`
+}
+
+// Validate checks settings of circuit breaker
+func (config *CircuitBreakerConfig) Validate() error {
+	if config.FailureThreshold < 0 {
+		return fmt.Errorf("invalid failure threshold of circuit breaker: %d", config.FailureThreshold)
+	}
+	if config.OpenDuration < 0 {
+		return fmt.Errorf("invalid open duration of circuit breaker: %d", config.OpenDuration)
+	}
+	return nil
+}
+
+func (config *CircuitBreakerConfig) openDuration() time.Duration {
+	if config.OpenDuration > 0 {
+		return time.Duration(config.OpenDuration) * time.Millisecond
+	}
+	return defaultCircuitOpenDuration
+}
+
+// BasketStatus describes runtime state of forwarding of a basket
+type BasketStatus struct {
+	RateLimit int             `json:"rate_limit"`
+	Targets   []*TargetStatus `json:"targets"`
+}
+
+// TargetStatus describes state of circuit breaker of a forward target, the dates are in Unix time ms.
+type TargetStatus struct {
+	URL      string `json:"url"`
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	OpenedAt int64  `json:"opened_at,omitempty"`
+	ProbeAt  int64  `json:"probe_at,omitempty"`
+}
+
+// circuitBreaker tracks failures of forwarding to a target
+type circuitBreaker struct {
+	sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow checks if request may be forwarded to the target, only one probe request is allowed in half-open state
+func (breaker *circuitBreaker) allow(config *CircuitBreakerConfig) error {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		if time.Since(breaker.openedAt) < config.openDuration() {
+			return errors.New("circuit breaker of target is open, request is not forwarded")
+		}
+		breaker.state = CircuitHalfOpen
+		breaker.probing = false
+		fallthrough
+	case CircuitHalfOpen:
+		if breaker.probing {
+			return errors.New("circuit breaker of target is half-open, probe request is in progress")
+		}
+		breaker.probing = true
+	}
+
+	return nil
+}
+
+// report records the outcome of forwarding to the target
+func (breaker *circuitBreaker) report(config *CircuitBreakerConfig, success bool) {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.probing = false
+	if success {
+		breaker.state = CircuitClosed
+		breaker.failures = 0
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == CircuitHalfOpen || breaker.failures >= config.FailureThreshold {
+		breaker.state = CircuitOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+// release gives up the probe request if it is not sent
+func (breaker *circuitBreaker) release() {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	breaker.probing = false
+}
+
+func (breaker *circuitBreaker) status(url string, config *CircuitBreakerConfig) *TargetStatus {
+	breaker.Lock()
+	defer breaker.Unlock()
+
+	status := &TargetStatus{URL: url, State: breaker.state, Failures: breaker.failures}
+	if len(status.State) == 0 {
+		status.State = CircuitClosed
+	}
+	if breaker.state != CircuitClosed && !breaker.openedAt.IsZero() {
+		status.OpenedAt = breaker.openedAt.UnixNano() / toMs
+		status.ProbeAt = breaker.openedAt.Add(config.openDuration()).UnixNano() / toMs
+	}
+	return status
+}
+
+// rateLimiter spaces out forwarded requests to keep the rate, bursts up to the rate are allowed
+type rateLimiter struct {
+	sync.Mutex
+	rate int
+	next time.Time // theoretical time of the next request
+}
+
+// reserve returns the delay before request may be forwarded, the request is rejected if the delay is too long
+func (limiter *rateLimiter) reserve(rate int) (time.Duration, error) {
+	limiter.Lock()
+	defer limiter.Unlock()
+
+	now := time.Now()
+	if limiter.rate != rate || limiter.next.Before(now) {
+		limiter.rate = rate
+		limiter.next = now
+	}
+
+	interval := time.Second / time.Duration(rate)
+	delay := limiter.next.Sub(now) - time.Duration(rate-1)*interval
+	if delay < 0 {
+		delay = 0
+	} else if delay > maxRateLimitDelay {
+		return 0, errRateLimitExceeded
+	}
+
+	limiter.next = limiter.next.Add(interval)
+	return delay, nil
+}
+
+// forwardGuard keeps circuit breakers of forward targets and rate limiter of a basket
+type forwardGuard struct {
+	sync.Mutex
+	limiter  rateLimiter
+	breakers map[string]*circuitBreaker
+}
+
+func (guard *forwardGuard) breaker(url string) *circuitBreaker {
+	guard.Lock()
+	defer guard.Unlock()
+
+	breaker, exists := guard.breakers[url]
+	if !exists {
+		breaker = &circuitBreaker{state: CircuitClosed}
+		guard.breakers[url] = breaker
+	}
+	return breaker
+}
+
+// guardsRegistry keeps forward guards of baskets, the state of guards is not persisted
+type guardsRegistry struct {
+	sync.Mutex
+	guards map[string]*forwardGuard
+}
+
+var forwardGuards = &guardsRegistry{guards: make(map[string]*forwardGuard)}
+
+func (registry *guardsRegistry) get(name string) *forwardGuard {
+	registry.Lock()
+	defer registry.Unlock()
+
+	guard, exists := registry.guards[name]
+	if !exists {
+		guard = &forwardGuard{breakers: make(map[string]*circuitBreaker)}
+		registry.guards[name] = guard
+	}
+	return guard
+}
+
+// release forgets the state of forwarding of deleted basket
+func (registry *guardsRegistry) release(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	delete(registry.guards, name)
+}
+
+// guardForward checks circuit breaker of forward target and waits for the rate limit of basket before request
+// is forwarded, returns a function to report the outcome of forwarding or an error if request must not be forwarded
+func guardForward(config BasketConfig, name string) (func(success bool), error) {
+	guard := forwardGuards.get(name)
+
+	var breaker *circuitBreaker
+	cb := config.CircuitBreaker
+	if cb != nil && cb.FailureThreshold > 0 {
+		breaker = guard.breaker(config.ForwardURL)
+		if err := breaker.allow(cb); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.RateLimit > 0 {
+		delay, err := guard.limiter.reserve(config.RateLimit)
+		if err != nil {
+			if breaker != nil {
+				breaker.release()
+			}
+			return nil, err
+		}
+		time.Sleep(delay)
+	}
+
+	return func(success bool) {
+		if breaker != nil {
+			breaker.report(cb, success)
+		}
+	}, nil
+}
+
+// getBasketStatus collects the state of circuit breakers of all forward targets of basket
+func getBasketStatus(config BasketConfig, name string) BasketStatus {
+	status := BasketStatus{RateLimit: config.RateLimit, Targets: make([]*TargetStatus, 0)}
+	guard := forwardGuards.get(name)
+
+	cb := config.CircuitBreaker
+	if cb == nil {
+		cb = &CircuitBreakerConfig{}
+	}
+
+	known := make(map[string]bool)
+	for _, target := range config.AllTargets() {
+		if !known[target.URL] {
+			known[target.URL] = true
+			status.Targets = append(status.Targets, guard.breaker(target.URL).status(target.URL, cb))
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	config := &CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: 50}
+	breaker := &circuitBreaker{state: CircuitClosed}
+
+	// failures below threshold
+	assert.NoError(t, breaker.allow(config), "request is expected to be allowed")
+	breaker.report(config, false)
+	assert.Equal(t, CircuitClosed, breaker.status("", config).State, "circuit is expected to be closed")
+
+	// failure threshold is reached
+	assert.NoError(t, breaker.allow(config), "request is expected to be allowed")
+	breaker.report(config, false)
+	status := breaker.status("http://localhost:8080", config)
+	assert.Equal(t, CircuitOpen, status.State, "circuit is expected to be open")
+	assert.Equal(t, 2, status.Failures, "wrong number of failures")
+	assert.Equal(t, status.OpenedAt+50, status.ProbeAt, "wrong time of probe")
+	if err := breaker.allow(config); assert.Error(t, err, "request is not expected to be allowed") {
+		assert.Contains(t, err.Error(), "circuit breaker of target is open", "error is not detailed enough")
+	}
+
+	// failed probe
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, breaker.allow(config), "probe request is expected to be allowed")
+	if err := breaker.allow(config); assert.Error(t, err, "only one probe request is expected") {
+		assert.Contains(t, err.Error(), "probe request is in progress", "error is not detailed enough")
+	}
+	breaker.report(config, false)
+	assert.Equal(t, CircuitOpen, breaker.status("", config).State, "circuit is expected to be open again")
+
+	// released probe
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, breaker.allow(config), "probe request is expected to be allowed")
+	breaker.release()
+	assert.Equal(t, CircuitHalfOpen, breaker.status("", config).State, "circuit is expected to be half-open")
+
+	// successful probe
+	assert.NoError(t, breaker.allow(config), "probe request is expected to be allowed")
+	breaker.report(config, true)
+	status = breaker.status("", config)
+	assert.Equal(t, CircuitClosed, status.State, "circuit is expected to be closed")
+	assert.Equal(t, 0, status.Failures, "failures are expected to be reset")
+}
+
+func TestCircuitBreakerConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&CircuitBreakerConfig{FailureThreshold: 5}).Validate(), "settings are expected to be valid")
+	assert.Error(t, (&CircuitBreakerConfig{FailureThreshold: -1}).Validate(), "negative threshold is not expected")
+	assert.Error(t, (&CircuitBreakerConfig{OpenDuration: -1}).Validate(), "negative duration is not expected")
+}
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	limiter := &rateLimiter{}
+
+	// burst up to the rate
+	for i := 0; i < 2; i++ {
+		delay, err := limiter.reserve(2)
+		if assert.NoError(t, err) {
+			assert.Equal(t, time.Duration(0), delay, "request #%d is not expected to be delayed", i+1)
+		}
+	}
+	delay, err := limiter.reserve(2)
+	if assert.NoError(t, err) {
+		assert.InDelta(t, float64(500*time.Millisecond), float64(delay), float64(20*time.Millisecond), "wrong delay")
+	}
+
+	// new rate resets the limiter
+	for i := 0; i <= int(maxRateLimitDelay/time.Second); i++ {
+		_, err = limiter.reserve(1)
+		assert.NoError(t, err, "request #%d is expected to be delayed", i+1)
+	}
+	_, err = limiter.reserve(1)
+	assert.Equal(t, errRateLimitExceeded, err, "request is expected to be rejected")
+}
+
+func TestForwardRequest_CircuitBreaker(t *testing.T) {
+	name := "breaker01"
+	defer forwardGuards.release(name)
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, CircuitBreaker: &CircuitBreakerConfig{FailureThreshold: 2}}
+	request := createTestRequestData("/"+name, "abc")
+
+	for i := 0; i < 2; i++ {
+		result, response, err := forwardRequest(request, config, name)
+		if assert.NoError(t, err) && assert.NotNil(t, response, "response of target is expected") {
+			response.Body.Close()
+			assert.Equal(t, 503, result.Status, "wrong status of target")
+		}
+	}
+
+	result, response, err := forwardRequest(request, config, name)
+	assert.NoError(t, err)
+	assert.Nil(t, response, "request is not expected to be forwarded")
+	assert.True(t, result.Rejected, "request is expected to be rejected")
+	assert.Contains(t, result.Error, "circuit breaker of target is open", "error is not detailed enough")
+	assert.Equal(t, 2, calls, "target is not expected to be called when circuit is open")
+
+	status := getBasketStatus(config, name)
+	if assert.Len(t, status.Targets, 1, "status of target is expected") {
+		assert.Equal(t, ts.URL, status.Targets[0].URL, "wrong URL of target")
+		assert.Equal(t, CircuitOpen, status.Targets[0].State, "circuit is expected to be open")
+	}
+}
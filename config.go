@@ -6,39 +6,75 @@ import (
 	"html/template"
 	"log"
 	"strings"
+	"time"
 )
 
 const (
-	defaultServicePort  = 55555
-	defaultServiceAddr  = "127.0.0.1"
-	defaultPageSize     = 20
-	initBasketCapacity  = 200
-	maxBasketCapacity   = 2000
-	defaultDatabaseType = DbTypeMemory
-	serviceOldAPIPath   = "baskets"
-	serviceAPIPath      = "api"
-	serviceUIPath       = "web"
-	serviceName         = "request-baskets"
-	basketNamePattern   = `^[\w\d\-_\.]{1,250}$`
-	sourceCodeURL       = "https://github.com/darklynx/request-baskets"
+	defaultServicePort    = 55555
+	defaultServiceAddr    = "127.0.0.1"
+	defaultPageSize       = 20
+	initBasketCapacity    = 200
+	maxBasketCapacity     = 2000
+	maxForwardRetries     = 10
+	defaultRetryBackoff   = 1000
+	maxRetryBackoff       = 60000
+	maxRetryDelay         = 5 * time.Minute
+	forwardResultBodySize = 2048
+	defaultFwdWorkers     = 10
+	defaultFwdConns       = 4
+	defaultFwdQueueSize   = 1000
+	defaultFwdTimeout     = 30 * time.Second
+	defaultFwdConnTimeout = 10 * time.Second
+	defaultFwdTLSTimeout  = 10 * time.Second
+	maxFwdTimeout         = 600000
+	defaultMaxHops        = 10
+	shutdownTimeout       = 30 * time.Second
+	retentionInterval     = time.Minute
+	defaultDatabaseType   = DbTypeMemory
+	serviceOldAPIPath     = "baskets"
+	serviceAPIPath        = "api"
+	serviceUIPath         = "web"
+	serviceName           = "request-baskets"
+	basketNamePattern     = `^[\w\d\-_\.]{1,250}$`
+	httpTokenPattern      = "^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$"
+	sourceCodeURL         = "https://github.com/darklynx/request-baskets"
 )
 
 // ServerConfig describes server configuration.
 type ServerConfig struct {
-	ServerPort   int
-	ServerAddr   string
-	InitCapacity int
-	MaxCapacity  int
-	PageSize     int
-	MasterToken  string
-	DbType       string
-	DbFile       string
-	DbConnection string
-	Baskets      []string
-	PathPrefix   string
-	Mode         string
-	Theme        string
-	ThemeCSS     template.HTML
+	ServerPort        int
+	ServerAddr        string
+	InitCapacity      int
+	MaxCapacity       int
+	PageSize          int
+	MasterToken       string
+	DbType            string
+	DbFile            string
+	DbConnection      string
+	MigrateOnly       bool
+	MigrateDryRun     bool
+	RebuildStats      bool
+	Baskets           []string
+	FwdWorkers        int
+	FwdConns          int
+	FwdQueueSize      int
+	FwdOverflow       string
+	FwdTimeout        time.Duration
+	FwdConnectTimeout time.Duration
+	FwdTLSTimeout     time.Duration
+	FwdRedirects      bool
+	FwdHTTP2          bool
+	FwdProxy          string
+	MaxHops           int
+	ServiceID         string
+	MaxAge            time.Duration
+	BasketTTL         time.Duration
+	BasketLifetime    time.Duration
+	TLSProfiles       map[string]*TLSConfig
+	PathPrefix        string
+	Mode              string
+	Theme             string
+	ThemeCSS          template.HTML
 }
 
 type arrayFlags []string
@@ -61,10 +97,13 @@ func CreateConfig() *ServerConfig {
 	var pageSize = flag.Int("page", defaultPageSize, "Default page size")
 	var masterToken = flag.String("token", "", "Master token, random token is generated if not provided")
 	var dbType = flag.String("db", defaultDatabaseType, fmt.Sprintf(
-		"Baskets storage type: \"%s\" - in-memory, \"%s\" - Bolt DB, \"%s\" - SQL database",
-		DbTypeMemory, DbTypeBolt, DbTypeSQL))
+		"Baskets storage type: \"%s\" - in-memory, \"%s\" - Bolt DB, \"%s\" - SQL database, \"%s\" - Redis",
+		DbTypeMemory, DbTypeBolt, DbTypeSQL, DbTypeRedis))
 	var dbFile = flag.String("file", "./baskets.db", "Database location, only applicable for file or SQL databases")
-	var dbConnection = flag.String("conn", "", "Database connection string for SQL databases, if undefined \"file\" argument is considered")
+	var dbConnection = flag.String("conn", "", "Database connection string for SQL or Redis databases, if undefined \"file\" argument is considered")
+	var migrateOnly = flag.Bool("migrate-only", false, "Apply pending migrations of SQL database schema and exit without starting the service")
+	var migrateDryRun = flag.Bool("migrate-dry-run", false, "List pending migrations of SQL database schema and exit without applying them")
+	var rebuildStats = flag.Bool("rebuild-stats", false, "Rebuild statistics of Bolt database and exit without starting the service")
 	var prefix = flag.String("prefix", "", "Service URL path prefix")
 	var mode = flag.String("mode", ModePublic, fmt.Sprintf(
 		"Service mode: \"%s\" - any visitor can create a new basket, \"%s\" - baskets creation requires master token",
@@ -72,6 +111,24 @@ func CreateConfig() *ServerConfig {
 	var theme = flag.String("theme", ThemeStandard, fmt.Sprintf(
 		"CSS theme for web UI, supported values: %s, %s, %s",
 		ThemeStandard, ThemeAdaptive, ThemeFlatly))
+	var fwdWorkers = flag.Int("fwdworkers", defaultFwdWorkers, "Number of workers forwarding requests to configured targets")
+	var fwdConns = flag.Int("fwdconns", defaultFwdConns, "Maximum number of concurrent forwards to a single target host")
+	var fwdQueueSize = flag.Int("fwdqueue", defaultFwdQueueSize, "Maximum number of requests waiting in queue to be forwarded")
+	var fwdOverflow = flag.String("fwdoverflow", OverflowDrop, fmt.Sprintf(
+		"Policy if forwarding queue is full: \"%s\" - keep new request as undelivered, \"%s\" - keep the oldest queued request as undelivered, \"%s\" - wait for free space in queue",
+		OverflowDrop, OverflowDropOldest, OverflowBlock))
+	var fwdTimeout = flag.Duration("fwdtimeout", defaultFwdTimeout, "Default total timeout of forwarded request, including reading of response body")
+	var fwdConnectTimeout = flag.Duration("fwdconnecttimeout", defaultFwdConnTimeout, "Default timeout to connect to forward target")
+	var fwdTLSTimeout = flag.Duration("fwdtlstimeout", defaultFwdTLSTimeout, "Default timeout of TLS handshake with forward target")
+	var fwdRedirects = flag.Bool("fwdredirects", true, "Follow redirects of forward targets by default")
+	var fwdHTTP2 = flag.Bool("fwdhttp2", true, "Prefer HTTP/2 to communicate with forward targets by default")
+	var fwdProxy = flag.String("fwdproxy", "", "URL of HTTP or SOCKS5 proxy to forward requests through by default, proxy from environment is used if undefined")
+	var maxHops = flag.Int("maxhops", defaultMaxHops, "Maximum number of times a request may be forwarded by baskets, requests that exceed it are not forwarded (0 - unlimited)")
+	var serviceID = flag.String("serviceid", defaultServiceID(), "Identifier of service in Via header of forwarded requests, instances sharing a database should use the same value")
+	var maxAge = flag.Duration("maxage", 0, "Default maximum age of collected requests, older requests are deleted (0 - only basket capacity applies)")
+	var basketTTL = flag.Duration("ttl", 0, "Period of inactivity after which basket is deleted, counted from the last collected request or basket creation (0 - baskets are kept)")
+	var basketLifetime = flag.Duration("lifetime", 0, "Maximum lifetime of basket since creation, older baskets are deleted (0 - unlimited)")
+	var tlsProfiles = flag.String("tlsprofiles", "", "Location of JSON file with named TLS profiles to communicate with forward targets")
 
 	var baskets arrayFlags
 	flag.Var(&baskets, "basket", "Name of a basket to auto-create during service startup (can be specified multiple times)")
@@ -83,21 +140,56 @@ func CreateConfig() *ServerConfig {
 		log.Printf("[info] generated master token: %s", token)
 	}
 
+	proxy := *fwdProxy
+	if len(proxy) > 0 && proxy != ProxyDirect {
+		if _, err := parseProxyURL(proxy); err != nil {
+			log.Fatalf("[error] invalid default proxy to forward requests: %s", err)
+		}
+	}
+
+	if !validHTTPToken.MatchString(*serviceID) {
+		log.Fatalf("[error] invalid service ID: %s, the ID must be a valid HTTP token", *serviceID)
+	}
+
+	profiles, err := loadTLSProfiles(*tlsProfiles)
+	if err != nil {
+		log.Fatalf("[error] failed to load TLS profiles: %s", err)
+	}
+
 	return &ServerConfig{
-		ServerPort:   *port,
-		ServerAddr:   *address,
-		InitCapacity: *initCapacity,
-		MaxCapacity:  *maxCapacity,
-		PageSize:     *pageSize,
-		MasterToken:  token,
-		DbType:       *dbType,
-		DbFile:       *dbFile,
-		DbConnection: *dbConnection,
-		Baskets:      baskets,
-		PathPrefix:   normalizePrefix(*prefix),
-		Mode:         *mode,
-		Theme:        *theme,
-		ThemeCSS:     toThemeCSS(*theme)}
+		ServerPort:        *port,
+		ServerAddr:        *address,
+		InitCapacity:      *initCapacity,
+		MaxCapacity:       *maxCapacity,
+		PageSize:          *pageSize,
+		MasterToken:       token,
+		DbType:            *dbType,
+		DbFile:            *dbFile,
+		DbConnection:      *dbConnection,
+		MigrateOnly:       *migrateOnly,
+		MigrateDryRun:     *migrateDryRun,
+		RebuildStats:      *rebuildStats,
+		Baskets:           baskets,
+		FwdWorkers:        *fwdWorkers,
+		FwdConns:          *fwdConns,
+		FwdQueueSize:      *fwdQueueSize,
+		FwdOverflow:       *fwdOverflow,
+		FwdTimeout:        *fwdTimeout,
+		FwdConnectTimeout: *fwdConnectTimeout,
+		FwdTLSTimeout:     *fwdTLSTimeout,
+		FwdRedirects:      *fwdRedirects,
+		FwdHTTP2:          *fwdHTTP2,
+		FwdProxy:          proxy,
+		MaxHops:           *maxHops,
+		ServiceID:         *serviceID,
+		MaxAge:            *maxAge,
+		BasketTTL:         *basketTTL,
+		BasketLifetime:    *basketLifetime,
+		TLSProfiles:       profiles,
+		PathPrefix:        normalizePrefix(*prefix),
+		Mode:              *mode,
+		Theme:             *theme,
+		ThemeCSS:          toThemeCSS(*theme)}
 }
 
 func normalizePrefix(prefix string) string {
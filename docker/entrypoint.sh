@@ -54,6 +54,82 @@ if [ -n "$THEME" ]; then
     args="$args -theme $THEME"
 fi
 
+if [ -n "$FWDWORKERS" ]; then
+    args="$args -fwdworkers $FWDWORKERS"
+fi
+
+if [ -n "$FWDCONNS" ]; then
+    args="$args -fwdconns $FWDCONNS"
+fi
+
+if [ -n "$FWDQUEUE" ]; then
+    args="$args -fwdqueue $FWDQUEUE"
+fi
+
+if [ -n "$FWDOVERFLOW" ]; then
+    args="$args -fwdoverflow $FWDOVERFLOW"
+fi
+
+if [ -n "$FWDTIMEOUT" ]; then
+    args="$args -fwdtimeout $FWDTIMEOUT"
+fi
+
+if [ -n "$FWDCONNECTTIMEOUT" ]; then
+    args="$args -fwdconnecttimeout $FWDCONNECTTIMEOUT"
+fi
+
+if [ -n "$FWDTLSTIMEOUT" ]; then
+    args="$args -fwdtlstimeout $FWDTLSTIMEOUT"
+fi
+
+if [ -n "$FWDREDIRECTS" ]; then
+    args="$args -fwdredirects=$FWDREDIRECTS"
+fi
+
+if [ -n "$FWDHTTP2" ]; then
+    args="$args -fwdhttp2=$FWDHTTP2"
+fi
+
+if [ -n "$FWDPROXY" ]; then
+    args="$args -fwdproxy $FWDPROXY"
+fi
+
+if [ -n "$MAXHOPS" ]; then
+    args="$args -maxhops $MAXHOPS"
+fi
+
+if [ -n "$SERVICEID" ]; then
+    args="$args -serviceid $SERVICEID"
+fi
+
+if [ -n "$MAXAGE" ]; then
+    args="$args -maxage $MAXAGE"
+fi
+
+if [ -n "$TTL" ]; then
+    args="$args -ttl $TTL"
+fi
+
+if [ -n "$LIFETIME" ]; then
+    args="$args -lifetime $LIFETIME"
+fi
+
+if [ -n "$TLSPROFILES" ]; then
+    args="$args -tlsprofiles $TLSPROFILES"
+fi
+
+if [ -n "$MIGRATEONLY" ]; then
+    args="$args -migrate-only=$MIGRATEONLY"
+fi
+
+if [ -n "$MIGRATEDRYRUN" ]; then
+    args="$args -migrate-dry-run=$MIGRATEDRYRUN"
+fi
+
+if [ -n "$REBUILDSTATS" ]; then
+    args="$args -rebuild-stats=$REBUILDSTATS"
+fi
+
 cmd="/bin/rbaskets $args"
 echo "Executing: $cmd"
 exec $cmd
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errForwardInterrupted = errors.New("forwarding is interrupted")
+
+// bodyRecorder keeps the beginning of response body to record it with the result of forwarding
+type bodyRecorder struct {
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (recorder *bodyRecorder) Write(p []byte) (int, error) {
+	if free := recorder.limit - recorder.body.Len(); free < len(p) {
+		recorder.body.Write(p[:free])
+		recorder.truncated = true
+	} else {
+		recorder.body.Write(p)
+	}
+	return len(p), nil
+}
+
+// record saves recorded response body with the result of forwarding
+func (recorder *bodyRecorder) record(result *ForwardResult) {
+	result.Body = recorder.body.String()
+	result.Truncated = recorder.truncated
+}
+
+// forwardRequest forwards request to the target defined by basket config and returns the result of forwarding along
+// with the response of target; returns an error if request cannot be forwarded because of invalid configuration,
+// the response is nil if request is not delivered because of transport error
+func forwardRequest(request *RequestData, config BasketConfig, name string) (*ForwardResult, *http.Response, error) {
+	result := &ForwardResult{Date: time.Now().UnixNano() / toMs, URL: config.ForwardURL}
+
+	// payload is transformed once and kept to see what was actually sent to the target
+	payload, err := request.Transform(config)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil, err
+	}
+	result.Payload = payload
+
+	forwardReq, err := request.newForwardRequest(config, name, payload)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil, err
+	}
+	result.URL = forwardReq.URL.String()
+
+	client, err := getHTTPClient(config)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil, err
+	}
+
+	report, err := guardForward(config, name)
+	if err != nil {
+		result.Error = err.Error()
+		result.Rejected = true
+		return result, nil, nil
+	}
+
+	start := time.Now()
+	response, err := client.Do(forwardReq)
+	result.Duration = int64(time.Since(start) / time.Millisecond)
+	report(err == nil && response.StatusCode < 500)
+	if err != nil {
+		log.Printf("[warn] failed to forward request for basket: %s - %s", name, err)
+		result.Error = err.Error()
+		return result, nil, nil
+	}
+
+	result.Status = response.StatusCode
+	return result, response, nil
+}
+
+// forwardWithRetries forwards request until it is delivered or the number of retries is exhausted,
+// returns the result and the error of last attempt; waiting for the next attempt is interrupted
+// when stop channel is closed
+func forwardWithRetries(request *RequestData, config BasketConfig, name string, stop <-chan struct{}) (*ForwardResult, error) {
+	attempt := 0
+	for {
+		attempt++
+		result, retry, err := forwardOnce(request, config, name)
+		result.Attempts = attempt
+		if err == nil || !retry || attempt > config.RetryCount {
+			return result, err
+		}
+
+		select {
+		case <-time.After(retryDelay(config.RetryBackoff, attempt)):
+		case <-stop:
+			return result, errForwardInterrupted
+		}
+	}
+}
+
+// forwardOnce makes single attempt to forward request, returns an error if request is not delivered and indicates
+// whether another attempt may succeed; HTTP 5xx responses of forward target are treated as failed delivery
+func forwardOnce(request *RequestData, config BasketConfig, name string) (*ForwardResult, bool, error) {
+	result, response, err := forwardRequest(request, config, name)
+	if err != nil {
+		// invalid configuration, there is no reason to retry
+		return result, false, err
+	} else if response == nil {
+		return result, true, fmt.Errorf("failed to forward request: %s - %s", result.Error, config.ForwardURL)
+	}
+	defer response.Body.Close()
+
+	recorder := &bodyRecorder{limit: forwardResultBodySize}
+	io.Copy(recorder, response.Body)
+	recorder.record(result)
+
+	if response.StatusCode >= 500 {
+		// keep the beginning of response body to explain the failure
+		body := result.Body
+		if len(body) > 256 {
+			body = body[:256]
+		}
+		return result, true, fmt.Errorf("HTTP %d %s - %s", response.StatusCode, strings.TrimSpace(body),
+			config.ForwardURL)
+	}
+
+	return result, false, nil
+}
+
+// recordForwardResult keeps the result of forwarding with collected request
+func recordForwardResult(request *RequestData, result *ForwardResult, name string) {
+	if result == nil || len(request.ID) == 0 {
+		return
+	}
+
+	// forwarding outlives the HTTP request that triggered it
+	ctx := context.Background()
+	basket, err := basketsDb.Get(ctx, name)
+	if err == nil && basket != nil {
+		err = basket.AddForwardResult(ctx, request.ID, result)
+	}
+	if err != nil {
+		log.Printf("[error] failed to record result of forwarding for basket: %s - %s", name, err)
+	}
+}
+
+// retryDelay calculates exponential delay before next attempt to forward request
+func retryDelay(backoff int, attempt int) time.Duration {
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	delay := time.Duration(backoff) * time.Millisecond
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// targetOf returns forward target described by basket config
+func targetOf(config BasketConfig) ForwardTarget {
+	return ForwardTarget{URL: config.ForwardURL, InsecureTLS: config.InsecureTLS, ExpandPath: config.ExpandPath}
+}
+
+// saveDeadLetter keeps undelivered request with transformed payload that was sent to the target
+func saveDeadLetter(request *RequestData, payload *ForwardPayload, config BasketConfig, name string, attempts int, err error) {
+	ctx := context.Background()
+	basket, berr := basketsDb.Get(ctx, name)
+	if berr != nil {
+		log.Printf("[error] failed to save undelivered request for basket: %s - %s", name, berr)
+		return
+	}
+	if basket == nil {
+		// basket is deleted while request was forwarded
+		return
+	}
+
+	id, gerr := GenerateID()
+	if gerr != nil {
+		log.Printf("[error] failed to generate ID of undelivered request for basket: %s - %s", name, gerr)
+		return
+	}
+
+	letter := &DeadLetter{
+		ID:       id,
+		Date:     time.Now().UnixNano() / toMs,
+		Target:   targetOf(config),
+		Attempts: attempts,
+		Error:    err.Error(),
+		Request:  request,
+		Payload:  payload}
+	if aerr := basket.AddDeadLetter(ctx, letter); aerr != nil {
+		log.Printf("[error] failed to save undelivered request for basket: %s - %s", name, aerr)
+	}
+}
+
+// redeliver makes single attempt to deliver undelivered request to its original target
+func redeliver(letter *DeadLetter, config BasketConfig, name string) error {
+	result, _, err := forwardOnce(letter.Request, config.ForTarget(letter.Target), name)
+	recordForwardResult(letter.Request, result, name)
+	return err
+}
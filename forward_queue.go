@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Overflow policies of forwarding queue
+const (
+	OverflowDrop       = "drop"
+	OverflowDropOldest = "drop-oldest"
+	OverflowBlock      = "block"
+)
+
+var errQueueFull = errors.New("forwarding queue is full")
+
+// forwardTask describes request queued to be forwarded to a target
+type forwardTask struct {
+	name    string
+	host    string
+	config  BasketConfig
+	forward *PendingForward
+	store   ForwardsStore
+}
+
+// forwardQueue is a bounded queue of requests to forward that is processed by a pool of workers,
+// the number of concurrent forwards to a single target host is limited
+type forwardQueue struct {
+	sync.Mutex
+	cond     *sync.Cond
+	tasks    []*forwardTask
+	active   map[string]int
+	size     int
+	conns    int
+	overflow string
+	closed   bool
+	stop     chan struct{}
+	workers  sync.WaitGroup
+}
+
+// newForwardQueue creates forwarding queue and starts its workers
+func newForwardQueue(workers int, conns int, size int, overflow string) *forwardQueue {
+	if workers < 1 {
+		log.Printf("[warn] invalid number of forwarding workers: %d, using default: %d", workers, defaultFwdWorkers)
+		workers = defaultFwdWorkers
+	}
+	if conns < 1 {
+		log.Printf("[warn] invalid number of concurrent forwards per target: %d, using default: %d", conns, defaultFwdConns)
+		conns = defaultFwdConns
+	}
+	if size < 1 {
+		log.Printf("[warn] invalid size of forwarding queue: %d, using default: %d", size, defaultFwdQueueSize)
+		size = defaultFwdQueueSize
+	}
+	if overflow != OverflowDrop && overflow != OverflowDropOldest && overflow != OverflowBlock {
+		log.Printf("[warn] unknown overflow policy of forwarding queue: %s, using: %s", overflow, OverflowDrop)
+		overflow = OverflowDrop
+	}
+
+	queue := &forwardQueue{
+		tasks:    make([]*forwardTask, 0),
+		active:   make(map[string]int),
+		size:     size,
+		conns:    conns,
+		overflow: overflow,
+		stop:     make(chan struct{})}
+	queue.cond = sync.NewCond(queue)
+
+	queue.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go queue.run()
+	}
+
+	log.Printf("[info] forwarding queue is started: %d workers, %d connections per target, size: %d, overflow policy: %s",
+		workers, conns, size, overflow)
+	return queue
+}
+
+// Enqueue adds request to the queue to forward it to the target defined by basket config, returns false if request
+// is not queued; requests that are not queued because of overflow are kept as undelivered requests of the basket
+func (queue *forwardQueue) Enqueue(name string, basket Basket, config BasketConfig, request *RequestData) bool {
+	id, err := GenerateID()
+	if err != nil {
+		log.Printf("[error] failed to generate ID of forwarded request for basket: %s - %s", name, err)
+		return false
+	}
+
+	task := &forwardTask{
+		name:    name,
+		host:    targetHost(config.ForwardURL),
+		config:  config,
+		forward: &PendingForward{ID: id, Date: time.Now().UnixNano() / toMs, Target: targetOf(config), Request: request}}
+
+	// persist the forward first, so it is not lost even if service is stopped before the forward is delivered
+	if store, ok := basket.(ForwardsStore); ok {
+		task.store = store
+		if err = store.SaveForward(context.Background(), task.forward); err != nil {
+			log.Printf("[error] failed to persist forwarded request for basket: %s - %s", name, err)
+		}
+	}
+
+	queue.Lock()
+	for !queue.closed && queue.overflow == OverflowBlock && len(queue.tasks) >= queue.size {
+		queue.cond.Wait()
+	}
+
+	if queue.closed {
+		queue.Unlock()
+		log.Printf("[warn] forwarding queue is closed, request for basket: %s is not forwarded to %s", name, config.ForwardURL)
+		return false
+	}
+
+	dropped := (*forwardTask)(nil)
+	if len(queue.tasks) >= queue.size {
+		if queue.overflow == OverflowDropOldest {
+			dropped = queue.tasks[0]
+			queue.tasks = append(queue.tasks[1:], task)
+		} else {
+			dropped = task
+		}
+	} else {
+		queue.tasks = append(queue.tasks, task)
+	}
+	queue.cond.Broadcast()
+	queue.Unlock()
+
+	if dropped != nil && queue.claim(dropped) {
+		log.Printf("[warn] %s, request for basket: %s is not forwarded to %s", errQueueFull, dropped.name,
+			dropped.config.ForwardURL)
+		result := &ForwardResult{Date: time.Now().UnixNano() / toMs, URL: dropped.config.ForwardURL,
+			Error: errQueueFull.Error()}
+		// keep payload that would be sent to the target
+		if result.Payload, err = dropped.forward.Request.Transform(dropped.config); err != nil {
+			log.Printf("[warn] failed to transform request for basket: %s - %s", dropped.name, err)
+		}
+		queue.complete(dropped, result, errQueueFull)
+	}
+
+	return dropped != task
+}
+
+// Resume adds forwards that are persisted by baskets of the database to the queue, the size of queue is not limited
+// for these forwards, returns the number of resumed forwards; resuming stops once the queue is shut down.
+// Resumed forwards are claimed before delivery, so the forwards that are also resumed by another service instance
+// sharing the database, or are queued meanwhile, are delivered once
+func (queue *forwardQueue) Resume(db BasketsDatabase) int {
+	ctx := context.Background()
+	count := 0
+	pageSize := 100
+
+	for skip := 0; !queue.isClosed(); skip += pageSize {
+		page, err := db.GetNames(ctx, pageSize, skip)
+		if err != nil {
+			log.Printf("[error] failed to resume forwarded requests - %s", err)
+			break
+		}
+		for _, name := range page.Names {
+			basket, err := db.Get(ctx, name)
+			if err != nil {
+				log.Printf("[error] failed to resume forwarded requests for basket: %s - %s", name, err)
+				continue
+			}
+			if store, ok := basket.(ForwardsStore); ok {
+				config, err := basket.Config(ctx)
+				var forwards []*PendingForward
+				if err == nil {
+					forwards, err = store.GetPendingForwards(ctx)
+				}
+				if err != nil {
+					log.Printf("[error] failed to resume forwarded requests for basket: %s - %s", name, err)
+					continue
+				}
+				for _, forward := range forwards {
+					queue.Lock()
+					if queue.closed {
+						// the forward remains persisted to be resumed on service restart
+						queue.Unlock()
+						return count
+					}
+					queue.tasks = append(queue.tasks, &forwardTask{
+						name:    name,
+						host:    targetHost(forward.Target.URL),
+						config:  config.ForTarget(forward.Target),
+						forward: forward,
+						store:   store})
+					queue.cond.Broadcast()
+					queue.Unlock()
+					count++
+				}
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+	}
+
+	return count
+}
+
+// Shutdown stops accepting new requests and waits until queued requests are forwarded, after the timeout forwarding
+// is interrupted and the forwards that are not delivered remain persisted to be resumed on service restart
+func (queue *forwardQueue) Shutdown(timeout time.Duration) {
+	queue.Lock()
+	queue.closed = true
+	queue.cond.Broadcast()
+	queue.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		queue.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	queue.Lock()
+	close(queue.stop)
+	lost := 0
+	for _, task := range queue.tasks {
+		if task.store == nil {
+			lost++
+		}
+	}
+	log.Printf("[warn] forwarding is interrupted, %d queued request(s) are not forwarded, %d of them are lost",
+		len(queue.tasks), lost)
+	queue.tasks = queue.tasks[:0]
+	queue.cond.Broadcast()
+	queue.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("[warn] forwarding workers are still busy, terminating anyway")
+	}
+}
+
+// Len returns the number of requests waiting in queue
+func (queue *forwardQueue) Len() int {
+	queue.Lock()
+	defer queue.Unlock()
+
+	return len(queue.tasks)
+}
+
+func (queue *forwardQueue) run() {
+	defer queue.workers.Done()
+
+	for task := queue.next(); task != nil; task = queue.next() {
+		if queue.claim(task) {
+			result, err := forwardWithRetries(task.forward.Request, task.config, task.name, queue.stop)
+			if err != errForwardInterrupted {
+				queue.complete(task, result, err)
+			} else {
+				queue.interrupt(task)
+			}
+		}
+		queue.release(task)
+	}
+}
+
+// isClosed checks if the queue is shut down
+func (queue *forwardQueue) isClosed() bool {
+	queue.Lock()
+	defer queue.Unlock()
+
+	return queue.closed
+}
+
+// claim takes persisted forward from the database before it is delivered, returns false if the forward is already
+// claimed by another queue; forward that cannot be claimed because of database error is still delivered
+func (queue *forwardQueue) claim(task *forwardTask) bool {
+	if task.store == nil {
+		return true
+	}
+
+	claimed, err := task.store.ClaimForward(context.Background(), task.forward.ID)
+	if err != nil {
+		log.Printf("[error] failed to claim forwarded request for basket: %s - %s", task.name, err)
+		return true
+	}
+	if !claimed {
+		log.Printf("[info] forwarded request for basket: %s is already claimed, skipping it", task.name)
+	}
+	return claimed
+}
+
+// interrupt persists the claimed forward again, so it is resumed after service restart
+func (queue *forwardQueue) interrupt(task *forwardTask) {
+	if task.store == nil {
+		log.Printf("[warn] forwarding of request for basket: %s is interrupted, request is lost", task.name)
+	} else if err := task.store.SaveForward(context.Background(), task.forward); err != nil {
+		log.Printf("[error] forwarding of request for basket: %s is interrupted, failed to persist request - %s",
+			task.name, err)
+	}
+}
+
+// next waits for a queued request which target host has free connections, returns nil if worker should stop
+func (queue *forwardQueue) next() *forwardTask {
+	queue.Lock()
+	defer queue.Unlock()
+
+	for {
+		for i, task := range queue.tasks {
+			if queue.active[task.host] < queue.conns {
+				queue.tasks = append(queue.tasks[:i], queue.tasks[i+1:]...)
+				queue.active[task.host]++
+				// let blocked producers know about free space in queue
+				queue.cond.Broadcast()
+				return task
+			}
+		}
+
+		if queue.closed && len(queue.tasks) == 0 {
+			return nil
+		}
+		queue.cond.Wait()
+	}
+}
+
+// release frees connection to the target host of forwarded request
+func (queue *forwardQueue) release(task *forwardTask) {
+	queue.Lock()
+	defer queue.Unlock()
+
+	if queue.active[task.host]--; queue.active[task.host] <= 0 {
+		delete(queue.active, task.host)
+	}
+	queue.cond.Broadcast()
+}
+
+// complete finishes processing of forwarded request, the result of forwarding is kept with collected request and
+// undelivered request is kept as undelivered request of the basket
+func (queue *forwardQueue) complete(task *forwardTask, result *ForwardResult, err error) {
+	recordForwardResult(task.forward.Request, result, task.name)
+	if err != nil {
+		if err != errQueueFull {
+			log.Printf("[warn] failed to deliver request for basket: %s after %d attempt(s) - %s", task.name,
+				result.Attempts, err)
+		}
+		saveDeadLetter(task.forward.Request, result.Payload, task.config, task.name, result.Attempts, err)
+	}
+}
+
+// targetHost returns the host of forward URL, it is used to limit the number of concurrent forwards to a target
+func targetHost(forwardURL string) string {
+	if u, err := url.Parse(forwardURL); err == nil && len(u.Host) > 0 {
+		return u.Host
+	}
+	return forwardURL
+}
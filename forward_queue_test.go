@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForQueue waits until queued requests are picked up by workers
+func waitForQueue(queue *forwardQueue, size int) {
+	for i := 0; i < 100 && queue.Len() > size; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTargetHost(t *testing.T) {
+	assert.Equal(t, "localhost:8080", targetHost("http://localhost:8080/hooks/github"), "wrong target host")
+	assert.Equal(t, "example.com", targetHost("https://example.com"), "wrong target host")
+	assert.Equal(t, "qwert", targetHost("qwert"), "invalid URL is expected as is")
+}
+
+func TestNewForwardQueue_InvalidSettings(t *testing.T) {
+	queue := newForwardQueue(0, -1, 0, "xyz")
+	defer queue.Shutdown(time.Second)
+
+	assert.Equal(t, defaultFwdConns, queue.conns, "default number of connections per target is expected")
+	assert.Equal(t, defaultFwdQueueSize, queue.size, "default queue size is expected")
+	assert.Equal(t, OverflowDrop, queue.overflow, "default overflow policy is expected")
+}
+
+func TestForwardQueue_Enqueue(t *testing.T) {
+	name := "queue01"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := newForwardQueue(2, 2, 10, OverflowDrop)
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	for i := 0; i < 5; i++ {
+		assert.True(t, queue.Enqueue(name, testDB(t, basketsDb).Get(name).Basket, config, createTestRequestData("/"+name, "abc")),
+			"request is expected to be queued")
+	}
+
+	// shutdown waits for queued requests
+	queue.Shutdown(5 * time.Second)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls), "wrong number of forwarded requests")
+	assert.Equal(t, 0, testDB(t, basketsDb).Get(name).GetDeadLetters(10, 0).Count, "no dead letters are expected")
+
+	// queue is closed
+	assert.False(t, queue.Enqueue(name, testDB(t, basketsDb).Get(name).Basket, config, createTestRequestData("/"+name, "abc")),
+		"request is not expected to be queued after shutdown")
+}
+
+func TestForwardQueue_DeadLetter(t *testing.T) {
+	name := "queue02"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	queue := newForwardQueue(2, 2, 10, OverflowDrop)
+	// assuming that nothing is running at port 55556
+	config := BasketConfig{ForwardURL: "http://localhost:55556/notify", ExpandPath: true, Capacity: 20, RetryCount: 1, RetryBackoff: 1}
+	queue.Enqueue(name, testDB(t, basketsDb).Get(name).Basket, config, createTestRequestData("/"+name+"/events", "xyz"))
+	queue.Shutdown(5 * time.Second)
+
+	page := testDB(t, basketsDb).Get(name).GetDeadLetters(10, 0)
+	assert.Equal(t, 1, page.Count, "wrong number of dead letters")
+	if assert.Equal(t, 1, len(page.DeadLetters), "wrong number of dead letters") {
+		letter := page.DeadLetters[0]
+		assert.NotEmpty(t, letter.ID, "dead letter ID is expected")
+		assert.Equal(t, 2, letter.Attempts, "wrong number of attempts")
+		assert.Equal(t, "http://localhost:55556/notify", letter.Target.URL, "wrong target URL")
+		assert.True(t, letter.Target.ExpandPath, "wrong value of Expand Path flag")
+		assert.Contains(t, letter.Error, "connection refused", "error is not detailed enough")
+		assert.Equal(t, "xyz", letter.Request.Body, "wrong request body")
+	}
+}
+
+func TestForwardQueue_TargetConcurrency(t *testing.T) {
+	name := "queue03"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	var mutex sync.Mutex
+	current, max, calls := 0, 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		current++
+		calls++
+		if current > max {
+			max = current
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		current--
+		mutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := newForwardQueue(5, 2, 10, OverflowDrop)
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	for i := 0; i < 8; i++ {
+		queue.Enqueue(name, testDB(t, basketsDb).Get(name).Basket, config, createTestRequestData("/"+name, "abc"))
+	}
+	queue.Shutdown(5 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 8, calls, "wrong number of forwarded requests")
+	assert.Equal(t, 2, max, "wrong number of concurrent forwards to a single target")
+}
+
+func TestForwardQueue_OverflowDrop(t *testing.T) {
+	name := "queue04"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := newForwardQueue(1, 1, 1, OverflowDrop)
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	basket := testDB(t, basketsDb).Get(name)
+
+	assert.True(t, queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req1")), "request is expected to be queued")
+	waitForQueue(queue, 0)
+	assert.True(t, queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req2")), "request is expected to be queued")
+	assert.False(t, queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req3")), "request is not expected to be queued")
+
+	close(release)
+	queue.Shutdown(5 * time.Second)
+
+	page := basket.GetDeadLetters(10, 0)
+	if assert.Equal(t, 1, len(page.DeadLetters), "wrong number of dead letters") {
+		assert.Equal(t, "req3", page.DeadLetters[0].Request.Body, "new request is expected to be dropped")
+		assert.Equal(t, errQueueFull.Error(), page.DeadLetters[0].Error, "wrong reason of failed delivery")
+		assert.Equal(t, 0, page.DeadLetters[0].Attempts, "wrong number of attempts")
+	}
+}
+
+func TestForwardQueue_OverflowDropOldest(t *testing.T) {
+	name := "queue05"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := newForwardQueue(1, 1, 1, OverflowDropOldest)
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	basket := testDB(t, basketsDb).Get(name)
+
+	queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req1"))
+	waitForQueue(queue, 0)
+	queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req2"))
+	assert.True(t, queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req3")), "request is expected to be queued")
+
+	close(release)
+	queue.Shutdown(5 * time.Second)
+
+	page := basket.GetDeadLetters(10, 0)
+	if assert.Equal(t, 1, len(page.DeadLetters), "wrong number of dead letters") {
+		assert.Equal(t, "req2", page.DeadLetters[0].Request.Body, "oldest queued request is expected to be dropped")
+	}
+}
+
+func TestForwardQueue_OverflowBlock(t *testing.T) {
+	name := "queue06"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	var calls int32
+	release := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	queue := newForwardQueue(1, 1, 1, OverflowBlock)
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	basket := testDB(t, basketsDb).Get(name)
+
+	queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req1"))
+	waitForQueue(queue, 0)
+	queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req2"))
+
+	queued := make(chan bool)
+	go func() {
+		queued <- queue.Enqueue(name, basket.Basket, config, createTestRequestData("/"+name, "req3"))
+	}()
+
+	select {
+	case <-queued:
+		assert.Fail(t, "request should wait for free space in queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case result := <-queued:
+		assert.True(t, result, "request is expected to be queued")
+	case <-time.After(time.Second):
+		assert.Fail(t, "request should be queued when space is available")
+	}
+
+	queue.Shutdown(5 * time.Second)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "wrong number of forwarded requests")
+	assert.Equal(t, 0, basket.GetDeadLetters(10, 0).Count, "no dead letters are expected")
+}
+
+func TestForwardQueue_Resume(t *testing.T) {
+	name := "queue07"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	var healthy int32
+	var forwardedData *RequestData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			forwardedData = ToRequestData(r)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	db.Create(name, BasketConfig{ForwardURL: ts.URL + "/service", ExpandPath: true, Capacity: 20, RetryCount: 3, RetryBackoff: 10000})
+	basket := db.Get(name)
+	store := basket.Basket.(ForwardsStore)
+
+	// forwarding is interrupted while waiting for retry
+	queue := newForwardQueue(1, 1, 10, OverflowDrop)
+	queue.Enqueue(name, basket.Basket, basket.Config(), createTestRequestData("/"+name+"/events", "xyz"))
+	queue.Shutdown(100 * time.Millisecond)
+
+	assert.Equal(t, 1, len(pendingForwards(t, store)), "interrupted forward is expected to be persisted")
+	assert.Equal(t, 0, basket.GetDeadLetters(10, 0).Count, "no dead letters are expected")
+
+	// forwarding is resumed by a new queue
+	atomic.StoreInt32(&healthy, 1)
+	queue = newForwardQueue(1, 1, 10, OverflowDrop)
+	assert.Equal(t, 1, queue.Resume(db.BasketsDatabase), "wrong number of resumed forwards")
+	queue.Shutdown(5 * time.Second)
+
+	if assert.NotNil(t, forwardedData, "request is not delivered") {
+		assert.Equal(t, "/service/events", forwardedData.Path, "wrong request path")
+		assert.Equal(t, "xyz", forwardedData.Body, "wrong request body")
+	}
+	assert.Empty(t, pendingForwards(t, store), "delivered forward is not expected to be persisted")
+}
+
+func TestForwardQueue_Resume_SharedDatabase(t *testing.T) {
+	name := "queue09"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	db.Create(name, BasketConfig{ForwardURL: ts.URL, Capacity: 20})
+	store := db.Get(name).Basket.(ForwardsStore)
+	for i := 0; i < 5; i++ {
+		id, _ := GenerateID()
+		store.SaveForward(context.Background(), &PendingForward{ID: id, Date: int64(i), Target: ForwardTarget{URL: ts.URL},
+			Request: createTestRequestData("/"+name, "abc")})
+	}
+
+	// both instances resume the same forwards
+	queue1 := newForwardQueue(2, 2, 10, OverflowDrop)
+	queue2 := newForwardQueue(2, 2, 10, OverflowDrop)
+	assert.Equal(t, 5, queue1.Resume(db.BasketsDatabase), "wrong number of resumed forwards")
+	assert.Equal(t, 5, queue2.Resume(db.BasketsDatabase), "wrong number of resumed forwards")
+	queue1.Shutdown(5 * time.Second)
+	queue2.Shutdown(5 * time.Second)
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls), "every forward is expected to be delivered once")
+	assert.Empty(t, pendingForwards(t, store), "delivered forwards are not expected to be persisted")
+}
+
+func TestForwardQueue_Resume_Closed(t *testing.T) {
+	name := "queue10"
+	db := testDB(t, NewBoltDatabase(name+".db"))
+	defer db.Release()
+	defer os.Remove(name + ".db")
+
+	db.Create(name, BasketConfig{ForwardURL: "http://localhost:55556", Capacity: 20})
+	store := db.Get(name).Basket.(ForwardsStore)
+	store.SaveForward(context.Background(), &PendingForward{ID: "fwd1", Target: ForwardTarget{URL: "http://localhost:55556"},
+		Request: createTestRequestData("/"+name, "abc")})
+
+	queue := newForwardQueue(1, 1, 10, OverflowDrop)
+	queue.Shutdown(time.Second)
+	assert.Equal(t, 0, queue.Resume(db.BasketsDatabase), "forwards are not expected to be resumed by closed queue")
+	assert.Len(t, pendingForwards(t, store), 1, "forward is expected to remain persisted")
+}
+
+func TestForwardQueue_ForwardResults(t *testing.T) {
+	name := "queue08"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer ts.Close()
+
+	basket := testDB(t, basketsDb).Get(name)
+	request := createTestRequestData("/"+name, "abc")
+	basket.Add(request)
+
+	queue := newForwardQueue(2, 2, 10, OverflowDrop)
+	queue.Enqueue(name, basket.Basket, BasketConfig{ForwardURL: ts.URL, Capacity: 20}, request)
+	// assuming that nothing is running at port 55556
+	queue.Enqueue(name, basket.Basket, BasketConfig{ForwardURL: "http://localhost:55556", Capacity: 20}, request)
+	queue.Shutdown(5 * time.Second)
+
+	page := basket.GetRequests(10, 0)
+	if assert.Equal(t, 1, len(page.Requests), "wrong number of requests") &&
+		assert.Equal(t, 2, len(page.Requests[0].Forwards), "wrong number of forward results") {
+		results := make(map[string]*ForwardResult)
+		for _, result := range page.Requests[0].Forwards {
+			results[result.URL] = result
+		}
+
+		if result := results[ts.URL]; assert.NotNil(t, result, "result of successful forward is expected") {
+			assert.Equal(t, http.StatusCreated, result.Status, "wrong status of target response")
+			assert.Equal(t, "created", result.Body, "wrong body of target response")
+			assert.Equal(t, 1, result.Attempts, "wrong number of attempts")
+			assert.Empty(t, result.Error, "no error is expected")
+		}
+		if result := results["http://localhost:55556"]; assert.NotNil(t, result, "result of failed forward is expected") {
+			assert.Equal(t, 0, result.Status, "no status is expected")
+			assert.Contains(t, result.Error, "connection refused", "error is not detailed enough")
+		}
+	}
+}
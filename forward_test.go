@@ -0,0 +1,233 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestRequestData(path string, body string) *RequestData {
+	data := new(RequestData)
+	data.ID, _ = GenerateID()
+	data.Date = time.Now().UnixNano() / toMs
+	data.Header = make(http.Header)
+	data.Header.Add("Content-Type", "text/plain")
+	data.Method = "POST"
+	data.Body = body
+	data.ContentLength = int64(len(body))
+	data.Path = path
+
+	return data
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, retryDelay(100, 1), "wrong delay of first retry")
+	assert.Equal(t, 200*time.Millisecond, retryDelay(100, 2), "wrong delay of second retry")
+	assert.Equal(t, 800*time.Millisecond, retryDelay(100, 4), "wrong delay of fourth retry")
+	assert.Equal(t, time.Duration(defaultRetryBackoff)*time.Millisecond, retryDelay(0, 1), "wrong default delay")
+	assert.Equal(t, maxRetryDelay, retryDelay(maxRetryBackoff, 10), "delay should not exceed the limit")
+}
+
+func TestForwardWithRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, RetryCount: 5, RetryBackoff: 1}
+	result, err := forwardWithRetries(createTestRequestData("/retry01", "abc"), config, "retry01", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Attempts, "wrong number of attempts")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "wrong number of forwarded requests")
+}
+
+func TestForwardWithRetries_Exhausted(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("dev server is down"))
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, RetryCount: 2, RetryBackoff: 1}
+	result, err := forwardWithRetries(createTestRequestData("/retry02", "abc"), config, "retry02", nil)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "HTTP 502", "error is not detailed enough")
+		assert.Contains(t, err.Error(), "dev server is down", "error is not detailed enough")
+	}
+	assert.Equal(t, 3, result.Attempts, "wrong number of attempts")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "wrong number of forwarded requests")
+}
+
+func TestForwardWithRetries_ClientError(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	// HTTP 4xx means that request is delivered
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, RetryCount: 2, RetryBackoff: 1}
+	result, err := forwardWithRetries(createTestRequestData("/retry03", "abc"), config, "retry03", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Attempts, "wrong number of attempts")
+}
+
+func TestForwardWithRetries_InvalidURL(t *testing.T) {
+	config := BasketConfig{ForwardURL: "qwert", Capacity: 20, RetryCount: 2, RetryBackoff: 1}
+	result, err := forwardWithRetries(createTestRequestData("/retry04", "abc"), config, "retry04", nil)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid forward URL", "error is not detailed enough")
+	}
+	assert.Equal(t, 1, result.Attempts, "invalid forward URL should not be retried")
+}
+
+func TestForwardWithRetries_Interrupted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	stop := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+	}()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, RetryCount: 5, RetryBackoff: 10000}
+	result, err := forwardWithRetries(createTestRequestData("/retry05", "abc"), config, "retry05", stop)
+
+	assert.Equal(t, errForwardInterrupted, err, "forwarding should be interrupted")
+	assert.Equal(t, 1, result.Attempts, "wrong number of attempts")
+}
+
+func TestBodyRecorder(t *testing.T) {
+	recorder := &bodyRecorder{limit: 10}
+	n, err := recorder.Write([]byte("12345"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n, "all bytes are expected to be consumed")
+	n, _ = recorder.Write([]byte("67890abcde"))
+	assert.Equal(t, 10, n, "all bytes are expected to be consumed")
+	recorder.Write([]byte("xyz"))
+
+	result := new(ForwardResult)
+	recorder.record(result)
+	assert.Equal(t, "1234567890", result.Body, "wrong recorded body")
+	assert.True(t, result.Truncated, "recorded body is expected to be truncated")
+}
+
+func TestForwardOnce_Result(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(strings.Repeat("a", forwardResultBodySize+10)))
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL + "/service", ExpandPath: true, Capacity: 20, ForwardMethod: "PUT"}
+	result, retry, err := forwardOnce(createTestRequestData("/result01/events", "abc"), config, "result01")
+
+	assert.NoError(t, err)
+	assert.False(t, retry, "delivered request should not be retried")
+	if assert.NotNil(t, result, "result of forwarding is expected") {
+		assert.Equal(t, ts.URL+"/service/events", result.URL, "wrong URL of forwarded request")
+		assert.Equal(t, http.StatusAccepted, result.Status, "wrong status of target response")
+		assert.True(t, result.Duration >= 20, "wrong duration of forwarding: %d", result.Duration)
+		assert.Equal(t, forwardResultBodySize, len(result.Body), "response body is expected to be truncated")
+		assert.True(t, result.Truncated, "response body is expected to be truncated")
+		assert.Empty(t, result.Error, "no error is expected")
+		if assert.NotNil(t, result.Payload, "transformed payload is expected") {
+			assert.Equal(t, "PUT", result.Payload.Method, "wrong method of transformed payload")
+		}
+	}
+}
+
+func TestForwardOnce_TransportError(t *testing.T) {
+	// assuming that nothing is running at port 55556
+	config := BasketConfig{ForwardURL: "http://localhost:55556", Capacity: 20}
+	result, retry, err := forwardOnce(createTestRequestData("/result02", "abc"), config, "result02")
+
+	assert.Error(t, err)
+	assert.True(t, retry, "request is expected to be retried")
+	if assert.NotNil(t, result, "result of forwarding is expected") {
+		assert.Equal(t, 0, result.Status, "no status is expected")
+		assert.Contains(t, result.Error, "connection refused", "error is not detailed enough")
+	}
+}
+
+func TestForwardOnce_TransformError(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, ForwardBodyTemplate: "{{template \"unknown\"}}"}
+	result, retry, err := forwardOnce(createTestRequestData("/result03", "abc"), config, "result03")
+
+	assert.Error(t, err, "transformation error is expected")
+	assert.False(t, retry, "request with invalid transformation should not be retried")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "request is not expected to be forwarded")
+	if assert.NotNil(t, result, "result of forwarding is expected") {
+		assert.Contains(t, result.Error, "failed to transform body", "error is not detailed enough")
+		assert.Nil(t, result.Payload, "no payload is expected")
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	var forwardedData *RequestData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedData = ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	letter := &DeadLetter{ID: "abc", Target: ForwardTarget{URL: ts.URL + "/service", ExpandPath: true},
+		Request: createTestRequestData("/retry06/events", "xyz")}
+
+	// basket forward URL is ignored, the original target is used
+	err := redeliver(letter, BasketConfig{ForwardURL: "http://localhost:55556", Capacity: 20}, "retry06")
+	if assert.NoError(t, err) && assert.NotNil(t, forwardedData, "request is not delivered") {
+		assert.Equal(t, "/service/events", forwardedData.Path, "wrong request path")
+		assert.Equal(t, "xyz", forwardedData.Body, "wrong request body")
+	}
+}
+
+func TestSaveDeadLetter_Payload(t *testing.T) {
+	name := "retry07"
+	testDB(t, basketsDb).Create(name, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(name)
+
+	config := BasketConfig{ForwardURL: "http://localhost:55556", Capacity: 20, ForwardMethod: "PUT", ForwardBodyTemplate: "[{{.method}}]"}
+	request := createTestRequestData("/"+name, "xyz")
+	payload, err := request.Transform(config)
+	assert.NoError(t, err)
+	saveDeadLetter(request, payload, config, name, 1, errQueueFull)
+
+	page := testDB(t, basketsDb).Get(name).GetDeadLetters(10, 0)
+	if assert.Equal(t, 1, len(page.DeadLetters), "wrong number of dead letters") {
+		letter := page.DeadLetters[0]
+		assert.Equal(t, "xyz", letter.Request.Body, "original request is expected")
+		if assert.NotNil(t, letter.Payload, "transformed payload is expected") {
+			assert.Equal(t, "PUT", letter.Payload.Method, "wrong method of transformed payload")
+			assert.Equal(t, "[POST]", letter.Payload.Body, "wrong body of transformed payload")
+		}
+	}
+}
@@ -3,10 +3,14 @@ module github.com/darklynx/request-baskets
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/go-sql-driver/mysql v1.7.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16 // indirect
+	github.com/redis/go-redis/v9 v9.17.3
 	github.com/stretchr/testify v1.8.1
 	go.etcd.io/bbolt v1.3.7
 	golang.org/x/sys v0.7.0 // indirect
+	modernc.org/sqlite v1.17.3
 )
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -53,6 +57,9 @@ const (
 )
 
 var validBasketName = regexp.MustCompile(basketNamePattern)
+var validHTTPToken = regexp.MustCompile(httpTokenPattern)
+var responseMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace}
 var defaultResponse = ResponseConfig{Status: http.StatusOK, Headers: http.Header{}, IsTemplate: false}
 var indexPageTemplate = template.Must(template.New("index").Parse(indexPageContentTemplate))
 var basketPageTemplate = template.Must(template.New("basket").Parse(basketPageContentTemplate))
@@ -69,6 +76,26 @@ func writeJSON(w http.ResponseWriter, status int, json []byte, err error) {
 	}
 }
 
+// storageFailed responds with an error if operation with baskets storage is failed, returns true in this case;
+// details of the error are logged, but not exposed to clients. Operation interrupted because the HTTP request
+// is canceled or timed out is reported as temporary unavailability of the service
+func storageFailed(ctx context.Context, w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	status := http.StatusInternalServerError
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("[warn] operation with baskets storage is interrupted: %s", err)
+		status = http.StatusServiceUnavailable
+	} else {
+		log.Printf("[error] %s", err)
+	}
+
+	http.Error(w, http.StatusText(status), status)
+	return true
+}
+
 // parseInt parses integer parameter from HTTP request query
 func parseInt(value string, min int, max int, defaultValue int) int {
 	if len(value) > 0 {
@@ -100,17 +127,32 @@ func getAuthorizedBasket(w http.ResponseWriter, r *http.Request, ps httprouter.P
 	name := ps.ByName("basket")
 	if !validBasketName.MatchString(name) {
 		http.Error(w, "invalid basket name; the name does not match pattern: "+validBasketName.String(), http.StatusBadRequest)
-	} else if basket := basketsDb.Get(name); basket != nil {
-		// maybe custom header, e.g. basket_key, basket_token
-		if token := r.Header.Get("Authorization"); basket.Authorize(token) || token == config.MasterToken {
-			return name, basket
-		}
-		w.WriteHeader(http.StatusUnauthorized)
-	} else {
+		return "", nil
+	}
+
+	basket, err := basketsDb.Get(r.Context(), name)
+	if storageFailed(r.Context(), w, err) {
+		return "", nil
+	}
+	if basket == nil {
 		w.WriteHeader(http.StatusNotFound)
+		return "", nil
+	}
+
+	// maybe custom header, e.g. basket_key, basket_token
+	token := r.Header.Get("Authorization")
+	if token != config.MasterToken {
+		authorized, err := basket.Authorize(r.Context(), token)
+		if storageFailed(r.Context(), w, err) {
+			return "", nil
+		}
+		if !authorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			return "", nil
+		}
 	}
 
-	return "", nil
+	return name, basket
 }
 
 // authorizeRequest helps to authorize requests for restricted end-points and returns true in case of successful authorization
@@ -146,6 +188,211 @@ func validateBasketConfig(config *BasketConfig) error {
 		}
 	}
 
+	// validate forward targets
+	if err := validateForwardTargets(*config); err != nil {
+		return err
+	}
+
+	// validate forward rules
+	names := make(map[string]bool)
+	for i, rule := range config.ForwardRules {
+		if len(rule.Name) == 0 {
+			return fmt.Errorf("name of forward rule #%d may not be empty", i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("name of forward rule #%d is not unique: %s", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := validateForwardRule(rule); err != nil {
+			return fmt.Errorf("invalid forward rule '%s': %s", rule.Name, err)
+		}
+		if err := validateForwardTargets(config.ForRule(&config.ForwardRules[i])); err != nil {
+			return fmt.Errorf("invalid forward rule '%s': %s", rule.Name, err)
+		}
+	}
+
+	// validate retries
+	if config.RetryCount < 0 || config.RetryCount > maxForwardRetries {
+		return fmt.Errorf("retry count should be in range from 0 to %d, but was %d", maxForwardRetries, config.RetryCount)
+	}
+
+	if config.RetryBackoff < 0 || config.RetryBackoff > maxRetryBackoff {
+		return fmt.Errorf("retry backoff should be in range from 0 to %d ms, but was %d", maxRetryBackoff, config.RetryBackoff)
+	}
+
+	// validate transport settings
+	if err := validateTransport(config.Transport); err != nil {
+		return err
+	}
+
+	// validate request signing
+	if config.Signing != nil {
+		if err := config.Signing.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate circuit breaker and rate limit
+	if config.CircuitBreaker != nil {
+		if err := config.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if config.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit: %d", config.RateLimit)
+	}
+
+	// validate retention
+	if config.MaxAge < 0 {
+		return fmt.Errorf("invalid max age of requests: %d", config.MaxAge)
+	}
+
+	// validate WebSocket settings
+	if ws := config.WebSocket; ws != nil && len(ws.Mode) > 0 && ws.Mode != WebSocketEcho && ws.Mode != WebSocketReply {
+		return fmt.Errorf("unknown WebSocket mode: %s, supported modes: %s, %s", ws.Mode, WebSocketEcho, WebSocketReply)
+	}
+
+	// validate header rules
+	for i, rule := range config.HeaderRules {
+		if rule.Action != HeaderRuleSet && rule.Action != HeaderRuleAdd && rule.Action != HeaderRuleRemove {
+			return fmt.Errorf("unknown action of header rule #%d: %s, supported actions: %s, %s, %s", i+1, rule.Action,
+				HeaderRuleSet, HeaderRuleAdd, HeaderRuleRemove)
+		}
+		if !validHTTPToken.MatchString(rule.Name) {
+			return fmt.Errorf("invalid header name in rule #%d: '%s'", i+1, rule.Name)
+		}
+		if _, err := rule.ParseValue(); err != nil {
+			return fmt.Errorf("invalid value template in header rule #%d: %s", i+1, err)
+		}
+	}
+
+	// validate payload transformation
+	if len(config.ForwardBodyTemplate) > 0 {
+		if _, err := config.ParseBodyTemplate(); err != nil {
+			return fmt.Errorf("invalid template of forwarded request body: %s", err)
+		}
+	}
+
+	if len(config.ForwardMethod) > 0 && !validHTTPToken.MatchString(config.ForwardMethod) {
+		return fmt.Errorf("invalid HTTP method of forwarded request: '%s'", config.ForwardMethod)
+	}
+
+	if len(config.ForwardContentType) > 0 {
+		if _, _, err := mime.ParseMediaType(config.ForwardContentType); err != nil {
+			return fmt.Errorf("invalid content type of forwarded request: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// validateForwardTargets validates forward targets of basket configuration
+func validateForwardTargets(config BasketConfig) error {
+	primaries := 0
+	for i, target := range config.ForwardTargets {
+		if len(target.URL) == 0 {
+			return fmt.Errorf("URL of forward target #%d may not be empty", i+1)
+		}
+		if _, err := url.ParseRequestURI(target.URL); err != nil {
+			return fmt.Errorf("invalid URL of forward target #%d: %s", i+1, err)
+		}
+		if target.Primary {
+			primaries++
+		}
+	}
+
+	if primaries > 1 {
+		return fmt.Errorf("only one forward target can be marked as primary, but found %d", primaries)
+	}
+
+	if config.ProxyResponse && len(config.Targets()) > 0 && config.PrimaryTarget() < 0 {
+		return fmt.Errorf("one of forward targets should be marked as primary to proxy its response")
+	}
+
+	return nil
+}
+
+// validateTransport validates transport settings of basket configuration
+func validateTransport(transport *TransportConfig) error {
+	if transport == nil {
+		return nil
+	}
+
+	timeouts := []struct {
+		name  string
+		value int
+	}{
+		{"connect timeout", transport.ConnectTimeout},
+		{"TLS handshake timeout", transport.TLSTimeout},
+		{"timeout", transport.Timeout}}
+
+	for _, timeout := range timeouts {
+		if timeout.value < 0 || timeout.value > maxFwdTimeout {
+			return fmt.Errorf("%s of forwarded request should be in range from 0 to %d ms, but was %d", timeout.name,
+				maxFwdTimeout, timeout.value)
+		}
+	}
+
+	if len(transport.TLSProfile) > 0 {
+		if transport.TLS != nil {
+			return errors.New("TLS profile and TLS settings of forwarded request may not be defined together")
+		}
+		if _, exists := serverConfig.TLSProfiles[transport.TLSProfile]; !exists {
+			return fmt.Errorf("unknown TLS profile: %s", transport.TLSProfile)
+		}
+	}
+
+	if transport.TLS != nil {
+		if _, err := transport.TLS.Build(); err != nil {
+			return fmt.Errorf("invalid TLS settings of forwarded request: %s", err)
+		}
+	}
+
+	if proxy := transport.Proxy; proxy != nil {
+		if len(proxy.URL) == 0 || proxy.URL == ProxyDirect {
+			if len(proxy.Username) > 0 || len(proxy.Password) > 0 {
+				return errors.New("proxy credentials require proxy URL")
+			}
+		} else if u, err := parseProxyURL(proxy.URL); err != nil {
+			return err
+		} else if u.User != nil {
+			return errors.New("proxy credentials should be defined with username and password, not in proxy URL")
+		} else if len(proxy.Password) > 0 && len(proxy.Username) == 0 {
+			return errors.New("proxy password requires username")
+		}
+	}
+
+	return nil
+}
+
+// validateForwardRule validates conditions of forward rule
+func validateForwardRule(rule ForwardRule) error {
+	if len(rule.Method) > 0 && !validHTTPToken.MatchString(rule.Method) {
+		return fmt.Errorf("invalid HTTP method: '%s'", rule.Method)
+	}
+
+	if _, err := compilePattern(rule.Path); err != nil {
+		return fmt.Errorf("invalid path pattern: %s", err)
+	}
+
+	for header, pattern := range rule.Headers {
+		if !validHTTPToken.MatchString(header) {
+			return fmt.Errorf("invalid header name: '%s'", header)
+		}
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid pattern of header %s: %s", header, err)
+		}
+	}
+
+	if _, err := compilePattern(rule.Body); err != nil {
+		return fmt.Errorf("invalid body pattern: %s", err)
+	}
+
+	if rule.Drop && len(rule.Targets) > 0 {
+		return fmt.Errorf("rule that drops requests may not define forward targets")
+	}
+
 	return nil
 }
 
@@ -169,24 +416,35 @@ func validateResponseConfig(config *ResponseConfig) error {
 // getValidMethod retrieves mathod name from HTTP request path and validates it
 func getValidMethod(ps httprouter.Params) (string, error) {
 	method := strings.ToUpper(ps.ByName("method"))
-
-	// valid HTTP methods
-	switch method {
-	case http.MethodGet,
-		http.MethodHead,
-		http.MethodPost,
-		http.MethodPut,
-		http.MethodPatch,
-		http.MethodDelete,
-		http.MethodConnect,
-		http.MethodOptions,
-		http.MethodTrace:
+	if isValidMethod(method) {
 		return method, nil
 	}
 
 	return method, fmt.Errorf("unknown HTTP method: %s", method)
 }
 
+// isValidMethod checks if basket response can be configured for HTTP method
+func isValidMethod(method string) bool {
+	for _, valid := range responseMethods {
+		if method == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNewBasketName validates the name of basket that is about to be created,
+// returns HTTP status code to report in case of invalid name
+func validateNewBasketName(name string) (int, error) {
+	if name == serviceOldAPIPath || name == serviceAPIPath || name == serviceUIPath {
+		return http.StatusForbidden, fmt.Errorf("This basket name conflicts with reserved system path: %s", name)
+	}
+	if !validBasketName.MatchString(name) {
+		return http.StatusBadRequest, fmt.Errorf("invalid basket name; the name does not match pattern: %s", validBasketName.String())
+	}
+	return http.StatusOK, nil
+}
+
 // GetBaskets handles HTTP request to get registered baskets
 func GetBaskets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if authorizeRequest(w, r, false, serverConfig) {
@@ -194,12 +452,17 @@ func GetBaskets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		if query := values.Get("q"); len(query) > 0 {
 			// find names
 			max, skip := getPage(values)
-			json, err := json.Marshal(basketsDb.FindNames(query, max, skip))
-			writeJSON(w, http.StatusOK, json, err)
+			if page, err := basketsDb.FindNames(r.Context(), query, max, skip); !storageFailed(r.Context(), w, err) {
+				json, err := json.Marshal(page)
+				writeJSON(w, http.StatusOK, json, err)
+			}
 		} else {
 			// get basket names page
-			json, err := json.Marshal(basketsDb.GetNames(getPage(values)))
-			writeJSON(w, http.StatusOK, json, err)
+			max, skip := getPage(values)
+			if page, err := basketsDb.GetNames(r.Context(), max, skip); !storageFailed(r.Context(), w, err) {
+				json, err := json.Marshal(page)
+				writeJSON(w, http.StatusOK, json, err)
+			}
 		}
 	}
 }
@@ -209,8 +472,10 @@ func GetStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if authorizeRequest(w, r, false, serverConfig) {
 		// get database stats
 		max := parseInt(r.URL.Query().Get("max"), 1, 100, 5)
-		json, err := json.Marshal(basketsDb.GetStats(max))
-		writeJSON(w, http.StatusOK, json, err)
+		if stats, err := basketsDb.GetStats(r.Context(), max); !storageFailed(r.Context(), w, err) {
+			json, err := json.Marshal(stats)
+			writeJSON(w, http.StatusOK, json, err)
+		}
 	}
 }
 
@@ -224,8 +489,29 @@ func GetVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 // GetBasket handles HTTP request to get basket configuration
 func GetBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
-		json, err := json.Marshal(basket.Config())
-		writeJSON(w, http.StatusOK, json, err)
+		if config, err := basket.Config(r.Context()); !storageFailed(r.Context(), w, err) {
+			details := BasketDetails{BasketConfig: config.WithoutSecrets()}
+			if !config.Pinned && (serverConfig.BasketTTL > 0 || serverConfig.BasketLifetime > 0) {
+				activity, err := basket.Activity(r.Context())
+				if storageFailed(r.Context(), w, err) {
+					return
+				}
+				details.ExpiryDate = config.ExpiryDate(activity, serverConfig.BasketTTL, serverConfig.BasketLifetime)
+			}
+
+			json, err := json.Marshal(details)
+			writeJSON(w, http.StatusOK, json, err)
+		}
+	}
+}
+
+// GetBasketStatus handles HTTP request to get runtime state of forwarding of a basket
+func GetBasketStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		if config, err := basket.Config(r.Context()); !storageFailed(r.Context(), w, err) {
+			json, err := json.Marshal(getBasketStatus(config, name))
+			writeJSON(w, http.StatusOK, json, err)
+		}
 	}
 }
 
@@ -236,19 +522,15 @@ func CreateBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	}
 
 	name := ps.ByName("basket")
-	if name == serviceOldAPIPath || name == serviceAPIPath || name == serviceUIPath {
-		http.Error(w, "This basket name conflicts with reserved system path: "+name, http.StatusForbidden)
-		return
-	}
-	if !validBasketName.MatchString(name) {
-		http.Error(w, "invalid basket name; the name does not match pattern: "+validBasketName.String(), http.StatusBadRequest)
+	if status, err := validateNewBasketName(name); err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
 	log.Printf("[info] creating basket: %s", name)
 
-	// read config (max 2 kB)
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 2048))
+	// read config (max 64 kB)
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
 	r.Body.Close()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -266,12 +548,16 @@ func CreateBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 			return
 		}
+		if err = validateForwardLoop(&config, name, r.Host); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 	}
 
-	auth, err := basketsDb.Create(name, config)
-	if err != nil {
+	auth, err := basketsDb.Create(r.Context(), name, config)
+	if errors.Is(err, errBasketExists) {
 		http.Error(w, err.Error(), http.StatusConflict)
-	} else {
+	} else if !storageFailed(r.Context(), w, err) {
 		json, err := json.Marshal(auth)
 		writeJSON(w, http.StatusCreated, json, err)
 	}
@@ -279,27 +565,36 @@ func CreateBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 // UpdateBasket handles HTTP request to update basket configuration
 func UpdateBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
-		// read config (max 2 kB)
-		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 2048))
+	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		// read config (max 64 kB)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
 		r.Body.Close()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		} else if len(body) > 0 {
-			// get current config
-			config := basket.Config()
+			// new config replaces the current one, secrets that are not exposed by API remain unchanged if not provided
+			current, err := basket.Config(r.Context())
+			if storageFailed(r.Context(), w, err) {
+				return
+			}
+			var config BasketConfig
 			if err = json.Unmarshal(body, &config); err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
+			config = config.WithSecretsOf(current)
 			if err = validateBasketConfig(&config); err != nil {
 				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 				return
 			}
+			if err = validateForwardLoop(&config, name, r.Host); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
 
-			basket.Update(config)
-
-			w.WriteHeader(http.StatusNoContent)
+			if !storageFailed(r.Context(), w, basket.Update(r.Context(), config)) {
+				w.WriteHeader(http.StatusNoContent)
+			}
 		} else {
 			w.WriteHeader(http.StatusNotModified)
 		}
@@ -311,8 +606,93 @@ func DeleteBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
 		log.Printf("[info] deleting basket: %s", name)
 
-		basketsDb.Delete(name)
-		w.WriteHeader(http.StatusNoContent)
+		if !storageFailed(r.Context(), w, basketsDb.Delete(r.Context(), name)) {
+			forwardGuards.release(name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// ExportBasket handles HTTP request to export basket as archive, secrets of basket configuration
+// are only exported with master token
+func ExportBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		withSecrets := r.Header.Get("Authorization") == serverConfig.MasterToken
+		if archive, err := exportBasket(r.Context(), name, basket, withSecrets); !storageFailed(r.Context(), w, err) {
+			json, err := json.Marshal(archive)
+			if err == nil {
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.json\"", name))
+			}
+			writeJSON(w, http.StatusOK, json, err)
+		}
+	}
+}
+
+// ImportBasket handles HTTP request to create a new basket from archive
+func ImportBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !authorizeRequest(w, r, true, serverConfig) {
+		return
+	}
+
+	name := ps.ByName("basket")
+	if status, err := validateNewBasketName(name); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	log.Printf("[info] importing basket: %s", name)
+
+	archive := new(BasketArchive)
+	err := json.NewDecoder(io.LimitReader(r.Body, maxArchiveSize)).Decode(archive)
+	r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = validateArchive(archive, name, r.Host); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	auth, err := importBasket(r.Context(), basketsDb, name, archive)
+	if errors.Is(err, errBasketExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+	} else if !storageFailed(r.Context(), w, err) {
+		json, err := json.Marshal(auth)
+		writeJSON(w, http.StatusCreated, json, err)
+	}
+}
+
+// ExportBaskets handles HTTP request to export all baskets as a stream of archives, one archive per line
+func ExportBaskets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if authorizeRequest(w, r, false, serverConfig) {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"baskets.jsonl\"")
+		w.WriteHeader(http.StatusOK)
+
+		// the response is already started, so the stream is only cut short in case of failure
+		if count, err := exportBaskets(r.Context(), basketsDb, w); err != nil {
+			log.Printf("[error] failed to export baskets, %d basket(s) are exported - %s", count, err)
+		} else {
+			log.Printf("[info] exported %d basket(s)", count)
+		}
+	}
+}
+
+// ImportBaskets handles HTTP request to create baskets from a stream of archives
+func ImportBaskets(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if authorizeRequest(w, r, false, serverConfig) {
+		result, err := importBaskets(r.Context(), basketsDb, r.Body, r.Host)
+		r.Body.Close()
+		log.Printf("[info] imported %d basket(s), failed to import %d basket(s)", len(result.Baskets), len(result.Failed))
+		if errors.Is(err, errArchiveTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			json, err := json.Marshal(result)
+			writeJSON(w, http.StatusOK, json, err)
+		}
 	}
 }
 
@@ -323,7 +703,10 @@ func GetBasketResponse(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		if errm != nil {
 			http.Error(w, errm.Error(), http.StatusBadRequest)
 		} else {
-			response := basket.GetResponse(method)
+			response, err := basket.GetResponse(r.Context(), method)
+			if storageFailed(r.Context(), w, err) {
+				return
+			}
 			if response == nil {
 				response = &defaultResponse
 			}
@@ -358,8 +741,9 @@ func UpdateBasketResponse(w http.ResponseWriter, r *http.Request, ps httprouter.
 					return
 				}
 
-				basket.SetResponse(method, response)
-				w.WriteHeader(http.StatusNoContent)
+				if !storageFailed(r.Context(), w, basket.SetResponse(r.Context(), method, response)) {
+					w.WriteHeader(http.StatusNoContent)
+				}
 			} else {
 				w.WriteHeader(http.StatusNotModified)
 			}
@@ -374,12 +758,17 @@ func GetBasketRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		if query := values.Get("q"); len(query) > 0 {
 			// find requests
 			max, skip := getPage(values)
-			json, err := json.Marshal(basket.FindRequests(query, values.Get("in"), max, skip))
-			writeJSON(w, http.StatusOK, json, err)
+			if page, err := basket.FindRequests(r.Context(), query, values.Get("in"), max, skip); !storageFailed(r.Context(), w, err) {
+				json, err := json.Marshal(page)
+				writeJSON(w, http.StatusOK, json, err)
+			}
 		} else {
 			// get requests page
-			json, err := json.Marshal(basket.GetRequests(getPage(values)))
-			writeJSON(w, http.StatusOK, json, err)
+			max, skip := getPage(values)
+			if page, err := basket.GetRequests(r.Context(), max, skip); !storageFailed(r.Context(), w, err) {
+				json, err := json.Marshal(page)
+				writeJSON(w, http.StatusOK, json, err)
+			}
 		}
 	}
 }
@@ -387,8 +776,117 @@ func GetBasketRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 // ClearBasket handles HTTP request to delete all requests collected by basket
 func ClearBasket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
-		basket.Clear()
-		w.WriteHeader(http.StatusNoContent)
+		if !storageFailed(r.Context(), w, basket.Clear(r.Context())) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// GetBasketDeadLetters handles HTTP request to get requests that basket failed to forward
+func GetBasketDeadLetters(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		max, skip := getPage(r.URL.Query())
+		if page, err := basket.GetDeadLetters(r.Context(), max, skip); !storageFailed(r.Context(), w, err) {
+			json, err := json.Marshal(page)
+			writeJSON(w, http.StatusOK, json, err)
+		}
+	}
+}
+
+// ClearBasketDeadLetters handles HTTP request to delete all requests that basket failed to forward
+func ClearBasketDeadLetters(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		if !storageFailed(r.Context(), w, basket.ClearDeadLetters(r.Context())) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// RedeliverDeadLetter handles HTTP request to forward undelivered request once again
+func RedeliverDeadLetter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		id := ps.ByName("id")
+		letter, err := basket.GetDeadLetter(r.Context(), id)
+		if storageFailed(r.Context(), w, err) {
+			return
+		}
+		if letter == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		config, err := basket.Config(r.Context())
+		if storageFailed(r.Context(), w, err) {
+			return
+		}
+		if err = redeliver(letter, config, name); err != nil {
+			http.Error(w, "Failed to redeliver request: "+err.Error(), http.StatusBadGateway)
+		} else if !storageFailed(r.Context(), w, basket.RemoveDeadLetter(r.Context(), id)) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// DeleteDeadLetter handles HTTP request to delete undelivered request
+func DeleteDeadLetter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if _, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		id := ps.ByName("id")
+		letter, err := basket.GetDeadLetter(r.Context(), id)
+		if storageFailed(r.Context(), w, err) {
+			return
+		}
+		if letter == nil {
+			w.WriteHeader(http.StatusNotFound)
+		} else if !storageFailed(r.Context(), w, basket.RemoveDeadLetter(r.Context(), id)) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// ReplayRequest handles HTTP request to send collected request again and responds with the response of target
+func ReplayRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if name, basket := getAuthorizedBasket(w, r, ps, serverConfig); basket != nil {
+		request, err := basket.GetRequest(r.Context(), ps.ByName("id"))
+		if storageFailed(r.Context(), w, err) {
+			return
+		}
+		if request == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// read replay options (max 1 MB)
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var options ReplayOptions
+		if len(body) > 0 {
+			if err = json.Unmarshal(body, &options); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		current, err := basket.Config(r.Context())
+		if storageFailed(r.Context(), w, err) {
+			return
+		}
+		config := current.ForReplay(options)
+		if len(config.ForwardURL) == 0 {
+			http.Error(w, "basket has no forward URL, URL to replay request is required", http.StatusUnprocessableEntity)
+			return
+		}
+		if _, err = url.ParseRequestURI(config.ForwardURL); err != nil {
+			http.Error(w, "invalid URL to replay request: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		log.Printf("[info] replaying request %s of basket: %s to %s", request.ID, name, config.ForwardURL)
+		forwardAndProxyResponse(w, options.Apply(request), config, name)
 	}
 }
 
@@ -398,11 +896,13 @@ func ForwardToWeb(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 }
 
 type TemplateData struct {
-	Prefix   string
-	Version  *Version
-	ThemeCSS template.HTML
-	Basket   string
-	Data     interface{}
+	Prefix       string
+	Version      *Version
+	ThemeCSS     template.HTML
+	Basket       string
+	MaxAge       time.Duration
+	BasketExpiry bool
+	Data         interface{}
 }
 
 // WebIndexPage handles HTTP request to render index page
@@ -420,7 +920,8 @@ func WebBasketPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
 			basketsPageTemplate.Execute(w, TemplateData{Prefix: serverConfig.PathPrefix, Version: version, ThemeCSS: serverConfig.ThemeCSS})
 		default:
-			basketPageTemplate.Execute(w, TemplateData{Prefix: serverConfig.PathPrefix, Version: version, ThemeCSS: serverConfig.ThemeCSS, Basket: name})
+			basketPageTemplate.Execute(w, TemplateData{Prefix: serverConfig.PathPrefix, Version: version, ThemeCSS: serverConfig.ThemeCSS, Basket: name,
+				MaxAge: serverConfig.MaxAge, BasketExpiry: serverConfig.BasketTTL > 0 || serverConfig.BasketLifetime > 0})
 		}
 	} else {
 		http.Error(w, "Basket name does not match pattern: "+validBasketName.String(), http.StatusBadRequest)
@@ -433,23 +934,82 @@ func AcceptBasketRequests(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Printf("[error] %s", err)
 		http.Error(w, publicErr, http.StatusBadRequest)
-	} else if basket := basketsDb.Get(name); basket != nil {
-		request := basket.Add(r)
-
-		// forward request if configured and it's a first forwarding
-		config := basket.Config()
-		if len(config.ForwardURL) > 0 && r.Header.Get(DoNotForwardHeader) != "1" {
-			if config.ProxyResponse {
-				forwardAndProxyResponse(w, request, config, name)
-				return
+		return
+	}
+
+	basket, err := basketsDb.Get(r.Context(), name)
+	if storageFailed(r.Context(), w, err) {
+		return
+	}
+	if basket == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	config, err := basket.Config(r.Context())
+	if storageFailed(r.Context(), w, err) {
+		return
+	}
+
+	request := ToRequestData(r)
+	upgrade := isWebSocketUpgrade(r)
+
+	// forward request if configured and it's a first forwarding
+	forward := r.Header.Get(DoNotForwardHeader) != "1"
+	if forward && len(config.ForwardRules) > 0 {
+		rule := config.MatchRule(request)
+		if rule != nil {
+			request.ForwardRule = rule.Name
+		}
+		config = config.ForRule(rule)
+	}
+
+	if storageFailed(r.Context(), w, basket.Add(r.Context(), request)) {
+		return
+	}
+
+	if targets := config.Targets(); len(targets) > 0 && forward {
+		if err := detectForwardLoop(r.Header, name); err != nil {
+			log.Printf("[warn] request for basket: %s is not forwarded - %s", name, err)
+			for _, target := range targets {
+				recordForwardResult(request, &ForwardResult{Date: time.Now().UnixNano() / toMs, URL: target.URL,
+					Error: err.Error()}, name)
 			}
+			if config.ProxyResponse && config.PrimaryTarget() >= 0 {
+				http.Error(w, err.Error(), http.StatusLoopDetected)
+			} else if upgrade {
+				acceptWebSocket(w, r, request, name, basket, config.WebSocket)
+			} else {
+				writeBasketResponse(r.Context(), w, request, name, basket)
+			}
+			return
+		}
 
-			go forwardAndForget(request, config, name)
+		primary := -1
+		if config.ProxyResponse {
+			primary = config.PrimaryTarget()
 		}
 
-		writeBasketResponse(w, request, name, basket)
+		// WebSocket sessions are only tunneled to the primary target
+		for i, target := range targets {
+			if i != primary && !upgrade {
+				forwardingQueue.Enqueue(name, basket, config.ForTarget(target), request)
+			}
+		}
+
+		if primary >= 0 && upgrade {
+			tunnelWebSocket(w, r, request, config.ForTarget(targets[primary]), name, basket)
+			return
+		} else if primary >= 0 {
+			forwardAndProxyResponse(w, request, config.ForTarget(targets[primary]), name)
+			return
+		}
+	}
+
+	if upgrade {
+		acceptWebSocket(w, r, request, name, basket, config.WebSocket)
 	} else {
-		w.WriteHeader(http.StatusNotFound)
+		writeBasketResponse(r.Context(), w, request, name, basket)
 	}
 }
 
@@ -473,43 +1033,54 @@ func getBasketNameOfAcceptedRequest(r *http.Request, prefix string) (string, str
 	return name, "", nil
 }
 
-func forwardAndForget(request *RequestData, config BasketConfig, name string) {
-	// forward request and discard the response
-	response, err := request.Forward(getHTTPClient(config.InsecureTLS), config, name)
-	if err != nil {
-		log.Printf("[warn] failed to forward request for basket: %s - %s", name, err)
-	} else {
-		io.Copy(ioutil.Discard, response.Body)
-		response.Body.Close()
-	}
-}
-
 func forwardAndProxyResponse(w http.ResponseWriter, request *RequestData, config BasketConfig, name string) {
 	// forward request in a full proxy mode
-	response, err := request.Forward(getHTTPClient(config.InsecureTLS), config, name)
+	result, response, err := forwardRequest(request, config, name)
+	defer recordForwardResult(request, result, name)
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	} else {
-		// headers
-		for k, v := range response.Header {
-			w.Header()[k] = v
+		if response == nil && result.Rejected {
+			// circuit breaker or rate limit - HTTP 503 Service Unavailable
+			response = unavailableResponse(errors.New(result.Error))
+		} else if response == nil {
+			// HTTP issue during forwarding - HTTP 502 Bad Gateway
+			response = badGatewayResponse(errors.New(result.Error))
 		}
+		writeProxiedResponse(w, response, result, name)
+	}
+}
 
-		// status
-		w.WriteHeader(response.StatusCode)
+// writeProxiedResponse writes the response of forward target and records its body with the result of forwarding
+func writeProxiedResponse(w http.ResponseWriter, response *http.Response, result *ForwardResult, name string) {
+	// headers
+	for k, v := range response.Header {
+		w.Header()[k] = v
+	}
 
-		// body
-		_, err := io.Copy(w, response.Body)
-		if err != nil {
-			log.Printf("[warn] failed to proxy response body for basket: %s - %s", name, err)
-			io.Copy(ioutil.Discard, response.Body)
-		}
-		response.Body.Close()
+	// status
+	w.WriteHeader(response.StatusCode)
+
+	// body
+	recorder := &bodyRecorder{limit: forwardResultBodySize}
+	_, err := io.Copy(w, io.TeeReader(response.Body, recorder))
+	if err != nil {
+		log.Printf("[warn] failed to proxy response body for basket: %s - %s", name, err)
+		io.Copy(ioutil.Discard, response.Body)
+	}
+	response.Body.Close()
+
+	if result.Status > 0 {
+		recorder.record(result)
 	}
 }
 
-func writeBasketResponse(w http.ResponseWriter, request *RequestData, name string, basket Basket) {
-	response := basket.GetResponse(request.Method)
+func writeBasketResponse(ctx context.Context, w http.ResponseWriter, request *RequestData, name string, basket Basket) {
+	response, err := basket.GetResponse(ctx, request.Method)
+	if storageFailed(ctx, w, err) {
+		return
+	}
 	if response == nil {
 		response = &defaultResponse
 	}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"testing/iotest"
@@ -26,6 +29,32 @@ func TestWriteJSON(t *testing.T) {
 	assert.Equal(t, "application/json; charset=UTF-8", w.Header().Get("Content-Type"), "wrong Content-Type")
 }
 
+func TestStorageFailed(t *testing.T) {
+	w := httptest.NewRecorder()
+	assert.False(t, storageFailed(context.Background(), w, nil), "failure is not expected")
+	assert.Equal(t, 200, w.Code, "response is not expected to be written")
+
+	// details of storage errors are not exposed
+	w = httptest.NewRecorder()
+	assert.True(t, storageFailed(context.Background(), w, fmt.Errorf("failed to get basket: %s - %s", "abc",
+		"dial tcp 10.0.0.5:6379: connect: connection refused")), "failure is expected")
+	assert.Equal(t, 500, w.Code, "wrong HTTP response code")
+	assert.Equal(t, "Internal Server Error\n", w.Body.String(), "wrong HTTP response body")
+
+	// interrupted operation is reported as unavailability of service
+	w = httptest.NewRecorder()
+	assert.True(t, storageFailed(context.Background(), w, fmt.Errorf("failed to get basket: %s - %w", "abc",
+		context.DeadlineExceeded)), "failure is expected")
+	assert.Equal(t, 503, w.Code, "wrong HTTP response code")
+	assert.Equal(t, "Service Unavailable\n", w.Body.String(), "wrong HTTP response body")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	assert.True(t, storageFailed(ctx, w, fmt.Errorf("failed to get basket: %s - %s", "abc", ctx.Err())), "failure is expected")
+	assert.Equal(t, 503, w.Code, "wrong HTTP response code")
+}
+
 func TestWriteJSON_Error(t *testing.T) {
 	w := httptest.NewRecorder()
 	writeJSON(w, http.StatusOK, nil, fmt.Errorf("Failed to generate JSON: whatever reason"))
@@ -58,7 +87,7 @@ func TestCreateBasket(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "\"token\"", "JSON response with token is expected")
 
 		// validate database
-		b := basketsDb.Get(basket)
+		b := testDB(t, basketsDb).Get(basket)
 		if assert.NotNil(t, b, "basket '%v' should be created", basket) {
 			config := b.Config()
 			assert.Equal(t, 200, config.Capacity, "wrong basket capacity")
@@ -86,7 +115,7 @@ func TestCreateBasket_CustomConfig(t *testing.T) {
 		assert.Contains(t, w.Body.String(), "\"token\"", "JSON response with token is expected")
 
 		// validate database
-		b := basketsDb.Get(basket)
+		b := testDB(t, basketsDb).Get(basket)
 		if assert.NotNil(t, b, "basket '%v' should be created", basket) {
 			config := b.Config()
 			assert.Equal(t, 30, config.Capacity, "wrong basket capacity")
@@ -111,7 +140,7 @@ func TestCreateBasket_Forbidden(t *testing.T) {
 		assert.Equal(t, 403, w.Code, "wrong HTTP result code")
 		assert.Equal(t, "This basket name conflicts with reserved system path: "+basket+"\n", w.Body.String(), "wrong error message")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -129,7 +158,7 @@ func TestCreateBasket_InvalidName(t *testing.T) {
 		assert.Equal(t, "invalid basket name; the name does not match pattern: "+validBasketName.String()+"\n", w.Body.String(),
 			"wrong error message")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -166,7 +195,7 @@ func TestCreateBasket_InvalidCapacity(t *testing.T) {
 		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "capacity should be a positive number", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -185,7 +214,7 @@ func TestCreateBasket_ExceedCapacityLimit(t *testing.T) {
 		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "capacity may not be greater than", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -204,7 +233,7 @@ func TestCreateBasket_InvalidForwardUrl(t *testing.T) {
 		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "invalid URI", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -223,36 +252,17 @@ func TestCreateBasket_BrokenJson(t *testing.T) {
 		assert.Equal(t, 400, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "unexpected end of JSON input", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
 func TestCreateBasket_ConfigOutOfLimit(t *testing.T) {
 	basket := "create08"
 
-	// only first 2048 bytes of config are read, bigger amount is truncated; this leads to an invalid JSON
+	// only first 64 kB of config are read, bigger amount is truncated; this leads to an invalid JSON
 	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
-		strings.NewReader("{\"capacity\": 300, \"forward_url\": \"http://localhost:8080/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/"+
-			"1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890/1234567890abcd\"}"))
+		strings.NewReader("{\"capacity\": 300, \"forward_url\": \"http://localhost:8080/"+
+			strings.Repeat("1234567890/", 6000)+"1234567890abcd\"}"))
 
 	if assert.NoError(t, err) {
 		w := httptest.NewRecorder()
@@ -263,7 +273,7 @@ func TestCreateBasket_ConfigOutOfLimit(t *testing.T) {
 		assert.Equal(t, 400, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "unexpected end of JSON input", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -282,7 +292,7 @@ func TestCreateBasket_ReadTimeout(t *testing.T) {
 		assert.Equal(t, 500, w.Code, "wrong HTTP result code")
 		assert.Contains(t, w.Body.String(), "timeout\n", "error message is incomplete")
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 }
 
@@ -300,7 +310,7 @@ func TestCreateBasket_Unauthorized(t *testing.T) {
 		assert.Equal(t, 401, w.Code, "wrong HTTP result code")
 
 		// validate database
-		assert.Nil(t, basketsDb.Get(basket), "basket '%v' should not be created", basket)
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
 	}
 
 	serverConfig.Mode = ModePublic
@@ -322,7 +332,7 @@ func TestCreateBasket_Authorized(t *testing.T) {
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
 
 		// validate database
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' should be created", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should be created", basket)
 	}
 
 	serverConfig.Mode = ModePublic
@@ -440,6 +450,40 @@ func TestGetBasket_BadRequest(t *testing.T) {
 	}
 }
 
+func TestGetBasket_ExpiryDate(t *testing.T) {
+	serverConfig.BasketTTL = time.Hour
+	defer func() { serverConfig.BasketTTL = 0 }()
+
+	for basket, pinned := range map[string]bool{"get06": false, "get07": true} {
+		auth, err := testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20, Pinned: pinned})
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		r, err := http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			GetBasket(w, r, ps)
+
+			// validate response: 200 - OK
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+
+			details := new(BasketDetails)
+			if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), details), "Failed to parse GetBasket response") {
+				assert.Equal(t, pinned, details.Pinned, "wrong value of Pinned flag")
+				if pinned {
+					assert.Zero(t, details.ExpiryDate, "pinned basket is not expected to expire")
+				} else {
+					expiry := time.Now().Add(time.Hour).UnixNano() / toMs
+					assert.InDelta(t, expiry, details.ExpiryDate, 5000, "wrong expiry date")
+				}
+			}
+		}
+	}
+}
+
 func TestUpdateBasket(t *testing.T) {
 	basket := "update01"
 
@@ -467,7 +511,7 @@ func TestUpdateBasket(t *testing.T) {
 				assert.Equal(t, 204, w.Code, "wrong HTTP result code")
 
 				// validate update
-				config := basketsDb.Get(basket).Config()
+				config := testDB(t, basketsDb).Get(basket).Config()
 				assert.Equal(t, 50, config.Capacity, "wrong basket capacity")
 				assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
 				assert.True(t, config.ExpandPath, "wrong value of Expand Path flag")
@@ -478,6 +522,37 @@ func TestUpdateBasket(t *testing.T) {
 	}
 }
 
+func TestUpdateBasket_ReplaceConfig(t *testing.T) {
+	basket := "update09"
+	auth, err := testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20, ForwardURL: "http://localhost:12345",
+		HeaderRules:  []HeaderRule{{Action: HeaderRuleSet, Name: "X-Basket", Value: "test"}},
+		ForwardRules: []ForwardRule{{Name: "events", Headers: map[string]string{"X-A": "1"}}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// omitted header rules and header conditions are removed
+	r, err := http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":30,\"forward_rules\":[{\"name\":\"events\",\"headers\":{\"X-B\":\"2\"}}]}"))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", auth.Token)
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		UpdateBasket(w, r, ps)
+
+		// validate response: 204 - No Content
+		assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+
+		config := testDB(t, basketsDb).Get(basket).Config()
+		assert.Equal(t, 30, config.Capacity, "wrong basket capacity")
+		assert.Empty(t, config.ForwardURL, "forward URL is not expected")
+		assert.Empty(t, config.HeaderRules, "header rules are not expected")
+		if assert.Len(t, config.ForwardRules, 1, "forward rule is expected") {
+			assert.Equal(t, map[string]string{"X-B": "2"}, config.ForwardRules[0].Headers, "wrong header conditions")
+		}
+	}
+}
+
 func TestUpdateBasket_EmptyConfig(t *testing.T) {
 	basket := "update02"
 
@@ -504,7 +579,7 @@ func TestUpdateBasket_EmptyConfig(t *testing.T) {
 				assert.Equal(t, 304, w.Code, "wrong HTTP result code")
 
 				// validate update
-				config := basketsDb.Get(basket).Config()
+				config := testDB(t, basketsDb).Get(basket).Config()
 				assert.Equal(t, 200, config.Capacity, "wrong basket capacity")
 				assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
 				assert.False(t, config.ExpandPath, "wrong value of Expand Path flag")
@@ -540,7 +615,7 @@ func TestUpdateBasket_BrokenJson(t *testing.T) {
 				assert.Equal(t, 400, w.Code, "wrong HTTP result code")
 
 				// validate update
-				config := basketsDb.Get(basket).Config()
+				config := testDB(t, basketsDb).Get(basket).Config()
 				assert.Equal(t, 200, config.Capacity, "wrong basket capacity")
 				assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
 				assert.False(t, config.ExpandPath, "wrong value of Expand Path flag")
@@ -577,7 +652,7 @@ func TestUpdateBasket_ReadTimeout(t *testing.T) {
 				assert.Equal(t, 500, w.Code, "wrong HTTP result code")
 
 				// validate update
-				config := basketsDb.Get(basket).Config()
+				config := testDB(t, basketsDb).Get(basket).Config()
 				assert.Equal(t, 200, config.Capacity, "wrong basket capacity")
 				assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
 				assert.False(t, config.ExpandPath, "wrong value of Expand Path flag")
@@ -614,7 +689,7 @@ func TestUpdateBasket_InvalidConfig(t *testing.T) {
 				assert.Equal(t, 422, w.Code, "wrong HTTP result code")
 
 				// validate update
-				config := basketsDb.Get(basket).Config()
+				config := testDB(t, basketsDb).Get(basket).Config()
 				assert.Equal(t, 200, config.Capacity, "wrong basket capacity")
 				assert.False(t, config.InsecureTLS, "wrong value of Insecure TLS flag")
 				assert.False(t, config.ExpandPath, "wrong value of Expand Path flag")
@@ -624,6 +699,196 @@ func TestUpdateBasket_InvalidConfig(t *testing.T) {
 	}
 }
 
+func TestExportBasket_Handler(t *testing.T) {
+	basket := "export01"
+	auth, err := testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20, Signing: &SigningConfig{Secret: "abc"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	testDB(t, basketsDb).Get(basket).Add(createTestRequestData("/"+basket, "data"))
+
+	// archives are imported under these names
+	copies := map[string]string{auth.Token: "export04", serverConfig.MasterToken: "export05"}
+	for token, secret := range map[string]string{auth.Token: "", serverConfig.MasterToken: "abc"} {
+		r, err := http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket+"/export", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", token)
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			ExportBasket(w, r, ps)
+
+			// validate response: 200 - OK
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+			assert.Equal(t, "attachment; filename=\"export01.json\"", w.Header().Get("Content-Disposition"), "wrong Content-Disposition")
+
+			archive := new(BasketArchive)
+			if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), archive), "Failed to parse ExportBasket response") {
+				assert.Equal(t, archiveVersion, archive.Version, "wrong archive version")
+				if len(secret) > 0 {
+					assert.Equal(t, secret, archive.Config.Signing.Secret, "wrong secret of request signing")
+					assert.Empty(t, archive.Warnings, "warnings are not expected")
+				} else {
+					assert.Nil(t, archive.Config.Signing, "signing without secret is not expected")
+					assert.Len(t, archive.Warnings, 1, "warning about signing is expected")
+				}
+				assert.Len(t, archive.Requests, 1, "wrong number of requests")
+			}
+
+			// exported archive can be imported
+			name := copies[token]
+			r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+name+"/import", bytes.NewReader(w.Body.Bytes()))
+			w = httptest.NewRecorder()
+			ImportBasket(w, r, append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: name}))
+			assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+			if imported := testDB(t, basketsDb).Get(name); assert.NotNil(t, imported, "basket '%v' should be imported", name) {
+				if len(secret) > 0 && assert.NotNil(t, imported.Config().Signing, "signing is expected") {
+					assert.Equal(t, secret, imported.Config().Signing.Secret, "wrong secret of request signing")
+				} else if len(secret) == 0 {
+					assert.Nil(t, imported.Config().Signing, "signing is not expected")
+				}
+				assert.Equal(t, 1, imported.Size(), "wrong number of imported requests")
+			}
+		}
+	}
+}
+
+func TestExportBasket_Unauthorized(t *testing.T) {
+	basket := "export02"
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20})
+
+	r, err := http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket+"/export", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", "wrong_token")
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		ExportBasket(w, r, ps)
+
+		// validate response: 401 - unauthorized
+		assert.Equal(t, 401, w.Code, "wrong HTTP result code")
+	}
+}
+
+func TestImportBasket_Handler(t *testing.T) {
+	basket := "import01"
+
+	archive := fmt.Sprintf("{\"version\":1,\"name\":\"other\",\"config\":{\"capacity\":20},"+
+		"\"responses\":{\"GET\":{\"status\":202,\"body\":\"accepted\"}},"+
+		"\"requests\":[{\"id\":\"req1\",\"date\":1000,\"method\":\"GET\",\"path\":\"/%s\"}]}", basket)
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/import", strings.NewReader(archive))
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		ImportBasket(w, r, ps)
+
+		// validate response: 201 - created
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "\"token\"", "JSON response with token is expected")
+
+		// basket is imported under the name of request path
+		b := testDB(t, basketsDb).Get(basket)
+		if assert.NotNil(t, b, "basket '%v' should be imported", basket) {
+			assert.Equal(t, 20, b.Config().Capacity, "wrong basket capacity")
+			if response := b.GetResponse("GET"); assert.NotNil(t, response, "response is expected") {
+				assert.Equal(t, 202, response.Status, "wrong response status")
+			}
+			assert.NotNil(t, b.GetRequest("req1"), "imported request is expected")
+		}
+		assert.Nil(t, testDB(t, basketsDb).Get("other"), "basket is not expected under the name of archive")
+
+		// conflict
+		r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/import", strings.NewReader(archive))
+		w = httptest.NewRecorder()
+		ImportBasket(w, r, ps)
+		assert.Equal(t, 409, w.Code, "wrong HTTP result code")
+	}
+}
+
+func TestImportBasket_InvalidArchive(t *testing.T) {
+	archives := map[string]int{
+		"import02": 400, // broken JSON
+		"import03": 422, // unsupported version
+		"import04": 422, // invalid capacity
+		"api":      403, // reserved name
+		"import~5": 400, // invalid name
+	}
+	content := map[string]string{
+		"import02": "{\"version\":1,",
+		"import03": "{\"version\":5,\"config\":{\"capacity\":20}}",
+		"import04": "{\"version\":1,\"config\":{\"capacity\":0}}",
+	}
+
+	for basket, code := range archives {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/import", strings.NewReader(content[basket]))
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			ImportBasket(w, r, ps)
+
+			assert.Equal(t, code, w.Code, "wrong HTTP result code for basket: %s", basket)
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is not expected", basket)
+		}
+	}
+}
+
+func TestExportImportBaskets_Handler(t *testing.T) {
+	basket := "export03"
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20})
+
+	r, err := http.NewRequest("GET", "http://localhost:55555/api/export", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", serverConfig.MasterToken)
+		w := httptest.NewRecorder()
+		ExportBaskets(w, r, make(httprouter.Params, 0))
+
+		// validate response: 200 - OK
+		assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+		assert.Equal(t, "application/x-ndjson; charset=UTF-8", w.Header().Get("Content-Type"), "wrong Content-Type")
+		assert.Contains(t, w.Body.String(), "\"name\":\""+basket+"\"", "archive of basket is expected")
+
+		// import into the same database fails for existing baskets
+		stream := w.Body.String()
+		r, err = http.NewRequest("POST", "http://localhost:55555/api/import", strings.NewReader(stream))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", serverConfig.MasterToken)
+			w = httptest.NewRecorder()
+			ImportBaskets(w, r, make(httprouter.Params, 0))
+
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+			result := new(ImportResult)
+			if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result), "Failed to parse ImportBaskets response") {
+				assert.Empty(t, result.Baskets, "no baskets are expected to be imported")
+				assert.Contains(t, result.Failed, basket, "existing basket is expected to fail")
+			}
+		}
+	}
+}
+
+func TestExportImportBaskets_Unauthorized(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://localhost:55555/api/export", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ExportBaskets(w, r, make(httprouter.Params, 0))
+		assert.Equal(t, 401, w.Code, "wrong HTTP result code")
+	}
+
+	r, err = http.NewRequest("POST", "http://localhost:55555/api/import", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ImportBaskets(w, r, make(httprouter.Params, 0))
+		assert.Equal(t, 401, w.Code, "wrong HTTP result code")
+	}
+}
+
+func TestImportBaskets_MalformedStream(t *testing.T) {
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/import", strings.NewReader("{\"version\":"))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", serverConfig.MasterToken)
+		w := httptest.NewRecorder()
+		ImportBaskets(w, r, make(httprouter.Params, 0))
+		assert.Equal(t, 400, w.Code, "wrong HTTP result code")
+	}
+}
+
 func TestDeleteBasket(t *testing.T) {
 	basket := "delete01"
 
@@ -634,7 +899,7 @@ func TestDeleteBasket(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		// get auth token
 		auth := new(BasketAuth)
@@ -651,7 +916,7 @@ func TestDeleteBasket(t *testing.T) {
 				assert.Equal(t, 204, w.Code, "wrong HTTP result code")
 
 				// validate deletion
-				assert.Nil(t, basketsDb.Get(basket), "basket '%v' is not expected", basket)
+				assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is not expected", basket)
 			}
 		}
 	}
@@ -683,7 +948,7 @@ func TestDeleteBasket_Unauthorized(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		r, err = http.NewRequest("DELETE", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
 		if assert.NoError(t, err) {
@@ -695,7 +960,7 @@ func TestDeleteBasket_Unauthorized(t *testing.T) {
 			assert.Equal(t, 401, w.Code, "wrong HTTP result code")
 
 			// validate not deleted
-			assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+			assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 		}
 	}
 }
@@ -881,7 +1146,7 @@ func TestGetBaskets_Page(t *testing.T) {
 			// validate response
 			assert.NotEmpty(t, names.Names, "names are expected")
 			assert.Len(t, names.Names, 5, "unexpected number of found baskets")
-			assert.Equal(t, names.Count, basketsDb.Size(), "wrong count of baskets")
+			assert.Equal(t, names.Count, testDB(t, basketsDb).Size(), "wrong count of baskets")
 			assert.True(t, names.HasMore, "more names are expected")
 		}
 	}
@@ -897,7 +1162,7 @@ func TestGetBasketRequests(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		// get auth token
 		auth := new(BasketAuth)
@@ -944,7 +1209,7 @@ func TestGetBasketRequests_Query(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		// get auth token
 		auth := new(BasketAuth)
@@ -992,7 +1257,7 @@ func TestGetBasketRequests_Page(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		// get auth token
 		auth := new(BasketAuth)
@@ -1041,7 +1306,7 @@ func TestClearBasket(t *testing.T) {
 
 		CreateBasket(w, r, ps)
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
-		assert.NotNil(t, basketsDb.Get(basket), "basket '%v' is expected", basket)
+		assert.NotNil(t, testDB(t, basketsDb).Get(basket), "basket '%v' is expected", basket)
 
 		// get auth token
 		auth := new(BasketAuth)
@@ -1107,6 +1372,58 @@ func TestAcceptBasketRequests_BadRequest(t *testing.T) {
 		"wrong error message")
 }
 
+func TestHandlers_StorageError(t *testing.T) {
+	basket := "storage01"
+	file := filepath.Join(os.TempDir(), "rbaskets_storage.db")
+	defer os.Remove(file)
+
+	// database becomes unavailable after the basket is created
+	db := NewSQLDatabase("sqlite://" + file)
+	if !assert.NotNil(t, db, "SQL database is expected") {
+		return
+	}
+	_, err := db.Create(context.Background(), basket, BasketConfig{Capacity: 20})
+	assert.NoError(t, err)
+	db.Release()
+
+	shared := basketsDb
+	basketsDb = db
+	defer func() { basketsDb = shared }()
+
+	ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+
+	// HTTP 500 - incoming request cannot be collected
+	w := httptest.NewRecorder()
+	AcceptBasketRequests(w, createTestPOSTRequest("http://localhost:55555/"+basket, "my data", "text/plain"))
+	assert.Equal(t, 500, w.Code, "wrong HTTP result code")
+
+	// HTTP 500 - basket cannot be created
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/storage02", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		w = httptest.NewRecorder()
+		CreateBasket(w, r, append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: "storage02"}))
+		assert.Equal(t, 500, w.Code, "wrong HTTP result code")
+	}
+
+	// HTTP 500 - basket cannot be fetched
+	r, err = http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket+"/requests", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", serverConfig.MasterToken)
+		w = httptest.NewRecorder()
+		GetBasketRequests(w, r, ps)
+		assert.Equal(t, 500, w.Code, "wrong HTTP result code")
+	}
+
+	// HTTP 500 - basket names cannot be fetched
+	r, err = http.NewRequest("GET", "http://localhost:55555/api/baskets", strings.NewReader(""))
+	if assert.NoError(t, err) {
+		r.Header.Add("Authorization", serverConfig.MasterToken)
+		w = httptest.NewRecorder()
+		GetBaskets(w, r, make(httprouter.Params, 0))
+		assert.Equal(t, 500, w.Code, "wrong HTTP result code")
+	}
+}
+
 func TestForwardToWeb(t *testing.T) {
 	r, err := http.NewRequest("GET", "http://localhost:55555/", strings.NewReader(""))
 	if assert.NoError(t, err) {
@@ -1310,7 +1627,7 @@ func TestUpdateBasketResponse(t *testing.T) {
 				assert.Equal(t, 204, w.Code, "wrong HTTP result code")
 
 				// validate database update
-				response := basketsDb.Get(basket).GetResponse(method)
+				response := testDB(t, basketsDb).Get(basket).GetResponse(method)
 				assert.Equal(t, 404, response.Status, "wrong response status")
 				assert.Equal(t, "application/xml", response.Headers["Content-Type"][0], "wrong Content-Type header")
 				assert.Equal(t, "<error><code>404</code><message>Not Found</message></error>", response.Body, "wrong response body")
@@ -1353,7 +1670,7 @@ func TestUpdateBasketResponse_InvalidMethod(t *testing.T) {
 				assert.Contains(t, w.Body.String(), "unknown HTTP method: "+method, "wrong response message")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1392,7 +1709,7 @@ func TestUpdateBasketResponse_BrokenJson(t *testing.T) {
 				assert.Contains(t, w.Body.String(), "invalid character '<'", "wrong response message")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1431,7 +1748,7 @@ func TestUpdateBasketResponse_InvalidConfig(t *testing.T) {
 				assert.Contains(t, w.Body.String(), "invalid HTTP status of response: 20", "wrong response message")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1470,7 +1787,7 @@ func TestUpdateBasketResponse_InvalidTemplate(t *testing.T) {
 				assert.Contains(t, w.Body.String(), "error in body template: body:1: function \"data\" not defined", "wrong response message")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1506,7 +1823,7 @@ func TestUpdateBasketResponse_NotModified(t *testing.T) {
 				assert.Equal(t, 304, w.Code, "wrong HTTP result code")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1543,7 +1860,7 @@ func TestUpdateBasketResponse_ReadTimeout(t *testing.T) {
 				assert.Equal(t, 500, w.Code, "wrong HTTP result code")
 
 				// validate database is not updated
-				assert.Nil(t, basketsDb.Get(basket).GetResponse(method), "response configuration is not expected")
+				assert.Nil(t, testDB(t, basketsDb).Get(basket).GetResponse(method), "response configuration is not expected")
 			}
 		}
 	}
@@ -1767,6 +2084,17 @@ func TestAcceptBasketRequests_WithProxyResponse(t *testing.T) {
 			// validate expected response
 			assert.Equal(t, 202, w.Code, "wrong HTTP response code")
 			assert.Equal(t, "server test response", string(responseBody), "wrong response body")
+
+			// result of forwarding is kept with collected request
+			page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0)
+			if assert.Equal(t, 1, len(page.Requests), "collected request is expected") &&
+				assert.Equal(t, 1, len(page.Requests[0].Forwards), "result of forwarding is expected") {
+				result := page.Requests[0].Forwards[0]
+				assert.Equal(t, ts.URL+"/service/articles/123?from="+basket+"&sig=abcdge3276542", result.URL, "wrong forward URL")
+				assert.Equal(t, 202, result.Status, "wrong status of target response")
+				assert.Equal(t, "server test response", result.Body, "wrong body of target response")
+				assert.Empty(t, result.Error, "no error is expected")
+			}
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
@@ -1828,6 +2156,14 @@ func TestAcceptBasketRequests_WithProxyResponse_BadGateway(t *testing.T) {
 			assert.Contains(t, w.Body.String(), "Failed to forward request", "wrong HTTP response body")
 			assert.Contains(t, w.Body.String(), forwardURL, "wrong HTTP response body")
 			assert.Contains(t, w.Body.String(), "connection refused", "wrong HTTP response body")
+
+			// transport error is kept with collected request
+			page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0)
+			if assert.Equal(t, 1, len(page.Requests), "collected request is expected") &&
+				assert.Equal(t, 1, len(page.Requests[0].Forwards), "result of forwarding is expected") {
+				assert.Equal(t, 0, page.Requests[0].Forwards[0].Status, "no status is expected")
+				assert.Contains(t, page.Requests[0].Forwards[0].Error, "connection refused", "wrong error of forwarding")
+			}
 		}
 	}
 }
@@ -1845,7 +2181,7 @@ func TestAcceptBasketRequests_WithForward_InternalServerError(t *testing.T) {
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
 
 		// set invalid forward URL directly
-		b := basketsDb.Get(basket)
+		b := testDB(t, basketsDb).Get(basket)
 		b.Update(BasketConfig{Capacity: 20, ForwardURL: "qwert"})
 
 		// send request and validate forwarding
@@ -1874,7 +2210,7 @@ func TestAcceptBasketRequests_WithProxyResponse_InternalServerError(t *testing.T
 		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
 
 		// set invalid forward URL directly
-		b := basketsDb.Get(basket)
+		b := testDB(t, basketsDb).Get(basket)
 		b.Update(BasketConfig{Capacity: 20, ForwardURL: "qwert", ProxyResponse: true})
 
 		// send request and validate forwarding
@@ -1995,3 +2331,984 @@ func TestCreateTemplateData(t *testing.T) {
 }
 `, result.String())
 }
+
+func TestCreateBasket_InvalidForwardTargets(t *testing.T) {
+	basket := "create12"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"forward_targets\":[{\"url\":\"http://localhost:12345/a\",\"primary\":true},"+
+			"{\"url\":\"http://localhost:12345/b\",\"primary\":true}]}"))
+
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+
+		// validate response: 422 - Unprocessable Entity
+		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "only one forward target can be marked as primary", "error message is incomplete")
+		// validate database
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+	}
+}
+
+func TestCreateBasket_InvalidForwardTargetUrl(t *testing.T) {
+	basket := "create13"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"forward_targets\":[{\"url\":\"http://localhost:12345/a\"},{\"url\":\".,?-7\"}]}"))
+
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+
+		// validate response: 422 - Unprocessable Entity
+		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "invalid URL of forward target #2", "error message is incomplete")
+		// validate database
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+	}
+}
+
+func TestCreateBasket_ProxyResponseWithoutPrimaryTarget(t *testing.T) {
+	basket := "create14"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"proxy_response\":true,\"forward_targets\":[{\"url\":\"http://localhost:12345/a\"},"+
+			"{\"url\":\"http://localhost:12345/b\"}]}"))
+
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+
+		// validate response: 422 - Unprocessable Entity
+		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "should be marked as primary", "error message is incomplete")
+		// validate database
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+	}
+}
+
+func TestAcceptBasketRequests_WithForwardTargets(t *testing.T) {
+	basket := "accept12"
+	method := "POST"
+
+	// Test HTTP servers
+	forwarded := make(chan *RequestData, 2)
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts2.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"forward_url\":\""+ts1.URL+"/staging\",\"capacity\":200,\"forward_targets\":[{\"url\":\""+
+			ts2.URL+"/dev\",\"expand_path\":true}]}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		// send request and validate forwarding
+		r, err = http.NewRequest(method, "http://localhost:55555/"+basket+"/hooks", strings.NewReader("event"))
+		if assert.NoError(t, err) {
+			w = httptest.NewRecorder()
+			AcceptBasketRequests(w, r)
+			// validate expected response
+			assert.Equal(t, 200, w.Code, "wrong HTTP response code")
+
+			paths := make([]string, 0, 2)
+			for i := 0; i < 2; i++ {
+				select {
+				case data := <-forwarded:
+					assert.Equal(t, "event", data.Body, "wrong request body")
+					paths = append(paths, data.Path)
+				case <-time.After(time.Second):
+					assert.Fail(t, "request is not forwarded to all targets")
+				}
+			}
+			assert.ElementsMatch(t, []string{"/staging", "/dev/hooks"}, paths, "wrong forwarded paths")
+		}
+	}
+}
+
+func TestAcceptBasketRequests_WithForwardTargets_ProxyPrimary(t *testing.T) {
+	basket := "accept13"
+
+	// Test HTTP servers
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("staging response"))
+	}))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("primary response"))
+	}))
+	defer ts2.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"forward_url\":\""+ts1.URL+"\",\"proxy_response\":true,\"capacity\":200,\"forward_targets\":[{\"url\":\""+
+			ts2.URL+"\",\"primary\":true}]}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		r, err = http.NewRequest("GET", "http://localhost:55555/"+basket, strings.NewReader(""))
+		if assert.NoError(t, err) {
+			w = httptest.NewRecorder()
+			AcceptBasketRequests(w, r)
+
+			// validate expected response of primary target
+			assert.Equal(t, 202, w.Code, "wrong HTTP response code")
+			assert.Equal(t, "primary response", w.Body.String(), "wrong response body")
+		}
+	}
+}
+
+func TestCreateBasket_InvalidRetryCount(t *testing.T) {
+	basket := "create15"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"retry_count\":100}"))
+
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+
+		// validate response: 422 - Unprocessable Entity
+		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "retry count should be in range", "error message is incomplete")
+		// validate database
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+	}
+}
+
+func TestGetBasketDeadLetters(t *testing.T) {
+	basket := "deadletters01"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		json.Unmarshal(w.Body.Bytes(), auth)
+
+		testDB(t, basketsDb).Get(basket).AddDeadLetter(&DeadLetter{ID: "letter1", Attempts: 3, Error: "HTTP 503",
+			Target: ForwardTarget{URL: "http://localhost:55556"}, Request: createTestRequestData("/"+basket, "abc")})
+
+		r, err = http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket+"/deadletters", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			GetBasketDeadLetters(w, r, ps)
+
+			// validate response: 200 - OK
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+			page := new(DeadLettersPage)
+			if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), page), "Failed to parse GetBasketDeadLetters response") {
+				assert.Equal(t, 1, page.Count, "wrong number of dead letters")
+				assert.False(t, page.HasMore, "no more dead letters are expected")
+				if assert.Equal(t, 1, len(page.DeadLetters), "wrong number of dead letters") {
+					assert.Equal(t, "letter1", page.DeadLetters[0].ID, "wrong dead letter ID")
+					assert.Equal(t, "abc", page.DeadLetters[0].Request.Body, "wrong request body")
+				}
+			}
+		}
+
+		// clear
+		r, err = http.NewRequest("DELETE", "http://localhost:55555/api/baskets/"+basket+"/deadletters", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			ClearBasketDeadLetters(w, r, ps)
+
+			// validate response: 204 - No Content
+			assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+			assert.Equal(t, 0, testDB(t, basketsDb).Get(basket).GetDeadLetters(10, 0).Count, "dead letters are not expected")
+		}
+	}
+}
+
+func TestRedeliverDeadLetter(t *testing.T) {
+	basket := "deadletters02"
+
+	// Test HTTP server
+	var forwardedData *RequestData
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedData = ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		json.Unmarshal(w.Body.Bytes(), auth)
+
+		b := testDB(t, basketsDb).Get(basket)
+		b.AddDeadLetter(&DeadLetter{ID: "down", Target: ForwardTarget{URL: "http://localhost:55556"},
+			Request: createTestRequestData("/"+basket, "abc")})
+		b.AddDeadLetter(&DeadLetter{ID: "up", Target: ForwardTarget{URL: ts.URL + "/hooks"},
+			Request: createTestRequestData("/"+basket, "xyz")})
+
+		// successful redelivery
+		r, err = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/deadletters/up", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			RedeliverDeadLetter(w, r, append(ps, httprouter.Param{Key: "id", Value: "up"}))
+
+			// validate response: 204 - No Content
+			assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+			if assert.NotNil(t, forwardedData, "request is not delivered") {
+				assert.Equal(t, "/hooks", forwardedData.Path, "wrong request path")
+				assert.Equal(t, "xyz", forwardedData.Body, "wrong request body")
+			}
+			assert.Nil(t, b.GetDeadLetter("up"), "delivered request should be removed")
+		}
+
+		// failed redelivery
+		r, err = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/deadletters/down", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			RedeliverDeadLetter(w, r, append(ps, httprouter.Param{Key: "id", Value: "down"}))
+
+			// validate response: 502 - Bad Gateway
+			assert.Equal(t, 502, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), "Failed to redeliver request", "wrong HTTP response body")
+			assert.NotNil(t, b.GetDeadLetter("down"), "undelivered request should be kept")
+		}
+
+		// unknown
+		r, err = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/deadletters/abc", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			RedeliverDeadLetter(w, r, append(ps, httprouter.Param{Key: "id", Value: "abc"}))
+
+			// validate response: 404 - Not Found
+			assert.Equal(t, 404, w.Code, "wrong HTTP result code")
+		}
+	}
+}
+
+func TestDeleteDeadLetter(t *testing.T) {
+	basket := "deadletters03"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		json.Unmarshal(w.Body.Bytes(), auth)
+
+		b := testDB(t, basketsDb).Get(basket)
+		b.AddDeadLetter(&DeadLetter{ID: "letter1", Request: createTestRequestData("/"+basket, "abc")})
+
+		r, err = http.NewRequest("DELETE", "http://localhost:55555/api/baskets/"+basket+"/deadletters/letter1", strings.NewReader(""))
+		if assert.NoError(t, err) {
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			DeleteDeadLetter(w, r, append(ps, httprouter.Param{Key: "id", Value: "letter1"}))
+
+			// validate response: 204 - No Content
+			assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+			assert.Nil(t, b.GetDeadLetter("letter1"), "dead letter should be removed")
+
+			// already removed
+			w = httptest.NewRecorder()
+			DeleteDeadLetter(w, r, append(ps, httprouter.Param{Key: "id", Value: "letter1"}))
+			assert.Equal(t, 404, w.Code, "wrong HTTP result code")
+		}
+	}
+}
+
+func TestCreateBasket_InvalidHeaderRules(t *testing.T) {
+	basket := "create16"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"header_rules\":[{\"action\":\"replace\",\"name\":\"X-Test\",\"value\":\"1\"}]}":                                                 "unknown action of header rule #1",
+		"{\"capacity\":20,\"header_rules\":[{\"action\":\"set\",\"name\":\"X Test\",\"value\":\"1\"}]}":                                                     "invalid header name in rule #1",
+		"{\"capacity\":20,\"header_rules\":[{\"action\":\"remove\",\"name\":\"Cookie\"},{\"action\":\"set\",\"name\":\"X-Test\",\"value\":\"{{.method\"}]}": "invalid value template in header rule #2",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestCreateBasket_InvalidTransformation(t *testing.T) {
+	basket := "create17"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"forward_body_template\":\"{{.body\"}":             "invalid template of forwarded request body",
+		"{\"capacity\":20,\"forward_method\":\"GET POST\"}":                   "invalid HTTP method of forwarded request",
+		"{\"capacity\":20,\"forward_content_type\":\"application/json; =x\"}": "invalid content type of forwarded request",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestAcceptBasketRequests_WithForwardRules(t *testing.T) {
+	basket := "accept14"
+
+	// Test HTTP servers
+	ciForwarded := make(chan *RequestData, 2)
+	ts1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ciForwarded <- ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts1.Close()
+	reviewForwarded := make(chan *RequestData, 2)
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reviewForwarded <- ToRequestData(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts2.Close()
+
+	// only push and pull request events are forwarded
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":200,\"forward_rules\":["+
+			"{\"name\":\"push\",\"headers\":{\"X-GitHub-Event\":\"^push$\"},\"targets\":[{\"url\":\""+ts1.URL+"/ci\"}]},"+
+			"{\"name\":\"pr\",\"method\":\"post\",\"headers\":{\"X-GitHub-Event\":\"^pull_request$\"},\"body\":\"\\\"action\\\":\\\\s*\\\"opened\\\"\","+
+			"\"targets\":[{\"url\":\""+ts2.URL+"/review\"}]}]}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		send := func(event string, body string) {
+			r, err := http.NewRequest("POST", "http://localhost:55555/"+basket, strings.NewReader(body))
+			if assert.NoError(t, err) {
+				r.Header.Add("X-GitHub-Event", event)
+				w := httptest.NewRecorder()
+				AcceptBasketRequests(w, r)
+				assert.Equal(t, 200, w.Code, "wrong HTTP response code")
+			}
+		}
+
+		send("push", "{\"ref\": \"refs/heads/main\"}")
+		send("pull_request", "{\"action\": \"opened\"}")
+		send("pull_request", "{\"action\": \"closed\"}")
+		send("issues", "{\"action\": \"opened\"}")
+
+		select {
+		case data := <-ciForwarded:
+			assert.Equal(t, "/ci", data.Path, "wrong forwarded path")
+			assert.Equal(t, "push", data.Header.Get("X-GitHub-Event"), "wrong event is forwarded to CI")
+		case <-time.After(time.Second):
+			assert.Fail(t, "push event is not forwarded")
+		}
+		select {
+		case data := <-reviewForwarded:
+			assert.Equal(t, "/review", data.Path, "wrong forwarded path")
+			assert.Equal(t, "{\"action\": \"opened\"}", data.Body, "wrong event is forwarded for review")
+		case <-time.After(time.Second):
+			assert.Fail(t, "pull request event is not forwarded")
+		}
+		// no more forwards are expected
+		select {
+		case <-ciForwarded:
+			assert.Fail(t, "unexpected forward to CI")
+		case <-reviewForwarded:
+			assert.Fail(t, "unexpected forward for review")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// fired rules are recorded
+		page := testDB(t, basketsDb).Get(basket).GetRequests(10, 0)
+		if assert.Equal(t, 4, len(page.Requests), "wrong number of collected requests") {
+			assert.Empty(t, page.Requests[0].ForwardRule, "no rule is expected to fire")
+			assert.Empty(t, page.Requests[1].ForwardRule, "no rule is expected to fire")
+			assert.Equal(t, "pr", page.Requests[2].ForwardRule, "wrong fired rule")
+			assert.Equal(t, "push", page.Requests[3].ForwardRule, "wrong fired rule")
+		}
+	}
+}
+
+func TestCreateBasket_InvalidForwardRules(t *testing.T) {
+	basket := "create18"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"forward_rules\":[{\"method\":\"GET\"}]}":                                                                                                     "name of forward rule #1 may not be empty",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"a\"},{\"name\":\"a\"}]}":                                                                                        "name of forward rule #2 is not unique",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"a\",\"path\":\"(abc\"}]}":                                                                                       "invalid path pattern",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"a\",\"headers\":{\"X-Event\":\"[a\"}}]}":                                                                        "invalid pattern of header X-Event",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"a\",\"targets\":[{\"url\":\"qwerty\"}]}]}":                                                                      "invalid URL of forward target #1",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"a\",\"drop\":true,\"targets\":[{\"url\":\"http://localhost\"}]}]}":                                              "rule that drops requests may not define forward targets",
+		"{\"capacity\":20,\"proxy_response\":true,\"forward_rules\":[{\"name\":\"a\",\"targets\":[{\"url\":\"http://localhost/a\"},{\"url\":\"http://localhost/b\"}]}]}": "should be marked as primary",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestCreateBasket_InvalidTransport(t *testing.T) {
+	basket := "create19"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"transport\":{\"connect_timeout\":-1}}":                                  "connect timeout of forwarded request should be in range",
+		"{\"capacity\":20,\"transport\":{\"tls_timeout\":-5}}":                                      "TLS handshake timeout of forwarded request should be in range",
+		"{\"capacity\":20,\"transport\":{\"timeout\":100000000}}":                                   "timeout of forwarded request should be in range",
+		"{\"capacity\":20,\"transport\":{\"follow_redirects\":\"x\"}}":                              "cannot unmarshal",
+		"{\"capacity\":20,\"transport\":{\"proxy\":{\"url\":\"ftp://proxy\"}}}":                     "unsupported proxy scheme",
+		"{\"capacity\":20,\"transport\":{\"proxy\":{\"url\":\"http://u:p@proxy\"}}}":                "not in proxy URL",
+		"{\"capacity\":20,\"transport\":{\"proxy\":{\"username\":\"u\"}}}":                          "proxy credentials require proxy URL",
+		"{\"capacity\":20,\"transport\":{\"proxy\":{\"url\":\"http://proxy\",\"password\":\"p\"}}}": "proxy password requires username",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 400 - Bad Request or 422 - Unprocessable Entity
+			assert.Contains(t, []int{400, 422}, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestUpdateBasket_TLSSecrets(t *testing.T) {
+	basket := "update06"
+	_, _, certPEM, keyPEM := createTestCertificate(t, "client", nil, nil)
+
+	config := BasketConfig{Capacity: 20, Transport: &TransportConfig{
+		TLS: &TLSConfig{CACerts: certPEM, ClientCert: certPEM, ClientKey: keyPEM}}}
+	body, _ := json.Marshal(config)
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, bytes.NewReader(body))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		// get auth token
+		auth := new(BasketAuth)
+		err = json.Unmarshal(w.Body.Bytes(), auth)
+		if assert.NoError(t, err, "Failed to parse CreateBasket response") {
+			// client key is not exposed
+			r, _ = http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			GetBasket(w, r, ps)
+
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+			assert.NotContains(t, w.Body.String(), "client_key", "client key is not expected")
+			assert.NotContains(t, w.Body.String(), "PRIVATE KEY", "client key is not expected")
+			assert.Contains(t, w.Body.String(), "client_cert", "client certificate is expected")
+
+			// client key remains unchanged if config is updated without key
+			exposed := w.Body.String()
+			r, _ = http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(exposed))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			UpdateBasket(w, r, ps)
+
+			assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+			if transport := testDB(t, basketsDb).Get(basket).Config().Transport; assert.NotNil(t, transport, "transport settings are expected") &&
+				assert.NotNil(t, transport.TLS, "TLS settings are expected") {
+				assert.Equal(t, keyPEM, transport.TLS.ClientKey, "client key is expected to remain unchanged")
+			}
+
+			// config of basket is not modified by invalid update
+			r, _ = http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket,
+				strings.NewReader("{\"capacity\":20,\"transport\":{\"tls\":{\"min_version\":\"0.1\"}}}"))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			UpdateBasket(w, r, ps)
+
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Empty(t, testDB(t, basketsDb).Get(basket).Config().Transport.TLS.MinVersion, "minimum TLS version is not expected")
+		}
+	}
+}
+
+func TestCreateBasket_InvalidTLS(t *testing.T) {
+	basket := "create20"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"transport\":{\"tls_profile\":\"xyz\"}}":            "unknown TLS profile: xyz",
+		"{\"capacity\":20,\"transport\":{\"tls_profile\":\"xyz\",\"tls\":{}}}": "may not be defined together",
+		"{\"capacity\":20,\"transport\":{\"tls\":{\"ca_certs\":\"abc\"}}}":     "no valid CA certificates are found",
+		"{\"capacity\":20,\"transport\":{\"tls\":{\"client_cert\":\"abc\"}}}":  "invalid client certificate or key",
+		"{\"capacity\":20,\"transport\":{\"tls\":{\"min_version\":\"1.5\"}}}":  "unsupported minimum TLS version",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestUpdateBasket_SigningSecret(t *testing.T) {
+	basket := "update07"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"signing\":{\"header\":\"X-Signature\",\"secret\":\"top-secret\"}}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		// get auth token
+		auth := new(BasketAuth)
+		err = json.Unmarshal(w.Body.Bytes(), auth)
+		if assert.NoError(t, err, "Failed to parse CreateBasket response") {
+			// secret is not exposed
+			r, _ = http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			GetBasket(w, r, ps)
+
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+			assert.NotContains(t, w.Body.String(), "top-secret", "signing secret is not expected")
+			assert.Contains(t, w.Body.String(), "X-Signature", "signature header is expected")
+
+			// secret remains unchanged if config is updated without secret
+			r, _ = http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket,
+				strings.NewReader("{\"capacity\":20,\"signing\":{\"header\":\"X-Signature\",\"algorithm\":\"sha512\"}}"))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			UpdateBasket(w, r, ps)
+
+			assert.Equal(t, 204, w.Code, "wrong HTTP result code")
+			if signing := testDB(t, basketsDb).Get(basket).Config().Signing; assert.NotNil(t, signing, "signing settings are expected") {
+				assert.Equal(t, "top-secret", signing.Secret, "signing secret is expected to remain unchanged")
+				assert.Equal(t, "sha512", signing.Algorithm, "wrong signing algorithm")
+				assert.Equal(t, "X-Signature", signing.Header, "wrong signature header")
+			}
+		}
+	}
+}
+
+func TestCreateBasket_InvalidSigning(t *testing.T) {
+	basket := "create21"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"signing\":{}}":                                       "secret of request signing is not defined",
+		"{\"capacity\":20,\"signing\":{\"algorithm\":\"md5\",\"secret\":\"s\"}}": "unsupported signing algorithm: md5",
+		"{\"capacity\":20,\"signing\":{\"format\":\"{{.X\",\"secret\":\"s\"}}":   "invalid format of signature",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestCreateBasket_InvalidWebSocket(t *testing.T) {
+	basket := "create23"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"capacity\":20,\"websocket\":{\"mode\":\"mirror\"}}"))
+
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+
+		// validate response: 422 - Unprocessable Entity
+		assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+		assert.Contains(t, w.Body.String(), "unknown WebSocket mode: mirror", "error message is incomplete")
+		// validate database
+		assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+	}
+}
+
+func TestCreateBasket_InvalidCircuitBreaker(t *testing.T) {
+	basket := "create24"
+
+	for body, message := range map[string]string{
+		"{\"capacity\":20,\"rate_limit\":-1}":                              "invalid rate limit: -1",
+		"{\"capacity\":20,\"max_age\":-1}":                                 "invalid max age of requests: -1",
+		"{\"capacity\":20,\"circuit_breaker\":{\"failure_threshold\":-2}}": "invalid failure threshold of circuit breaker: -2",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), message, "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestGetBasketStatus(t *testing.T) {
+	basket := "status01"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(
+		"{\"forward_url\":\""+ts.URL+"\",\"proxy_response\":true,\"capacity\":20,"+
+			"\"circuit_breaker\":{\"failure_threshold\":1,\"open_duration\":60000}}"))
+	if assert.NoError(t, err) {
+		w := httptest.NewRecorder()
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		err = json.Unmarshal(w.Body.Bytes(), auth)
+		if assert.NoError(t, err, "Failed to parse CreateBasket response") {
+			// failure of target opens the circuit
+			r, _ = http.NewRequest("POST", "http://localhost:55555/"+basket, strings.NewReader("first"))
+			w = httptest.NewRecorder()
+			AcceptBasketRequests(w, r)
+			assert.Equal(t, 500, w.Code, "response of target is expected")
+
+			r, _ = http.NewRequest("POST", "http://localhost:55555/"+basket, strings.NewReader("second"))
+			w = httptest.NewRecorder()
+			AcceptBasketRequests(w, r)
+			assert.Equal(t, 503, w.Code, "request is not expected to be forwarded when circuit is open")
+			assert.Contains(t, w.Body.String(), "circuit breaker of target is open", "error message is incomplete")
+
+			page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0)
+			if assert.Equal(t, 1, len(page.Requests)) && assert.Equal(t, 1, len(page.Requests[0].Forwards)) {
+				assert.True(t, page.Requests[0].Forwards[0].Rejected, "rejected forwarding is expected")
+			}
+
+			r, _ = http.NewRequest("GET", "http://localhost:55555/api/baskets/"+basket+"/status", strings.NewReader(""))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			GetBasketStatus(w, r, ps)
+			assert.Equal(t, 200, w.Code, "wrong HTTP result code")
+
+			status := new(BasketStatus)
+			if err = json.Unmarshal(w.Body.Bytes(), status); assert.NoError(t, err, "Failed to parse status response") {
+				if assert.Len(t, status.Targets, 1, "status of target is expected") {
+					assert.Equal(t, ts.URL, status.Targets[0].URL, "wrong URL of target")
+					assert.Equal(t, CircuitOpen, status.Targets[0].State, "circuit is expected to be open")
+					assert.Equal(t, 1, status.Targets[0].Failures, "wrong number of failures")
+				}
+			}
+
+			// state is released with basket
+			r, _ = http.NewRequest("DELETE", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(""))
+			r.Header.Add("Authorization", auth.Token)
+			DeleteBasket(httptest.NewRecorder(), r, ps)
+			forwardGuards.Lock()
+			_, exists := forwardGuards.guards[basket]
+			forwardGuards.Unlock()
+			assert.False(t, exists, "state of forwarding is not expected after basket is deleted")
+		}
+	}
+}
+
+func TestReplayRequest(t *testing.T) {
+	basket := "replay01"
+
+	var received *http.Request
+	var receivedBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received, receivedBody = r, string(body)
+		w.Header().Set("X-Target", "test")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("replayed"))
+	}))
+	defer ts.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader("{\"capacity\":20}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		err = json.Unmarshal(w.Body.Bytes(), auth)
+		if assert.NoError(t, err, "Failed to parse CreateBasket response") {
+			// collect request
+			r, _ = http.NewRequest("POST", "http://localhost:55555/"+basket+"/events", strings.NewReader("{\"event\":\"push\"}"))
+			r.Header.Set("X-Event", "push")
+			AcceptBasketRequests(httptest.NewRecorder(), r)
+
+			page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0)
+			if assert.Equal(t, 1, len(page.Requests), "collected request is expected") {
+				id := page.Requests[0].ID
+				ps = append(ps, httprouter.Param{Key: "id", Value: id})
+
+				// basket has no forward URL
+				r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/"+id+"/replay", strings.NewReader(""))
+				r.Header.Add("Authorization", auth.Token)
+				w = httptest.NewRecorder()
+				ReplayRequest(w, r, ps)
+				assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+
+				// replay with overrides to specified URL
+				r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/"+id+"/replay",
+					strings.NewReader("{\"url\":\""+ts.URL+"/local\",\"headers\":{\"X-Event\":[\"ping\"]},\"body\":\"{}\"}"))
+				r.Header.Add("Authorization", auth.Token)
+				w = httptest.NewRecorder()
+				ReplayRequest(w, r, ps)
+
+				assert.Equal(t, 202, w.Code, "wrong HTTP result code")
+				assert.Equal(t, "replayed", w.Body.String(), "response of target is expected")
+				assert.Equal(t, "test", w.Header().Get("X-Target"), "headers of target response are expected")
+				if assert.NotNil(t, received, "request is expected to be replayed") {
+					assert.Equal(t, "POST", received.Method, "wrong method of replayed request")
+					assert.Equal(t, "/local", received.URL.Path, "wrong path of replayed request")
+					assert.Equal(t, "ping", received.Header.Get("X-Event"), "header is expected to be overridden")
+					assert.Equal(t, "{}", receivedBody, "body is expected to be overridden")
+				}
+
+				// replay to forward URL of basket
+				r, _ = http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket,
+					strings.NewReader("{\"capacity\":20,\"forward_url\":\""+ts.URL+"/hook\",\"expand_path\":true}"))
+				r.Header.Add("Authorization", auth.Token)
+				UpdateBasket(httptest.NewRecorder(), r, ps)
+
+				r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/"+id+"/replay", strings.NewReader(""))
+				r.Header.Add("Authorization", auth.Token)
+				w = httptest.NewRecorder()
+				ReplayRequest(w, r, ps)
+
+				assert.Equal(t, 202, w.Code, "wrong HTTP result code")
+				assert.Equal(t, "/hook/events", received.URL.Path, "wrong path of replayed request")
+				assert.Equal(t, "push", received.Header.Get("X-Event"), "original header is expected")
+				assert.Equal(t, "{\"event\":\"push\"}", receivedBody, "original body is expected")
+
+				// results of replays are kept with collected request
+				if request := testDB(t, basketsDb).Get(basket).GetRequest(id); assert.NotNil(t, request, "request is expected") {
+					assert.Equal(t, 2, len(request.Forwards), "results of replays are expected")
+				}
+			}
+
+			// unknown request
+			ps = append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket},
+				httprouter.Param{Key: "id", Value: "abc"})
+			r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/abc/replay", strings.NewReader(""))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			ReplayRequest(w, r, ps)
+			assert.Equal(t, 404, w.Code, "wrong HTTP result code")
+
+			// invalid options
+			if id := testDB(t, basketsDb).Get(basket).GetRequests(1, 0).Requests[0].ID; len(id) > 0 {
+				ps[1].Value = id
+				r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/"+id+"/replay",
+					strings.NewReader("{\"url\":"))
+				r.Header.Add("Authorization", auth.Token)
+				w = httptest.NewRecorder()
+				ReplayRequest(w, r, ps)
+				assert.Equal(t, 400, w.Code, "wrong HTTP result code")
+
+				r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/"+id+"/replay",
+					strings.NewReader("{\"url\":\"not-a-url\"}"))
+				r.Header.Add("Authorization", auth.Token)
+				w = httptest.NewRecorder()
+				ReplayRequest(w, r, ps)
+				assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			}
+
+			// unauthorized
+			r, _ = http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket+"/requests/abc/replay", strings.NewReader(""))
+			w = httptest.NewRecorder()
+			ReplayRequest(w, r, ps)
+			assert.Equal(t, 401, w.Code, "wrong HTTP result code")
+		}
+	}
+}
+
+func TestCreateBasket_ForwardLoop(t *testing.T) {
+	basket := "create22"
+
+	for _, body := range []string{
+		"{\"forward_url\":\"http://localhost:55555/" + basket + "\",\"capacity\":20}",
+		"{\"capacity\":20,\"forward_targets\":[{\"url\":\"http://127.0.0.1:55555/" + basket + "/hook\"}]}",
+		"{\"capacity\":20,\"forward_rules\":[{\"name\":\"loop\",\"targets\":[{\"url\":\"http://localhost:55555/" + basket + "\"}]}]}",
+	} {
+		r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader(body))
+
+		if assert.NoError(t, err) {
+			w := httptest.NewRecorder()
+			ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+			CreateBasket(w, r, ps)
+
+			// validate response: 422 - Unprocessable Entity
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), "refers to the basket itself", "error message is incomplete")
+			// validate database
+			assert.Nil(t, testDB(t, basketsDb).Get(basket), "basket '%v' should not be created", basket)
+		}
+	}
+}
+
+func TestUpdateBasket_ForwardLoop(t *testing.T) {
+	basket := "update08"
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket, strings.NewReader("{\"capacity\":20}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		auth := new(BasketAuth)
+		err = json.Unmarshal(w.Body.Bytes(), auth)
+		if assert.NoError(t, err, "Failed to parse CreateBasket response") {
+			r, _ = http.NewRequest("PUT", "http://localhost:55555/api/baskets/"+basket,
+				strings.NewReader("{\"capacity\":20,\"forward_url\":\"http://localhost:55555/"+basket+"\"}"))
+			r.Header.Add("Authorization", auth.Token)
+			w = httptest.NewRecorder()
+			UpdateBasket(w, r, ps)
+
+			assert.Equal(t, 422, w.Code, "wrong HTTP result code")
+			assert.Contains(t, w.Body.String(), "refers to the basket itself", "error message is incomplete")
+			assert.Empty(t, testDB(t, basketsDb).Get(basket).Config().ForwardURL, "forward URL is not expected")
+		}
+	}
+}
+
+func TestAcceptBasketRequests_ForwardLoop(t *testing.T) {
+	basket := "accept15"
+
+	var forwarded *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	r, err := http.NewRequest("POST", "http://localhost:55555/api/baskets/"+basket,
+		strings.NewReader("{\"forward_url\":\""+ts.URL+"\",\"capacity\":20,\"proxy_response\":true}"))
+	if assert.NoError(t, err) {
+		ps := append(make(httprouter.Params, 0), httprouter.Param{Key: "basket", Value: basket})
+		w := httptest.NewRecorder()
+		CreateBasket(w, r, ps)
+		assert.Equal(t, 201, w.Code, "wrong HTTP result code")
+
+		// request forwarded by another basket is forwarded further
+		r, _ = http.NewRequest("GET", "http://localhost:55555/"+basket, strings.NewReader(""))
+		r.Header.Set(HopsHeader, "1")
+		r.Header.Set(ViaHeader, viaEntry("accept14"))
+		w = httptest.NewRecorder()
+		AcceptBasketRequests(w, r)
+
+		assert.Equal(t, 200, w.Code, "wrong HTTP response code")
+		if assert.NotNil(t, forwarded, "request is expected to be forwarded") {
+			assert.Equal(t, "2", forwarded.Header.Get(HopsHeader), "wrong number of hops")
+		}
+
+		// request that was already forwarded by this basket is not forwarded again
+		forwarded = nil
+		r, _ = http.NewRequest("GET", "http://localhost:55555/"+basket, strings.NewReader(""))
+		r.Header.Set(ViaHeader, viaEntry(basket))
+		w = httptest.NewRecorder()
+		AcceptBasketRequests(w, r)
+
+		assert.Equal(t, http.StatusLoopDetected, w.Code, "wrong HTTP response code")
+		assert.Nil(t, forwarded, "request is not expected to be forwarded")
+		page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0)
+		if assert.Equal(t, 1, len(page.Requests), "collected request is expected") &&
+			assert.Equal(t, 1, len(page.Requests[0].Forwards), "result of forwarding is expected") {
+			assert.Equal(t, ts.URL, page.Requests[0].Forwards[0].URL, "wrong forward URL")
+			assert.Contains(t, page.Requests[0].Forwards[0].Error, "forward loop detected", "loop is expected to be reported")
+		}
+
+		// request that exceeds maximum number of hops is not forwarded
+		r, _ = http.NewRequest("GET", "http://localhost:55555/"+basket, strings.NewReader(""))
+		r.Header.Set(HopsHeader, fmt.Sprint(serverConfig.MaxHops))
+		w = httptest.NewRecorder()
+		AcceptBasketRequests(w, r)
+
+		assert.Equal(t, http.StatusLoopDetected, w.Code, "wrong HTTP response code")
+		assert.Nil(t, forwarded, "request is not expected to be forwarded")
+	}
+}
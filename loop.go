@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HopsHeader defines the number of times a request has been forwarded by baskets
+const HopsHeader = "X-Forward-Hops"
+
+// ViaHeader lists intermediaries of request, every basket that forwards request adds its entry
+const ViaHeader = "Via"
+
+// defaultServiceID returns the identifier of service in Via header of forwarded requests if it is not configured,
+// the host name is used, so the identifier is stable across restarts of the service
+func defaultServiceID() string {
+	if host, err := os.Hostname(); err == nil && validHTTPToken.MatchString(host) {
+		return host
+	}
+	return serviceName
+}
+
+// viaEntry returns entry of Via header that identifies forwarding by the basket of this service, instances of
+// service that share the database are configured with the same service ID to detect loops across instances
+func viaEntry(basket string) string {
+	return "1.1 " + serverConfig.ServiceID + " (" + basket + ")"
+}
+
+// hopsOf returns the number of times request has been forwarded
+func hopsOf(header http.Header) int {
+	hops, err := strconv.Atoi(header.Get(HopsHeader))
+	if err != nil || hops < 0 {
+		return 0
+	}
+	return hops
+}
+
+// detectForwardLoop checks if incoming request has been already forwarded by the basket or it has been forwarded
+// too many times, returns the description of forward loop if request should not be forwarded
+func detectForwardLoop(header http.Header, basket string) error {
+	entry := viaEntry(basket)
+	for _, via := range header.Values(ViaHeader) {
+		for _, value := range strings.Split(via, ",") {
+			if strings.TrimSpace(value) == entry {
+				return fmt.Errorf("forward loop detected: request has been already forwarded by basket: %s", basket)
+			}
+		}
+	}
+
+	if hops := hopsOf(header); serverConfig.MaxHops > 0 && hops >= serverConfig.MaxHops {
+		return fmt.Errorf("forward loop detected: request has been forwarded %d times, maximum is %d", hops,
+			serverConfig.MaxHops)
+	}
+
+	return nil
+}
+
+// validateForwardLoop checks that forward targets of basket configuration do not refer to the basket itself,
+// serviceHost is the host used by client to access this service
+func validateForwardLoop(config *BasketConfig, basket string, serviceHost string) error {
+	for _, target := range config.AllTargets() {
+		if refersToBasket(target.URL, basket, serviceHost) {
+			return fmt.Errorf("forward URL: %s refers to the basket itself, requests would be forwarded in a loop",
+				target.URL)
+		}
+	}
+
+	return nil
+}
+
+// refersToBasket checks if URL refers to the basket of this service; the URL refers to this service if its host
+// is the host used to access the service or if it resolves to a local address and port of the service
+func refersToBasket(target string, basket string, serviceHost string) bool {
+	u, err := url.Parse(target)
+	if err != nil || len(u.Host) == 0 {
+		return false
+	}
+
+	path := u.Path
+	if prefix := serverConfig.PathPrefix; len(prefix) > 0 {
+		if !strings.HasPrefix(path, prefix) {
+			return false
+		}
+		path = strings.TrimPrefix(path, prefix)
+	}
+	if strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0] != basket {
+		return false
+	}
+
+	port := u.Port()
+	if len(port) == 0 {
+		port = defaultPortOf(u.Scheme)
+	}
+
+	// the same host as the one used to access the service
+	if len(serviceHost) > 0 {
+		host, servicePort, err := net.SplitHostPort(serviceHost)
+		if err != nil {
+			// port is not specified, the service may be behind a reverse proxy
+			host, servicePort = serviceHost, port
+		}
+		if strings.EqualFold(host, u.Hostname()) && servicePort == port {
+			return true
+		}
+	}
+
+	return port == strconv.Itoa(serverConfig.ServerPort) && isLocalHost(u.Hostname())
+}
+
+func defaultPortOf(scheme string) string {
+	if strings.EqualFold(scheme, "https") || strings.EqualFold(scheme, "wss") {
+		return "443"
+	}
+	return "80"
+}
+
+// isLocalHost checks if host resolves to a loopback address or to an address of local network interfaces
+func isLocalHost(host string) bool {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return false
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	local, _ := net.InterfaceAddrs()
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return true
+		}
+		for _, addr := range local {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHopsOf(t *testing.T) {
+	assert.Equal(t, 0, hopsOf(http.Header{}), "no hops are expected")
+	assert.Equal(t, 3, hopsOf(http.Header{HopsHeader: {"3"}}), "wrong number of hops")
+	assert.Equal(t, 0, hopsOf(http.Header{HopsHeader: {"abc"}}), "invalid number of hops is expected to be ignored")
+	assert.Equal(t, 0, hopsOf(http.Header{HopsHeader: {"-2"}}), "negative number of hops is expected to be ignored")
+}
+
+func TestDetectForwardLoop(t *testing.T) {
+	assert.NoError(t, detectForwardLoop(http.Header{}, "loop01"), "loop is not expected")
+	assert.NoError(t, detectForwardLoop(http.Header{ViaHeader: {viaEntry("loop02")}}, "loop01"),
+		"request forwarded by another basket is expected to be forwarded")
+
+	err := detectForwardLoop(http.Header{ViaHeader: {"1.1 proxy.local, " + viaEntry("loop01") + ", 1.1 gateway"}}, "loop01")
+	if assert.Error(t, err, "loop is expected") {
+		assert.Contains(t, err.Error(), "already forwarded by basket: loop01", "error is not detailed enough")
+	}
+
+	err = detectForwardLoop(http.Header{HopsHeader: {"10"}}, "loop01")
+	if assert.Error(t, err, "loop is expected") {
+		assert.Contains(t, err.Error(), "forwarded 10 times, maximum is 10", "error is not detailed enough")
+	}
+	assert.NoError(t, detectForwardLoop(http.Header{HopsHeader: {"9"}}, "loop01"), "loop is not expected")
+
+	defaultMaxHops := serverConfig.MaxHops
+	serverConfig.MaxHops = 0
+	defer func() { serverConfig.MaxHops = defaultMaxHops }()
+	assert.NoError(t, detectForwardLoop(http.Header{HopsHeader: {"100"}}, "loop01"), "number of hops is not limited")
+}
+
+func TestDetectForwardLoop_ServiceID(t *testing.T) {
+	defaultServiceID := serverConfig.ServiceID
+	defer func() { serverConfig.ServiceID = defaultServiceID }()
+
+	// request forwarded by the basket of another service
+	serverConfig.ServiceID = "rbaskets-1"
+	header := http.Header{ViaHeader: {viaEntry("loop04")}}
+	serverConfig.ServiceID = "rbaskets-2"
+	assert.NoError(t, detectForwardLoop(header, "loop04"), "request forwarded by another service is expected to be forwarded")
+
+	// request forwarded by the same basket through another instance of service
+	serverConfig.ServiceID = "rbaskets-1"
+	assert.Error(t, detectForwardLoop(header, "loop04"), "loop is expected across instances with the same service ID")
+}
+
+func TestDefaultServiceID(t *testing.T) {
+	id := defaultServiceID()
+	assert.Regexp(t, httpTokenPattern, id, "service ID is expected to be a valid HTTP token")
+	assert.Equal(t, id, defaultServiceID(), "service ID is expected to be stable")
+}
+
+func TestRefersToBasket(t *testing.T) {
+	for target, expected := range map[string]bool{
+		"http://localhost:55555/loop01":           true,
+		"http://LOCALHOST:55555/loop01/sub?a=b":   true,
+		"http://127.0.0.1:55555/loop01":           true,
+		"http://[::1]:55555/loop01":               true,
+		"http://localhost:55555/loop02":           false,
+		"http://localhost:55555/loop012":          false,
+		"http://localhost:8080/loop01":            false,
+		"http://rbaskets.example.com/loop01":      false,
+		"https://localhost:55555/api/loop01":      false,
+		"/loop01":                                 false,
+		"http://localhost:55555":                  false,
+		"http://localhost:55555/loop01#fragment":  true,
+		"http://127.0.0.1:55555/loop01?from=test": true,
+	} {
+		assert.Equal(t, expected, refersToBasket(target, "loop01", "localhost:55555"), "wrong detection for: %s", target)
+	}
+
+	// service behind reverse proxy
+	assert.True(t, refersToBasket("https://rbaskets.example.com/loop01", "loop01", "rbaskets.example.com"),
+		"URL with host of service is expected to refer the service")
+	assert.False(t, refersToBasket("https://example.com/loop01", "loop01", "rbaskets.example.com"),
+		"URL with another host is not expected to refer the service")
+
+	// service with path prefix
+	defaultPrefix := serverConfig.PathPrefix
+	serverConfig.PathPrefix = "/rb"
+	defer func() { serverConfig.PathPrefix = defaultPrefix }()
+	assert.True(t, refersToBasket("http://localhost:55555/rb/loop01", "loop01", "localhost:55555"),
+		"URL with path prefix is expected to refer the basket")
+	assert.False(t, refersToBasket("http://localhost:55555/loop01", "loop01", "localhost:55555"),
+		"URL without path prefix is not expected to refer the basket")
+}
+
+func TestValidateForwardLoop(t *testing.T) {
+	config := &BasketConfig{ForwardURL: "http://localhost:8080/hook",
+		ForwardTargets: []ForwardTarget{{URL: "http://localhost:55555/loop02"}}}
+	assert.NoError(t, validateForwardLoop(config, "loop01", "localhost:55555"), "forward to another basket is expected")
+
+	config.ForwardRules = []ForwardRule{{Name: "loop", Targets: []ForwardTarget{{URL: "http://localhost:55555/loop01"}}}}
+	err := validateForwardLoop(config, "loop01", "localhost:55555")
+	if assert.Error(t, err, "forward to the basket itself is not expected") {
+		assert.Contains(t, err.Error(), "refers to the basket itself", "error is not detailed enough")
+	}
+}
+
+func TestNewForwardRequest_Hops(t *testing.T) {
+	request := createTestRequestData("/loop03", "abc")
+	request.Header.Set(HopsHeader, "2")
+	request.Header.Set(ViaHeader, "1.1 gateway")
+
+	forwardReq, err := request.NewForwardRequest(BasketConfig{ForwardURL: "http://localhost:8080"}, "loop03")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "3", forwardReq.Header.Get(HopsHeader), "number of hops is expected to be incremented")
+		assert.Equal(t, []string{"1.1 gateway", viaEntry("loop03")}, forwardReq.Header.Values(ViaHeader),
+			"entry of basket is expected to be added to via header")
+	}
+	assert.Equal(t, "2", request.Header.Get(HopsHeader), "original request should not be modified")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a cache of limited size that evicts the least recently used entries, it is safe for concurrent use
+type lruCache struct {
+	sync.Mutex
+	size    int
+	entries map[interface{}]*list.Element
+	order   *list.List
+	evicted func(key interface{}, value interface{})
+}
+
+type lruEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// newLRUCache creates cache that keeps up to size entries, evicted function (if defined) is called for every
+// entry that is evicted from cache
+func newLRUCache(size int, evicted func(key interface{}, value interface{})) *lruCache {
+	return &lruCache{size: size, entries: make(map[interface{}]*list.Element), order: list.New(), evicted: evicted}
+}
+
+// getOrCreate returns cached value of the key or creates and caches a new value, nothing is cached if creation fails
+func (cache *lruCache) getOrCreate(key interface{}, create func() (interface{}, error)) (interface{}, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if element, exists := cache.entries[key]; exists {
+		cache.order.MoveToFront(element)
+		return element.Value.(*lruEntry).value, nil
+	}
+
+	value, err := create()
+	if err != nil {
+		return nil, err
+	}
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key: key, value: value})
+
+	for cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		entry := oldest.Value.(*lruEntry)
+		cache.order.Remove(oldest)
+		delete(cache.entries, entry.key)
+		if cache.evicted != nil {
+			cache.evicted(entry.key, entry.value)
+		}
+	}
+
+	return value, nil
+}
+
+// len returns the number of cached entries
+func (cache *lruCache) len() int {
+	cache.Lock()
+	defer cache.Unlock()
+	return cache.order.Len()
+}
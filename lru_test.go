@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	evicted := make([]interface{}, 0)
+	cache := newLRUCache(2, func(key interface{}, value interface{}) { evicted = append(evicted, key) })
+	created := 0
+	create := func(value string) func() (interface{}, error) {
+		return func() (interface{}, error) {
+			created++
+			return value, nil
+		}
+	}
+
+	value, err := cache.getOrCreate("a", create("A"))
+	assert.NoError(t, err)
+	assert.Equal(t, "A", value, "wrong cached value")
+	cache.getOrCreate("b", create("B"))
+
+	// cached value is reused and becomes the most recently used
+	value, _ = cache.getOrCreate("a", create("X"))
+	assert.Equal(t, "A", value, "wrong cached value")
+	assert.Equal(t, 2, created, "cached value is not expected to be created again")
+
+	// the least recently used entry is evicted
+	cache.getOrCreate("c", create("C"))
+	assert.Equal(t, 2, cache.len(), "wrong cache size")
+	assert.Equal(t, []interface{}{"b"}, evicted, "wrong evicted entries")
+
+	value, _ = cache.getOrCreate("b", create("B2"))
+	assert.Equal(t, "B2", value, "evicted value is expected to be created again")
+	assert.Equal(t, []interface{}{"b", "a"}, evicted, "wrong evicted entries")
+}
+
+func TestLRUCache_CreateFailed(t *testing.T) {
+	cache := newLRUCache(2, nil)
+	_, err := cache.getOrCreate("a", func() (interface{}, error) { return nil, errors.New("failed") })
+	assert.Error(t, err, "creation error is expected")
+	assert.Equal(t, 0, cache.len(), "failed value is not expected to be cached")
+}
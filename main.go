@@ -1,16 +1,39 @@
 package main
 
-import "log"
+import (
+	"log"
+	"net/http"
+	"os"
+)
 
 var serverConfig *ServerConfig
 
 func main() {
 	// read config
 	serverConfig = CreateConfig()
+	// only migrate database schema if requested
+	if serverConfig.MigrateOnly || serverConfig.MigrateDryRun {
+		if err := migrateDatabase(serverConfig); err != nil {
+			log.Printf("[error] failed to migrate database: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// only rebuild database statistics if requested
+	if serverConfig.RebuildStats {
+		if err := rebuildDatabaseStats(serverConfig); err != nil {
+			log.Printf("[error] failed to rebuild statistics: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
 	// create & start server
 	if server := CreateServer(serverConfig); server != nil {
-		if err := server.ListenAndServe(); err != nil {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
+		// wait for graceful shutdown
+		<-serverStopped
+		log.Printf("[info] terminating server")
 	}
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisConnectTimeout = 5 * time.Second
+	redisTimeout        = 30 * time.Second
+	redisMaxRetries     = 10
+)
+
+// errRedisConflict is returned when optimistic transaction is aborted because watched keys are modified
+// more times than it is retried
+var errRedisConflict = errors.New("redis: transaction is aborted due to concurrent modification")
+
+// parseRedisOptions parses Redis connection string: redis://[:password@]host[:port][/db] or host[:port]
+func parseRedisOptions(connection string) (*redis.Options, error) {
+	if !strings.Contains(connection, "://") {
+		connection = "redis://" + connection
+	}
+
+	options, err := redis.ParseURL(connection)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis connection: %s - %s", connection, err)
+	}
+
+	options.DialTimeout = redisConnectTimeout
+	options.ReadTimeout = redisTimeout
+	options.WriteTimeout = redisTimeout
+	// commands respect the deadline of request context
+	options.ContextTimeoutEnabled = true
+
+	return options, nil
+}
+
+// redisError returns the error of context instead of the error of command if the command is interrupted because
+// the context is canceled or its deadline is passed; the deadline of connection may fire before the context is done
+func redisError(ctx context.Context, err error) error {
+	if err == nil || err == redis.Nil {
+		return err
+	}
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// redisOptimistic runs the function within optimistic transaction that watches the keys, the function is retried
+// if the transaction fails due to concurrent modification of watched keys
+func redisOptimistic(ctx context.Context, client *redis.Client, f func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < redisMaxRetries; i++ {
+		err := client.Watch(ctx, f, keys...)
+		if err != redis.TxFailedErr {
+			return redisError(ctx, err)
+		}
+	}
+
+	return errRedisConflict
+}
+
+// redisInt64 parses integer value of hash field, undefined or invalid value is treated as 0
+func redisInt64(value interface{}) int64 {
+	if s, ok := value.(string); ok {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		return i
+	}
+	return 0
+}
+
+// redisTrim queues command that keeps the list up to specified size
+func redisTrim(ctx context.Context, pipe redis.Pipeliner, key string, size int) {
+	if size <= 0 {
+		pipe.Del(ctx, key)
+	} else {
+		pipe.LTrim(ctx, key, 0, int64(size-1))
+	}
+}
+
+// redisRange returns LRANGE/ZRANGE bounds of a page, ok is false if the page is empty
+func redisRange(max int, skip int) (int64, int64, bool) {
+	return int64(skip), int64(skip + max - 1), max > 0 && skip >= 0
+}
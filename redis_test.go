@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	redisTestOnce sync.Once
+	redisTestURL  string
+)
+
+// redisTestConnection returns connection string of Redis server for tests: the server defined
+// by REDIS_TEST_URL environment variable or in-process stand-in server if variable is undefined
+func redisTestConnection() string {
+	redisTestOnce.Do(func() {
+		if redisTestURL = os.Getenv("REDIS_TEST_URL"); len(redisTestURL) == 0 {
+			server, err := miniredis.Run()
+			if err != nil {
+				panic(err)
+			}
+			redisTestURL = "redis://" + server.Addr()
+		}
+	})
+	return redisTestURL
+}
+
+// redisTestClient creates client of Redis server for tests
+func redisTestClient(t *testing.T) *redis.Client {
+	options, err := parseRedisOptions(redisTestConnection())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return redis.NewClient(options)
+}
+
+func TestParseRedisOptions(t *testing.T) {
+	options, err := parseRedisOptions("redis://:pwd@redis.local:6380/2")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "redis.local:6380", options.Addr, "wrong address")
+		assert.Equal(t, "pwd", options.Password, "wrong password")
+		assert.Equal(t, 2, options.DB, "wrong database")
+		assert.True(t, options.ContextTimeoutEnabled, "context deadline is expected to be respected")
+	}
+
+	options, err = parseRedisOptions("localhost")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "localhost:6379", options.Addr, "wrong address")
+		assert.Empty(t, options.Password, "password is not expected")
+		assert.Equal(t, 0, options.DB, "wrong database")
+	}
+
+	_, err = parseRedisOptions("redis://localhost/abc")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid database number", "error is not detailed enough")
+	}
+
+	_, err = parseRedisOptions("http://localhost")
+	assert.Error(t, err, "unsupported scheme is not expected")
+}
+
+func TestRedisError_Context(t *testing.T) {
+	// server accepts connections, but never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	options, err := parseRedisOptions("redis://" + listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	client := redis.NewClient(options)
+	defer client.Close()
+
+	// command is interrupted when context deadline is exceeded
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = redisError(ctx, client.Ping(ctx).Err())
+	assert.Equal(t, context.DeadlineExceeded, err, "command is expected to time out")
+	assert.True(t, time.Since(start) < redisTimeout/2, "command is expected to be interrupted")
+
+	// command is not sent with context that is already canceled
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = redisError(ctx, client.Ping(ctx).Err())
+	assert.Equal(t, context.Canceled, err, "command is expected to be canceled")
+
+	assert.NoError(t, redisError(context.Background(), nil), "no error is expected")
+	assert.Equal(t, redis.Nil, redisError(ctx, redis.Nil), "nil reply is not expected to be replaced")
+}
+
+func TestRedisOptimistic_Conflict(t *testing.T) {
+	client := redisTestClient(t)
+	defer client.Close()
+	ctx := context.Background()
+	key := "rb:test:client02"
+	defer client.Del(ctx, key)
+
+	attempts := 0
+	err := redisOptimistic(ctx, client, func(tx *redis.Tx) error {
+		attempts++
+		if attempts == 1 {
+			// concurrent modification
+			client.LPush(ctx, key, "other")
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, "mine")
+			return nil
+		})
+		return err
+	}, key)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts, "transaction is expected to be retried")
+	values, _ := client.LRange(ctx, key, 0, -1).Result()
+	assert.Equal(t, []string{"mine", "other"}, values, "wrong list values")
+}
+
+func TestRedisOptimistic_RetriesExhausted(t *testing.T) {
+	client := redisTestClient(t)
+	defer client.Close()
+	ctx := context.Background()
+	key := "rb:test:client03"
+	defer client.Del(ctx, key)
+
+	attempts := 0
+	err := redisOptimistic(ctx, client, func(tx *redis.Tx) error {
+		attempts++
+		client.LPush(ctx, key, "other")
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, "mine")
+			return nil
+		})
+		return err
+	}, key)
+
+	assert.Equal(t, errRedisConflict, err, "conflict is expected")
+	assert.Equal(t, redisMaxRetries, attempts, "wrong number of attempts")
+}
+
+func TestRedisInt64(t *testing.T) {
+	assert.Equal(t, int64(123), redisInt64("123"), "wrong value")
+	assert.Equal(t, int64(0), redisInt64(nil), "undefined value is expected as 0")
+	assert.Equal(t, int64(0), redisInt64("abc"), "invalid value is expected as 0")
+}
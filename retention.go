@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// retentionSweeper periodically deletes collected requests that are older than maximum age defined by basket
+// configuration or by server default, as well as baskets that are expired according to server configuration
+type retentionSweeper struct {
+	db       BasketsDatabase
+	maxAge   time.Duration
+	ttl      time.Duration
+	lifetime time.Duration
+	interval time.Duration
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// newRetentionSweeper creates and starts the sweeper of baskets database
+func newRetentionSweeper(db BasketsDatabase, config *ServerConfig, interval time.Duration) *retentionSweeper {
+	ctx, cancel := context.WithCancel(context.Background())
+	sweeper := &retentionSweeper{db: db, maxAge: config.MaxAge, ttl: config.BasketTTL, lifetime: config.BasketLifetime,
+		interval: interval, cancel: cancel, done: make(chan struct{})}
+
+	go sweeper.run(ctx)
+	return sweeper
+}
+
+func (sweeper *retentionSweeper) run(ctx context.Context) {
+	defer close(sweeper.done)
+
+	ticker := time.NewTicker(sweeper.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			requests, baskets := sweeper.Sweep(ctx)
+			if baskets > 0 {
+				log.Printf("[info] deleted %d expired basket(s)", baskets)
+			}
+			if requests > 0 {
+				log.Printf("[info] deleted %d expired request(s)", requests)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep deletes expired baskets and expired requests of remaining baskets, returns the number of deleted
+// requests and the number of deleted baskets
+func (sweeper *retentionSweeper) Sweep(ctx context.Context) (int, int) {
+	requests := 0
+	baskets := 0
+	pageSize := 100
+
+	for skip := 0; ctx.Err() == nil; skip += pageSize {
+		page, err := sweeper.db.GetNames(ctx, pageSize, skip)
+		if err != nil {
+			log.Printf("[error] failed to sweep baskets - %s", err)
+			break
+		}
+
+		for _, name := range page.Names {
+			deleted, expired, err := sweeper.sweepBasket(ctx, name)
+			if err != nil {
+				log.Printf("[error] failed to sweep basket: %s - %s", name, err)
+			}
+			requests += deleted
+			if expired {
+				// remaining baskets are shifted to the current page
+				baskets++
+				skip--
+			}
+		}
+
+		if !page.HasMore {
+			break
+		}
+	}
+
+	return requests, baskets
+}
+
+// sweepBasket deletes a single basket if it is expired, otherwise deletes its expired requests
+func (sweeper *retentionSweeper) sweepBasket(ctx context.Context, name string) (int, bool, error) {
+	basket, err := sweeper.db.Get(ctx, name)
+	if err != nil || basket == nil {
+		// basket may be deleted meanwhile
+		return 0, false, err
+	}
+
+	config, err := basket.Config(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if !config.Pinned && (sweeper.ttl > 0 || sweeper.lifetime > 0) {
+		activity, err := basket.Activity(ctx)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if expiry := config.ExpiryDate(activity, sweeper.ttl, sweeper.lifetime); expiry > 0 && expiry <= time.Now().UnixNano()/toMs {
+			log.Printf("[info] deleting expired basket: %s", name)
+			if err = sweeper.db.Delete(ctx, name); err != nil {
+				return 0, false, err
+			}
+			forwardGuards.release(name)
+			return 0, true, nil
+		}
+	}
+
+	if maxAge := config.RetentionAge(sweeper.maxAge); maxAge > 0 {
+		deleted, err := basket.DeleteExpired(ctx, maxAge)
+		return deleted, false, err
+	}
+	return 0, false, nil
+}
+
+// Shutdown stops the sweeper and waits until the running sweep is interrupted
+func (sweeper *retentionSweeper) Shutdown() {
+	sweeper.cancel()
+	<-sweeper.done
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasketConfig_RetentionAge(t *testing.T) {
+	assert.Equal(t, time.Hour, BasketConfig{}.RetentionAge(time.Hour), "server default is expected")
+	assert.Equal(t, time.Duration(0), BasketConfig{}.RetentionAge(0), "unlimited age is expected")
+	assert.Equal(t, 30*time.Second, BasketConfig{MaxAge: 30}.RetentionAge(time.Hour), "basket age is expected")
+	assert.Equal(t, 30*time.Second, BasketConfig{MaxAge: 30}.RetentionAge(0), "basket age is expected")
+}
+
+func TestBasketConfig_ExpiryDate(t *testing.T) {
+	activity := BasketActivity{CreatedDate: 1000000, LastRequestDate: 3000000}
+	config := BasketConfig{Capacity: 20}
+
+	assert.Zero(t, config.ExpiryDate(activity, 0, 0), "basket is not expected to expire")
+	assert.Equal(t, int64(3060000), config.ExpiryDate(activity, time.Minute, 0), "inactivity is counted from the last request")
+	assert.Equal(t, int64(1060000), config.ExpiryDate(BasketActivity{CreatedDate: 1000000}, time.Minute, 0),
+		"inactivity is counted from creation")
+	assert.Equal(t, int64(2000000), config.ExpiryDate(activity, 0, 1000*time.Second), "lifetime is counted from creation")
+	assert.Equal(t, int64(2000000), config.ExpiryDate(activity, time.Hour, 1000*time.Second), "the earliest date is expected")
+	assert.Equal(t, int64(3060000), config.ExpiryDate(activity, time.Minute, time.Hour), "the earliest date is expected")
+
+	config.Pinned = true
+	assert.Zero(t, config.ExpiryDate(activity, time.Minute, time.Hour), "pinned basket is not expected to expire")
+}
+
+func TestRetentionSweeper_Sweep(t *testing.T) {
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	// test158 - server default, test159 - own age, test160 - own age that exceeds age of requests
+	configs := map[string]BasketConfig{
+		"test158": {Capacity: 20},
+		"test159": {Capacity: 20, MaxAge: 60},
+		"test160": {Capacity: 20, MaxAge: 86400},
+	}
+	for name, config := range configs {
+		db.Create(name, config)
+		basket := db.Get(name)
+		if assert.NotNil(t, basket, "basket with name: %v is expected", name) {
+			old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+			old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+			basket.Add(old)
+			basket.Add(createTestRequestData(fmt.Sprintf("/%v/new", name), "new"))
+		}
+	}
+
+	// unlimited age by default
+	sweeper := &retentionSweeper{db: db.BasketsDatabase}
+	requests, baskets := sweeper.Sweep(context.Background())
+	assert.Equal(t, 1, requests, "wrong number of deleted requests")
+	assert.Equal(t, 0, baskets, "no expired baskets are expected")
+	assert.Equal(t, 2, db.Get("test158").Size(), "wrong basket size")
+	assert.Equal(t, 1, db.Get("test159").Size(), "wrong basket size")
+	assert.Equal(t, 2, db.Get("test160").Size(), "wrong basket size")
+
+	// server default is applied to basket without own age
+	sweeper.maxAge = time.Hour
+	requests, baskets = sweeper.Sweep(context.Background())
+	assert.Equal(t, 1, requests, "wrong number of deleted requests")
+	assert.Equal(t, 0, baskets, "no expired baskets are expected")
+	assert.Equal(t, 1, db.Get("test158").Size(), "wrong basket size")
+	assert.Equal(t, 1, db.Get("test159").Size(), "wrong basket size")
+	assert.Equal(t, 2, db.Get("test160").Size(), "wrong basket size")
+
+	// nothing is left to delete
+	requests, _ = sweeper.Sweep(context.Background())
+	assert.Equal(t, 0, requests, "no expired requests are expected")
+}
+
+func TestRetentionSweeper_Sweep_Canceled(t *testing.T) {
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	name := "test161"
+	db.Create(name, BasketConfig{Capacity: 20, MaxAge: 60})
+	old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+	old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+	db.Get(name).Add(old)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sweeper := &retentionSweeper{db: db.BasketsDatabase}
+	requests, _ := sweeper.Sweep(ctx)
+	assert.Equal(t, 0, requests, "no requests are expected to be deleted")
+	assert.Equal(t, 1, db.Get(name).Size(), "wrong basket size")
+}
+
+func TestRetentionSweeper_Run(t *testing.T) {
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	name := "test162"
+	db.Create(name, BasketConfig{Capacity: 20})
+	old := createTestRequestData(fmt.Sprintf("/%v/old", name), "old")
+	old.Date = time.Now().Add(-2*time.Hour).UnixNano() / toMs
+	db.Get(name).Add(old)
+
+	sweeper := newRetentionSweeper(db.BasketsDatabase, &ServerConfig{MaxAge: time.Hour}, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		basket, _ := db.BasketsDatabase.Get(context.Background(), name)
+		size, _ := basket.Size(context.Background())
+		return size == 0
+	}, time.Second, 10*time.Millisecond, "expired request is expected to be deleted")
+
+	sweeper.Shutdown()
+}
+
+func TestRetentionSweeper_Sweep_ExpiredBaskets(t *testing.T) {
+	db := testDB(t, NewMemoryDatabase())
+	defer db.Release()
+
+	// baskets across several pages, every third basket is pinned
+	outdated := time.Now().Add(-2*time.Hour).UnixNano() / toMs
+	for i := 0; i < 250; i++ {
+		name := fmt.Sprintf("test164_%03d", i)
+		db.Create(name, BasketConfig{Capacity: 20, Pinned: i%3 == 0})
+		db.Get(name).Basket.(*memoryBasket).activity.CreatedDate = outdated
+	}
+	// recently active basket
+	name := "test165"
+	db.Create(name, BasketConfig{Capacity: 20})
+	db.Get(name).Basket.(*memoryBasket).activity.CreatedDate = outdated
+	db.Get(name).Add(createTestRequestData(fmt.Sprintf("/%v/new", name), "new"))
+
+	sweeper := &retentionSweeper{db: db.BasketsDatabase, ttl: time.Hour}
+	requests, baskets := sweeper.Sweep(context.Background())
+	assert.Equal(t, 0, requests, "no expired requests are expected")
+	assert.Equal(t, 166, baskets, "wrong number of deleted baskets")
+	assert.Equal(t, 85, db.Size(), "wrong database size")
+	assert.NotNil(t, db.Get(name), "recently active basket is expected")
+	assert.NotNil(t, db.Get("test164_000"), "pinned basket is expected")
+	assert.Nil(t, db.Get("test164_001"), "expired basket is not expected")
+
+	// lifetime applies to active baskets too
+	sweeper = &retentionSweeper{db: db.BasketsDatabase, lifetime: time.Hour}
+	_, baskets = sweeper.Sweep(context.Background())
+	assert.Equal(t, 1, baskets, "wrong number of deleted baskets")
+	assert.Nil(t, db.Get(name), "outlived basket is not expected")
+	assert.Equal(t, 84, db.Size(), "wrong database size")
+}
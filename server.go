@@ -1,7 +1,7 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,8 +13,9 @@ import (
 )
 
 var basketsDb BasketsDatabase
-var httpClient *http.Client
-var httpInsecureClient *http.Client
+var forwardingQueue *forwardQueue
+var retention *retentionSweeper
+var serverStopped = make(chan bool)
 var version *Version
 
 // CreateServer creates an instance of Request Baskets server
@@ -37,10 +38,26 @@ func CreateServer(config *ServerConfig) *http.Server {
 
 	basketsDb = db
 
-	// HTTP clients
-	httpClient = new(http.Client)
-	insecureTransport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	httpInsecureClient = &http.Client{Transport: insecureTransport}
+	// forwarding queue
+	forwardingQueue = newForwardQueue(config.FwdWorkers, config.FwdConns, config.FwdQueueSize, config.FwdOverflow)
+	// pending forwards are resumed in background, so the service does not wait for the backlog to be loaded
+	go func(queue *forwardQueue) {
+		if resumed := queue.Resume(db); resumed > 0 {
+			log.Printf("[info] resumed forwarding of %d queued request(s)", resumed)
+		}
+	}(forwardingQueue)
+
+	// deletion of expired requests and baskets
+	retention = newRetentionSweeper(db, config, retentionInterval)
+	if config.MaxAge > 0 {
+		log.Printf("[info] collected requests are deleted after: %s", config.MaxAge)
+	}
+	if config.BasketTTL > 0 {
+		log.Printf("[info] inactive baskets are deleted after: %s", config.BasketTTL)
+	}
+	if config.BasketLifetime > 0 {
+		log.Printf("[info] baskets are deleted after lifetime: %s", config.BasketLifetime)
+	}
 
 	// configure service HTTP router
 	pathPrefix := getPathPrefix(config)
@@ -66,16 +83,27 @@ func CreateServer(config *ServerConfig) *http.Server {
 	router.GET(pathPrefix+"/"+serviceAPIPath+"/version", GetVersion)
 	// basket names
 	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets", GetBaskets)
+	router.GET(pathPrefix+"/"+serviceAPIPath+"/export", ExportBaskets)
+	router.POST(pathPrefix+"/"+serviceAPIPath+"/import", ImportBaskets)
 	// basket management
 	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket", GetBasket)
 	router.POST(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket", CreateBasket)
 	router.PUT(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket", UpdateBasket)
 	router.DELETE(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket", DeleteBasket)
+	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/status", GetBasketStatus)
+	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/export", ExportBasket)
+	router.POST(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/import", ImportBasket)
 	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/responses/:method", GetBasketResponse)
 	router.PUT(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/responses/:method", UpdateBasketResponse)
 	// requests management
 	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/requests", GetBasketRequests)
 	router.DELETE(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/requests", ClearBasket)
+	router.POST(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/requests/:id/replay", ReplayRequest)
+	// undelivered requests management
+	router.GET(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/deadletters", GetBasketDeadLetters)
+	router.DELETE(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/deadletters", ClearBasketDeadLetters)
+	router.POST(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/deadletters/:id", RedeliverDeadLetter)
+	router.DELETE(pathPrefix+"/"+serviceAPIPath+"/baskets/:basket/deadletters/:id", DeleteDeadLetter)
 
 	// web pages
 	router.GET(pathPrefix+"/", ForwardToWeb)
@@ -91,7 +119,7 @@ func CreateServer(config *ServerConfig) *http.Server {
 	log.Printf("[info] HTTP server is listening on http://%s:%d", serverConfig.ServerAddr, serverConfig.ServerPort)
 	server := &http.Server{Addr: fmt.Sprintf("%s:%d", serverConfig.ServerAddr, serverConfig.ServerPort), Handler: router}
 
-	go shutdownHook()
+	go shutdownHook(server)
 	return server
 }
 
@@ -106,12 +134,40 @@ func createBasketsDatabase(dbtype string, file string, conn string) BasketsDatab
 			return NewSQLDatabase(conn)
 		}
 		return NewSQLDatabase(file)
+	case DbTypeRedis:
+		if len(conn) > 0 {
+			return NewRedisDatabase(conn)
+		}
+		return NewRedisDatabase(file)
 	default:
 		log.Printf("[error] unknown database type: %s", dbtype)
 		return nil
 	}
 }
 
+// migrateDatabase applies pending migrations to schema of SQL database, other types of databases have no schema
+func migrateDatabase(config *ServerConfig) error {
+	if config.DbType != DbTypeSQL {
+		log.Printf("[info] database type: %s has no schema to migrate", config.DbType)
+		return nil
+	}
+
+	conn := config.DbConnection
+	if len(conn) == 0 {
+		conn = config.DbFile
+	}
+	return MigrateSQLDatabase(conn, config.MigrateDryRun)
+}
+
+// rebuildDatabaseStats recreates statistics of Bolt database, other types of databases do not keep statistics
+func rebuildDatabaseStats(config *ServerConfig) error {
+	if config.DbType != DbTypeBolt {
+		log.Printf("[info] database type: %s has no statistics to rebuild", config.DbType)
+		return nil
+	}
+	return RebuildBoltStats(config.DbFile)
+}
+
 func getPathPrefix(config *ServerConfig) string {
 	pathPrefix := config.PathPrefix
 	if len(pathPrefix) > 0 {
@@ -121,28 +177,28 @@ func getPathPrefix(config *ServerConfig) string {
 	return pathPrefix
 }
 
-func shutdownHook() {
+func shutdownHook(server *http.Server) {
 	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
-
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		log.Printf("[info] received signal: %s, shutting down database", sig)
-		basketsDb.Release()
-		done <- true
-	}()
-
-	<-done
-	log.Printf("[info] terminating server")
-	os.Exit(0)
-}
 
-func getHTTPClient(insecure bool) *http.Client {
-	if insecure {
-		return httpInsecureClient
+	sig := <-sigs
+	log.Printf("[info] received signal: %s, shutting down server", sig)
+
+	// stop accepting new requests and let the active ones complete
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[warn] failed to gracefully shutdown HTTP server: %s", err)
 	}
-	return httpClient
+
+	log.Printf("[info] waiting for queued requests to be forwarded")
+	forwardingQueue.Shutdown(shutdownTimeout)
+
+	retention.Shutdown()
+
+	log.Printf("[info] shutting down database")
+	basketsDb.Release()
+	close(serverStopped)
 }
 
 func createDefaultBaskets(db BasketsDatabase, baskets []string) {
@@ -155,7 +211,7 @@ func createDefaultBasket(db BasketsDatabase, basket string) {
 	if !validBasketName.MatchString(basket) {
 		log.Printf("[error] invalid basket name to auto-create; '%s' does not match pattern: %s", basket, validBasketName.String())
 	} else {
-		auth, err := db.Create(basket, BasketConfig{ForwardURL: "", Capacity: serverConfig.InitCapacity})
+		auth, err := db.Create(context.Background(), basket, BasketConfig{ForwardURL: "", Capacity: serverConfig.InitCapacity})
 		if err != nil {
 			log.Printf("[error] %s", err)
 		} else {
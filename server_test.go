@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -32,8 +33,7 @@ func testsShutdown() {
 func TestCreateServer(t *testing.T) {
 	assert.NotNil(t, testServer, "HTTP server is expected")
 	assert.NotNil(t, basketsDb, "shared instance of basket database is expected")
-	assert.NotNil(t, httpClient, "default HTTP client is expected")
-	assert.NotNil(t, httpInsecureClient, "insecure HTTP client is expected")
+	assert.NotNil(t, forwardingQueue, "forwarding queue is expected")
 }
 
 func TestCreateServer_UnknownDbType(t *testing.T) {
@@ -63,14 +63,41 @@ func TestCreateBasketsDatabase(t *testing.T) {
 		sqldbconn.Release()
 	}
 
+	sqlitedb := createBasketsDatabase(DbTypeSQL, "./baskets.db", sqliteTestConnection)
+	if assert.NotNil(t, sqlitedb, "SQLite database is expected") {
+		sqlitedb.Release()
+	}
+
+	redisdb := createBasketsDatabase(DbTypeRedis, "./baskets.db", redisTestConnection())
+	if assert.NotNil(t, redisdb, "Redis database is expected") {
+		redisdb.Release()
+	}
+
 	assert.Nil(t, createBasketsDatabase("xyz", "./xyz", ""), "Database of unknown type is not expected")
 }
 
+func TestMigrateDatabase(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "migration06.db")
+	defer removeTestSQLiteDatabase(file)
+
+	assert.NoError(t, migrateDatabase(&ServerConfig{DbType: DbTypeMemory}), "in-memory database has nothing to migrate")
+	assert.NoError(t, migrateDatabase(&ServerConfig{DbType: DbTypeSQL, DbConnection: "sqlite://" + file}))
+	assert.Error(t, migrateDatabase(&ServerConfig{DbType: DbTypeSQL, DbFile: "./baskets.db"}), "database type is not expected to be detected")
+}
+
+func TestRebuildDatabaseStats(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "stats01.db")
+	defer os.Remove(file)
+
+	assert.NoError(t, rebuildDatabaseStats(&ServerConfig{DbType: DbTypeMemory}), "in-memory database has no statistics to rebuild")
+	assert.NoError(t, rebuildDatabaseStats(&ServerConfig{DbType: DbTypeBolt, DbFile: file}))
+}
+
 func TestCreateDefaultBaskets(t *testing.T) {
-	db := NewMemoryDatabase()
+	db := testDB(t, NewMemoryDatabase())
 	defer db.Release()
 
-	createDefaultBaskets(db, []string{"abc", "xyz", "illegal/name", "abc"})
+	createDefaultBaskets(db.BasketsDatabase, []string{"abc", "xyz", "illegal/name", "abc"})
 
 	assert.Equal(t, 2, db.Size(), "wrong database size")
 	assert.NotNil(t, db.Get("abc"), "default basket 'abc' is expected")
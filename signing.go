@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default settings of request signing, they produce signatures compatible with GitHub webhooks
+const (
+	defaultSigningAlgorithm = "sha256"
+	defaultSigningHeader    = "X-Hub-Signature-256"
+	defaultSigningFormat    = "sha256={{.signature}}"
+	defaultSigningMessage   = "{{.body}}"
+)
+
+var signingAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New}
+
+// SigningConfig describes HMAC signature of forwarded requests, the message template defines signed content
+// and the format template defines the value of signature header; secret is never exposed by API.
+type SigningConfig struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Header    string `json:"header,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+// Validate checks settings of request signing
+func (signing *SigningConfig) Validate() error {
+	if _, exists := signingAlgorithms[signing.algorithm()]; !exists {
+		return fmt.Errorf("unsupported signing algorithm: %s, supported algorithms: sha1, sha256, sha512",
+			signing.Algorithm)
+	}
+	if !validHTTPToken.MatchString(signing.header()) {
+		return fmt.Errorf("invalid name of signature header: %s", signing.Header)
+	}
+	if _, err := template.New("signing-format").Parse(signing.format()); err != nil {
+		return fmt.Errorf("invalid format of signature: %s", err)
+	}
+	if _, err := template.New("signing-message").Parse(signing.message()); err != nil {
+		return fmt.Errorf("invalid template of signed message: %s", err)
+	}
+	if len(signing.Secret) == 0 {
+		return errors.New("secret of request signing is not defined")
+	}
+
+	return nil
+}
+
+// Sign sets signature header of forwarded request, the body of forwarded request is passed separately,
+// so the request is not consumed
+func (signing *SigningConfig) Sign(req *http.Request, body string) error {
+	algorithm := signing.algorithm()
+	data := map[string]interface{}{
+		"method":    req.Method,
+		"url":       req.URL.String(),
+		"body":      body,
+		"timestamp": time.Now().Unix(),
+		"algorithm": algorithm}
+
+	message, err := executeSigningTemplate("signing-message", signing.message(), data)
+	if err != nil {
+		return fmt.Errorf("failed to build signed message - %s", err)
+	}
+
+	newHash, exists := signingAlgorithms[algorithm]
+	if !exists {
+		return fmt.Errorf("unsupported signing algorithm: %s", signing.Algorithm)
+	}
+	mac := hmac.New(newHash, []byte(signing.Secret))
+	mac.Write([]byte(message))
+	sum := mac.Sum(nil)
+	data["signature"] = hex.EncodeToString(sum)
+	data["signature_base64"] = base64.StdEncoding.EncodeToString(sum)
+
+	value, err := executeSigningTemplate("signing-format", signing.format(), data)
+	if err != nil {
+		return fmt.Errorf("failed to format signature - %s", err)
+	}
+	req.Header.Set(signing.header(), value)
+
+	return nil
+}
+
+func (signing *SigningConfig) algorithm() string {
+	if len(signing.Algorithm) > 0 {
+		return strings.ToLower(signing.Algorithm)
+	}
+	return defaultSigningAlgorithm
+}
+
+func (signing *SigningConfig) header() string {
+	if len(signing.Header) > 0 {
+		return signing.Header
+	}
+	return defaultSigningHeader
+}
+
+func (signing *SigningConfig) format() string {
+	if len(signing.Format) > 0 {
+		return signing.Format
+	}
+	return defaultSigningFormat
+}
+
+func (signing *SigningConfig) message() string {
+	if len(signing.Message) > 0 {
+		return signing.Message
+	}
+	return defaultSigningMessage
+}
+
+func executeSigningTemplate(name string, text string, data map[string]interface{}) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var value strings.Builder
+	if err = t.Execute(&value, data); err != nil {
+		return "", err
+	}
+	return value.String(), nil
+}
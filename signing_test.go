@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func expectedSignature(secret string, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func TestSigningConfig_Sign_Defaults(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost/hook", nil)
+	signing := &SigningConfig{Secret: "secret"}
+
+	if assert.NoError(t, signing.Sign(req, "{\"event\":\"push\"}")) {
+		assert.Equal(t, "sha256="+hex.EncodeToString(expectedSignature("secret", "{\"event\":\"push\"}")),
+			req.Header.Get("X-Hub-Signature-256"), "wrong signature")
+	}
+}
+
+func TestSigningConfig_Sign_Format(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost/hook", nil)
+	signing := &SigningConfig{Header: "Stripe-Signature", Format: "t={{.timestamp}},v1={{.signature}}",
+		Message: "{{.timestamp}}.{{.body}}", Secret: "whsec"}
+
+	if assert.NoError(t, signing.Sign(req, "{\"id\":\"evt\"}")) {
+		value := req.Header.Get("Stripe-Signature")
+		match := regexp.MustCompile(`^t=(\d+),v1=([0-9a-f]+)$`).FindStringSubmatch(value)
+		if assert.NotNil(t, match, "wrong format of signature: %s", value) {
+			message := fmt.Sprintf("%s.{\"id\":\"evt\"}", match[1])
+			assert.Equal(t, hex.EncodeToString(expectedSignature("whsec", message)), match[2], "wrong signature")
+		}
+	}
+}
+
+func TestSigningConfig_Sign_Algorithm(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost/hook", nil)
+	signing := &SigningConfig{Algorithm: "SHA1", Header: "X-Signature", Format: "{{.signature_base64}}", Secret: "s"}
+
+	if assert.NoError(t, signing.Sign(req, "abc")) {
+		mac := hmac.New(sha1.New, []byte("s"))
+		mac.Write([]byte("abc"))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), req.Header.Get("X-Signature"),
+			"wrong signature")
+	}
+}
+
+func TestSigningConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&SigningConfig{Secret: "s"}).Validate(), "default settings are expected to be valid")
+
+	for message, signing := range map[string]*SigningConfig{
+		"unsupported signing algorithm":            {Algorithm: "md5", Secret: "s"},
+		"invalid name of signature header":         {Header: "X Signature", Secret: "s"},
+		"invalid format of signature":              {Format: "{{.signature", Secret: "s"},
+		"invalid template of signed message":       {Message: "{{end}}", Secret: "s"},
+		"secret of request signing is not defined": {Algorithm: "sha512"},
+	} {
+		err := signing.Validate()
+		if assert.Error(t, err, "signing settings are expected to be invalid: %+v", signing) {
+			assert.Contains(t, err.Error(), message, "error is not detailed enough")
+		}
+	}
+}
+
+func TestNewForwardRequest_Signing(t *testing.T) {
+	request := createTestRequestData("/sign01", "{\"a\":1}")
+	config := BasketConfig{ForwardURL: "http://localhost/hook", ForwardBodyTemplate: "{\"method\":\"{{.method}}\"}",
+		Signing: &SigningConfig{Secret: "secret"}}
+
+	forwardReq, err := request.NewForwardRequest(config, "sign01")
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(forwardReq.Body)
+		assert.Equal(t, "{\"method\":\"POST\"}", string(body), "wrong body of forwarded request")
+		assert.Equal(t, "sha256="+hex.EncodeToString(expectedSignature("secret", string(body))),
+			forwardReq.Header.Get("X-Hub-Signature-256"), "transformed body is expected to be signed")
+	}
+
+	config.Signing = &SigningConfig{Format: "{{index .signature 100}}", Secret: "secret"}
+	_, err = request.NewForwardRequest(config, "sign01")
+	if assert.Error(t, err, "invalid signature format is expected to fail") {
+		assert.Contains(t, err.Error(), "failed to format signature", "error is not detailed enough")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// tlsVersions maps supported values of minimum TLS version to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13}
+
+// tlsProfileFiles describes named TLS profile of server configuration, certificates and keys are read from files
+type tlsProfileFiles struct {
+	CAFile     string `json:"ca_file"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+	MinVersion string `json:"min_version"`
+}
+
+// Build creates TLS configuration of HTTP client according to TLS settings
+func (config *TLSConfig) Build() (*tls.Config, error) {
+	tlsConfig := new(tls.Config)
+
+	if len(config.CACerts) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(config.CACerts)) {
+			return nil, errors.New("no valid CA certificates are found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(config.ClientCert) > 0 || len(config.ClientKey) > 0 {
+		cert, err := tls.X509KeyPair([]byte(config.ClientCert), []byte(config.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.MinVersion) > 0 {
+		version, ok := tlsVersions[config.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version: %s, supported versions: 1.0, 1.1, 1.2, 1.3", config.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	return tlsConfig, nil
+}
+
+// fingerprint identifies TLS settings, so HTTP clients with the same settings can be shared
+func (config *TLSConfig) fingerprint() string {
+	data, _ := json.Marshal(config)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// tlsConfigOf resolves TLS settings of basket configuration that are either defined by named TLS profile of server
+// configuration or by basket itself; returns nil if TLS settings are not defined
+func tlsConfigOf(config BasketConfig) (*TLSConfig, error) {
+	transport := config.Transport
+	if transport == nil {
+		return nil, nil
+	}
+
+	if len(transport.TLSProfile) > 0 {
+		profile, exists := serverConfig.TLSProfiles[transport.TLSProfile]
+		if !exists {
+			return nil, fmt.Errorf("unknown TLS profile: %s", transport.TLSProfile)
+		}
+		return profile, nil
+	}
+
+	return transport.TLS, nil
+}
+
+// loadTLSProfiles reads named TLS profiles from JSON file, invalid profiles are skipped
+func loadTLSProfiles(file string) (map[string]*TLSConfig, error) {
+	profiles := make(map[string]*TLSConfig)
+	if len(file) == 0 {
+		return profiles, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return profiles, err
+	}
+
+	files := make(map[string]tlsProfileFiles)
+	if err = json.Unmarshal(data, &files); err != nil {
+		return profiles, fmt.Errorf("invalid TLS profiles file: %s - %s", file, err)
+	}
+
+	for name, profileFiles := range files {
+		profile, err := profileFiles.load()
+		if err == nil {
+			_, err = profile.Build()
+		}
+
+		if err != nil {
+			return profiles, fmt.Errorf("invalid TLS profile: %s - %s", name, err)
+		}
+		profiles[name] = profile
+		log.Printf("[info] loaded TLS profile: %s", name)
+	}
+
+	return profiles, nil
+}
+
+// load reads certificates and keys of TLS profile from files
+func (files tlsProfileFiles) load() (*TLSConfig, error) {
+	profile := &TLSConfig{MinVersion: files.MinVersion}
+
+	for _, entry := range []struct {
+		file  string
+		value *string
+	}{
+		{files.CAFile, &profile.CACerts},
+		{files.CertFile, &profile.ClientCert},
+		{files.KeyFile, &profile.ClientKey}} {
+		if len(entry.file) > 0 {
+			data, err := ioutil.ReadFile(entry.file)
+			if err != nil {
+				return nil, err
+			}
+			*entry.value = string(data)
+		}
+	}
+
+	return profile, nil
+}
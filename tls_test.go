@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTestCertificate creates certificate signed by parent certificate or self-signed certificate if parent is nil,
+// returns the certificate, its key and their PEM encoding
+func createTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (
+	*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	return cert, key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+// serverCertificatePEM returns PEM encoded certificate of test TLS server
+func serverCertificatePEM(ts *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}))
+}
+
+func TestTLSConfig_Build(t *testing.T) {
+	_, _, certPEM, keyPEM := createTestCertificate(t, "client", nil, nil)
+
+	tlsConfig, err := (&TLSConfig{CACerts: certPEM, ClientCert: certPEM, ClientKey: keyPEM, MinVersion: "1.2"}).Build()
+	if assert.NoError(t, err) {
+		assert.NotNil(t, tlsConfig.RootCAs, "CA certificates are expected")
+		assert.Equal(t, 1, len(tlsConfig.Certificates), "client certificate is expected")
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion, "wrong minimum TLS version")
+	}
+
+	_, err = (&TLSConfig{CACerts: "abc"}).Build()
+	assert.Error(t, err, "invalid CA certificates are not expected to be accepted")
+	_, err = (&TLSConfig{ClientCert: certPEM}).Build()
+	assert.Error(t, err, "client certificate without key is not expected to be accepted")
+	_, err = (&TLSConfig{MinVersion: "1.4"}).Build()
+	assert.Error(t, err, "unsupported TLS version is not expected to be accepted")
+}
+
+func TestForward_CustomCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	// certificate of test server is not trusted by default
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20}
+	result, _, err := forwardOnce(createTestRequestData("/tls01", "abc"), config, "tls01")
+	if assert.Error(t, err) {
+		assert.Contains(t, result.Error, "certificate", "error is not detailed enough")
+	}
+
+	config.Transport = &TransportConfig{TLS: &TLSConfig{CACerts: serverCertificatePEM(ts)}}
+	result, _, err = forwardOnce(createTestRequestData("/tls01", "abc"), config, "tls01")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, result.Status, "wrong status of target response")
+	}
+}
+
+func TestForward_ClientCertificate(t *testing.T) {
+	ca, caKey, _, _ := createTestCertificate(t, "test-ca", nil, nil)
+	_, _, certPEM, keyPEM := createTestCertificate(t, "rbaskets", ca, caKey)
+
+	var client string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.WriteHeader(http.StatusOK)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// client certificate is required
+	tlsConfig := &TLSConfig{CACerts: serverCertificatePEM(ts), MinVersion: "1.2"}
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, Transport: &TransportConfig{TLS: tlsConfig}}
+	_, _, err := forwardOnce(createTestRequestData("/tls02", "abc"), config, "tls02")
+	assert.Error(t, err, "request without client certificate is not expected to be accepted")
+
+	tlsConfig = &TLSConfig{CACerts: serverCertificatePEM(ts), ClientCert: certPEM, ClientKey: keyPEM, MinVersion: "1.2"}
+	config.Transport = &TransportConfig{TLS: tlsConfig}
+	result, _, err := forwardOnce(createTestRequestData("/tls02", "abc"), config, "tls02")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, result.Status, "wrong status of target response")
+		assert.Equal(t, "rbaskets", client, "wrong client certificate")
+	}
+}
+
+func TestLoadTLSProfiles(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "tls-profiles")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, []byte(serverCertificatePEM(ts)), 0600)
+	profilesFile := filepath.Join(dir, "profiles.json")
+	ioutil.WriteFile(profilesFile, []byte(`{
+		"internal": {"ca_file": "`+caFile+`", "min_version": "1.2"}}`), 0600)
+
+	profiles, err := loadTLSProfiles(profilesFile)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, len(profiles), "wrong number of TLS profiles")
+		if profile := profiles["internal"]; assert.NotNil(t, profile, "TLS profile is expected") {
+			assert.Equal(t, serverCertificatePEM(ts), profile.CACerts, "wrong CA certificates")
+			assert.Equal(t, "1.2", profile.MinVersion, "wrong minimum TLS version")
+		}
+	}
+
+	// forward with named TLS profile
+	defaultProfiles := serverConfig.TLSProfiles
+	serverConfig.TLSProfiles = profiles
+	defer func() { serverConfig.TLSProfiles = defaultProfiles }()
+
+	config := BasketConfig{ForwardURL: ts.URL, Capacity: 20, Transport: &TransportConfig{TLSProfile: "internal"}}
+	result, _, err := forwardOnce(createTestRequestData("/tls03", "abc"), config, "tls03")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, result.Status, "wrong status of target response")
+	}
+
+	_, err = loadTLSProfiles(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err, "missing file of TLS profiles is expected to be reported")
+
+	for name, profile := range map[string]string{
+		"missing": `{"ca_file": "` + filepath.Join(dir, "missing.pem") + `"}`,
+		"invalid": `{"min_version": "0.9"}`} {
+		invalidFile := filepath.Join(dir, name+".json")
+		ioutil.WriteFile(invalidFile, []byte(`{"internal": {"ca_file": "`+caFile+`"}, "`+name+`": `+profile+`}`), 0600)
+		_, err = loadTLSProfiles(invalidFile)
+		if assert.Error(t, err, "invalid TLS profile is expected to be reported") {
+			assert.Contains(t, err.Error(), "invalid TLS profile: "+name, "error is not detailed enough")
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600)
+	_, err = loadTLSProfiles(filepath.Join(dir, "broken.json"))
+	assert.Error(t, err, "broken file of TLS profiles is expected to be reported")
+
+	profiles, err = loadTLSProfiles("")
+	assert.NoError(t, err)
+	assert.Empty(t, profiles, "no TLS profiles are expected")
+}
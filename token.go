@@ -3,6 +3,7 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 )
 
 // GenerateToken generates a cryptographically strong token that uses only base64 characters
@@ -15,3 +16,14 @@ func GenerateToken() (string, error) {
 
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
+
+// GenerateID generates a random identifier that consists of hexadecimal characters
+func GenerateID() (string, error) {
+	bytes := make([]byte, 12)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyDirect is the proxy URL that disables proxy to forward requests
+const ProxyDirect = "direct"
+
+// clientSettings describes effective settings of HTTP client used to forward requests,
+// HTTP clients are shared by baskets with the same settings
+type clientSettings struct {
+	insecure        bool
+	connectTimeout  time.Duration
+	tlsTimeout      time.Duration
+	timeout         time.Duration
+	followRedirects bool
+	http2           bool
+	tls             string
+	proxy           string
+}
+
+// maxForwardClients is the number of HTTP clients with distinct settings that are kept to forward requests,
+// idle connections of evicted clients are closed
+const maxForwardClients = 100
+
+var forwardClients = newLRUCache(maxForwardClients, func(key interface{}, value interface{}) {
+	value.(*http.Client).CloseIdleConnections()
+})
+
+// getHTTPClient returns HTTP client to forward requests according to basket configuration
+func getHTTPClient(config BasketConfig) (*http.Client, error) {
+	settings := settingsOf(config)
+	tlsConfig, err := tlsConfigOf(config)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		settings.tls = tlsConfig.fingerprint()
+	}
+
+	client, err := forwardClients.getOrCreate(settings, func() (interface{}, error) {
+		client, err := newHTTPClient(settings, tlsConfig)
+		if err == nil {
+			logged := settings
+			logged.proxy = redactProxy(settings.proxy)
+			log.Printf("[info] created HTTP client to forward requests: %+v", logged)
+		}
+		return client, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*http.Client), nil
+}
+
+// settingsOf resolves settings of HTTP client for basket configuration, server defaults apply to undefined settings
+func settingsOf(config BasketConfig) clientSettings {
+	settings := clientSettings{
+		insecure:        config.InsecureTLS,
+		connectTimeout:  serverConfig.FwdConnectTimeout,
+		tlsTimeout:      serverConfig.FwdTLSTimeout,
+		timeout:         serverConfig.FwdTimeout,
+		followRedirects: serverConfig.FwdRedirects,
+		http2:           serverConfig.FwdHTTP2,
+		proxy:           serverConfig.FwdProxy}
+
+	if transport := config.Transport; transport != nil {
+		if transport.ConnectTimeout > 0 {
+			settings.connectTimeout = time.Duration(transport.ConnectTimeout) * time.Millisecond
+		}
+		if transport.TLSTimeout > 0 {
+			settings.tlsTimeout = time.Duration(transport.TLSTimeout) * time.Millisecond
+		}
+		if transport.Timeout > 0 {
+			settings.timeout = time.Duration(transport.Timeout) * time.Millisecond
+		}
+		if transport.FollowRedirects != nil {
+			settings.followRedirects = *transport.FollowRedirects
+		}
+		if transport.HTTP2 != nil {
+			settings.http2 = *transport.HTTP2
+		}
+		if proxy := transport.Proxy; proxy != nil && len(proxy.URL) > 0 {
+			settings.proxy = proxy.String()
+		}
+	}
+
+	return settings
+}
+
+// newHTTPClient creates HTTP client with specified settings
+func newHTTPClient(settings clientSettings, tlsConfig *TLSConfig) (*http.Client, error) {
+	clientTLS := new(tls.Config)
+	if tlsConfig != nil {
+		var err error
+		if clientTLS, err = tlsConfig.Build(); err != nil {
+			return nil, err
+		}
+	}
+	clientTLS.InsecureSkipVerify = settings.insecure
+
+	proxy, err := proxyFunc(settings.proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: settings.connectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       clientTLS,
+		TLSHandshakeTimeout:   settings.tlsTimeout,
+		ForceAttemptHTTP2:     settings.http2,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second}
+
+	if !settings.http2 {
+		// non-nil empty map disables HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	client := &http.Client{Transport: transport, Timeout: settings.timeout}
+	if !settings.followRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return client, nil
+}
+
+// String returns URL of proxy with credentials
+func (proxy *ProxyConfig) String() string {
+	if proxy.URL == ProxyDirect || len(proxy.Username) == 0 {
+		return proxy.URL
+	}
+
+	u, err := url.Parse(proxy.URL)
+	if err != nil {
+		return proxy.URL
+	}
+	u.User = url.UserPassword(proxy.Username, proxy.Password)
+	return u.String()
+}
+
+// parseProxyURL parses and validates URL of HTTP or SOCKS5 proxy, credentials may be defined as user info of URL
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s, supported schemes: http, https, socks5", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("invalid proxy URL: host is not defined")
+	}
+	return u, nil
+}
+
+// proxyFunc returns function that selects proxy to forward requests: proxy from environment if proxy is undefined,
+// no proxy for "direct", otherwise the specified HTTP or SOCKS5 proxy
+func proxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return nil, nil
+	}
+
+	u, err := parseProxyURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(u), nil
+}
+
+// redactProxy hides password of proxy URL, so it can be logged
+func redactProxy(proxy string) string {
+	if u, err := url.Parse(proxy); err == nil {
+		return u.Redacted()
+	}
+	return proxy
+}
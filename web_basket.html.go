@@ -25,6 +25,10 @@ const (
     var fetchedRequests = {};
     var totalCount = 0;
     var currentConfig;
+    var initialTransport;
+    var initialSigning;
+    var replayedRequest;
+    var basketExpiry = {{.BasketExpiry}};
 
     var autoRefresh = false;
     var autoRefreshId;
@@ -99,10 +103,14 @@ const (
       var html = '<div class="row"><div class="col-md-2"><h4 class="text-' + headerClass + '">[' + request.method + ']</h4>' +
         '<div><i class="glyphicon glyphicon-time" title="' + date.toString() + '"></i> ' + date.toLocaleTimeString() +
         '</div><div><i class="glyphicon glyphicon-calendar" title="' + date.toString() + '"></i> ' + date.toLocaleDateString() +
-        '</div></div><div class="col-md-10"><div class="panel-group" id="' + id + '">' +
+        '</div>' + (request.forward_rule ? '<div><span class="label label-info" title="Fired forward rule">' +
+        escapeHTML(request.forward_rule) + '</span></div>' : '') +
+        '</div><div class="col-md-10"><div class="panel-group" id="' + id + '">' +
         '<div class="panel panel-' + headerClass + '"><div class="panel-heading"><h4 class="panel-title">' + escapeHTML(path) +
         '<span id="' + id + '_copy_request_btn" for="' + requestId + '" class="pull-right copy-req-btn">' +
-        '<span title="Copy Request Details" class="glyphicon glyphicon-copy"></span></span></h4></div></div>' +
+        '<span title="Copy Request Details" class="glyphicon glyphicon-copy"></span></span>' +
+        (request.id ? '<span id="' + id + '_replay_btn" for="' + requestId + '" class="pull-right" style="margin-right: 10px">' +
+        '<span title="Replay Request" class="glyphicon glyphicon-send"></span></span>' : '') + '</h4></div></div>' +
         '<div class="panel panel-default"><div class="panel-heading"><h4 class="panel-title">' +
         '<a class="collapsed" data-toggle="collapse" data-parent="#' + id + '" href="#' + id + '_headers">Headers</a></h4></div>' +
         '<div id="' + id + '_headers" class="panel-collapse collapse">' +
@@ -122,11 +130,90 @@ const (
           '<div class="panel-body"><pre>' + escapeHTML(request.body) + '</pre></div></div></div>';
       }
 
+      if (request.forwards) {
+        html += '<div class="panel panel-default"><div class="panel-heading"><h4 class="panel-title">' +
+          '<a class="collapsed" data-toggle="collapse" data-parent="#' + id + '" href="#' + id + '_forwards">Forwards</a> ' +
+          renderForwardStatuses(request.forwards) + '</h4></div>' +
+          '<div id="' + id + '_forwards" class="panel-collapse collapse">' +
+          '<div class="panel-body">' + renderForwards(request.forwards, request.method) + '</div></div></div>';
+      }
+
+      if (request.frames) {
+        html += '<div class="panel panel-default"><div class="panel-heading"><h4 class="panel-title">' +
+          '<a class="collapsed" data-toggle="collapse" data-parent="#' + id + '" href="#' + id + '_frames">WebSocket</a> ' +
+          '<span class="badge" title="Recorded frames">' + request.frames.length + '</span></h4></div>' +
+          '<div id="' + id + '_frames" class="panel-collapse collapse in">' +
+          '<div class="panel-body">' + renderFrames(request.frames) + '</div></div></div>';
+      }
+
       html += '</div></div></div><hr/>';
 
       return html;
     }
 
+    function getForwardClass(result) {
+      if (result.rejected) {
+        return "warning";
+      } else if (result.error || result.status >= 500) {
+        return "danger";
+      } else if (result.status >= 400) {
+        return "warning";
+      }
+      return "success";
+    }
+
+    function getForwardStatus(result) {
+      if (result.status) {
+        return result.status;
+      }
+      return result.rejected ? "rejected" : "error";
+    }
+
+    function renderForwardStatuses(forwards) {
+      var html = "";
+      for (var i = 0; i < forwards.length; i++) {
+        html += '<span class="label label-' + getForwardClass(forwards[i]) + '" title="' + escapeHTML(forwards[i].url) + '">' +
+          getForwardStatus(forwards[i]) + '</span> ';
+      }
+      return html;
+    }
+
+    function renderForwards(forwards, method) {
+      var html = "";
+      for (var i = 0; i < forwards.length; i++) {
+        var result = forwards[i];
+        html += '<p><span class="label label-' + getForwardClass(result) + '">' + getForwardStatus(result) +
+          '</span> <code>' + escapeHTML(result.payload ? result.payload.method : method) + ' ' + escapeHTML(result.url) + '</code> ' +
+          '<i class="glyphicon glyphicon-time" title="Duration"></i> ' + result.duration + ' ms' +
+          (result.attempts > 1 ? ', ' + result.attempts + ' attempts' : '') + '</p>';
+        if (result.error) {
+          html += '<pre class="text-danger">' + escapeHTML(result.error) + '</pre>';
+        }
+        if (result.body) {
+          html += '<pre>' + escapeHTML(result.body) + (result.truncated ? '\n...' : '') + '</pre>';
+        }
+      }
+      return html;
+    }
+
+    function renderFrames(frames) {
+      var html = "";
+      for (var i = 0; i < frames.length; i++) {
+        var frame = frames[i];
+        var incoming = frame.direction == "in";
+        html += '<p><span class="label label-' + (incoming ? 'info' : 'success') + '" title="' +
+          (incoming ? 'From client' : 'To client') + '"><i class="glyphicon glyphicon-arrow-' + (incoming ? 'right' : 'left') +
+          '"></i> ' + frame.direction + '</span> <code>' + escapeHTML(frame.type) + (frame.final ? '' : ', not final') + '</code> ' +
+          '<i class="glyphicon glyphicon-time" title="' + new Date(frame.date).toString() + '"></i> ' +
+          new Date(frame.date).toLocaleTimeString() + ', ' + frame.size + ' bytes' +
+          (frame.encoding ? ', ' + escapeHTML(frame.encoding) : '') + '</p>';
+        if (frame.data) {
+          html += '<pre>' + escapeHTML(frame.data) + (frame.truncated ? '\n...' : '') + '</pre>';
+        }
+      }
+      return html;
+    }
+
     function addRequests(data) {
       totalCount = data.total_count;
       $("#requests_count").html(data.count + " (" + totalCount + ")");
@@ -163,6 +250,9 @@ const (
           $("#" + requestId + "_copy_request_btn").on("click", function(event) {
             copyRequest(this);
           });
+          $("#" + requestId + "_replay_btn").on("click", function(event) {
+            openReplay(this);
+          });
 
           fetchedCount++;
         }
@@ -278,6 +368,72 @@ const (
       }
     }
 
+    function openReplay(btn) {
+      replayedRequest = JSON.parse(fetchedRequests[$(btn).attr("for")]);
+      $("#replay_url").val("");
+      $("#replay_headers").val("");
+      $("#replay_body").val(replayedRequest.body || "");
+      $("#replay_response").addClass("hide");
+      $("#replay_dialog").modal();
+    }
+
+    function collectReplayHeaders() {
+      // one header per line: "Name: value", header without value is removed
+      var headers = {};
+      var lines = $("#replay_headers").val().split("\n");
+      for (var i = 0; i < lines.length; i++) {
+        var line = lines[i].trim();
+        var pos = line.indexOf(":");
+        if (pos > 0) {
+          var name = line.substring(0, pos).trim();
+          var value = line.substring(pos + 1).trim();
+          headers[name] = headers[name] || [];
+          if (value) {
+            headers[name].push(value);
+          }
+        }
+      }
+      return headers;
+    }
+
+    function replayRequest() {
+      var options = { headers: collectReplayHeaders() };
+      var url = $("#replay_url").val().trim();
+      if (url) {
+        options.url = url;
+      }
+      var body = $("#replay_body").val();
+      if (body != (replayedRequest.body || "")) {
+        options.body = body;
+      }
+
+      $.ajax({
+        method: "POST",
+        url: "{{.Prefix}}/api/baskets/{{.Basket}}/requests/" + replayedRequest.id + "/replay",
+        dataType: "text",
+        data: JSON.stringify(options),
+        headers: {
+          "Authorization" : getToken()
+        }
+      }).done(function(data, status, jqXHR) {
+        showReplayResponse(jqXHR);
+      }).fail(function(jqXHR) {
+        if (jqXHR.status == 401) {
+          onAjaxError(jqXHR);
+        } else {
+          showReplayResponse(jqXHR);
+        }
+      });
+    }
+
+    function showReplayResponse(jqXHR) {
+      $("#replay_response_status").removeClass("label-success label-warning label-danger")
+        .addClass("label-" + getForwardClass({ status: jqXHR.status }))
+        .text("HTTP " + jqXHR.status + " " + jqXHR.statusText);
+      $("#replay_response_body").text(jqXHR.responseText || "");
+      $("#replay_response").removeClass("hide");
+    }
+
     function copyBasketUrl(btn) {
       var button = $(btn);
       if (copyToClipboard(basketUrl, button.get(0))) {
@@ -297,6 +453,27 @@ const (
       }).done(addRequests).fail(onAjaxError);
     }
 
+    function fetchExpiry() {
+      if (!basketExpiry) {
+        return;
+      }
+      $.ajax({
+        method: "GET",
+        url: "{{.Prefix}}/api/baskets/{{.Basket}}",
+        headers: {
+          "Authorization" : getToken()
+        }
+      }).done(function(data) {
+        if (data && data.expiry_date) {
+          var date = new Date(data.expiry_date);
+          $("#expiry_date").html(date.toLocaleDateString() + " " + date.toLocaleTimeString()).attr("title", date.toString());
+          $("#basket_expiry").removeClass("hide");
+        } else {
+          $("#basket_expiry").addClass("hide");
+        }
+      });
+    }
+
     function fetchTotalCount() {
       $.ajax({
         method: "GET",
@@ -401,19 +578,298 @@ const (
       }).fail(onAjaxError);
     }
 
+    function displayTargets(targets) {
+      $("#basket_forward_targets").html(""); // reset
+      for (var index = 0; index < targets.length; ++index) {
+        addTarget(targets[index]);
+      }
+    }
+
+    function addTarget(target) {
+      target = target || {};
+      var index = $("#basket_forward_targets > div.row").length;
+      var row = $('<div class="row"><div class="col-md-6"><input type="input" class="form-control" id="target_url_' + index +
+        '" placeholder="URL"></div><div class="col-md-6">' +
+        '<label class="checkbox-inline"><input type="checkbox" id="target_insecure_tls_' + index + '"> Insecure TLS</label>' +
+        '<label class="checkbox-inline"><input type="checkbox" id="target_expand_path_' + index + '"> Expand Path</label>' +
+        '<label class="checkbox-inline"><input type="checkbox" id="target_primary_' + index + '"> ' +
+        '<abbr title="Response of primary target is proxied back to the client">Primary</abbr></label></div></div>');
+      row.appendTo($("#basket_forward_targets"));
+
+      $("#target_url_" + index).val(target.url);
+      $("#target_insecure_tls_" + index).prop("checked", target.insecure_tls);
+      $("#target_expand_path_" + index).prop("checked", target.expand_path);
+      $("#target_primary_" + index).prop("checked", target.primary);
+    }
+
+    function collectTargets() {
+      var targets = [];
+      $("#basket_forward_targets > div.row").each( function(index) {
+        var url = $("#target_url_" + index).val();
+        if (url && url.length > 0) {
+          targets.push({
+            "url": url,
+            "insecure_tls": $("#target_insecure_tls_" + index).prop("checked"),
+            "expand_path": $("#target_expand_path_" + index).prop("checked"),
+            "primary": $("#target_primary_" + index).prop("checked")
+          });
+        }
+      });
+      return targets;
+    }
+
+    function displayHeaderRules(rules) {
+      $("#basket_header_rules").html(""); // reset
+      for (var index = 0; index < rules.length; ++index) {
+        addHeaderRule(rules[index]);
+      }
+    }
+
+    function addHeaderRule(rule) {
+      rule = rule || {};
+      var index = $("#basket_header_rules > div.row").length;
+      var row = $('<div class="row"><div class="col-md-3"><select class="form-control" id="header_rule_action_' + index + '">' +
+        '<option value="set">Set</option><option value="add">Add</option><option value="remove">Remove</option></select></div>' +
+        '<div class="col-md-4"><input type="input" class="form-control" id="header_rule_name_' + index + '" placeholder="Header"></div>' +
+        '<div class="col-md-5"><input type="input" class="form-control" id="header_rule_value_' + index + '" placeholder="Value"></div></div>');
+      row.appendTo($("#basket_header_rules"));
+
+      $("#header_rule_action_" + index).val(rule.action || "set");
+      $("#header_rule_name_" + index).val(rule.name);
+      $("#header_rule_value_" + index).val(rule.value);
+    }
+
+    function collectHeaderRules() {
+      var rules = [];
+      $("#basket_header_rules > div.row").each( function(index) {
+        var name = $("#header_rule_name_" + index).val();
+        if (name && name.length > 0) {
+          var rule = { "action": $("#header_rule_action_" + index).val(), "name": name };
+          var value = $("#header_rule_value_" + index).val();
+          if (rule.action != "remove" && value && value.length > 0) {
+            rule.value = value;
+          }
+          rules.push(rule);
+        }
+      });
+      return rules;
+    }
+
+    function displayTransport(transport) {
+      var tls = transport.tls || {};
+      var proxy = transport.proxy || {};
+      $("#basket_connect_timeout").val(transport.connect_timeout || "");
+      $("#basket_tls_timeout").val(transport.tls_timeout || "");
+      $("#basket_timeout").val(transport.timeout || "");
+      $("#basket_follow_redirects").val(transport.follow_redirects === undefined ? "" : String(transport.follow_redirects));
+      $("#basket_http2").val(transport.http2 === undefined ? "" : String(transport.http2));
+      $("#basket_tls_profile").val(transport.tls_profile || "");
+      $("#basket_tls_min_version").val(tls.min_version || "");
+      $("#basket_tls_ca_certs").val(tls.ca_certs || "");
+      $("#basket_tls_client_cert").val(tls.client_cert || "");
+      $("#basket_tls_client_key").val("");
+      $("#basket_proxy_url").val(proxy.url || "");
+      $("#basket_proxy_username").val(proxy.username || "");
+      $("#basket_proxy_password").val("");
+      initialTransport = JSON.stringify(collectTransport());
+    }
+
+    function collectTransport() {
+      // all settings are defined explicitly, so the settings that are not defined anymore are reset
+      var transport = {
+        connect_timeout: parseInt($("#basket_connect_timeout").val()) || 0,
+        tls_timeout: parseInt($("#basket_tls_timeout").val()) || 0,
+        timeout: parseInt($("#basket_timeout").val()) || 0,
+        follow_redirects: $("#basket_follow_redirects").val() ? $("#basket_follow_redirects").val() == "true" : null,
+        http2: $("#basket_http2").val() ? $("#basket_http2").val() == "true" : null,
+        tls_profile: $("#basket_tls_profile").val().trim(),
+        tls: null,
+        proxy: null
+      };
+      var tls = {
+        ca_certs: $("#basket_tls_ca_certs").val().trim(),
+        client_cert: $("#basket_tls_client_cert").val().trim(),
+        min_version: $("#basket_tls_min_version").val()
+      };
+      // client key is never displayed, it remains unchanged unless a new key is provided or certificate is removed
+      var key = $("#basket_tls_client_key").val().trim();
+      if (key || !tls.client_cert) {
+        tls.client_key = key;
+      }
+      if (tls.ca_certs || tls.client_cert || tls.min_version) {
+        transport.tls = tls;
+      }
+      var proxy = {
+        url: $("#basket_proxy_url").val().trim(),
+        username: $("#basket_proxy_username").val().trim()
+      };
+      // proxy password is never displayed, it remains unchanged unless a new password is provided or username is removed
+      var password = $("#basket_proxy_password").val();
+      if (password || !proxy.username) {
+        proxy.password = password;
+      }
+      if (proxy.url || proxy.username) {
+        transport.proxy = proxy;
+      }
+
+      if (!transport.connect_timeout && !transport.tls_timeout && !transport.timeout && transport.follow_redirects === null &&
+        transport.http2 === null && !transport.tls_profile && !transport.tls && !transport.proxy) {
+        return null;
+      }
+      return transport;
+    }
+
+    function displaySigning(signing) {
+      $("#basket_signing_enabled").prop("checked", !!signing);
+      signing = signing || {};
+      $("#basket_signing_algorithm").val(signing.algorithm || "");
+      $("#basket_signing_header").val(signing.header || "");
+      $("#basket_signing_format").val(signing.format || "");
+      $("#basket_signing_message").val(signing.message || "");
+      $("#basket_signing_secret").val("");
+      initialSigning = JSON.stringify(collectSigning());
+    }
+
+    function collectSigning() {
+      if (!$("#basket_signing_enabled").prop("checked")) {
+        return null;
+      }
+      // all settings are defined explicitly, so the settings that are not defined anymore are reset to defaults
+      var signing = {
+        algorithm: $("#basket_signing_algorithm").val(),
+        header: $("#basket_signing_header").val().trim(),
+        format: $("#basket_signing_format").val().trim(),
+        message: $("#basket_signing_message").val().trim()
+      };
+      // secret is never displayed, it remains unchanged unless a new secret is provided
+      var secret = $("#basket_signing_secret").val();
+      if (secret) {
+        signing.secret = secret;
+      }
+      return signing;
+    }
+
+    function displayWebSocket(websocket) {
+      websocket = websocket || {};
+      $("#basket_websocket_mode").val(websocket.mode || "");
+      $("#basket_websocket_messages").val((websocket.messages || []).join("\n"));
+    }
+
+    function collectWebSocket() {
+      var mode = $("#basket_websocket_mode").val();
+      var messages = $("#basket_websocket_messages").val().split("\n").filter(function(message) {
+        return message.length > 0;
+      });
+      if (!mode && messages.length == 0) {
+        return null;
+      }
+      var websocket = { mode: mode };
+      if (messages.length > 0) {
+        websocket.messages = messages;
+      }
+      return websocket;
+    }
+
+    function displayCircuitBreaker(config) {
+      var breaker = config.circuit_breaker || {};
+      $("#basket_breaker_threshold").val(breaker.failure_threshold || "");
+      $("#basket_breaker_duration").val(breaker.open_duration || "");
+      $("#basket_rate_limit").val(config.rate_limit || "");
+
+      $("#basket_targets_status").html("");
+      $.ajax({
+        method: "GET",
+        url: "{{.Prefix}}/api/baskets/{{.Basket}}/status",
+        headers: {
+          "Authorization" : getToken()
+        }
+      }).done(function(status) {
+        var html = "";
+        var classes = { "closed": "success", "half-open": "warning", "open": "danger" };
+        for (var i = 0; status && i < status.targets.length; i++) {
+          var target = status.targets[i];
+          html += '<div><span class="label label-' + classes[target.state] + '"' +
+            (target.probe_at ? ' title="Probe after ' + new Date(target.probe_at).toLocaleTimeString() + '"' : '') + '>' +
+            escapeHTML(target.state) + '</span> <code>' + escapeHTML(target.url) + '</code> ' +
+            (target.failures > 0 ? target.failures + ' failures' : '') + '</div>';
+        }
+        $("#basket_targets_status").html(html);
+      }).fail(onAjaxError);
+    }
+
+    function collectCircuitBreaker() {
+      var breaker = {};
+      var threshold = parseInt($("#basket_breaker_threshold").val()) || 0;
+      var duration = parseInt($("#basket_breaker_duration").val()) || 0;
+      if (threshold > 0) {
+        breaker.failure_threshold = threshold;
+      }
+      if (duration > 0) {
+        breaker.open_duration = duration;
+      }
+      return (threshold > 0 || duration > 0) ? breaker : null;
+    }
+
+    function collectForwardRules() {
+      var rules = $("#basket_forward_rules").val().trim();
+      return rules.length > 0 ? JSON.parse(rules) : [];
+    }
+
     function updateConfig() {
+      var targets = collectTargets();
+      var rules = collectHeaderRules();
+      var transport = collectTransport();
+      var signing = collectSigning();
+      var websocket = collectWebSocket();
+      var breaker = collectCircuitBreaker();
+      var rateLimit = parseInt($("#basket_rate_limit").val()) || 0;
+      var maxAge = parseInt($("#basket_max_age").val()) || 0;
+      var forwardRules;
+      try {
+        forwardRules = collectForwardRules();
+      } catch (err) {
+        alert("Invalid JSON of forward rules: " + err);
+        return;
+      }
       if (currentConfig && (
         currentConfig.forward_url != $("#basket_forward_url").val() ||
         currentConfig.proxy_response != $("#basket_proxy_response").prop("checked") ||
         currentConfig.expand_path != $("#basket_expand_path").prop("checked") ||
         currentConfig.insecure_tls != $("#basket_insecure_tls").prop("checked") ||
-        currentConfig.capacity != $("#basket_capacity").val()
+        currentConfig.capacity != $("#basket_capacity").val() ||
+        JSON.stringify(currentConfig.forward_targets || []) != JSON.stringify(targets) ||
+        JSON.stringify(currentConfig.header_rules || []) != JSON.stringify(rules) ||
+        (currentConfig.forward_method || "") != $("#basket_forward_method").val() ||
+        (currentConfig.forward_content_type || "") != $("#basket_forward_content_type").val() ||
+        (currentConfig.forward_body_template || "") != $("#basket_forward_body_template").val() ||
+        JSON.stringify(currentConfig.forward_rules || []) != JSON.stringify(forwardRules) ||
+        initialTransport != JSON.stringify(transport) ||
+        initialSigning != JSON.stringify(signing) ||
+        JSON.stringify(currentConfig.websocket || null) != JSON.stringify(websocket) ||
+        JSON.stringify(currentConfig.circuit_breaker || null) != JSON.stringify(breaker) ||
+        (currentConfig.rate_limit || 0) != rateLimit ||
+        (currentConfig.max_age || 0) != maxAge ||
+        (currentConfig.pinned || false) != $("#basket_pinned").prop("checked")
       )) {
         currentConfig.forward_url = $("#basket_forward_url").val();
         currentConfig.proxy_response = $("#basket_proxy_response").prop("checked");
         currentConfig.expand_path = $("#basket_expand_path").prop("checked");
         currentConfig.insecure_tls = $("#basket_insecure_tls").prop("checked");
         currentConfig.capacity = parseInt($("#basket_capacity").val());
+        currentConfig.forward_targets = targets;
+        currentConfig.header_rules = rules;
+        currentConfig.forward_method = $("#basket_forward_method").val();
+        currentConfig.forward_content_type = $("#basket_forward_content_type").val();
+        currentConfig.forward_body_template = $("#basket_forward_body_template").val();
+        currentConfig.forward_rules = forwardRules;
+        currentConfig.transport = transport;
+        currentConfig.signing = signing;
+        currentConfig.websocket = websocket;
+        currentConfig.circuit_breaker = breaker;
+        currentConfig.rate_limit = rateLimit;
+        currentConfig.max_age = maxAge;
+        currentConfig.pinned = $("#basket_pinned").prop("checked");
+        delete currentConfig.expiry_date;
 
         $.ajax({
           method: "PUT",
@@ -425,6 +881,7 @@ const (
           }
         }).done(function(data) {
           alert("Basket is reconfigured");
+          fetchExpiry();
         }).fail(onAjaxError);
       }
     }
@@ -434,6 +891,7 @@ const (
       fetchedCount = 0;
       fetchedRequests = {};
       fetchRequests(); // fetch latest
+      fetchExpiry();
     }
 
     function enableAutoRefresh(enable) {
@@ -469,6 +927,18 @@ const (
           $("#basket_expand_path").prop("checked", currentConfig.expand_path);
           $("#basket_insecure_tls").prop("checked", currentConfig.insecure_tls);
           $("#basket_capacity").val(currentConfig.capacity);
+          $("#basket_max_age").val(currentConfig.max_age || "");
+          $("#basket_pinned").prop("checked", currentConfig.pinned || false);
+          displayTargets(currentConfig.forward_targets || []);
+          displayHeaderRules(currentConfig.header_rules || []);
+          $("#basket_forward_method").val(currentConfig.forward_method);
+          $("#basket_forward_content_type").val(currentConfig.forward_content_type);
+          $("#basket_forward_body_template").val(currentConfig.forward_body_template);
+          $("#basket_forward_rules").val(currentConfig.forward_rules ? JSON.stringify(currentConfig.forward_rules, null, 2) : "");
+          displayTransport(currentConfig.transport || {});
+          displaySigning(currentConfig.signing);
+          displayWebSocket(currentConfig.websocket);
+          displayCircuitBreaker(currentConfig);
           $("#config_dialog").modal();
         }
       }).fail(onAjaxError);
@@ -562,6 +1032,7 @@ const (
       $("#token_dialog").on("hidden.bs.modal", function (event) {
         localStorage.setItem("basket_{{.Basket}}", $("#basket_token").val());
         fetchRequests();
+        fetchExpiry();
       });
       $("#config_form").on("submit", function(event) {
         $("#config_dialog").modal("hide");
@@ -602,6 +1073,15 @@ const (
       $("#update_response").on("click", function(event) {
         updateResponse();
       });
+      $("#replay_request").on("click", function(event) {
+        replayRequest();
+      });
+      $("#add_target").on("click", function(event) {
+        addTarget();
+      });
+      $("#add_header_rule").on("click", function(event) {
+        addHeaderRule();
+      });
       // copy basket URL
       $(".copy-url-btn").on("click", function(event) {
         copyBasketUrl(this);
@@ -617,6 +1097,7 @@ const (
         enableAutoRefresh(true);
       }
       fetchRequests();
+      fetchExpiry();
       fetchResponse("GET");
     });
   })(jQuery);
@@ -713,10 +1194,197 @@ const (
           <div class="checkbox">
             <label><input type="checkbox" id="basket_expand_path"> Expand Forward Path</label>
           </div>
+          <div class="form-group">
+            <label class="control-label">
+              <abbr title="Incoming requests are forwarded to every listed URL in addition to Forward URL">Additional Forward Targets</abbr>:
+            </label>
+            <div id="basket_forward_targets">
+              <!-- forward targets -->
+            </div>
+            <button id="add_target" type="button" title="Add Forward Target" class="btn btn-success">
+              <span class="glyphicon glyphicon-plus-sign"></span>
+            </button>
+          </div>
+          <div class="form-group">
+            <label class="control-label">
+              <abbr title="Headers of forwarded requests are set, added or removed; values may refer the original request as templates, e.g. {{"{{"}}.method{{"}}"}}">Forwarded Headers</abbr>:
+            </label>
+            <div id="basket_header_rules">
+              <!-- header rules -->
+            </div>
+            <button id="add_header_rule" type="button" title="Add Header Rule" class="btn btn-success">
+              <span class="glyphicon glyphicon-plus-sign"></span>
+            </button>
+          </div>
+          <div class="form-group">
+            <div class="row">
+              <div class="col-md-6">
+                <label for="basket_forward_method" class="control-label">Forward Method:</label>
+                <input type="input" class="form-control" id="basket_forward_method" placeholder="as received">
+              </div>
+              <div class="col-md-6">
+                <label for="basket_forward_content_type" class="control-label">Forward Content-Type:</label>
+                <input type="input" class="form-control" id="basket_forward_content_type" placeholder="as received">
+              </div>
+            </div>
+          </div>
+          <div class="form-group">
+            <label for="basket_forward_body_template" class="control-label">
+              <abbr title="Body of forwarded requests is replaced by the result of this template, the same data is available as for response templates">Forward Body Template</abbr>:
+            </label>
+            <textarea class="form-control" id="basket_forward_body_template" rows="4" placeholder="body as received"></textarea>
+          </div>
+          <div class="form-group">
+            <label for="basket_forward_rules" class="control-label">
+              <abbr title="The first rule matching method, path, headers and body of incoming request defines where the request is forwarded; requests without matching rule are forwarded to the URLs above">Forward Rules</abbr> (JSON):
+            </label>
+            <textarea class="form-control" id="basket_forward_rules" rows="4"
+              placeholder='[{"name": "push", "headers": {"X-GitHub-Event": "^push$"}, "targets": [{"url": "http://ci.local/hook"}]}]'></textarea>
+          </div>
+          <div class="form-group">
+            <label class="control-label">
+              <abbr title="Timeouts are defined in milliseconds, server defaults apply to empty values">Forward Transport</abbr>:
+            </label>
+            <div class="row">
+              <div class="col-md-4">
+                <input type="input" class="form-control" id="basket_connect_timeout" placeholder="connect timeout">
+              </div>
+              <div class="col-md-4">
+                <input type="input" class="form-control" id="basket_tls_timeout" placeholder="TLS handshake timeout">
+              </div>
+              <div class="col-md-4">
+                <input type="input" class="form-control" id="basket_timeout" placeholder="total timeout">
+              </div>
+            </div>
+            <div class="row" style="margin-top: 5px">
+              <div class="col-md-6">
+                <select class="form-control" id="basket_follow_redirects" title="Follow Redirects">
+                  <option value="">Follow redirects: server default</option>
+                  <option value="true">Follow redirects</option>
+                  <option value="false">Do not follow redirects</option>
+                </select>
+              </div>
+              <div class="col-md-6">
+                <select class="form-control" id="basket_http2" title="HTTP/2">
+                  <option value="">HTTP/2: server default</option>
+                  <option value="true">Prefer HTTP/2</option>
+                  <option value="false">HTTP/1.1 only</option>
+                </select>
+              </div>
+            </div>
+          </div>
+          <div class="form-group">
+            <label class="control-label">
+              <abbr title="Either the name of TLS profile defined by server or TLS settings of this basket; certificates and keys are PEM encoded">Forward TLS</abbr>:
+            </label>
+            <div class="row">
+              <div class="col-md-6">
+                <input type="input" class="form-control" id="basket_tls_profile" placeholder="server TLS profile">
+              </div>
+              <div class="col-md-6">
+                <select class="form-control" id="basket_tls_min_version" title="Minimum TLS Version">
+                  <option value="">Minimum TLS version: default</option>
+                  <option value="1.0">TLS 1.0</option>
+                  <option value="1.1">TLS 1.1</option>
+                  <option value="1.2">TLS 1.2</option>
+                  <option value="1.3">TLS 1.3</option>
+                </select>
+              </div>
+            </div>
+            <textarea class="form-control" id="basket_tls_ca_certs" rows="2" style="margin-top: 5px"
+              placeholder="CA certificates, system CA certificates are used if empty"></textarea>
+            <textarea class="form-control" id="basket_tls_client_cert" rows="2" style="margin-top: 5px"
+              placeholder="client certificate"></textarea>
+            <textarea class="form-control" id="basket_tls_client_key" rows="2" style="margin-top: 5px"
+              placeholder="client key, the current key is kept if empty"></textarea>
+          </div>
+          <div class="form-group">
+            <label class="control-label">
+              <abbr title="HTTP or SOCKS5 proxy, e.g. socks5://localhost:1080; &quot;direct&quot; disables the proxy of server">Forward Proxy</abbr>:
+            </label>
+            <input type="input" class="form-control" id="basket_proxy_url" placeholder="proxy URL, server default is used if empty">
+            <div class="row" style="margin-top: 5px">
+              <div class="col-md-6">
+                <input type="input" class="form-control" id="basket_proxy_username" placeholder="proxy username">
+              </div>
+              <div class="col-md-6">
+                <input type="password" class="form-control" id="basket_proxy_password"
+                  placeholder="proxy password, the current password is kept if empty">
+              </div>
+            </div>
+          </div>
+          <div class="form-group">
+            <div class="checkbox">
+              <label><input type="checkbox" id="basket_signing_enabled">
+                <abbr title="HMAC signature of forwarded payload; format and message are templates with .signature, .signature_base64, .timestamp, .body, .method and .url values">Sign forwarded requests</abbr>
+              </label>
+            </div>
+            <div class="row">
+              <div class="col-md-4">
+                <select class="form-control" id="basket_signing_algorithm" title="Signing Algorithm">
+                  <option value="">HMAC SHA-256 (default)</option>
+                  <option value="sha1">HMAC SHA-1</option>
+                  <option value="sha512">HMAC SHA-512</option>
+                </select>
+              </div>
+              <div class="col-md-8">
+                <input type="input" class="form-control" id="basket_signing_header" placeholder="signature header, default: X-Hub-Signature-256">
+              </div>
+            </div>
+            <input type="input" class="form-control" id="basket_signing_format" style="margin-top: 5px"
+              placeholder="signature format, default: sha256={{"{{"}}.signature{{"}}"}}">
+            <input type="input" class="form-control" id="basket_signing_message" style="margin-top: 5px"
+              placeholder="signed message, default: {{"{{"}}.body{{"}}"}}">
+            <input type="password" class="form-control" id="basket_signing_secret" style="margin-top: 5px"
+              placeholder="secret, the current secret is kept if empty">
+          </div>
+          <div class="form-group">
+            <label for="basket_websocket_mode" class="control-label">
+              <abbr title="Response to messages of WebSocket sessions, sessions are tunneled to forward URL in proxy mode">WebSocket Sessions:</abbr>
+            </label>
+            <select class="form-control" id="basket_websocket_mode">
+              <option value="">Echo incoming messages (default)</option>
+              <option value="reply">Reply with messages</option>
+            </select>
+            <textarea class="form-control" id="basket_websocket_messages" rows="3" style="margin-top: 5px"
+              placeholder="reply messages, one message per line"></textarea>
+          </div>
+          <div class="form-group">
+            <label for="basket_breaker_threshold" class="control-label">
+              <abbr title="Circuit of a target opens after the number of consecutive failures and stops forwarding for open duration, then a single probe request decides whether the circuit is closed">Circuit Breaker and Rate Limit:</abbr>
+            </label>
+            <div class="row">
+              <div class="col-md-4">
+                <input type="number" min="0" class="form-control" id="basket_breaker_threshold"
+                  title="Failure Threshold" placeholder="failures to open, 0 - disabled">
+              </div>
+              <div class="col-md-4">
+                <input type="number" min="0" class="form-control" id="basket_breaker_duration"
+                  title="Open Duration, ms." placeholder="open duration, default: 30000 ms.">
+              </div>
+              <div class="col-md-4">
+                <input type="number" min="0" class="form-control" id="basket_rate_limit"
+                  title="Rate Limit of Forwarded Requests" placeholder="requests per second, 0 - unlimited">
+              </div>
+            </div>
+            <div id="basket_targets_status" style="margin-top: 5px"></div>
+          </div>
           <div class="form-group">
             <label for="basket_capacity" class="control-label">Basket Capacity:</label>
             <input type="input" class="form-control" id="basket_capacity">
           </div>
+          <div class="form-group">
+            <label for="basket_max_age" class="control-label">
+              <abbr title="Collected requests older than max age are deleted, server default applies if undefined">Max Age of Requests:</abbr>
+            </label>
+            <input type="number" min="0" class="form-control" id="basket_max_age"
+              placeholder="seconds, 0 - server default{{if .MaxAge}}: {{.MaxAge}}{{else}}: unlimited{{end}}">
+          </div>
+          <div class="checkbox">
+            <label><input type="checkbox" id="basket_pinned">
+              <abbr title="Pinned basket is never deleted as inactive or outlived">Pinned</abbr>
+            </label>
+          </div>
         </div>
         <div class="modal-footer">
           <button type="button" class="btn btn-default" data-dismiss="modal">Cancel</button>
@@ -779,6 +1447,41 @@ const (
   </form>
 
   <!-- Destroy dialog -->
+  <div class="modal fade" id="replay_dialog" tabindex="-1">
+    <div class="modal-dialog">
+      <div class="modal-content panel-default">
+        <div class="modal-header panel-heading">
+          <button type="button" class="close" data-dismiss="modal">&times;</button>
+          <h4 class="modal-title">Replay Request</h4>
+        </div>
+        <div class="modal-body">
+          <div class="form-group">
+            <label for="replay_url" class="control-label">URL:</label>
+            <input type="input" class="form-control" id="replay_url" placeholder="forward URL of basket is used if empty">
+          </div>
+          <div class="form-group">
+            <label for="replay_headers" class="control-label">
+              <abbr title="One header per line, e.g. X-Event: ping; a header without value is removed">Override Headers</abbr>:
+            </label>
+            <textarea class="form-control" id="replay_headers" rows="3"></textarea>
+          </div>
+          <div class="form-group">
+            <label for="replay_body" class="control-label">Body:</label>
+            <textarea class="form-control" id="replay_body" rows="6"></textarea>
+          </div>
+          <div id="replay_response" class="hide">
+            <label class="control-label">Response:</label> <span id="replay_response_status" class="label"></span>
+            <pre id="replay_response_body" style="margin-top: 5px"></pre>
+          </div>
+        </div>
+        <div class="modal-footer">
+          <button type="button" class="btn btn-default" data-dismiss="modal">Close</button>
+          <button type="button" class="btn btn-primary" id="replay_request">Replay</button>
+        </div>
+      </div>
+    </div>
+  </div>
+
   <div class="modal fade" id="destroy_dialog" tabindex="-1">
     <div class="modal-dialog">
       <div class="modal-content panel-danger">
@@ -823,6 +1526,9 @@ const (
       </div>
       <div class="col-md-3 col-md-offset-1">
         <h4><abbr title="Current requests count (Total count)">Requests</abbr>: <span id="requests_count"></span></h4>
+        <p id="basket_expiry" class="hide">
+          <abbr title="Basket is deleted as inactive or outlived unless it is pinned">Expires</abbr>: <span id="expiry_date"></span>
+        </p>
       </div>
     </div>
     <hr/>
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// webSocketGUID is appended to the key of WebSocket handshake to calculate the accept value, see RFC 6455
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+)
+
+// Directions of recorded WebSocket frames
+const (
+	WebSocketIn  = "in"
+	WebSocketOut = "out"
+)
+
+const (
+	maxWebSocketPayload    = 16 * 1024 * 1024
+	maxWebSocketFrames     = 1000
+	webSocketFrameDataSize = 2048
+	webSocketIdleTimeout   = 10 * time.Minute
+	webSocketFlushInterval = time.Second
+	webSocketCloseTimeout  = 5 * time.Second
+)
+
+var wsFrameTypes = map[byte]string{
+	wsOpContinuation: "continuation",
+	wsOpText:         "text",
+	wsOpBinary:       "binary",
+	wsOpClose:        "close",
+	wsOpPing:         "ping",
+	wsOpPong:         "pong"}
+
+var errWebSocketTooBig = fmt.Errorf("payload of frame exceeds %d bytes", maxWebSocketPayload)
+
+// isWebSocketUpgrade checks if HTTP request asks to upgrade the connection to WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	return r.Method == http.MethodGet && headerHasToken(r.Header, "Connection", "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketAccept calculates the value of Sec-WebSocket-Accept header for the key of WebSocket handshake
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsFrame is a single frame of WebSocket protocol with unmasked payload
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func (frame *wsFrame) isControl() bool {
+	return frame.opcode&0x8 != 0
+}
+
+// closeFrame creates close frame with status code and reason
+func closeFrame(code int, reason string) *wsFrame {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return &wsFrame{fin: true, opcode: wsOpClose, payload: append(payload, reason...)}
+}
+
+// wsConn reads and writes frames of WebSocket connection, frames sent by client side must be masked
+type wsConn struct {
+	sync.Mutex
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+	client bool
+}
+
+func (c *wsConn) readFrame() (*wsFrame, error) {
+	if conn, ok := c.conn.(net.Conn); ok {
+		conn.SetReadDeadline(time.Now().Add(webSocketIdleTimeout))
+	}
+
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return nil, err
+	}
+
+	frame := &wsFrame{fin: head[0]&0x80 != 0, opcode: head[0] & 0x0f}
+	if head[0]&0x70 != 0 {
+		return nil, errors.New("reserved bits of frame are set, WebSocket extensions are not supported")
+	}
+	if _, known := wsFrameTypes[frame.opcode]; !known {
+		return nil, fmt.Errorf("unknown opcode of frame: %d", frame.opcode)
+	}
+	if masked := head[1]&0x80 != 0; masked == c.client {
+		return nil, errors.New("masking of frame violates WebSocket protocol")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if frame.isControl() && (length > 125 || !frame.fin) {
+		return nil, errors.New("invalid control frame")
+	}
+	if length > maxWebSocketPayload {
+		return nil, errWebSocketTooBig
+	}
+
+	var mask [4]byte
+	if !c.client {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		return nil, err
+	}
+	if !c.client {
+		for i := range frame.payload {
+			frame.payload[i] ^= mask[i%4]
+		}
+	}
+
+	return frame, nil
+}
+
+func (c *wsConn) writeFrame(frame *wsFrame) error {
+	head := make([]byte, 2, 14)
+	head[0] = frame.opcode
+	if frame.fin {
+		head[0] |= 0x80
+	}
+
+	length := len(frame.payload)
+	switch {
+	case length < 126:
+		head[1] = byte(length)
+	case length <= 0xffff:
+		head[1] = 126
+		head = append(head, 0, 0)
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+	default:
+		head[1] = 127
+		head = append(head, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+	}
+
+	data := frame.payload
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		head[1] |= 0x80
+		head = append(head, mask[:]...)
+		data = make([]byte, length)
+		for i := range data {
+			data[i] = frame.payload[i] ^ mask[i%4]
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	_, err := c.conn.Write(append(head, data...))
+	return err
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
+
+// webSocketRecorder collects frames of WebSocket session and saves them in batches along with the upgrade request,
+// the number of recorded frames is limited
+type webSocketRecorder struct {
+	sync.Mutex
+	basket  Basket
+	id      string
+	pending []*WebSocketFrame
+	count   int
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newWebSocketRecorder(basket Basket, id string) *webSocketRecorder {
+	recorder := &webSocketRecorder{basket: basket, id: id, stop: make(chan struct{}), done: make(chan struct{})}
+	go recorder.run()
+	return recorder
+}
+
+func (recorder *webSocketRecorder) run() {
+	defer close(recorder.done)
+
+	ticker := time.NewTicker(webSocketFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			recorder.flush()
+		case <-recorder.stop:
+			recorder.flush()
+			return
+		}
+	}
+}
+
+func (recorder *webSocketRecorder) record(direction string, frame *wsFrame) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if recorder.count >= maxWebSocketFrames {
+		return
+	}
+	recorder.count++
+	recorder.pending = append(recorder.pending, toWebSocketFrame(direction, frame))
+}
+
+func (recorder *webSocketRecorder) flush() {
+	recorder.Lock()
+	frames := recorder.pending
+	recorder.pending = nil
+	recorder.Unlock()
+
+	if len(frames) > 0 && len(recorder.id) > 0 {
+		if err := recorder.basket.AddWebSocketFrames(context.Background(), recorder.id, frames); err != nil {
+			log.Printf("[error] failed to save WebSocket frames - %s", err)
+		}
+	}
+}
+
+// close saves pending frames and stops recording
+func (recorder *webSocketRecorder) close() {
+	close(recorder.stop)
+	<-recorder.done
+}
+
+// toWebSocketFrame converts WebSocket frame into collected frame data, close frames are recorded as status code
+// followed by reason, payload that is not a valid UTF-8 text is base64 encoded
+func toWebSocketFrame(direction string, frame *wsFrame) *WebSocketFrame {
+	data := &WebSocketFrame{Date: time.Now().UnixNano() / toMs, Direction: direction, Type: wsFrameTypes[frame.opcode],
+		Final: frame.fin, Size: len(frame.payload)}
+
+	payload := frame.payload
+	if frame.opcode == wsOpClose && len(payload) >= 2 {
+		data.Data = fmt.Sprintf("%d %s", binary.BigEndian.Uint16(payload), payload[2:])
+		return data
+	}
+
+	if len(payload) > webSocketFrameDataSize {
+		payload = payload[:webSocketFrameDataSize]
+		data.Truncated = true
+	}
+	if frame.opcode != wsOpBinary && utf8.Valid(frame.payload) {
+		data.Data = strings.ToValidUTF8(string(payload), "")
+	} else {
+		data.Encoding = "base64"
+		data.Data = base64.StdEncoding.EncodeToString(payload)
+	}
+
+	return data
+}
+
+// acceptWebSocket completes WebSocket handshake and serves the session of a basket, incoming messages are either
+// echoed or answered with configured messages
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, request *RequestData, name string, basket Basket,
+	config *WebSocketConfig) {
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported version of WebSocket protocol", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid key of WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	header := http.Header{}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", webSocketAccept(key))
+	if protocol := r.Header.Get("Sec-WebSocket-Protocol"); len(protocol) > 0 {
+		// the first offered subprotocol is accepted
+		header.Set("Sec-WebSocket-Protocol", strings.TrimSpace(strings.Split(protocol, ",")[0]))
+	}
+
+	conn, err := hijackWebSocket(w, header)
+	if err != nil {
+		log.Printf("[error] failed to accept WebSocket session for basket: %s - %s", name, err)
+		return
+	}
+	defer conn.Close()
+
+	recorder := newWebSocketRecorder(basket, request.ID)
+	defer recorder.close()
+
+	serveWebSocket(conn, config, recorder, name)
+}
+
+// hijackWebSocket takes over HTTP connection and completes WebSocket handshake with specified response headers
+func hijackWebSocket(w http.ResponseWriter, header http.Header) (*wsConn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket is not supported", http.StatusInternalServerError)
+		return nil, errors.New("HTTP connection cannot be hijacked")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	var handshake strings.Builder
+	handshake.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(&handshake)
+	handshake.WriteString("\r\n")
+	if _, err = conn.Write([]byte(handshake.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: brw.Reader}, nil
+}
+
+// serveWebSocket reads messages of WebSocket session until client closes the session or connection fails
+func serveWebSocket(conn *wsConn, config *WebSocketConfig, recorder *webSocketRecorder, name string) {
+	send := func(frame *wsFrame) error {
+		recorder.record(WebSocketOut, frame)
+		return conn.writeFrame(frame)
+	}
+
+	var message []byte
+	var messageType byte
+	for {
+		frame, err := conn.readFrame()
+		if err == errWebSocketTooBig {
+			send(closeFrame(wsCloseTooBig, err.Error()))
+			return
+		} else if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				send(closeFrame(wsCloseGoingAway, "session is idle"))
+			} else if err != io.EOF {
+				log.Printf("[warn] WebSocket session of basket: %s is interrupted - %s", name, err)
+				send(closeFrame(wsCloseProtocolError, err.Error()))
+			}
+			return
+		}
+		recorder.record(WebSocketIn, frame)
+
+		switch frame.opcode {
+		case wsOpPing:
+			err = send(&wsFrame{fin: true, opcode: wsOpPong, payload: frame.payload})
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(frame.payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(frame.payload))
+			}
+			send(closeFrame(code, ""))
+			return
+		case wsOpContinuation:
+			if messageType == 0 {
+				send(closeFrame(wsCloseProtocolError, "unexpected continuation frame"))
+				return
+			}
+			if len(message)+len(frame.payload) > maxWebSocketPayload {
+				send(closeFrame(wsCloseTooBig, errWebSocketTooBig.Error()))
+				return
+			}
+			message = append(message, frame.payload...)
+		default:
+			messageType, message = frame.opcode, frame.payload
+		}
+
+		if err == nil && !frame.isControl() && frame.fin {
+			err = respondWebSocket(send, config, messageType, message)
+			messageType, message = 0, nil
+		}
+		if err != nil {
+			log.Printf("[warn] failed to respond to WebSocket message of basket: %s - %s", name, err)
+			return
+		}
+	}
+}
+
+// respondWebSocket answers complete WebSocket message according to basket configuration
+func respondWebSocket(send func(*wsFrame) error, config *WebSocketConfig, messageType byte, message []byte) error {
+	if config != nil && config.Mode == WebSocketReply {
+		for _, reply := range config.Messages {
+			if err := send(&wsFrame{fin: true, opcode: wsOpText, payload: []byte(reply)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return send(&wsFrame{fin: true, opcode: messageType, payload: message})
+}
+
+// tunnelWebSocket establishes WebSocket session with the target defined by basket config and relays frames
+// between client and target, the response of target is proxied as is if it does not accept the session
+func tunnelWebSocket(w http.ResponseWriter, r *http.Request, request *RequestData, config BasketConfig, name string,
+	basket Basket) {
+	result := &ForwardResult{Date: time.Now().UnixNano() / toMs, URL: config.ForwardURL}
+	defer recordForwardResult(request, result, name)
+
+	forwardReq, err := newWebSocketRequest(r, request, config, name)
+	if err != nil {
+		result.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result.URL = forwardReq.URL.String()
+
+	client, err := getHTTPClient(config)
+	if err != nil {
+		result.Error = err.Error()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := guardForward(config, name)
+	if err != nil {
+		result.Error = err.Error()
+		result.Rejected = true
+		writeProxiedResponse(w, unavailableResponse(err), result, name)
+		return
+	}
+
+	// client timeout would interrupt the session, so the transport is used directly
+	start := time.Now()
+	response, err := client.Transport.RoundTrip(forwardReq)
+	result.Duration = int64(time.Since(start) / time.Millisecond)
+	report(err == nil && response.StatusCode < 500)
+	if err != nil {
+		log.Printf("[warn] failed to forward WebSocket session for basket: %s - %s", name, err)
+		result.Error = err.Error()
+		writeProxiedResponse(w, badGatewayResponse(err), result, name)
+		return
+	}
+
+	result.Status = response.StatusCode
+	upstream, ok := response.Body.(io.ReadWriteCloser)
+	if response.StatusCode != http.StatusSwitchingProtocols || !ok {
+		writeProxiedResponse(w, response, result, name)
+		return
+	}
+	defer upstream.Close()
+
+	conn, err := hijackWebSocket(w, response.Header)
+	if err != nil {
+		log.Printf("[error] failed to accept WebSocket session for basket: %s - %s", name, err)
+		result.Error = err.Error()
+		return
+	}
+	defer conn.Close()
+
+	recorder := newWebSocketRecorder(basket, request.ID)
+	defer recorder.close()
+
+	target := &wsConn{conn: upstream, reader: bufio.NewReader(upstream), client: true}
+	relayWebSocket(conn, target, recorder, name)
+}
+
+// newWebSocketRequest creates the handshake request of WebSocket session with forward target
+func newWebSocketRequest(r *http.Request, request *RequestData, config BasketConfig, name string) (*http.Request, error) {
+	forwardReq, err := request.NewForwardRequest(config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(forwardReq.URL.Scheme) {
+	case "ws":
+		forwardReq.URL.Scheme = "http"
+	case "wss":
+		forwardReq.URL.Scheme = "https"
+	}
+
+	forwardReq.Method = http.MethodGet
+	forwardReq.Body = nil
+	forwardReq.ContentLength = 0
+	forwardReq.Header.Set("Connection", "Upgrade")
+	forwardReq.Header.Set("Upgrade", "websocket")
+	// frames must be readable to be recorded, so compression is not negotiated
+	forwardReq.Header.Del("Sec-WebSocket-Extensions")
+	for _, header := range []string{"Sec-WebSocket-Key", "Sec-WebSocket-Version", "Sec-WebSocket-Protocol"} {
+		if values := r.Header.Values(header); len(values) > 0 {
+			forwardReq.Header[header] = values
+		}
+	}
+
+	return forwardReq, nil
+}
+
+// relayWebSocket relays frames between client and target until one of them closes the session,
+// the other side is given some time to confirm closing of the session
+func relayWebSocket(client *wsConn, target *wsConn, recorder *webSocketRecorder, name string) {
+	done := make(chan error, 2)
+	go relayFrames(client, target, WebSocketIn, recorder, done)
+	go relayFrames(target, client, WebSocketOut, recorder, done)
+
+	if err := <-done; err != nil && err != io.EOF {
+		log.Printf("[warn] WebSocket session of basket: %s is interrupted - %s", name, err)
+	} else {
+		select {
+		case <-done:
+		case <-time.After(webSocketCloseTimeout):
+		}
+	}
+
+	client.Close()
+	target.Close()
+}
+
+func relayFrames(from *wsConn, to *wsConn, direction string, recorder *webSocketRecorder, done chan<- error) {
+	for {
+		frame, err := from.readFrame()
+		if err != nil {
+			done <- err
+			return
+		}
+		recorder.record(direction, frame)
+
+		if err = to.writeFrame(frame); err != nil {
+			done <- err
+			return
+		}
+		if frame.opcode == wsOpClose {
+			done <- nil
+			return
+		}
+	}
+}
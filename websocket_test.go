@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dialTestWebSocket opens WebSocket session with the test server, returns nil connection if upgrade is rejected
+func dialTestWebSocket(t *testing.T, serverURL string, path string, version string) (*wsConn, *http.Response) {
+	u, _ := url.Parse(serverURL)
+	conn, err := net.Dial("tcp", u.Host)
+	if !assert.NoError(t, err, "failed to connect to test server") {
+		return nil, nil
+	}
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: %s\r\nSec-WebSocket-Protocol: chat, json\r\n\r\n",
+		path, u.Host, key, version)
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if !assert.NoError(t, err, "failed to read handshake response") {
+		conn.Close()
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, response
+	}
+	assert.Equal(t, webSocketAccept(key), response.Header.Get("Sec-WebSocket-Accept"), "wrong accept value")
+
+	return &wsConn{conn: conn, reader: reader, client: true}, response
+}
+
+// waitForFrames waits until the frames of WebSocket session are saved with collected request
+func waitForFrames(basket *testBasket, count int) *RequestData {
+	var request *RequestData
+	for i := 0; i < 50; i++ {
+		if page := basket.GetRequests(1, 0); len(page.Requests) > 0 {
+			if request = page.Requests[0]; len(request.Frames) >= count {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return request
+}
+
+func assertFrame(t *testing.T, conn *wsConn, opcode byte, payload string) {
+	frame, err := conn.readFrame()
+	if assert.NoError(t, err, "frame is expected") {
+		assert.Equal(t, opcode, frame.opcode, "wrong opcode of frame")
+		assert.Equal(t, payload, string(frame.payload), "wrong payload of frame")
+		assert.True(t, frame.fin, "final frame is expected")
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// example from RFC 6455
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="), "wrong accept value")
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://localhost:55555/ws", nil)
+	assert.False(t, isWebSocketUpgrade(r), "regular request is not an upgrade")
+
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "WebSocket")
+	assert.True(t, isWebSocketUpgrade(r), "WebSocket upgrade is expected")
+
+	r.Header.Set("Upgrade", "h2c")
+	assert.False(t, isWebSocketUpgrade(r), "upgrade to another protocol is not expected")
+
+	r = httptest.NewRequest("POST", "http://localhost:55555/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	assert.False(t, isWebSocketUpgrade(r), "WebSocket upgrade is only expected with GET method")
+}
+
+func TestWsConn_Frames(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	client := &wsConn{conn: clientSide, reader: bufio.NewReader(clientSide), client: true}
+	server := &wsConn{conn: serverSide, reader: bufio.NewReader(serverSide)}
+	defer client.Close()
+	defer server.Close()
+
+	for _, size := range []int{0, 5, 125, 300, 70000} {
+		payload := strings.Repeat("x", size)
+		go client.writeFrame(&wsFrame{fin: true, opcode: wsOpText, payload: []byte(payload)})
+		assertFrame(t, server, wsOpText, payload)
+
+		go server.writeFrame(&wsFrame{fin: true, opcode: wsOpBinary, payload: []byte(payload)})
+		assertFrame(t, client, wsOpBinary, payload)
+	}
+
+	// server does not expect unmasked frames
+	go server.writeFrame(&wsFrame{fin: true, opcode: wsOpText, payload: []byte("abc")})
+	unmasked := &wsConn{conn: clientSide, reader: bufio.NewReader(clientSide)}
+	_, err := unmasked.readFrame()
+	if assert.Error(t, err, "unmasked frame is expected to be rejected") {
+		assert.Contains(t, err.Error(), "masking of frame violates WebSocket protocol", "error is not detailed enough")
+	}
+}
+
+func TestToWebSocketFrame(t *testing.T) {
+	frame := toWebSocketFrame(WebSocketIn, &wsFrame{fin: true, opcode: wsOpText, payload: []byte("hello")})
+	assert.Equal(t, WebSocketIn, frame.Direction, "wrong direction of frame")
+	assert.Equal(t, "text", frame.Type, "wrong type of frame")
+	assert.Equal(t, "hello", frame.Data, "wrong data of frame")
+	assert.Equal(t, 5, frame.Size, "wrong size of frame")
+	assert.Empty(t, frame.Encoding, "text is not expected to be encoded")
+
+	frame = toWebSocketFrame(WebSocketOut, &wsFrame{fin: false, opcode: wsOpBinary, payload: []byte{0, 1, 2}})
+	assert.Equal(t, "binary", frame.Type, "wrong type of frame")
+	assert.Equal(t, "AAEC", frame.Data, "binary payload is expected to be base64 encoded")
+	assert.Equal(t, "base64", frame.Encoding, "wrong encoding of frame data")
+	assert.False(t, frame.Final, "frame is not final")
+
+	frame = toWebSocketFrame(WebSocketIn, closeFrame(wsCloseGoingAway, "bye"))
+	assert.Equal(t, "close", frame.Type, "wrong type of frame")
+	assert.Equal(t, "1001 bye", frame.Data, "close frame is expected to be recorded with status code")
+
+	frame = toWebSocketFrame(WebSocketIn, &wsFrame{fin: true, opcode: wsOpText,
+		payload: []byte(strings.Repeat("a", webSocketFrameDataSize+10))})
+	assert.True(t, frame.Truncated, "frame data is expected to be truncated")
+	assert.Equal(t, webSocketFrameDataSize, len(frame.Data), "wrong size of truncated data")
+	assert.Equal(t, webSocketFrameDataSize+10, frame.Size, "frame size is expected to be original size")
+}
+
+func TestAcceptWebSocket_Echo(t *testing.T) {
+	basket := "ws01"
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(basket)
+
+	ts := httptest.NewServer(http.HandlerFunc(AcceptBasketRequests))
+	defer ts.Close()
+
+	conn, response := dialTestWebSocket(t, ts.URL, "/"+basket+"/chat", "13")
+	if assert.NotNil(t, conn, "WebSocket session is expected") {
+		defer conn.Close()
+		assert.Equal(t, "chat", response.Header.Get("Sec-WebSocket-Protocol"), "first subprotocol is expected")
+
+		conn.writeFrame(&wsFrame{fin: true, opcode: wsOpText, payload: []byte("hello")})
+		assertFrame(t, conn, wsOpText, "hello")
+
+		conn.writeFrame(&wsFrame{fin: true, opcode: wsOpPing, payload: []byte("p")})
+		assertFrame(t, conn, wsOpPong, "p")
+
+		// fragmented message
+		conn.writeFrame(&wsFrame{fin: false, opcode: wsOpBinary, payload: []byte("ab")})
+		conn.writeFrame(&wsFrame{fin: true, opcode: wsOpContinuation, payload: []byte("cd")})
+		assertFrame(t, conn, wsOpBinary, "abcd")
+
+		conn.writeFrame(closeFrame(wsCloseNormal, ""))
+		assertFrame(t, conn, wsOpClose, "\x03\xe8")
+
+		request := waitForFrames(testDB(t, basketsDb).Get(basket), 9)
+		if assert.NotNil(t, request, "collected request is expected") {
+			assert.Equal(t, "GET", request.Method, "wrong method of upgrade request")
+			assert.Equal(t, "/"+basket+"/chat", request.Path, "wrong path of upgrade request")
+			if assert.Equal(t, 9, len(request.Frames), "all frames are expected to be recorded") {
+				types := make([]string, 0, len(request.Frames))
+				for _, frame := range request.Frames {
+					types = append(types, frame.Direction+":"+frame.Type)
+				}
+				assert.Equal(t, []string{"in:text", "out:text", "in:ping", "out:pong", "in:binary", "in:continuation",
+					"out:binary", "in:close", "out:close"}, types, "wrong recorded frames")
+				assert.Equal(t, "hello", request.Frames[0].Data, "wrong data of recorded frame")
+			}
+		}
+	}
+}
+
+func TestAcceptWebSocket_Reply(t *testing.T) {
+	basket := "ws02"
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20,
+		WebSocket: &WebSocketConfig{Mode: WebSocketReply, Messages: []string{"ack", "{\"status\":\"done\"}"}}})
+	defer testDB(t, basketsDb).Delete(basket)
+
+	ts := httptest.NewServer(http.HandlerFunc(AcceptBasketRequests))
+	defer ts.Close()
+
+	conn, _ := dialTestWebSocket(t, ts.URL, "/"+basket, "13")
+	if assert.NotNil(t, conn, "WebSocket session is expected") {
+		defer conn.Close()
+
+		conn.writeFrame(&wsFrame{fin: true, opcode: wsOpText, payload: []byte("hello")})
+		assertFrame(t, conn, wsOpText, "ack")
+		assertFrame(t, conn, wsOpText, "{\"status\":\"done\"}")
+
+		conn.writeFrame(closeFrame(wsCloseGoingAway, "bye"))
+		assertFrame(t, conn, wsOpClose, "\x03\xe9")
+
+		request := waitForFrames(testDB(t, basketsDb).Get(basket), 5)
+		if assert.NotNil(t, request, "collected request is expected") && assert.Equal(t, 5, len(request.Frames)) {
+			assert.Equal(t, "1001 bye", request.Frames[3].Data, "wrong data of recorded close frame")
+		}
+	}
+}
+
+func TestAcceptWebSocket_UnsupportedVersion(t *testing.T) {
+	basket := "ws03"
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(basket)
+
+	ts := httptest.NewServer(http.HandlerFunc(AcceptBasketRequests))
+	defer ts.Close()
+
+	conn, response := dialTestWebSocket(t, ts.URL, "/"+basket, "8")
+	assert.Nil(t, conn, "WebSocket session is not expected")
+	if assert.NotNil(t, response, "response is expected") {
+		assert.Equal(t, http.StatusUpgradeRequired, response.StatusCode, "wrong HTTP result code")
+		assert.Equal(t, "13", response.Header.Get("Sec-WebSocket-Version"), "supported version is expected")
+	}
+}
+
+func TestTunnelWebSocket(t *testing.T) {
+	basket := "ws04"
+	target := "ws05"
+
+	ts := httptest.NewServer(http.HandlerFunc(AcceptBasketRequests))
+	defer ts.Close()
+
+	testDB(t, basketsDb).Create(target, BasketConfig{Capacity: 20})
+	defer testDB(t, basketsDb).Delete(target)
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20, ProxyResponse: true, ExpandPath: true,
+		ForwardURL: strings.Replace(ts.URL, "http://", "ws://", 1) + "/" + target})
+	defer testDB(t, basketsDb).Delete(basket)
+
+	conn, response := dialTestWebSocket(t, ts.URL, "/"+basket+"/feed", "13")
+	if assert.NotNil(t, conn, "WebSocket session is expected") {
+		defer conn.Close()
+		assert.Equal(t, "chat", response.Header.Get("Sec-WebSocket-Protocol"), "subprotocol of target is expected")
+
+		conn.writeFrame(&wsFrame{fin: true, opcode: wsOpText, payload: []byte("tunneled")})
+		assertFrame(t, conn, wsOpText, "tunneled")
+
+		conn.writeFrame(closeFrame(wsCloseNormal, ""))
+		assertFrame(t, conn, wsOpClose, "\x03\xe8")
+
+		request := waitForFrames(testDB(t, basketsDb).Get(basket), 4)
+		if assert.NotNil(t, request, "collected request is expected") {
+			assert.Equal(t, 4, len(request.Frames), "frames in both directions are expected to be recorded")
+			if assert.Equal(t, 1, len(request.Forwards), "result of forwarding is expected") {
+				assert.Equal(t, http.StatusSwitchingProtocols, request.Forwards[0].Status, "wrong status of target")
+				assert.Contains(t, request.Forwards[0].URL, "/"+target+"/feed", "wrong URL of target")
+			}
+		}
+
+		request = waitForFrames(testDB(t, basketsDb).Get(target), 4)
+		if assert.NotNil(t, request, "request is expected to be tunneled to target") {
+			assert.Equal(t, "/"+target+"/feed", request.Path, "wrong path of tunneled request")
+			assert.Equal(t, 4, len(request.Frames), "frames of tunneled session are expected")
+		}
+	}
+}
+
+func TestTunnelWebSocket_Rejected(t *testing.T) {
+	basket := "ws06"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no sessions", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	testDB(t, basketsDb).Create(basket, BasketConfig{Capacity: 20, ProxyResponse: true, ForwardURL: ts.URL})
+	defer testDB(t, basketsDb).Delete(basket)
+
+	server := httptest.NewServer(http.HandlerFunc(AcceptBasketRequests))
+	defer server.Close()
+
+	conn, response := dialTestWebSocket(t, server.URL, "/"+basket, "13")
+	assert.Nil(t, conn, "WebSocket session is not expected")
+	if assert.NotNil(t, response, "response is expected") {
+		assert.Equal(t, http.StatusForbidden, response.StatusCode, "response of target is expected")
+	}
+
+	if page := testDB(t, basketsDb).Get(basket).GetRequests(1, 0); assert.Equal(t, 1, len(page.Requests)) {
+		if forwards := page.Requests[0].Forwards; assert.Equal(t, 1, len(forwards), "result of forwarding is expected") {
+			assert.Equal(t, http.StatusForbidden, forwards[0].Status, "wrong status of target")
+			assert.Equal(t, "no sessions\n", forwards[0].Body, "wrong body of target response")
+		}
+	}
+}